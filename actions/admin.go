@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"subscription_service/models"
+)
+
+// adminSessionKey holds the id of the admin user logged in to the session
+const adminSessionKey = "admin_user_id"
+
+// AdminAuthenticate loads the admin user logged in to the session, sending everyone else to the
+// login page. The user's e-mail becomes the operator of the actions taken and of the audit log.
+func AdminAuthenticate(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		tx := c.Value("tx").(*pop.Connection)
+
+		user := &models.AdminUser{}
+		id, _ := c.Session().Get(adminSessionKey).(string)
+		if id == "" || tx.Find(user, id) != nil || user.Disabled() {
+			c.Session().Delete(adminSessionKey)
+			if c.Request().Method == http.MethodGet {
+				c.Session().Set("admin_return_to", c.Request().URL.String())
+			}
+			return c.Redirect(http.StatusFound, "adminLoginPath()")
+		}
+
+		c.Set("current_admin", user)
+		c.Set("operator", user.Email)
+		c.LogField("operator", user.Email)
+		setAuditActor(c, models.ActorAdmin, user.Email)
+		return next(c)
+	}
+}
+
+// RequireRole lets only admin users granted the role through
+func RequireRole(role string, next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		user, ok := c.Value("current_admin").(*models.AdminUser)
+		if !ok || !user.HasRole(role) {
+			return c.Error(http.StatusForbidden, fmt.Errorf("the %s role is required", role))
+		}
+		return next(c)
+	}
+}
+
+// recordAdminAction keeps the action taken by the current operator and whether it worked
+func recordAdminAction(c buffalo.Context, action models.AdminAction, err error) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	action.ID, _ = uuid.NewV4()
+	action.Operator, _ = c.Value("operator").(string)
+	action.Succeeded = err == nil
+	if err != nil {
+		if action.Details != "" {
+			action.Details += "\n"
+		}
+		action.Details += "error: " + err.Error()
+	}
+
+	verrs, verr := tx.ValidateAndCreate(&action)
+	if verr != nil {
+		return verr
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"strings"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// outcomeCount is how many attempts of the funnel ended with the outcome
+type outcomeCount struct {
+	Outcome string `db:"outcome"`
+	Count   int    `db:"count"`
+}
+
+// AdminCheckoutAttemptsIndex lists the checkout attempts between the from and to parameters
+// (YYYY-MM-DD, the last 30 days by default), filtered by email, ip and outcome, along with
+// how many ended with each outcome
+func AdminCheckoutAttemptsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	from := c.Param("from")
+	if from == "" && c.Param("to") == "" {
+		from = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+	start, end, err := services.ReconciliationRange(from, c.Param("to"), time.Now())
+	if err != nil {
+		return c.Error(http.StatusBadRequest, err)
+	}
+
+	conditions := []string{"created_at >= ?", "created_at < ?"}
+	args := []interface{}{start, end}
+	if email := strings.TrimSpace(c.Param("email")); email != "" {
+		conditions, args = append(conditions, "lower(email) = ?"), append(args, strings.ToLower(email))
+	}
+	if ip := strings.TrimSpace(c.Param("ip")); ip != "" {
+		conditions, args = append(conditions, "ip = ?"), append(args, ip)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	// The funnel ignores the outcome filter, it is what the outcomes are compared with
+	counts := []outcomeCount{}
+	err = tx.RawQuery("SELECT outcome, count(*) AS count FROM checkout_attempts WHERE "+where+" GROUP BY outcome", args...).All(&counts)
+	if err != nil {
+		return err
+	}
+	funnel := map[string]int{}
+	for _, outcome := range models.CheckoutOutcomes {
+		funnel[outcome] = 0
+	}
+	total := 0
+	for _, count := range counts {
+		funnel[count.Outcome] = count.Count
+		total += count.Count
+	}
+
+	if outcome := c.Param("outcome"); outcome != "" {
+		where, args = where+" AND outcome = ?", append(args, outcome)
+	}
+	query := tx.PaginateFromParams(c.Params()).Where(where, args...).Order("created_at desc")
+	attempts := models.CheckoutAttempts{}
+	if err := query.All(&attempts); err != nil {
+		return err
+	}
+
+	c.Set("from", start.Format("2006-01-02"))
+	c.Set("to", end.AddDate(0, 0, -1).Format("2006-01-02"))
+	c.Set("outcomes", models.CheckoutOutcomes)
+	c.Set("funnel", funnel)
+	c.Set("total", total)
+	c.Set("attempts", attempts)
+	c.Set("pagination", query.Paginator)
+	return c.Render(http.StatusOK, r.HTML("admin/checkout_attempts/index.html"))
+}
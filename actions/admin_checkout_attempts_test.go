@@ -0,0 +1,31 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"time"
+)
+
+func (as *ActionSuite) Test_AdminCheckoutAttemptsIndex() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "card_declined", "52998224725")
+	as.checkout("credit_card", "", "52998224725")
+	as.loginAdmin(models.RoleSupport)
+
+	today := time.Now().Format("2006-01-02")
+	res := as.HTML("/admin/checkout_attempts?from=" + today + "&to=" + today + "&outcome=declined").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "ana@example.com")
+	as.Contains(res.Body.String(), "card_refused")
+	as.NotContains(res.Body.String(), "<td>succeeded</td>")
+}
+
+func (as *ActionSuite) Test_AdminCheckoutAttemptsIndex_RequiresSupport() {
+	as.loginAdmin(models.RoleViewer)
+
+	res := as.HTML("/admin/checkout_attempts").Get()
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,83 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// AdminFraudReviewsIndex lists the subscriptions the fraud check flagged, the open ones
+// first unless the status parameter asks for the decided ones
+func AdminFraudReviewsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	status := c.Param("status")
+	if status == "" {
+		status = models.FraudReviewOpen
+	}
+
+	query := tx.PaginateFromParams(c.Params()).
+		Eager("Subscription.Subscriber", "Subscription.Plan").
+		Where("status = ?", status).
+		Order("created_at asc")
+	reviews := models.FraudReviews{}
+	if err := query.All(&reviews); err != nil {
+		return err
+	}
+
+	c.Set("status", status)
+	c.Set("statuses", []string{models.FraudReviewOpen, models.FraudReviewApproved, models.FraudReviewRejected})
+	c.Set("reviews", reviews)
+	c.Set("pagination", query.Paginator)
+	return c.Render(http.StatusOK, r.HTML("admin/fraud_reviews/index.html"))
+}
+
+// AdminFraudReviewsApprove keeps the flagged subscription
+func AdminFraudReviewsApprove(c buffalo.Context) error {
+	return decideFraudReview(c, models.AdminActionFraudApprove, func(refunds *services.RefundService, review *models.FraudReview, operator string, notes string) error {
+		return services.ApproveFraudReview(refunds.Connection, review, operator, notes)
+	})
+}
+
+// AdminFraudReviewsReject refunds and cancels the flagged subscription
+func AdminFraudReviewsReject(c buffalo.Context) error {
+	return decideFraudReview(c, models.AdminActionFraudReject, services.RejectFraudReview)
+}
+
+func decideFraudReview(c buffalo.Context, actionName string, decide func(*services.RefundService, *models.FraudReview, string, string) error) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	review := &models.FraudReview{}
+	if err := tx.Eager("Subscription").Find(review, c.Param("fraud_review_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	refunds := services.NewRefundService(tx, RabbitMQ)
+	refunds.Gateway = refunds.Gateway.WithContext(c)
+	// Refunds the gateway made are kept even if this request fails afterwards
+	refunds.Ledger = models.DB
+	operator, _ := c.Value("operator").(string)
+	notes := c.Param("Notes")
+	err := decide(refunds, review, operator, notes)
+
+	action := models.AdminAction{
+		Action:       actionName,
+		SubscriberID: nulls.NewUUID(review.Subscription.SubscriberID),
+		SubjectType:  "subscription",
+		SubjectID:    review.SubscriptionID.String(),
+		Details:      notes,
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	if err != nil {
+		c.Flash().Add("danger", "Revisão não concluída: "+err.Error())
+	} else {
+		c.Flash().Add("success", "Revisão concluída.")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminFraudReviewsPath()")
+}
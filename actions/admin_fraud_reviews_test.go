@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// flaggedCheckout checks out a subscription the fraud check sends to review
+func (as *ActionSuite) flaggedCheckout() *models.FraudReview {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.useFraudChecker(fixedFraud{Decision: services.FraudReview, Score: 60, Reasons: []string{"disposable email domain mailinator.com"}})
+	as.checkout("credit_card", "any hash", "52998224725")
+
+	review := &models.FraudReview{}
+	as.NoError(models.DB.First(review))
+	return review
+}
+
+func (as *ActionSuite) Test_AdminFraudReviewsIndex() {
+	as.flaggedCheckout()
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/fraud_reviews").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Ana Souza")
+	as.Contains(res.Body.String(), "disposable email domain mailinator.com")
+}
+
+func (as *ActionSuite) Test_AdminFraudReviewsApprove() {
+	review := as.flaggedCheckout()
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/fraud_reviews/%s/approve", review.ID).Post(map[string]interface{}{"Notes": "cliente confirmou por telefone"})
+
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(models.DB.Eager("Subscription").Find(review, review.ID))
+	as.Equal(models.FraudReviewApproved, review.Status)
+	as.Equal("support@example.com", review.ReviewedBy.String)
+	as.Equal(models.SubscriptionPaid, review.Subscription.Status)
+}
+
+func (as *ActionSuite) Test_AdminFraudReviewsReject() {
+	review := as.flaggedCheckout()
+	as.loginAdmin(models.RoleFinance)
+
+	res := as.HTML("/admin/fraud_reviews/%s/reject", review.ID).Post(map[string]interface{}{"Notes": "cartão de terceiro"})
+
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(models.DB.Eager("Subscription").Find(review, review.ID))
+	as.Equal(models.FraudReviewRejected, review.Status)
+	as.Equal(models.SubscriptionCanceled, review.Subscription.Status)
+
+	count, err := models.DB.Count(&models.Refund{})
+	as.NoError(err)
+	as.Equal(1, count)
+
+	change := &models.SubscriptionStatusChange{}
+	as.NoError(models.DB.Where("subscription_id = ? AND to_status = ?", review.SubscriptionID, models.SubscriptionCanceled).First(change))
+	as.Equal("finance@example.com", change.ChangedBy)
+}
+
+func (as *ActionSuite) Test_AdminFraudReviewsReject_RequiresFinance() {
+	review := as.flaggedCheckout()
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/fraud_reviews/%s/reject", review.ID).Post(nil)
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,103 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"strconv"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// AdminPaymentsShow shows the payment with its refunds and the refund form
+func AdminPaymentsShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	payment := &models.Payment{}
+	if err := tx.Eager("Refunds", "Subscription").Find(payment, c.Param("payment_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	c.Set("payment", payment)
+	c.Set("refundable", payment.Total-payment.Refunds.Total())
+	return c.Render(http.StatusOK, r.HTML("admin/payments/show.html"))
+}
+
+// AdminPaymentsRefund refunds the payment from the admin form
+func AdminPaymentsRefund(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	// Bound by hand because an empty Amount, meaning "refund what is left", does not bind to an int
+	request := services.RefundRequest{
+		Reason:             c.Param("Reason"),
+		CancelSubscription: c.Param("CancelSubscription") == "true",
+	}
+	if amount := c.Param("Amount"); amount != "" {
+		var err error
+		if request.Amount, err = strconv.Atoi(amount); err != nil {
+			return c.Error(http.StatusBadRequest, err)
+		}
+	}
+
+	payment := &models.Payment{}
+	if err := tx.Eager("Subscription").Find(payment, c.Param("payment_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	refunds := services.NewRefundService(tx, RabbitMQ)
+	refunds.Gateway = refunds.Gateway.WithContext(c)
+	// Refunds the gateway made are kept even if this request fails afterwards
+	refunds.Ledger = models.DB
+	_, err := refunds.Refund(payment.ID, request)
+
+	action := models.AdminAction{
+		Action:       models.AdminActionRefund,
+		SubscriberID: nulls.NewUUID(payment.Subscription.SubscriberID),
+		SubjectType:  "payment",
+		SubjectID:    payment.ID.String(),
+		Details:      fmt.Sprintf("amount: %d, cancel subscription: %t, reason: %s", request.Amount, request.CancelSubscription, request.Reason),
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	if err != nil {
+		c.Flash().Add("danger", "Estorno não realizado: "+err.Error())
+	} else {
+		c.Flash().Add("success", "Estorno realizado.")
+	}
+
+	return c.Redirect(http.StatusSeeOther, "adminPaymentPath()", map[string]interface{}{"payment_id": c.Param("payment_id")})
+}
+
+// AdminPaymentsResendBoleto sends the open boleto of the payment to the subscriber again
+func AdminPaymentsResendBoleto(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	payment := &models.Payment{}
+	if err := tx.Eager("Subscription.Subscriber").Find(payment, c.Param("payment_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	err := services.ResendBoleto(tx, RabbitMQ, *payment, payment.Subscription.Subscriber)
+
+	action := models.AdminAction{
+		Action:       models.AdminActionResendBoleto,
+		SubscriberID: nulls.NewUUID(payment.Subscription.SubscriberID),
+		SubjectType:  "payment",
+		SubjectID:    payment.ID.String(),
+		Details:      payment.Subscription.Subscriber.Email,
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	if err != nil {
+		c.Flash().Add("danger", "Boleto não reenviado: "+err.Error())
+	} else {
+		c.Flash().Add("success", "Boleto reenviado para "+payment.Subscription.Subscriber.Email+".")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminSubscriberPath()", map[string]interface{}{"subscriber_id": payment.Subscription.SubscriberID})
+}
@@ -0,0 +1,62 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"net/http"
+	"subscription_service/services"
+	"time"
+)
+
+// businessReport builds the report of the months between the from and to parameters (YYYY-MM),
+// the last twelve months by default
+func businessReport(c buffalo.Context) (*services.BusinessReport, error) {
+	tx := c.Value("tx").(*pop.Connection)
+
+	from, to, err := services.ReportRange(c.Param("from"), c.Param("to"), time.Now())
+	if err != nil {
+		return nil, c.Error(http.StatusBadRequest, err)
+	}
+	return services.NewReportingService(tx).Run(from, to)
+}
+
+// AdminReportsIndex shows the MRR, churn, trial conversion and retention dashboard
+func AdminReportsIndex(c buffalo.Context) error {
+	report, err := businessReport(c)
+	if err != nil {
+		return err
+	}
+
+	c.Set("report", report)
+	return c.Render(http.StatusOK, r.HTML("admin/reports/index.html"))
+}
+
+// AdminReportsExport downloads the monthly metrics, or the cohorts with report=cohorts, as CSV
+func AdminReportsExport(c buffalo.Context) error {
+	report, err := businessReport(c)
+	if err != nil {
+		return err
+	}
+
+	name, write := "metricas", report.WriteMonthsCSV
+	if c.Param("report") == "cohorts" {
+		name, write = "coortes", report.WriteCohortsCSV
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s.csv\"", name, report.From, report.To))
+	return c.Render(http.StatusOK, r.Func("text/csv", func(w io.Writer, d render.Data) error {
+		return write(w)
+	}))
+}
+
+// ApiReportsShow returns the monthly metrics and cohorts as JSON, amounts in cents
+func ApiReportsShow(c buffalo.Context) error {
+	report, err := businessReport(c)
+	if err != nil {
+		return err
+	}
+	return c.Render(http.StatusOK, r.JSON(report))
+}
@@ -0,0 +1,138 @@
+package actions
+
+import (
+	"net/http"
+	"strings"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_AdminReportsIndex() {
+	as.loginAdmin(models.RoleFinance)
+
+	res := as.HTML("/admin/reports?from=2020-01&to=2020-03").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "2020-02")
+}
+
+func (as *ActionSuite) Test_AdminReportsIndex_RequiresFinance() {
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/reports").Get()
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminReportsExport() {
+	as.loginAdmin(models.RoleFinance)
+
+	res := as.HTML("/admin/reports/export?from=2020-01&to=2020-03&report=cohorts").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Header().Get("Content-Disposition"), "coortes-2020-01-2020-03.csv")
+	as.True(strings.HasPrefix(res.Body.String(), "cohort,customers,"))
+}
+
+func (as *ActionSuite) Test_ApiReportsShow() {
+	res := as.apiJSON([]string{models.ScopeReadReports}, "/api/v1/reports?from=2020-01&to=2020-03").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	report := services.BusinessReport{}
+	res.Bind(&report)
+	as.Len(report.Months, 3)
+	as.Len(report.Cohorts, 3)
+
+	res = as.apiJSON([]string{models.ScopeReadReports}, "/api/v1/reports?from=january").Get()
+	as.Equal(http.StatusBadRequest, res.Code)
+}
+
+// reportSubscription creates a subscription to the plan for the e-mail, with the status history
+// given as from, to and when the change was made, three values at a time
+func (as *ActionSuite) reportSubscription(plan models.Plan, email string, start string, status string, history ...string) {
+	subscriber := &models.Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Cliente", Email: email}
+	as.NoError(models.DB.Create(subscriber))
+	subscription := &models.Subscription{
+		ID:                   uuid.Must(uuid.NewV4()),
+		SubscriberID:         subscriber.ID,
+		PlanID:               plan.ID,
+		RemotePlanID:         plan.RemotePanID,
+		RemoteSubscriptionID: uuid.Must(uuid.NewV4()).String(),
+		StartDate:            reportDay(start),
+		ExpiresAt:            reportDay(start).AddDate(1, 0, 0),
+		Status:               status,
+	}
+	as.NoError(models.DB.Create(subscription))
+	as.NoError(models.DB.RawQuery("DELETE FROM subscription_status_changes WHERE subscription_id = ?", subscription.ID).Exec())
+
+	for i := 0; i+2 < len(history); i += 3 {
+		as.NoError(models.DB.Create(&models.SubscriptionStatusChange{
+			ID:             uuid.Must(uuid.NewV4()),
+			SubscriptionID: subscription.ID,
+			FromStatus:     history[i],
+			ToStatus:       history[i+1],
+			CreatedAt:      reportDay(history[i+2]),
+			UpdatedAt:      reportDay(history[i+2]),
+		}))
+	}
+}
+
+func reportDay(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func (as *ActionSuite) Test_ReportingService_Run() {
+	as.LoadFixture("plan catalog")
+	plan := models.Plan{}
+	as.NoError(models.DB.Where("remote_plan_id = ?", "1001").First(&plan))
+
+	// a pays since before the range; b pays from January to mid February; c starts a trial in
+	// January and pays from February; d starts a trial and gives up
+	as.reportSubscription(plan, "a@example.com", "2019-12-10", models.SubscriptionPaid)
+	as.reportSubscription(plan, "b@example.com", "2020-01-05", models.SubscriptionCanceled,
+		"", models.SubscriptionPaid, "2020-01-05",
+		models.SubscriptionPaid, models.SubscriptionCanceled, "2020-02-15")
+	as.reportSubscription(plan, "c@example.com", "2020-01-20", models.SubscriptionPaid,
+		"", models.SubscriptionTrialing, "2020-01-20",
+		models.SubscriptionTrialing, models.SubscriptionPaid, "2020-02-03")
+	as.reportSubscription(plan, "d@example.com", "2020-01-25", models.SubscriptionCanceled,
+		"", models.SubscriptionTrialing, "2020-01-25",
+		models.SubscriptionTrialing, models.SubscriptionCanceled, "2020-02-01")
+	// a checks out again in March with the e-mail in capitals, creating another subscriber
+	as.reportSubscription(plan, "A@Example.com", "2020-03-10", models.SubscriptionPaid)
+
+	c := &models.Subscription{}
+	as.NoError(models.DB.Where("start_date = ?", reportDay("2020-01-20")).First(c))
+	as.NoError(models.DB.Create(&models.Payment{
+		ID: uuid.Must(uuid.NewV4()), SubscriptionID: c.ID, TransactionID: "9001", Status: models.PaymentPaid, Total: 2990,
+		PaymentType: "credit_card", Installments: 1, CreatedAt: reportDay("2020-02-03"),
+	}))
+
+	report, err := services.NewReportingService(models.DB).Run(reportDay("2020-01-15"), reportDay("2020-03-31"))
+	as.NoError(err)
+	as.Len(report.Months, 3)
+
+	jan, feb, mar := report.Months[0], report.Months[1], report.Months[2]
+	as.Equal(services.MonthlyMetrics{
+		Month: "2020-01", MRR: 5980, ARR: 71760, NewMRR: 2990, NetNewMRR: 2990,
+		Customers: 2, NewCustomers: 1, ARPU: 2990,
+		TrialsStarted: 2, TrialsConverted: 1, TrialConversionRate: 0.5,
+	}, jan)
+	as.Equal(services.MonthlyMetrics{
+		Month: "2020-02", MRR: 5980, ARR: 71760, NewMRR: 2990, ChurnedMRR: 2990,
+		Customers: 2, NewCustomers: 1, ChurnedCustomers: 1, ChurnRate: 0.5, ARPU: 2990, Collected: 2990,
+	}, feb)
+	// The second subscriber of a is the same customer, expanding
+	as.Equal(services.MonthlyMetrics{
+		Month: "2020-03", MRR: 8970, ARR: 107640, ExpansionMRR: 2990, NetNewMRR: 2990,
+		Customers: 2, ARPU: 4485,
+	}, mar)
+
+	as.Len(report.Cohorts, 3)
+	as.Equal(services.Cohort{Month: "2020-01", Customers: 3, Retention: []float64{0.3333, 0.3333, 0.3333}}, report.Cohorts[0])
+	as.Equal(services.Cohort{Month: "2020-03", Customers: 0, Retention: []float64{0}}, report.Cohorts[2])
+}
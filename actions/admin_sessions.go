@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"math"
+	"net/http"
+	"subscription_service/services"
+	"time"
+)
+
+// AdminSessionsNew shows the admin login form
+func AdminSessionsNew(c buffalo.Context) error {
+	c.Set("email", "")
+	return c.Render(http.StatusOK, r.HTML("admin/sessions/new.html"))
+}
+
+// AdminSessionsCreate logs the admin user in, asking for the TOTP code of users with two-factor
+// enabled. Attempts are limited by account and by IP.
+func AdminSessionsCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	if retryAfter := takeRateLimits(c, services.LimitAdminLoginIP, services.LimitAdminLoginAccount); retryAfter > 0 {
+		c.Set("email", c.Param("Email"))
+		c.Flash().Add("danger", fmt.Sprintf("Muitas tentativas de login. Tente novamente em %d minuto(s).", int(math.Ceil(retryAfter.Minutes()))))
+		return c.Render(http.StatusTooManyRequests, r.HTML("admin/sessions/new.html"))
+	}
+
+	user, err := services.AuthenticateAdmin(tx, c.Param("Email"), c.Param("Password"), c.Param("Code"), time.Now())
+	if err == services.ErrInvalidLogin {
+		c.Set("email", c.Param("Email"))
+		c.Flash().Add("danger", "E-mail, senha ou código inválidos.")
+		return c.Render(http.StatusUnauthorized, r.HTML("admin/sessions/new.html"))
+	}
+	if err != nil {
+		return err
+	}
+
+	returnTo, _ := c.Session().Get("admin_return_to").(string)
+
+	// A new session for the logged in user, so an id planted before the login is worthless
+	c.Session().Clear()
+	c.Session().Set(adminSessionKey, user.ID.String())
+
+	if returnTo == "" {
+		return c.Redirect(http.StatusSeeOther, "adminSubscribersPath()")
+	}
+	return c.Redirect(http.StatusSeeOther, returnTo)
+}
+
+// AdminSessionsDestroy logs the admin user out
+func AdminSessionsDestroy(c buffalo.Context) error {
+	c.Session().Clear()
+	c.Flash().Add("success", "Sessão encerrada.")
+	return c.Redirect(http.StatusSeeOther, "adminLoginPath()")
+}
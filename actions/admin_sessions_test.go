@@ -0,0 +1,122 @@
+package actions
+
+import (
+	"net/http"
+	"os"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+)
+
+// loginAdmin creates an admin user with the role and logs it in to the test session
+func (as *ActionSuite) loginAdmin(role string) *models.AdminUser {
+	user := &models.AdminUser{Email: role + "@example.com", Name: role, Role: role, Password: "correct horse battery"}
+	verrs, err := user.Create(models.DB)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+
+	as.Session.Set(adminSessionKey, user.ID.String())
+	return user
+}
+
+func (as *ActionSuite) Test_AdminAuthenticate_RedirectsToLogin() {
+	res := as.HTML("/admin/subscribers").Get()
+
+	as.Equal(http.StatusFound, res.Code)
+	as.Equal("/admin/login/", res.Location())
+}
+
+// useTOTPKey sets the key the TOTP secrets are encrypted with for the test
+func (as *ActionSuite) useTOTPKey() {
+	os.Setenv("TOTP_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	as.T().Cleanup(func() { os.Unsetenv("TOTP_ENCRYPTION_KEY") })
+}
+
+func (as *ActionSuite) Test_AdminSessionsCreate() {
+	as.useRateLimits(services.NewRateLimiter().Limits)
+	user := &models.AdminUser{Email: "ana@example.com", Name: "Ana", Role: models.RoleViewer, Password: "correct horse battery"}
+	_, err := user.Create(models.DB)
+	as.NoError(err)
+
+	res := as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "wrong password"})
+	as.Equal(http.StatusUnauthorized, res.Code)
+	as.Contains(res.Body.String(), "E-mail, senha ou código inválidos")
+
+	res = as.HTML("/admin/login").Post(map[string]string{"Email": "ANA@example.com", "Password": "correct horse battery"})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal("/admin/subscribers/", res.Location())
+}
+
+func (as *ActionSuite) Test_AdminSessionsCreate_TwoFactor() {
+	as.useRateLimits(services.NewRateLimiter().Limits)
+	as.useTOTPKey()
+	secret, _ := services.GenerateTOTPSecret()
+	sealed, err := services.EncryptTOTPSecret(secret)
+	as.NoError(err)
+	user := &models.AdminUser{Email: "ana@example.com", Name: "Ana", Role: models.RoleViewer, Password: "correct horse battery", TOTPSecret: nulls.NewString(sealed)}
+	_, err = user.Create(models.DB)
+	as.NoError(err)
+
+	res := as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "correct horse battery"})
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	code, _ := services.TOTPCode(secret, time.Now())
+	res = as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "correct horse battery", "Code": code})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	// The code was used; it does not log in again
+	res = as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "correct horse battery", "Code": code})
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminSessionsCreate_EncryptsLegacySecret() {
+	as.useRateLimits(services.NewRateLimiter().Limits)
+	as.useTOTPKey()
+	secret, _ := services.GenerateTOTPSecret()
+	user := &models.AdminUser{Email: "ana@example.com", Name: "Ana", Role: models.RoleViewer, Password: "correct horse battery", TOTPSecret: nulls.NewString(secret)}
+	_, err := user.Create(models.DB)
+	as.NoError(err)
+
+	code, _ := services.TOTPCode(secret, time.Now())
+	res := as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "correct horse battery", "Code": code})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	as.NoError(models.DB.Reload(user))
+	as.True(services.TOTPSecretEncrypted(user.TOTPSecret.String))
+	as.True(user.TOTPLastStep.Valid)
+}
+
+func (as *ActionSuite) Test_AdminSessionsCreate_Throttled() {
+	as.useRateLimits(map[string]services.RateLimit{
+		services.LimitAdminLoginAccount: {Burst: 2, Period: 15 * time.Minute},
+		services.LimitAdminLoginIP:      {Burst: 3, Period: 10 * time.Minute},
+	})
+	user := &models.AdminUser{Email: "ana@example.com", Name: "Ana", Role: models.RoleViewer, Password: "correct horse battery"}
+	_, err := user.Create(models.DB)
+	as.NoError(err)
+
+	for i := 0; i < 2; i++ {
+		res := as.HTML("/admin/login").Post(map[string]string{"Email": "ana@example.com", "Password": "wrong password"})
+		as.Equal(http.StatusUnauthorized, res.Code)
+	}
+
+	// Even the right password waits once the account ran out of attempts
+	res := as.HTML("/admin/login").Post(map[string]string{"Email": "ANA@example.com", "Password": "correct horse battery"})
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.NotEmpty(res.Header().Get("Retry-After"))
+	as.Contains(res.Body.String(), "Muitas tentativas de login")
+
+	// Other accounts are limited by the address too
+	res = as.HTML("/admin/login").Post(map[string]string{"Email": "bia@example.com", "Password": "wrong password"})
+	as.Equal(http.StatusTooManyRequests, res.Code)
+}
+
+func (as *ActionSuite) Test_RequireRole_Forbidden() {
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/payments/00000000-0000-0000-0000-000000000000/refunds").Post(map[string]string{"Reason": "duplicate"})
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"regexp"
+	"strings"
+	"subscription_service/models"
+)
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// AdminSubscribersIndex searches subscribers by name, e-mail, CPF or remote subscription id
+func AdminSubscribersIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	term := strings.TrimSpace(c.Param("q"))
+	query := tx.PaginateFromParams(c.Params()).Order("created_at desc")
+	if term != "" {
+		like := "%" + strings.ToLower(term) + "%"
+		conditions := "lower(name) LIKE ? OR lower(email) LIKE ? OR id IN (SELECT subscriber_id FROM subscriptions WHERE remote_subscription_id = ?)"
+		args := []interface{}{like, like, term}
+
+		// CPFs are stored as typed, with or without punctuation
+		if cpf := nonDigits.ReplaceAllString(term, ""); cpf != "" {
+			conditions += " OR regexp_replace(document_number, '\\D', '', 'g') = ?"
+			args = append(args, cpf)
+		}
+		query = query.Where(conditions, args...)
+	}
+
+	subscribers := models.Subscribers{}
+	if err := query.All(&subscribers); err != nil {
+		return err
+	}
+
+	c.Set("q", term)
+	c.Set("subscribers", subscribers)
+	c.Set("pagination", query.Paginator)
+	return c.Render(http.StatusOK, r.HTML("admin/subscribers/index.html"))
+}
+
+// AdminSubscribersShow shows the subscriber with their subscriptions, status history and payments,
+// and what operators did to them
+func AdminSubscribersShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	subscriber := &models.Subscriber{}
+	if err := tx.Find(subscriber, c.Param("subscriber_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	subscriptions := models.Subscriptions{}
+	err := tx.Eager("Plan", "Payments", "StatusChanges").
+		Where("subscriber_id = ?", subscriber.ID).
+		Order("created_at desc").
+		All(&subscriptions)
+	if err != nil {
+		return err
+	}
+
+	actions := models.AdminActions{}
+	if err := tx.Where("subscriber_id = ?", subscriber.ID).Order("created_at desc").Limit(50).All(&actions); err != nil {
+		return err
+	}
+
+	c.Set("subscriber", subscriber)
+	c.Set("subscriptions", subscriptions)
+	c.Set("actions", actions)
+	return c.Render(http.StatusOK, r.HTML("admin/subscribers/show.html"))
+}
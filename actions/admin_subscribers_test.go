@@ -0,0 +1,58 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_AdminSubscribersIndex() {
+	as.loginAdmin(models.RoleViewer)
+
+	res := as.HTML("/admin/subscribers?q=123.456.789-00").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Nenhum assinante encontrado")
+}
+
+func (as *ActionSuite) Test_AdminSubscribersShow_NotFound() {
+	as.loginAdmin(models.RoleViewer)
+
+	id, _ := uuid.NewV4()
+	res := as.HTML("/admin/subscribers/%s", id).Get()
+
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminSubscribersShow_Payments() {
+	as.LoadFixture("subscribers with payments")
+	as.loginAdmin(models.RoleViewer)
+
+	subscriber := &models.Subscriber{}
+	as.NoError(as.DB.Where("email = ?", "ana@example.com").First(subscriber))
+
+	res := as.HTML("/admin/subscribers/%s", subscriber.ID).Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	as.Contains(body, "Ana Souza")
+	as.Contains(body, "Assinatura Profissional Mensal")
+	as.Contains(body, "<td>paid</td>")
+	as.Contains(body, "R$ 79,90")
+	for _, transactionID := range []string{"300001", "300002", "300003"} {
+		as.Contains(body, transactionID)
+	}
+	as.NotContains(body, "300011")
+}
+
+func (as *ActionSuite) Test_AdminSubscribersIndex_Search() {
+	as.LoadFixture("subscribers with payments")
+	as.loginAdmin(models.RoleViewer)
+
+	res := as.HTML("/admin/subscribers?q=bruno@example.com").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Bruno Lima")
+	as.NotContains(res.Body.String(), "Ana Souza")
+}
@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// AdminSubscriptionsCancel cancels the subscription at the gateway and here
+func AdminSubscriptionsCancel(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	subscription := &models.Subscription{}
+	if err := tx.Find(subscription, c.Param("subscription_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	reason := c.Param("Reason")
+	subscription.ChangedBy = c.Value("operator").(string)
+	err := services.CancelSubscription(tx, services.NewGatewayClient().WithContext(c), RabbitMQ, subscription, reason)
+
+	action := models.AdminAction{
+		Action:       models.AdminActionCancel,
+		SubscriberID: nulls.NewUUID(subscription.SubscriberID),
+		SubjectType:  "subscription",
+		SubjectID:    subscription.ID.String(),
+		Details:      reason,
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	if err != nil {
+		c.Flash().Add("danger", "Assinatura não cancelada: "+err.Error())
+	} else {
+		c.Flash().Add("success", "Assinatura cancelada.")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminSubscriberPath()", map[string]interface{}{"subscriber_id": subscription.SubscriberID})
+}
+
+// AdminSubscriptionsResync fetches the subscription from the gateway and applies what changed
+func AdminSubscriptionsResync(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	subscription := &models.Subscription{}
+	if err := tx.Find(subscription, c.Param("subscription_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	subscription.ChangedBy = c.Value("operator").(string)
+	renewals := services.NewRenewalService(tx, RabbitMQ)
+	renewals.Gateway = renewals.Gateway.WithContext(c)
+	transition, err := renewals.Reconcile(subscription, time.Now())
+
+	action := models.AdminAction{
+		Action:       models.AdminActionResync,
+		SubscriberID: nulls.NewUUID(subscription.SubscriberID),
+		SubjectType:  "subscription",
+		SubjectID:    subscription.ID.String(),
+		Details:      transition,
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	switch {
+	case err != nil:
+		c.Flash().Add("danger", "Não foi possível sincronizar com o gateway: "+err.Error())
+	case transition == "":
+		c.Flash().Add("info", "Assinatura já estava sincronizada com o gateway.")
+	default:
+		c.Flash().Add("success", "Assinatura sincronizada com o gateway: "+transition+".")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminSubscriberPath()", map[string]interface{}{"subscriber_id": subscription.SubscriberID})
+}
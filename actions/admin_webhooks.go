@@ -0,0 +1,153 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// AdminWebhooksIndex lists the webhook endpoints, with the form registering new ones
+func AdminWebhooksIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	endpoints := models.WebhookEndpoints{}
+	if err := tx.Order("created_at asc").All(&endpoints); err != nil {
+		return err
+	}
+
+	c.Set("endpoints", endpoints)
+	return c.Render(http.StatusOK, r.HTML("admin/webhooks/index.html"))
+}
+
+// AdminWebhooksCreate registers an endpoint, with a new secret shown on its page
+func AdminWebhooksCreate(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	endpoint, err := services.CreateWebhookEndpoint(tx, c.Param("URL"), c.Param("EventTypes"), c.Param("Description"))
+	if err != nil {
+		c.Flash().Add("danger", "Webhook não cadastrado: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "adminWebhooksPath()")
+	}
+
+	action := models.AdminAction{
+		Action:      models.AdminActionWebhookCreate,
+		SubjectType: "webhook_endpoint",
+		SubjectID:   endpoint.ID.String(),
+		Details:     endpoint.URL + " " + endpoint.EventTypes,
+	}
+	if err := recordAdminAction(c, action, nil); err != nil {
+		return err
+	}
+
+	c.Flash().Add("success", "Webhook cadastrado.")
+	return c.Redirect(http.StatusSeeOther, "adminWebhookPath()", map[string]interface{}{"webhook_endpoint_id": endpoint.ID})
+}
+
+// AdminWebhooksShow shows the endpoint, its secret and its latest deliveries with their attempts
+func AdminWebhooksShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	endpoint := models.WebhookEndpoint{}
+	if err := tx.Find(&endpoint, c.Param("webhook_endpoint_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	query := tx.PaginateFromParams(c.Params()).
+		Eager("AttemptLog").
+		Where("webhook_endpoint_id = ?", endpoint.ID).
+		Order("created_at desc")
+	if status := c.Param("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	deliveries := models.WebhookDeliveries{}
+	if err := query.All(&deliveries); err != nil {
+		return err
+	}
+
+	c.Set("endpoint", endpoint)
+	c.Set("deliveries", deliveries)
+	c.Set("status", c.Param("status"))
+	c.Set("statuses", []string{models.WebhookDeliveryPending, models.WebhookDeliveryDelivering, models.WebhookDeliverySucceeded, models.WebhookDeliveryFailed})
+	c.Set("pagination", query.Paginator)
+	return c.Render(http.StatusOK, r.HTML("admin/webhooks/show.html"))
+}
+
+// AdminWebhooksEnable sends events to the endpoint again, and the deliveries held while it was disabled
+func AdminWebhooksEnable(c buffalo.Context) error {
+	return toggleWebhookEndpoint(c, models.AdminActionWebhookEnable, func(tx *pop.Connection, endpoint *models.WebhookEndpoint, operator string) error {
+		return services.EnableWebhookEndpoint(tx, endpoint)
+	})
+}
+
+// AdminWebhooksDisable stops sending events to the endpoint
+func AdminWebhooksDisable(c buffalo.Context) error {
+	return toggleWebhookEndpoint(c, models.AdminActionWebhookDisable, func(tx *pop.Connection, endpoint *models.WebhookEndpoint, operator string) error {
+		return services.DisableWebhookEndpoint(tx, endpoint, "Desativado por "+operator, time.Now())
+	})
+}
+
+func toggleWebhookEndpoint(c buffalo.Context, actionName string, toggle func(*pop.Connection, *models.WebhookEndpoint, string) error) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	endpoint := &models.WebhookEndpoint{}
+	if err := tx.Find(endpoint, c.Param("webhook_endpoint_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	operator, _ := c.Value("operator").(string)
+	err := toggle(tx, endpoint, operator)
+
+	action := models.AdminAction{
+		Action:      actionName,
+		SubjectType: "webhook_endpoint",
+		SubjectID:   endpoint.ID.String(),
+		Details:     endpoint.URL,
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	if err != nil {
+		c.Flash().Add("danger", "Webhook não alterado: "+err.Error())
+	} else if endpoint.Enabled {
+		c.Flash().Add("success", "Webhook ativado.")
+	} else {
+		c.Flash().Add("success", "Webhook desativado.")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminWebhookPath()", map[string]interface{}{"webhook_endpoint_id": endpoint.ID})
+}
+
+// AdminWebhookDeliveriesRedeliver queues the event of the delivery again for its endpoint
+func AdminWebhookDeliveriesRedeliver(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	delivery := models.WebhookDelivery{}
+	if err := tx.Eager("WebhookEndpoint").Find(&delivery, c.Param("webhook_delivery_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	redelivery, err := services.NewWebhookService(tx).Redeliver(delivery)
+
+	action := models.AdminAction{
+		Action:      models.AdminActionWebhookRedeliver,
+		SubjectType: "webhook_delivery",
+		SubjectID:   delivery.ID.String(),
+		Details:     delivery.EventType + " " + delivery.EventID.String(),
+	}
+	if rerr := recordAdminAction(c, action, err); rerr != nil {
+		return rerr
+	}
+
+	switch {
+	case err != nil:
+		c.Flash().Add("danger", "Evento não reenviado: "+err.Error())
+	case !delivery.WebhookEndpoint.Enabled:
+		c.Flash().Add("success", "Evento agendado; será enviado quando o webhook for ativado.")
+	default:
+		c.Flash().Add("success", "Evento agendado para reenvio ("+redelivery.ID.String()+").")
+	}
+	return c.Redirect(http.StatusSeeOther, "adminWebhookPath()", map[string]interface{}{"webhook_endpoint_id": delivery.WebhookEndpointID})
+}
@@ -0,0 +1,147 @@
+package actions
+
+import (
+	"errors"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"net/http/httptest"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// webhookEndpoint registers an endpoint for all events at the URL
+func (as *ActionSuite) webhookEndpoint(url string) *models.WebhookEndpoint {
+	endpoint, err := services.CreateWebhookEndpoint(models.DB, url, "*", "")
+	as.NoError(err)
+	return endpoint
+}
+
+func (as *ActionSuite) Test_AdminWebhooksCreate() {
+	as.loginAdmin(models.RoleAdmin)
+
+	res := as.HTML("/admin/webhooks").Post(map[string]interface{}{
+		"URL":        "https://erp.example.com/hooks",
+		"EventTypes": "subscription.*  payment.refunded",
+	})
+
+	as.Equal(http.StatusSeeOther, res.Code)
+	endpoint := &models.WebhookEndpoint{}
+	as.NoError(models.DB.First(endpoint))
+	as.Equal("subscription.* payment.refunded", endpoint.EventTypes)
+	as.True(endpoint.Enabled)
+	as.Contains(endpoint.Secret, "whsec_")
+
+	res = as.HTML("/admin/webhooks/%s", endpoint.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), endpoint.Secret)
+}
+
+func (as *ActionSuite) Test_AdminWebhooksCreate_Invalid() {
+	as.loginAdmin(models.RoleAdmin)
+
+	res := as.HTML("/admin/webhooks").Post(map[string]interface{}{"URL": "not a url", "EventTypes": "*"})
+
+	as.Equal(http.StatusSeeOther, res.Code)
+	count, err := models.DB.Count(&models.WebhookEndpoint{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
+
+func (as *ActionSuite) Test_AdminWebhooks_RequiresAdmin() {
+	as.loginAdmin(models.RoleFinance)
+
+	res := as.HTML("/admin/webhooks").Get()
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminWebhooksDisableAndEnable() {
+	endpoint := as.webhookEndpoint("https://erp.example.com/hooks")
+	as.loginAdmin(models.RoleAdmin)
+
+	res := as.HTML("/admin/webhooks/%s/disable", endpoint.ID).Post(nil)
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(models.DB.Reload(endpoint))
+	as.False(endpoint.Enabled)
+	as.Equal("Desativado por admin@example.com", endpoint.DisabledReason)
+
+	res = as.HTML("/admin/webhooks/%s/enable", endpoint.ID).Post(nil)
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(models.DB.Reload(endpoint))
+	as.True(endpoint.Enabled)
+	as.False(endpoint.DisabledAt.Valid)
+}
+
+func (as *ActionSuite) Test_Webhooks_DeliverAndRedeliver() {
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get(services.WebhookSignatureHeader))
+	}))
+	defer server.Close()
+	endpoint := as.webhookEndpoint(server.URL)
+	_, err := services.CreateWebhookEndpoint(models.DB, "https://other.example.com/hooks", "payment.*", "")
+	as.NoError(err)
+
+	as.NoError(RabbitMQ.PublishEvent(models.DB, services.NewEvent("subscription.canceled", map[string]string{"id": "1"})))
+
+	deliveries := models.WebhookDeliveries{}
+	as.NoError(models.DB.Where("webhook_endpoint_id = ?", endpoint.ID).All(&deliveries))
+	as.Len(deliveries, 1)
+
+	report, err := services.NewWebhookService(models.DB).DeliverPending(10)
+	as.NoError(err)
+	as.Equal(1, report.Succeeded)
+	as.Len(signatures, 1)
+
+	as.loginAdmin(models.RoleAdmin)
+	res := as.HTML("/admin/webhook_deliveries/%s/redeliver", deliveries[0].ID).Post(nil)
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	redeliveries := models.WebhookDeliveries{}
+	as.NoError(models.DB.Where("event_id = ?", deliveries[0].EventID).All(&redeliveries))
+	as.Len(redeliveries, 2)
+}
+
+func (as *ActionSuite) Test_Webhooks_DisableFailingEndpoint() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	endpoint := as.webhookEndpoint(server.URL)
+
+	for i := 0; i < 3; i++ {
+		as.NoError(RabbitMQ.PublishEvent(models.DB, services.NewEvent("payment.refunded", map[string]int{"n": i})))
+	}
+
+	service := services.NewWebhookService(models.DB)
+	service.DisableAfter = 2
+	report, err := service.DeliverPending(10)
+	as.NoError(err)
+	as.Equal(services.WebhookReport{Retried: 2, Disabled: 1}, report)
+
+	as.NoError(models.DB.Reload(endpoint))
+	as.False(endpoint.Enabled)
+	as.Equal(2, endpoint.ConsecutiveFailures)
+
+	delivery := &models.WebhookDelivery{}
+	as.NoError(models.DB.Eager("AttemptLog").Where("attempts = 1").First(delivery))
+	as.Equal(models.WebhookDeliveryPending, delivery.Status)
+	as.Equal(http.StatusInternalServerError, delivery.LastStatusCode)
+	as.Len(delivery.AttemptLog, 1)
+	as.True(delivery.NextAttemptAt.After(time.Now()))
+}
+
+func (as *ActionSuite) Test_Webhooks_RolledBackEventsAreNotQueued() {
+	as.webhookEndpoint("https://erp.example.com/hooks")
+
+	err := models.DB.Transaction(func(tx *pop.Connection) error {
+		as.NoError(RabbitMQ.PublishEvent(tx, services.NewEvent("subscription.canceled", map[string]string{"id": "1"})))
+		return errors.New("the change failed")
+	})
+	as.Error(err)
+
+	count, err := models.DB.Count(&models.WebhookDelivery{})
+	as.NoError(err)
+	as.Equal(0, count)
+}
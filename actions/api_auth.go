@@ -0,0 +1,69 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"net/http"
+	"strings"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// jwtVerifier checks the JWTs of other services; nil when only API keys are accepted
+var jwtVerifier *services.JWTVerifier
+
+// APIAuthenticate requires an API key or a JWT in the Authorization header, e.g.
+// "Authorization: Bearer ssk_1a2b3c4d_...", and puts the authenticated services.Principal
+// in the context as "api_principal". Use RequireScope on each route to authorize it.
+func APIAuthenticate(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		token := strings.TrimSpace(strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer "))
+		if token == "" {
+			return apiUnauthorized(c, "missing credentials")
+		}
+
+		var principal *services.Principal
+		var err error
+		switch {
+		case services.IsAPIKeyToken(token):
+			// Outside the request transaction, so last_used_at is kept even when the request fails
+			principal, err = services.AuthenticateAPIKey(models.DB, token, time.Now())
+		case jwtVerifier != nil:
+			principal, err = jwtVerifier.Verify(token)
+		default:
+			err = services.ErrUnauthenticated
+		}
+		if err == services.ErrUnauthenticated {
+			return apiUnauthorized(c, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		c.Set("api_principal", principal)
+		c.LogField("api_principal", principal.Kind+":"+principal.ID)
+		setAuditActor(c, models.ActorService, principal.Kind+":"+principal.ID)
+		return next(c)
+	}
+}
+
+// RequireScope lets the request through only when the authenticated principal has the scope
+func RequireScope(scope string, next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		principal, ok := c.Value("api_principal").(*services.Principal)
+		if !ok {
+			return apiUnauthorized(c, "missing credentials")
+		}
+		if !principal.HasScope(scope) {
+			c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			return c.Render(http.StatusForbidden, r.JSON(map[string]string{"error": "missing scope " + scope}))
+		}
+		return next(c)
+	}
+}
+
+func apiUnauthorized(c buffalo.Context, message string) error {
+	c.Response().Header().Set("WWW-Authenticate", `Bearer realm="subscription_service"`)
+	return c.Render(http.StatusUnauthorized, r.JSON(map[string]string{"error": message}))
+}
@@ -0,0 +1,60 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+
+	"github.com/gobuffalo/httptest"
+)
+
+// apiJSON builds a JSON request authenticated with a new API key holding the scopes
+func (as *ActionSuite) apiJSON(scopes []string, u string, args ...interface{}) *httptest.JSON {
+	_, token, err := services.GenerateAPIKey(models.DB, "test", scopes)
+	as.NoError(err)
+
+	req := as.JSON(u, args...)
+	req.Headers["Authorization"] = "Bearer " + token
+	return req
+}
+
+func (as *ActionSuite) Test_APIAuthenticate_MissingCredentials() {
+	res := as.JSON("/api/v1/entitlements?email=nobody@example.com").Get()
+
+	as.Equal(http.StatusUnauthorized, res.Code)
+	as.Contains(res.Header().Get("WWW-Authenticate"), "Bearer")
+}
+
+func (as *ActionSuite) Test_APIAuthenticate_InvalidKey() {
+	req := as.JSON("/api/v1/entitlements?email=nobody@example.com")
+	req.Headers["Authorization"] = "Bearer ssk_00000000_nope"
+	res := req.Get()
+
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) Test_APIAuthenticate_RevokedKey() {
+	key, token, err := services.GenerateAPIKey(models.DB, "test", []string{models.ScopeReadSubscriptions})
+	as.NoError(err)
+	as.NoError(services.RevokeAPIKey(models.DB, key.Prefix))
+
+	req := as.JSON("/api/v1/entitlements?email=nobody@example.com")
+	req.Headers["Authorization"] = "Bearer " + token
+	res := req.Get()
+
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) Test_RequireScope_Forbidden() {
+	res := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/payments/%s/refunds", "00000000-0000-0000-0000-000000000000").
+		Post(map[string]interface{}{"reason": "customer asked"})
+
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Contains(res.Header().Get("WWW-Authenticate"), "insufficient_scope")
+}
+
+func (as *ActionSuite) Test_RequireScope_AdminGrantsEverything() {
+	res := as.apiJSON([]string{models.ScopeAdmin}, "/api/v1/entitlements?email=nobody@example.com").Get()
+
+	as.Equal(http.StatusNotFound, res.Code)
+}
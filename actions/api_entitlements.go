@@ -0,0 +1,53 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// ApiEntitlementsShow answers what a subscriber, given by the email or subscriber_id query
+// parameter, may use through their active subscriptions. With feature=<key> it answers only
+// whether that feature is allowed, e.g. GET /api/v1/entitlements?email=a@b.com&feature=projects
+func ApiEntitlementsShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	lookup := services.EntitlementLookup{SubscriberID: c.Param("subscriber_id"), Email: c.Param("email")}
+	if lookup.SubscriberID == "" && lookup.Email == "" {
+		return c.Render(http.StatusBadRequest, r.JSON(map[string]string{"error": "email or subscriber_id is required"}))
+	}
+	if lookup.SubscriberID != "" {
+		if _, err := uuid.FromString(lookup.SubscriberID); err != nil {
+			return c.Render(http.StatusBadRequest, r.JSON(map[string]string{"error": fmt.Sprintf("invalid subscriber_id %q", lookup.SubscriberID)}))
+		}
+	}
+
+	entitlements := services.NewEntitlementService(tx)
+	set, err := entitlements.Resolve(lookup, time.Now())
+	if err != nil {
+		if models.IsNotFound(err) {
+			return c.Render(http.StatusNotFound, r.JSON(map[string]string{"error": "subscriber not found"}))
+		}
+		return err
+	}
+
+	c.Response().Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(entitlements.Cache.TTL.Seconds())))
+
+	if feature := c.Param("feature"); feature != "" {
+		entitlement, allowed := set.Allows(feature)
+		return c.Render(http.StatusOK, r.JSON(map[string]interface{}{
+			"subscriber_id": set.SubscriberID,
+			"feature":       feature,
+			"allowed":       allowed,
+			"limit":         entitlement.Limit,
+			"unlimited":     entitlement.Unlimited,
+		}))
+	}
+
+	return c.Render(http.StatusOK, r.JSON(set))
+}
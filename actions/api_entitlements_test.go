@@ -0,0 +1,57 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+)
+
+func (as *ActionSuite) Test_ApiEntitlementsShow_RequiresSubscriber() {
+	res := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/entitlements").Get()
+
+	as.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiEntitlementsShow_InvalidSubscriberID() {
+	res := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/entitlements?subscriber_id=not-a-uuid").Get()
+
+	as.Equal(http.StatusBadRequest, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiEntitlementsShow_NotFound() {
+	res := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/entitlements?email=nobody@example.com").Get()
+
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiEntitlementsShow_MergesCheckoutsOfTheEmail() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	scopes := []string{models.ScopeReadSubscriptions}
+
+	as.Equal(http.StatusOK, as.checkout("credit_card", "any hash", "52998224725").Code)
+	res := as.apiJSON(scopes, "/api/v1/entitlements?email=ANA@example.com&feature=api").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"allowed":false`)
+
+	// The second checkout creates another subscriber with the same e-mail, and drops the
+	// cached answer once its transaction commits
+	pro := &models.Plan{}
+	as.NoError(models.DB.Where("remote_plan_id = ?", "1004").First(pro))
+	as.Equal(http.StatusOK, as.checkout("credit_card", "any hash", "52998224725", map[string]interface{}{"PlanID": pro.ID}).Code)
+	count, err := models.DB.Where("email = ?", "ana@example.com").Count(&models.Subscriber{})
+	as.NoError(err)
+	as.Equal(2, count)
+
+	set := struct {
+		SubscriberIDs   []string            `json:"subscriber_ids"`
+		SubscriptionIDs []string            `json:"subscription_ids"`
+		Entitlements    models.Entitlements `json:"entitlements"`
+	}{}
+	res = as.apiJSON(scopes, "/api/v1/entitlements?email=ana@example.com").Get()
+	as.Equal(http.StatusOK, res.Code)
+	res.Bind(&set)
+	as.Len(set.SubscriberIDs, 2)
+	as.Len(set.SubscriptionIDs, 2)
+	as.Equal(20, set.Entitlements["projects"].Limit)
+	as.True(set.Entitlements["api"].Unlimited)
+}
@@ -0,0 +1,48 @@
+package actions
+
+import (
+	"errors"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// ApiPaymentsRefund refunds a payment, fully or partially, and answers with the refund record
+func ApiPaymentsRefund(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	request := services.RefundRequest{}
+	if err := c.Bind(&request); err != nil {
+		return c.Render(http.StatusBadRequest, r.JSON(map[string]string{"error": err.Error()}))
+	}
+
+	refunds := services.NewRefundService(tx, RabbitMQ)
+	refunds.Gateway = refunds.Gateway.WithContext(c)
+	// Refunds the gateway made are kept even if this request fails afterwards
+	refunds.Ledger = models.DB
+	refund, err := refunds.Refund(c.Param("payment_id"), request)
+	if err != nil {
+		var verrs *validate.Errors
+		switch {
+		case models.IsNotFound(err):
+			return c.Render(http.StatusNotFound, r.JSON(map[string]string{"error": "payment not found"}))
+		case errors.As(err, &verrs):
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+		case errors.Is(err, services.ErrNotRefundable), errors.Is(err, services.ErrRefundAmount):
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(map[string]string{"error": err.Error()}))
+		case errors.Is(err, services.ErrCircuitOpen):
+			return c.Render(http.StatusServiceUnavailable, r.JSON(map[string]string{"error": err.Error()}))
+		case refund != nil:
+			// The refund is kept as the gateway left it; what was to follow it, e.g. canceling
+			// the subscription, is rolled back and may be asked for again
+			return c.Render(http.StatusBadGateway, r.JSON(map[string]interface{}{"error": err.Error(), "refund": refund}))
+		default:
+			return err
+		}
+	}
+
+	return c.Render(http.StatusCreated, r.JSON(refund))
+}
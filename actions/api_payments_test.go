@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"net/http"
+	"os"
+	"subscription_service/models"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_ApiPaymentsRefund_NotFound() {
+	id, _ := uuid.NewV4()
+	res := as.apiJSON([]string{models.ScopeWriteSubscriptions}, "/api/v1/payments/%s/refunds", id).Post(map[string]interface{}{"reason": "customer asked"})
+
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiPaymentsRefund_KeptWhenCancelFails() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+	payment := &models.Payment{}
+	as.NoError(models.DB.First(payment))
+
+	// The refund goes through but the gateway can not be reached to cancel the subscription
+	previous := os.Getenv("PAYMENT_CANCEL_ENDPOINT")
+	os.Setenv("PAYMENT_CANCEL_ENDPOINT", "http://127.0.0.1:1/cancel")
+	as.T().Cleanup(func() { os.Setenv("PAYMENT_CANCEL_ENDPOINT", previous) })
+
+	res := as.apiJSON([]string{models.ScopeWriteSubscriptions}, "/api/v1/payments/%s/refunds", payment.ID).
+		Post(map[string]interface{}{"reason": "customer asked", "cancel_subscription": true})
+	as.Equal(http.StatusBadGateway, res.Code)
+
+	as.NoError(models.DB.Eager("Refunds").Find(payment, payment.ID))
+	as.Equal(models.PaymentRefunded, payment.Status)
+	as.Len(payment.Refunds, 1)
+	as.Equal(models.RefundSucceeded, payment.Refunds[0].Status)
+
+	res = as.apiJSON([]string{models.ScopeWriteSubscriptions}, "/api/v1/payments/%s/refunds", payment.ID).
+		Post(map[string]interface{}{"reason": "customer asked"})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiPaymentsRefund_CountsPending() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+	payment := &models.Payment{}
+	as.NoError(models.DB.First(payment))
+
+	// Another refund of 2000 is still waiting for the gateway
+	pending := &models.Refund{PaymentID: payment.ID, Kind: models.RefundKindRefund, Status: models.RefundPending, Amount: 2000, Reason: "customer asked"}
+	pending.ID, _ = uuid.NewV4()
+	as.NoError(models.DB.Create(pending))
+
+	res := as.apiJSON([]string{models.ScopeWriteSubscriptions}, "/api/v1/payments/%s/refunds", payment.ID).
+		Post(map[string]interface{}{"amount": 1000, "reason": "customer asked"})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	// What is left is refunded, the payment still partially until the other refund goes through
+	res = as.apiJSON([]string{models.ScopeWriteSubscriptions}, "/api/v1/payments/%s/refunds", payment.ID).
+		Post(map[string]interface{}{"reason": "customer asked"})
+	as.Equal(http.StatusCreated, res.Code)
+	as.NoError(models.DB.Reload(payment))
+	as.Equal(models.PaymentPartiallyRefunded, payment.Status)
+}
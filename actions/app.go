@@ -5,8 +5,12 @@ import (
 	"github.com/gobuffalo/envy"
 	forcessl "github.com/gobuffalo/mw-forcessl"
 	paramlogger "github.com/gobuffalo/mw-paramlogger"
+	"github.com/gobuffalo/pop/v5"
 	"github.com/unrolled/secure"
+	"log"
+	"net/http"
 	"subscription_service/services"
+	"time"
 
 	"subscription_service/models"
 
@@ -41,6 +45,34 @@ func App() *buffalo.App {
 		RabbitMQ.Connect()
 		RabbitMQ.GetChannel()
 
+		// Set RENEWAL_SCHEDULER_INTERVAL (e.g. "1h") to renew and expire subscriptions in process,
+		// otherwise run the subscriptions:renew task from a cron job.
+		if interval, err := time.ParseDuration(envy.Get("RENEWAL_SCHEDULER_INTERVAL", "")); err == nil {
+			services.StartRenewalScheduler(models.DB, RabbitMQ, interval)
+		}
+
+		// Set NOTIFICATIONS_SEND_INTERVAL (e.g. "1m") to send the queued emails in process,
+		// otherwise run the notifications:send task from a cron job.
+		if interval, err := time.ParseDuration(envy.Get("NOTIFICATIONS_SEND_INTERVAL", "")); err == nil {
+			services.StartNotificationSender(models.DB, interval)
+		}
+
+		// Events published from here on are also queued for the webhook endpoints. Set
+		// WEBHOOKS_DELIVERY_INTERVAL (e.g. "30s") to deliver them in process, otherwise run the
+		// webhooks:deliver task from a cron job.
+		services.Webhooks = services.NewWebhookService(models.DB)
+		if interval, err := time.ParseDuration(envy.Get("WEBHOOKS_DELIVERY_INTERVAL", "")); err == nil {
+			services.StartWebhookDeliverer(models.DB, interval)
+		}
+
+		// Set ENTITLEMENTS_EVENTS_BINDING (e.g. "subscription.*") so changes made by other
+		// replicas also drop the entitlements cached here.
+		if binding := envy.Get("ENTITLEMENTS_EVENTS_BINDING", ""); binding != "" {
+			if err := services.Entitlements.ListenSubscriptionEvents(RabbitMQ, binding); err != nil {
+				log.Println("Error listening to subscription events:", err)
+			}
+		}
+
 		app = buffalo.New(buffalo.Options{
 			Env:         ENV,
 			SessionName: "_subscription_service_session",
@@ -56,19 +88,89 @@ func App() *buffalo.App {
 		// Remove to disable this.
 		app.Use(csrf.New)
 
+		// Runs what waits for the transaction of the request to commit, e.g. dropping cached entitlements
+		app.Use(afterCommit)
+
 		// Wraps each request in a transaction.
 		//  c.Value("tx").(*pop.Connection)
 		// Remove to disable this.
 		app.Use(popmw.Transaction(models.DB))
 
+		// Attributes the changes made by each request in the audit log.
+		app.Use(AuditActor)
+
 		// Setup and use translations:
 		app.Use(translations())
 
+		// Probes of the orchestrator; they come over plain HTTP from inside the cluster, and the
+		// readiness probe checks the database itself instead of opening a transaction
+		app.GET("/healthz", Healthz)
+		app.GET("/readyz", Readyz)
+		app.Middleware.Skip(forceSSL(), Healthz, Readyz)
+		app.Middleware.Skip(paramlogger.ParameterLogger, Healthz, Readyz)
+		app.Middleware.Skip(csrf.New, Healthz, Readyz)
+		app.Middleware.Skip(popmw.Transaction(models.DB), Healthz, Readyz)
+
 		app.GET("/", HomeHandler)
 
 		app.GET("/plans/", PlansIndex)
 		app.GET("/subscribe/", SubscribeIndex)
-		app.POST("/subscribe/process", SubscribeProcess)
+		// Card testers hammer the checkout; limit it by IP and by email
+		app.POST("/subscribe/process", RateLimit(services.LimitCheckoutIP, services.LimitCheckoutEmail)(SubscribeProcess))
+		app.GET("/receipts/{receipt_id}", ReceiptsShow)
+		app.GET("/receipts/{receipt_id}/pdf", ReceiptsPDF).Name("receiptPdfPath")
+
+		// The gateway can not send our CSRF token; postbacks are signed instead, over the raw body.
+		webhooks := app.Group("/webhooks")
+		webhooks.Middleware.Remove(csrf.New)
+		webhooks.Use(RawBody)
+		webhooks.POST("/gateway", GatewayWebhook)
+
+		// Every admin user can look around; actions are unlocked by the user's role
+		admin := app.Group("/admin")
+		admin.Use(AdminAuthenticate)
+		admin.Middleware.Skip(AdminAuthenticate, AdminSessionsNew, AdminSessionsCreate)
+		admin.GET("/login", AdminSessionsNew)
+		admin.POST("/login", AdminSessionsCreate)
+		admin.POST("/logout", AdminSessionsDestroy)
+		admin.GET("/subscribers", AdminSubscribersIndex)
+		admin.GET("/subscribers/{subscriber_id}", AdminSubscribersShow)
+		admin.POST("/subscriptions/{subscription_id}/cancel", RequireRole(models.RoleSupport, AdminSubscriptionsCancel))
+		admin.POST("/subscriptions/{subscription_id}/resync", RequireRole(models.RoleSupport, AdminSubscriptionsResync))
+		admin.GET("/audit_logs", AdminAuditLogsIndex)
+		admin.GET("/checkout_attempts", RequireRole(models.RoleSupport, AdminCheckoutAttemptsIndex))
+		admin.GET("/fraud_reviews", RequireRole(models.RoleSupport, AdminFraudReviewsIndex))
+		admin.POST("/fraud_reviews/{fraud_review_id}/approve", RequireRole(models.RoleSupport, AdminFraudReviewsApprove))
+		admin.POST("/fraud_reviews/{fraud_review_id}/reject", RequireRole(models.RoleFinance, AdminFraudReviewsReject))
+		admin.GET("/webhooks", RequireRole(models.RoleAdmin, AdminWebhooksIndex))
+		admin.POST("/webhooks", RequireRole(models.RoleAdmin, AdminWebhooksCreate))
+		admin.GET("/webhooks/{webhook_endpoint_id}", RequireRole(models.RoleAdmin, AdminWebhooksShow))
+		admin.POST("/webhooks/{webhook_endpoint_id}/enable", RequireRole(models.RoleAdmin, AdminWebhooksEnable))
+		admin.POST("/webhooks/{webhook_endpoint_id}/disable", RequireRole(models.RoleAdmin, AdminWebhooksDisable))
+		admin.POST("/webhook_deliveries/{webhook_delivery_id}/redeliver", RequireRole(models.RoleAdmin, AdminWebhookDeliveriesRedeliver))
+		admin.GET("/reports", RequireRole(models.RoleFinance, AdminReportsIndex))
+		admin.GET("/reports/export", RequireRole(models.RoleFinance, AdminReportsExport))
+		admin.GET("/exports", RequireRole(models.RoleFinance, AdminExportsIndex))
+		admin.GET("/exports/{dataset}", RequireRole(models.RoleFinance, AdminExportsDownload))
+		admin.GET("/payments/{payment_id}", AdminPaymentsShow)
+		admin.POST("/payments/{payment_id}/refunds", RequireRole(models.RoleFinance, AdminPaymentsRefund))
+		admin.POST("/payments/{payment_id}/boleto", RequireRole(models.RoleSupport, AdminPaymentsResendBoleto))
+
+		// Other services authenticate with an API key or a JWT instead of the CSRF token
+		var err error
+		if jwtVerifier, err = services.NewJWTVerifierFromEnv(); err != nil {
+			app.Stop(err)
+		}
+		api := app.Group("/api/v1")
+		api.Middleware.Remove(csrf.New)
+		api.Use(APIAuthenticate)
+		api.Use(RateLimit(services.LimitAPIKey))
+		api.POST("/payments/{payment_id}/refunds", RequireScope(models.ScopeWriteSubscriptions, ApiPaymentsRefund))
+		api.GET("/entitlements", RequireScope(models.ScopeReadSubscriptions, ApiEntitlementsShow))
+		api.GET("/audit_logs", RequireScope(models.ScopeAdmin, ApiAuditLogsIndex))
+		api.GET("/reports", RequireScope(models.ScopeReadReports, ApiReportsShow))
+		api.GET("/exports/{dataset}", RequireScope(models.ScopeReadReports, ApiExportsDownload))
+
 		app.ServeFiles("/", assetsBox) // serve files from the public directory
 	}
 
@@ -98,3 +200,19 @@ func forceSSL() buffalo.MiddlewareFunc {
 		SSLProxyHeaders: map[string]string{"X-Forwarded-Proto": "https"},
 	})
 }
+
+// afterCommit runs what the request registered with services.AfterCommit once popmw commits
+// its transaction, which it does when the handler succeeds with a 2xx or 3xx status
+func afterCommit(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		err := next(c)
+		if tx, ok := c.Value("tx").(*pop.Connection); ok {
+			status := http.StatusOK
+			if res, ok := c.Response().(*buffalo.Response); ok {
+				status = res.Status
+			}
+			services.Finished(tx, err == nil && status >= 200 && status < 400)
+		}
+		return err
+	}
+}
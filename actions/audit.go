@@ -0,0 +1,157 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"subscription_service/models"
+)
+
+// AuditActor attributes the changes made during the request, in the audit log, to the
+// customer using the site. Admin, API and webhook routes refine the actor with setAuditActor.
+func AuditActor(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		tx, ok := c.Value("tx").(*pop.Connection)
+		if !ok {
+			return next(c)
+		}
+
+		release := models.SetAuditActor(tx, models.AuditActor{Type: models.ActorCustomer, RequestID: requestID(c)})
+		defer release()
+		return next(c)
+	}
+}
+
+// setAuditActor changes who the changes of the rest of the request are attributed to
+func setAuditActor(c buffalo.Context, actorType string, actorID string) {
+	if tx, ok := c.Value("tx").(*pop.Connection); ok {
+		models.SetAuditActor(tx, models.AuditActor{Type: actorType, ID: actorID, RequestID: requestID(c)})
+	}
+}
+
+// requestID prefers the id given by the calling service, so a change can be traced across services
+func requestID(c buffalo.Context) string {
+	if id := c.Request().Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	id, _ := c.Value("request_id").(string)
+	return id
+}
+
+// trustedProxies are the proxies in front of the app, set in TRUSTED_PROXIES as addresses or
+// CIDR ranges, e.g. "10.0.0.0/8,192.168.0.10". X-Forwarded-For is only believed when the
+// request comes from one of them; anyone else could send it to pass for another address.
+var trustedProxies = parseTrustedProxies(envy.Get("TRUSTED_PROXIES", ""))
+
+func parseTrustedProxies(list string) []*net.IPNet {
+	proxies := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring the trusted proxy %q: %v", entry, err)
+			continue
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func trustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the customer
+func clientIP(c buffalo.Context) string {
+	return requestIP(c.Request())
+}
+
+// requestIP is the address the request came from. When that is a trusted proxy, it is the
+// last entry of X-Forwarded-For that is not one of our proxies; earlier entries are whatever
+// the client sent.
+func requestIP(r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	if !trustedProxy(address) {
+		return address
+	}
+
+	entries := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		address = entry
+		if !trustedProxy(entry) {
+			break
+		}
+	}
+	return address
+}
+
+// auditLogsQuery filters the audit log by the auditable_type, auditable_id, actor_type,
+// actor_id and request_id parameters, newest first
+func auditLogsQuery(c buffalo.Context) *pop.Query {
+	tx := c.Value("tx").(*pop.Connection)
+
+	query := tx.PaginateFromParams(c.Params()).Order("created_at desc")
+	for _, column := range []string{"auditable_type", "auditable_id", "actor_type", "actor_id", "request_id"} {
+		if value := c.Param(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	return query
+}
+
+// AdminAuditLogsIndex lists the audit log
+func AdminAuditLogsIndex(c buffalo.Context) error {
+	query := auditLogsQuery(c)
+
+	logs := models.AuditLogs{}
+	if err := query.All(&logs); err != nil {
+		return err
+	}
+
+	c.Set("logs", logs)
+	c.Set("pagination", query.Paginator)
+	return c.Render(http.StatusOK, r.HTML("admin/audit_logs/index.html"))
+}
+
+// ApiAuditLogsIndex lists the audit log as JSON, with the pagination in the X-Pagination header
+func ApiAuditLogsIndex(c buffalo.Context) error {
+	query := auditLogsQuery(c)
+
+	logs := models.AuditLogs{}
+	if err := query.All(&logs); err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("X-Pagination", query.Paginator.String())
+	return c.Render(http.StatusOK, r.JSON(logs))
+}
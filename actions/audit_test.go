@@ -0,0 +1,69 @@
+package actions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"subscription_service/models"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_AdminAuditLogsIndex() {
+	subscriber := &models.Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	as.NoError(models.DB.Create(subscriber))
+
+	as.loginAdmin(models.RoleViewer)
+
+	res := as.HTML("/admin/audit_logs?auditable_id=%s", subscriber.ID).Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), subscriber.ID.String())
+	as.Contains(res.Body.String(), "ana@example.com")
+}
+
+func (as *ActionSuite) Test_ApiAuditLogsIndex_RequiresAdminScope() {
+	res := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/audit_logs").Get()
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiAuditLogsIndex() {
+	subscriber := &models.Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	as.NoError(models.DB.Create(subscriber))
+
+	res := as.apiJSON([]string{models.ScopeAdmin}, "/api/v1/audit_logs?auditable_type=subscriber&auditable_id=%s", subscriber.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	logs := models.AuditLogs{}
+	res.Bind(&logs)
+	as.Len(logs, 1)
+	as.Equal(models.AuditCreate, logs[0].Action)
+	as.NotEmpty(res.Header().Get("X-Pagination"))
+}
+
+// useTrustedProxies replaces the proxies X-Forwarded-For is believed from for the test
+func (as *ActionSuite) useTrustedProxies(list string) {
+	previous := trustedProxies
+	trustedProxies = parseTrustedProxies(list)
+	as.T().Cleanup(func() { trustedProxies = previous })
+}
+
+func (as *ActionSuite) Test_RequestIP() {
+	// httptest requests come from 192.0.2.1
+	request := func(forwarded string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return r
+	}
+
+	as.useTrustedProxies("")
+	as.Equal("192.0.2.1", requestIP(request("203.0.113.7")))
+
+	as.useTrustedProxies("192.0.2.0/24, 10.0.0.5")
+	as.Equal("192.0.2.1", requestIP(request("")))
+	as.Equal("203.0.113.7", requestIP(request("203.0.113.7")))
+	as.Equal("203.0.113.7", requestIP(request("198.51.100.1, 203.0.113.7, 10.0.0.5")))
+	as.Equal("10.0.0.5", requestIP(request("10.0.0.5")))
+}
@@ -0,0 +1,91 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// exportSheetNames are the sheet and file names of the datasets
+var exportSheetNames = map[string]string{
+	services.ExportSubscribers:   "assinantes",
+	services.ExportSubscriptions: "assinaturas",
+	services.ExportPayments:      "pagamentos",
+}
+
+// streamExport writes the dataset straight to the response as CSV, or XLSX with format=xlsx,
+// filtered by the from, to, plan_id, status, payment_type and gateway parameters
+func streamExport(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	dataset := c.Param("dataset")
+	name, ok := exportSheetNames[dataset]
+	if !ok {
+		return c.Error(http.StatusNotFound, services.ErrUnknownExport)
+	}
+	filter, err := services.ParseExportFilter(c.Param)
+	if err != nil {
+		return c.Error(http.StatusBadRequest, err)
+	}
+
+	format := c.Param("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		return c.Error(http.StatusBadRequest, fmt.Errorf("unknown format %q, use csv or xlsx", format))
+	}
+
+	res := c.Response()
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+
+	var sheet services.SheetWriter
+	if format == "xlsx" {
+		res.Header().Set("Content-Type", services.XLSXContentType)
+		res.WriteHeader(http.StatusOK)
+		sheet, err = services.NewXLSXSheetWriter(res, name)
+	} else {
+		res.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		res.WriteHeader(http.StatusOK)
+		sheet, err = services.NewCSVSheetWriter(res)
+	}
+
+	// The status is already sent: a failure from here on can only cut the file short and be
+	// logged; returning it would have buffalo write an error page into the file
+	if err == nil {
+		err = services.NewExportService(tx).Export(dataset, filter, sheet)
+	}
+	if err == nil {
+		err = sheet.Close()
+	}
+	if err != nil {
+		c.Logger().Errorf("exporting %s as %s: %v", dataset, format, err)
+	}
+	return nil
+}
+
+// AdminExportsIndex shows the export form
+func AdminExportsIndex(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	plans := models.Plans{}
+	if err := tx.Order("name asc").All(&plans); err != nil {
+		return err
+	}
+
+	c.Set("plans", plans)
+	return c.Render(http.StatusOK, r.HTML("admin/exports/index.html"))
+}
+
+// AdminExportsDownload downloads subscribers, subscriptions or payments as CSV or XLSX
+func AdminExportsDownload(c buffalo.Context) error {
+	return streamExport(c)
+}
+
+// ApiExportsDownload downloads subscribers, subscriptions or payments as CSV or XLSX
+func ApiExportsDownload(c buffalo.Context) error {
+	return streamExport(c)
+}
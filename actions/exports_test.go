@@ -0,0 +1,52 @@
+package actions
+
+import (
+	"net/http"
+	"strings"
+	"subscription_service/models"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_AdminExportsDownload_Payments() {
+	plan := &models.Plan{ID: uuid.Must(uuid.NewV4()), Name: "Mensal", IntervalUnit: models.IntervalMonth, IntervalCount: 1}
+	as.NoError(models.DB.Create(plan))
+	subscriber := &models.Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	as.NoError(models.DB.Create(subscriber))
+	subscription := &models.Subscription{ID: uuid.Must(uuid.NewV4()), SubscriberID: subscriber.ID, PlanID: plan.ID, Status: models.SubscriptionPaid, ExpiresAt: time.Now()}
+	as.NoError(models.DB.Create(subscription))
+	payment := &models.Payment{ID: uuid.Must(uuid.NewV4()), SubscriptionID: subscription.ID, Status: models.PaymentPaid, PaymentType: "boleto", Gateway: "pagarme", Total: 4990}
+	as.NoError(models.DB.Create(payment))
+
+	as.loginAdmin(models.RoleFinance)
+	res := as.HTML("/admin/exports/payments?payment_type=boleto").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Header().Get("Content-Disposition"), "pagamentos.csv")
+	lines := strings.Split(strings.TrimSpace(res.Body.String()), "\n")
+	as.Len(lines, 2)
+	as.Contains(lines[1], payment.ID.String())
+	as.Contains(lines[1], "49.9")
+	as.Contains(lines[1], "Mensal")
+	as.Contains(lines[1], "ana@example.com")
+}
+
+func (as *ActionSuite) Test_AdminExportsDownload_RequiresFinance() {
+	as.loginAdmin(models.RoleSupport)
+
+	res := as.HTML("/admin/exports/payments").Get()
+
+	as.Equal(http.StatusForbidden, res.Code)
+}
+
+func (as *ActionSuite) Test_ApiExportsDownload_XLSX() {
+	res := as.apiJSON([]string{models.ScopeReadReports}, "/api/v1/exports/subscribers?format=xlsx").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Header().Get("Content-Type"), "spreadsheetml")
+	as.True(strings.HasPrefix(res.Body.String(), "PK"))
+
+	res = as.apiJSON([]string{models.ScopeReadReports}, "/api/v1/exports/refunds").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
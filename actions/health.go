@@ -0,0 +1,30 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// Healthz is the liveness probe: 503 Service Unavailable once the RabbitMQ connection is
+// closed, since the process does not open it again and only a restart brings it back. The
+// database is left to Readyz; it comes back by itself, so its outages must not restart pods.
+func Healthz(c buffalo.Context) error {
+	report := services.RunHealthChecks(c.Request().Context(), services.LivenessChecks(RabbitMQ), 2*time.Second)
+	if !report.Ready() {
+		return c.Render(http.StatusServiceUnavailable, r.JSON(report))
+	}
+	return c.Render(http.StatusOK, r.JSON(report))
+}
+
+// Readyz is the readiness probe: 503 Service Unavailable, with the failed checks, while the
+// database or RabbitMQ can not be used, so no traffic is sent to the pod meanwhile
+func Readyz(c buffalo.Context) error {
+	report := services.RunHealthChecks(c.Request().Context(), services.ReadinessChecks(models.DB, RabbitMQ), 2*time.Second)
+	if !report.Ready() {
+		return c.Render(http.StatusServiceUnavailable, r.JSON(report))
+	}
+	return c.Render(http.StatusOK, r.JSON(report))
+}
@@ -0,0 +1,37 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/services"
+)
+
+func (as *ActionSuite) Test_Healthz() {
+	res := as.JSON("/healthz").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"status":"up"`)
+}
+
+func (as *ActionSuite) Test_Healthz_RabbitMQClosed() {
+	previous := RabbitMQ
+	RabbitMQ = services.NewRabbitMQ()
+	as.T().Cleanup(func() { RabbitMQ = previous })
+
+	res := as.JSON("/healthz").Get()
+
+	as.Equal(http.StatusServiceUnavailable, res.Code)
+	report := services.HealthReport{}
+	res.Bind(&report)
+	as.Equal(services.HealthDown, report.Checks["rabbitmq"].Status)
+}
+
+func (as *ActionSuite) Test_Readyz() {
+	res := as.JSON("/readyz").Get()
+
+	as.Equal(http.StatusOK, res.Code)
+	report := services.HealthReport{}
+	res.Bind(&report)
+	as.Equal(services.HealthUp, report.Status)
+	as.Equal(services.HealthUp, report.Checks["database"].Status)
+	as.Equal(services.HealthUp, report.Checks["rabbitmq"].Status)
+}
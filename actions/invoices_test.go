@@ -0,0 +1,37 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+)
+
+func (as *ActionSuite) Test_Invoices_RetryDue() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+
+	// Left pending, never attempted, e.g. by a process that died right after queueing it
+	invoice := &models.Invoice{}
+	as.NoError(models.DB.First(invoice))
+	invoice.Status, invoice.Attempts, invoice.NextAttemptAt = models.InvoicePending, 0, nulls.Time{}
+	as.NoError(models.DB.Update(invoice))
+
+	invoices, err := services.NewInvoiceService(models.DB)
+	as.NoError(err)
+	issued, err := invoices.RetryDue(time.Now())
+	as.NoError(err)
+	as.Equal(1, issued)
+
+	// Failed invoices without a next attempt were given up and stay failed
+	as.NoError(models.DB.Reload(invoice))
+	invoice.Status, invoice.NextAttemptAt = models.InvoiceFailed, nulls.Time{}
+	as.NoError(models.DB.Update(invoice))
+	issued, err = invoices.RetryDue(time.Now())
+	as.NoError(err)
+	as.Equal(0, issued)
+}
@@ -0,0 +1,148 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// recordingSender keeps the messages instead of sending them
+type recordingSender struct {
+	messages []services.MailMessage
+}
+
+func (s *recordingSender) Send(message services.MailMessage) error {
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func (as *ActionSuite) Test_Notifications_Checkout() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	res = as.checkout("boleto", "", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+
+	notifications := models.EmailNotifications{}
+	as.NoError(models.DB.Order("created_at").All(&notifications))
+	as.Len(notifications, 2)
+	as.Equal(models.NotificationWelcome, notifications[0].Kind)
+	as.Equal(models.NotificationBoletoIssued, notifications[1].Kind)
+	as.Equal(models.LocalePtBR, notifications[0].Locale)
+	as.Equal("ana@example.com", notifications[0].Recipient)
+
+	sender := &recordingSender{}
+	notifier := services.NewNotificationService(models.DB)
+	notifier.Sender = sender
+	report, err := notifier.SendPending(10)
+	as.NoError(err)
+	as.Equal(2, report.Sent)
+	as.Len(sender.messages, 2)
+	as.Equal("Sua assinatura do plano Básico Mensal está ativa", sender.messages[0].Subject)
+	as.Contains(sender.messages[0].HTML, "Olá, Ana Souza!")
+	as.Contains(sender.messages[0].Text, "/receipts/")
+	as.Contains(sender.messages[1].Text, "Código de barras")
+
+	// Sent emails are not sent again, nor queued twice
+	report, err = notifier.SendPending(10)
+	as.NoError(err)
+	as.Equal(0, report.Sent)
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.Where("id = ?", notifications[0].SubscriptionID).First(subscription))
+	as.NoError(services.Notify(models.DB, models.NotificationWelcome, *subscription, nil))
+	count, err := models.DB.Count(&models.EmailNotification{})
+	as.NoError(err)
+	as.Equal(2, count)
+}
+
+func (as *ActionSuite) Test_Notifications_QueuedAtOnce() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.First(subscription))
+
+	// Two changes queue the same email before either commits
+	first, err := models.DB.NewTransaction()
+	as.NoError(err)
+	second, err := models.DB.NewTransaction()
+	as.NoError(err)
+	as.NoError(services.Notify(first, models.NotificationSubscriptionCanceled, *subscription, nil))
+	queued := make(chan error)
+	go func() { queued <- services.Notify(second, models.NotificationSubscriptionCanceled, *subscription, nil) }()
+
+	time.Sleep(50 * time.Millisecond)
+	as.NoError(first.TX.Commit())
+	as.NoError(<-queued)
+	as.NoError(second.TX.Commit())
+
+	count, err := models.DB.Where("kind = ?", models.NotificationSubscriptionCanceled).Count(&models.EmailNotification{})
+	as.NoError(err)
+	as.Equal(1, count)
+}
+
+func (as *ActionSuite) Test_Notifications_Cancellation() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.First(subscription))
+	subscriber := &models.Subscriber{}
+	as.NoError(models.DB.Find(subscriber, subscription.SubscriberID))
+	subscriber.Locale = models.LocaleEn
+	as.NoError(models.DB.Update(subscriber))
+
+	as.NoError(services.CancelSubscription(models.DB, services.NewGatewayClient(), RabbitMQ, subscription, "customer asked"))
+
+	notification := &models.EmailNotification{}
+	as.NoError(models.DB.Where("kind = ?", models.NotificationSubscriptionCanceled).First(notification))
+	as.Equal(models.LocaleEn, notification.Locale)
+
+	message, err := services.NewNotificationService(models.DB).Render(*notification)
+	as.NoError(err)
+	as.Equal("Your Básico Mensal subscription was canceled", message.Subject)
+	as.Contains(message.Text, "will not be charged again")
+}
+
+func (as *ActionSuite) Test_Notifications_Retry() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+
+	notifier := services.NewNotificationService(models.DB)
+	notifier.Sender = failingSender{}
+	notifier.MaxAttempts = 2
+	report, err := notifier.SendPending(10)
+	as.NoError(err)
+	as.Equal(1, report.Retried)
+
+	notification := &models.EmailNotification{}
+	as.NoError(models.DB.First(notification))
+	as.Equal(models.NotificationPending, notification.Status)
+	as.Equal(1, notification.Attempts)
+	as.Contains(notification.LastError, "connection refused")
+
+	// Not due until the backoff passed
+	report, err = notifier.SendPending(10)
+	as.NoError(err)
+	as.Equal(services.NotificationReport{}, report)
+
+	notifier.Now = func() time.Time { return notification.SendAfter.Add(time.Second) }
+	report, err = notifier.SendPending(10)
+	as.NoError(err)
+	as.Equal(1, report.Failed)
+	as.NoError(models.DB.Reload(notification))
+	as.Equal(models.NotificationFailed, notification.Status)
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(message services.MailMessage) error {
+	return errors.New("dial tcp 127.0.0.1:587: connect: connection refused")
+}
@@ -1,6 +1,12 @@
 package actions
 
+import "net/http"
+
 func (as *ActionSuite) Test_Plans_Index() {
-	as.Fail("Not Implemented!")
-}
+	as.LoadFixture("plan catalog")
 
+	res := as.HTML("/plans/").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Básico Mensal")
+	as.Contains(res.Body.String(), "Profissional Anual")
+}
@@ -0,0 +1,64 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription_service/services"
+	"time"
+)
+
+// rateLimitKeys tell what each limit counts the requests by
+var rateLimitKeys = map[string]func(c buffalo.Context) string{
+	services.LimitCheckoutIP:        clientIP,
+	services.LimitCheckoutEmail:     paramEmail,
+	services.LimitAdminLoginIP:      clientIP,
+	services.LimitAdminLoginAccount: paramEmail,
+	services.LimitAPIKey: func(c buffalo.Context) string {
+		if principal, ok := c.Value("api_principal").(*services.Principal); ok {
+			return principal.Kind + ":" + principal.ID
+		}
+		return ""
+	},
+}
+
+func paramEmail(c buffalo.Context) string {
+	return strings.ToLower(strings.TrimSpace(c.Param("Email")))
+}
+
+// RateLimit answers 429 Too Many Requests, with Retry-After, once any of the named limits
+// of services.Limiter is exceeded. The API key limit needs APIAuthenticate to run first.
+func RateLimit(limits ...string) buffalo.MiddlewareFunc {
+	return func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			retryAfter := takeRateLimits(c, limits...)
+			if retryAfter == 0 {
+				return next(c)
+			}
+
+			if _, ok := c.Value("api_principal").(*services.Principal); ok {
+				return c.Render(http.StatusTooManyRequests, r.JSON(map[string]string{"error": "rate limit exceeded"}))
+			}
+			c.Set("retryMinutes", int(math.Ceil(retryAfter.Minutes())))
+			return c.Render(http.StatusTooManyRequests, r.HTML("subscribe/rate_limited.html"))
+		}
+	}
+}
+
+// takeRateLimits takes the request from each of the named limits of services.Limiter. When any
+// is exceeded it sets Retry-After and returns how long the client must wait.
+func takeRateLimits(c buffalo.Context, limits ...string) time.Duration {
+	var retryAfter time.Duration
+	for _, name := range limits {
+		if wait := services.Limiter.Allow(name, rateLimitKeys[name](c)); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		c.LogField("rate_limited", strings.Join(limits, ","))
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return retryAfter
+}
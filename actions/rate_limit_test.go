@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+// useRateLimits replaces the limits, and the buckets, of services.Limiter for the test
+func (as *ActionSuite) useRateLimits(limits map[string]services.RateLimit) {
+	previous := services.Limiter
+	services.Limiter = &services.RateLimiter{Store: services.NewMemoryRateLimitStore(), Limits: limits, Now: time.Now}
+	as.T().Cleanup(func() { services.Limiter = previous })
+}
+
+func (as *ActionSuite) Test_RateLimit_Checkout() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.useRateLimits(map[string]services.RateLimit{services.LimitCheckoutIP: {Burst: 1, Period: time.Minute}})
+
+	res := as.checkout("credit_card", "card_declined", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+
+	res = as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.Equal("60", res.Header().Get("Retry-After"))
+	as.Contains(res.Body.String(), "Tente novamente em 1 minuto")
+
+	count, err := models.DB.Count(&models.CheckoutAttempt{})
+	as.NoError(err)
+	as.Equal(1, count)
+}
+
+func (as *ActionSuite) Test_RateLimit_CheckoutEmail() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.useRateLimits(map[string]services.RateLimit{services.LimitCheckoutEmail: {Burst: 2, Period: time.Hour}})
+
+	as.checkout("credit_card", "card_declined", "52998224725")
+	as.checkout("credit_card", "card_declined", "52998224725")
+	res := as.checkout("credit_card", "card_declined", "52998224725")
+
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.Equal("1800", res.Header().Get("Retry-After"))
+}
+
+func (as *ActionSuite) Test_RateLimit_APIKey() {
+	as.useRateLimits(map[string]services.RateLimit{services.LimitAPIKey: {Burst: 1, Period: time.Second}})
+
+	req := as.apiJSON([]string{models.ScopeReadSubscriptions}, "/api/v1/entitlements?email=nobody@example.com")
+	as.Equal(http.StatusNotFound, req.Get().Code)
+
+	res := req.Get()
+	as.Equal(http.StatusTooManyRequests, res.Code)
+	as.Equal("1", res.Header().Get("Retry-After"))
+	as.Contains(res.Body.String(), "rate limit exceeded")
+}
+
+func (as *ActionSuite) Test_RateLimit_SpoofedForwardedFor() {
+	as.useTrustedProxies("")
+	as.useRateLimits(map[string]services.RateLimit{services.LimitAdminLoginIP: {Burst: 1, Period: 10 * time.Minute}})
+
+	req := as.HTML("/admin/login")
+	req.Headers["X-Forwarded-For"] = "203.0.113.1"
+	as.Equal(http.StatusUnauthorized, req.Post(map[string]string{"Email": "ana@example.com", "Password": "wrong password"}).Code)
+
+	// A new address in the header does not come with a new bucket
+	req = as.HTML("/admin/login")
+	req.Headers["X-Forwarded-For"] = "203.0.113.2"
+	as.Equal(http.StatusTooManyRequests, req.Post(map[string]string{"Email": "bia@example.com", "Password": "wrong password"}).Code)
+}
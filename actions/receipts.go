@@ -0,0 +1,39 @@
+package actions
+
+import (
+	"fmt"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"net/http"
+	"subscription_service/services"
+)
+
+// ReceiptsShow renders the receipt as a printable HTML page
+func ReceiptsShow(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	doc, err := services.NewReceiptService(tx).Document(c.Param("receipt_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	c.Set("receipt", doc)
+	return c.Render(http.StatusOK, r.HTML("receipts/show.html"))
+}
+
+// ReceiptsPDF downloads the receipt as a PDF file
+func ReceiptsPDF(c buffalo.Context) error {
+	tx := c.Value("tx").(*pop.Connection)
+
+	doc, err := services.NewReceiptService(tx).Document(c.Param("receipt_id"))
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"recibo-%s.pdf\"", doc.Receipt.Number))
+	return c.Render(http.StatusOK, r.Func("application/pdf", func(w io.Writer, d render.Data) error {
+		return doc.WritePDF(w)
+	}))
+}
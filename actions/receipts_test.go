@@ -0,0 +1,39 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+
+	"github.com/gofrs/uuid"
+)
+
+func (as *ActionSuite) Test_Receipts_Show_NotFound() {
+	id, _ := uuid.NewV4()
+	res := as.HTML("/receipts/%s", id).Get()
+
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_Receipts_Show() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.Equal(http.StatusOK, as.checkout("credit_card", "any hash", "52998224725").Code)
+	receipt := &models.Receipt{}
+	as.NoError(models.DB.Eager("Payment").First(receipt))
+	as.Equal(receipt.Payment.CreatedAt.Year(), receipt.Year)
+
+	// The receipt prints what it was issued with, whatever changed since
+	as.NoError(models.DB.RawQuery("UPDATE subscribers SET name = 'Ana Lima'").Exec())
+	as.NoError(models.DB.RawQuery("UPDATE plans SET name = 'Renamed'").Exec())
+
+	res := as.HTML("/receipts/%s", receipt.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	as.Contains(body, "Ana Souza")
+	as.NotContains(body, "Ana Lima")
+	as.NotContains(body, "Renamed")
+
+	// Anyone with the link can open it, so the CPF is masked
+	as.Contains(body, "***.982.247-**")
+	as.NotContains(body, "52998224725")
+}
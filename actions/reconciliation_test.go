@@ -0,0 +1,36 @@
+package actions
+
+import (
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+func (as *ActionSuite) Test_Reconciliation_RepairPublishesEvent() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	endpoint := as.webhookEndpoint("https://erp.example.com/hooks")
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.First(subscription))
+	subscription.Status = models.SubscriptionUnpaid
+	as.NoError(models.DB.Update(subscription))
+
+	now := time.Now()
+	report, err := services.NewReconciliationService(models.DB, RabbitMQ).Run(now.Add(-time.Hour), now.Add(time.Hour), true)
+	as.NoError(err)
+	as.Len(report.Issues, 1)
+	as.Equal(services.IssueSubscriptionStatus, report.Issues[0].Kind)
+	as.True(report.Issues[0].Repaired, report.Issues[0].RepairError)
+
+	as.NoError(models.DB.Reload(subscription))
+	as.Equal(models.SubscriptionPaid, subscription.Status)
+
+	deliveries := models.WebhookDeliveries{}
+	as.NoError(models.DB.Where("webhook_endpoint_id = ?", endpoint.ID).All(&deliveries))
+	as.Len(deliveries, 1)
+	as.Equal("subscription.status_changed", deliveries[0].EventType)
+}
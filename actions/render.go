@@ -3,6 +3,7 @@ package actions
 import (
 	"github.com/gobuffalo/buffalo/render"
 	"github.com/gobuffalo/packr/v2"
+	"subscription_service/services"
 )
 
 var r *render.Engine
@@ -19,6 +20,8 @@ func init() {
 
 		// Add template helpers here:
 		Helpers: render.Helpers{
+			"formatCents":   services.FormatCents,
+			"formatPercent": services.FormatPercent,
 			// for non-bootstrap form helpers uncomment the lines
 			// below and import "github.com/gobuffalo/helpers/forms"
 			// forms.FormKey:     forms.Form,
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"subscription_service/gatewaysim"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+func (as *ActionSuite) Test_Renewals_RecordPayment() {
+	as.LoadFixture("plan catalog")
+	simulator := as.useGatewaySimulator()
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.First(subscription))
+	renewedAt := subscription.ExpiresAt.Add(time.Hour).UTC().Truncate(time.Second)
+	simulator.Now = func() time.Time { return renewedAt }
+	remoteID, err := strconv.Atoi(subscription.RemoteSubscriptionID)
+	as.NoError(err)
+	as.NoError(simulator.Renew(remoteID, gatewaysim.OutcomeApprove))
+
+	transition, err := services.NewRenewalService(models.DB, RabbitMQ).Reconcile(subscription, renewedAt)
+	as.NoError(err)
+	as.Equal("subscription.renewed", transition)
+
+	// The renewal is dated when the gateway started the new period
+	payments := models.Payments{}
+	as.NoError(models.DB.Where("subscription_id = ?", subscription.ID).Order("created_at asc").All(&payments))
+	as.Len(payments, 2)
+	as.True(payments[1].CreatedAt.Equal(renewedAt), "renewal dated %s, want %s", payments[1].CreatedAt, renewedAt)
+
+	as.NoError(models.DB.Reload(subscription))
+	as.True(subscription.ExpiresAt.After(renewedAt))
+}
+
+func (as *ActionSuite) Test_Renewals_ReconcileTwice() {
+	as.LoadFixture("plan catalog")
+	simulator := as.useGatewaySimulator()
+	as.Equal(http.StatusOK, as.checkout("credit_card", "any hash", "52998224725").Code)
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.First(subscription))
+	stale := *subscription
+	renewedAt := subscription.ExpiresAt.Add(time.Hour).UTC().Truncate(time.Second)
+	simulator.Now = func() time.Time { return renewedAt }
+	remoteID, err := strconv.Atoi(subscription.RemoteSubscriptionID)
+	as.NoError(err)
+	as.NoError(simulator.Renew(remoteID, gatewaysim.OutcomeApprove))
+
+	renewals := services.NewRenewalService(models.DB, RabbitMQ)
+	transition, err := renewals.Reconcile(subscription, renewedAt)
+	as.NoError(err)
+	as.Equal("subscription.renewed", transition)
+
+	// A postback holding the subscription as it was before sees the renewal already applied
+	transition, err = renewals.Reconcile(&stale, renewedAt)
+	as.NoError(err)
+	as.Equal("", transition)
+	as.True(stale.ExpiresAt.Equal(subscription.ExpiresAt))
+
+	count, err := models.DB.Where("subscription_id = ?", subscription.ID).Count(&models.Payment{})
+	as.NoError(err)
+	as.Equal(2, count)
+}
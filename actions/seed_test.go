@@ -0,0 +1,94 @@
+package actions
+
+import (
+	"github.com/gobuffalo/nulls"
+	"strings"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+func (as *ActionSuite) Test_SeedService_Run() {
+	now := time.Date(2026, 6, 15, 0, 0, 0, 0, time.UTC)
+	summary, err := services.NewSeedService(models.DB).Run(services.SeedOptions{Subscribers: 30, Seed: 1, Now: now})
+	as.NoError(err)
+	as.Equal(7, summary.Plans)
+	as.Equal(30, summary.Subscribers)
+
+	plans := models.Plans{}
+	as.NoError(models.DB.Order("remote_plan_id").All(&plans))
+	as.Len(plans, 7)
+	byRemoteID := map[string]models.Plan{}
+	for _, plan := range plans {
+		byRemoteID[plan.RemotePanID] = plan
+	}
+	as.Equal("Plano Único", byRemoteID["1000"].Name)
+	as.False(byRemoteID["1000"].Active)
+	as.Equal("Básico Mensal", byRemoteID["1001"].Name)
+	as.Equal(2990, byRemoteID["1001"].PriceInCents())
+	as.Equal("Profissional Anual", byRemoteID["1004"].Name)
+	as.Equal(79900, byRemoteID["1004"].PriceInCents())
+	as.True(byRemoteID["1004"].Active)
+
+	features := models.PlanFeatures{}
+	as.NoError(models.DB.Where("plan_id = ?", byRemoteID["1003"].ID).All(&features))
+	limits := map[string]nulls.Int{}
+	for _, feature := range features {
+		limits[feature.FeatureKey] = feature.Limit
+	}
+	as.Equal(map[string]nulls.Int{"projects": nulls.NewInt(20), "reports": nulls.NewInt(10), "api": {}}, limits)
+
+	rate := &models.PlanInstallment{}
+	as.NoError(models.DB.Where("plan_id = ? AND installments = ?", byRemoteID["1004"].ID, 12).First(rate))
+	as.Equal(9.99, rate.InterestRate)
+
+	subscribers := models.Subscribers{}
+	as.NoError(models.DB.Order("email").All(&subscribers))
+	as.Len(subscribers, 30)
+	for _, subscriber := range subscribers {
+		as.True(strings.HasPrefix(subscriber.Email, "seed1."), subscriber.Email)
+		as.NotEmpty(subscriber.Name)
+		as.Len(subscriber.DocumentNumber, 11)
+	}
+
+	subscriptions := models.Subscriptions{}
+	as.NoError(models.DB.Eager("Plan", "Payments", "StatusChanges").All(&subscriptions))
+	as.Len(subscriptions, summary.Subscriptions)
+	statuses := []string{models.SubscriptionTrialing, models.SubscriptionPaid, models.SubscriptionPendingPayment,
+		models.SubscriptionUnpaid, models.SubscriptionCanceled, models.SubscriptionEnded}
+	payments := 0
+	for _, subscription := range subscriptions {
+		as.Contains(statuses, subscription.Status)
+		as.Equal(subscription.Plan.RemotePanID, subscription.RemotePlanID)
+		as.False(subscription.StartDate.After(now))
+
+		as.NotEmpty(subscription.StatusChanges)
+		last := subscription.StatusChanges[0]
+		for _, change := range subscription.StatusChanges {
+			as.Equal("db:seed", change.ChangedBy)
+			if change.CreatedAt.After(last.CreatedAt) {
+				last = change
+			}
+		}
+		as.Equal(subscription.Status, last.ToStatus)
+
+		for _, payment := range subscription.Payments {
+			as.Contains([]string{models.PaymentPaid, "waiting_payment", "refused"}, payment.Status)
+			as.Contains([]string{"credit_card", "boleto"}, payment.PaymentType)
+			expected := subscription.Plan.PriceInCents()
+			if option, ok := subscription.Plan.InstallmentOption(payment.Installments); ok && payment.PaymentType == "credit_card" {
+				expected = option.Total
+			}
+			as.Equal(expected, payment.Total, payment.TransactionID)
+			if payment.PaymentType == "boleto" {
+				as.Equal("https://boletos.example.com/"+payment.TransactionID, payment.BoletoURL)
+			}
+		}
+		payments += len(subscription.Payments)
+	}
+	as.Equal(summary.Payments, payments)
+
+	again, err := services.NewSeedService(models.DB).Run(services.SeedOptions{Subscribers: 30, Seed: 1, Now: now})
+	as.NoError(err)
+	as.Equal(services.SeedSummary{}, again)
+}
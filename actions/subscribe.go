@@ -16,18 +16,65 @@ func SubscribeIndex(c buffalo.Context) error {
 
 	tx := c.Value("tx").(*pop.Connection)
 
+	if err := setCheckoutPlan(c, tx); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	setCheckoutCaptcha(c, tx, "")
+
+	c.Set("GATEWAY_ENCRYPTION_KEY", os.Getenv("GATEWAY_ENCRYPTION_KEY"))
+	c.Set("form", &services.ProcessData{PaymentMethod: "credit_card", State: "SP"})
+
+	return c.Render(http.StatusOK, r.HTML("subscribe/index.html"))
+}
+
+// brazilianStates are the options of the state field of the checkout, in the order shown
+var brazilianStates = [][2]string{
+	{"AC", "Acre"}, {"AL", "Alagoas"}, {"AP", "Amapá"}, {"AM", "Amazonas"}, {"BA", "Bahia"},
+	{"CE", "Ceará"}, {"DF", "Distrito Federal"}, {"ES", "Espirito Santo"}, {"GO", "Goiás"},
+	{"MA", "Maranhão"}, {"MT", "Mato Grosso"}, {"MS", "Mato Grosso do Sul"}, {"MG", "Minas Gerais"},
+	{"PA", "Pará"}, {"PB", "Paraiba"}, {"PR", "Paraná"}, {"PE", "Pernambuco"}, {"PI", "Piauí"},
+	{"RJ", "Rio de Janeiro"}, {"RN", "Rio Grande do Norte"}, {"RS", "Rio Grande do Sul"},
+	{"RO", "Rondônia"}, {"RR", "Roraima"}, {"SC", "Santa Catarina"}, {"SP", "São Paulo"},
+	{"SE", "Sergipe"}, {"TO", "Tocantis"},
+}
+
+// setCheckoutPlan loads the plan given by the parameter plan_id, along with the installments it offers
+func setCheckoutPlan(c buffalo.Context, tx *pop.Connection) error {
 	// Allocate an empty Plan
 	plan := &models.Plan{}
 
 	// To find the Plan the parameter plan_id is used.
-	if err := tx.Find(plan, c.Param("plan_id")); err != nil {
-		return c.Error(http.StatusNotFound, err)
+	if err := tx.Eager("InstallmentRates").Find(plan, c.Param("plan_id")); err != nil {
+		return err
 	}
 
-	c.Set("GATEWAY_ENCRYPTION_KEY", os.Getenv("GATEWAY_ENCRYPTION_KEY"))
 	c.Set("plan", plan)
+	c.Set("installmentOptions", plan.InstallmentOptions())
+	c.Set("states", brazilianStates)
+	return nil
+}
 
-	return c.Render(http.StatusOK, r.HTML("subscribe/index.html"))
+// captchaEscalation decides when the checkout asks for a CAPTCHA
+var captchaEscalation = services.NewCaptchaEscalation()
+
+// setCheckoutCaptcha shows the CAPTCHA of services.Captcha on the checkout form once the
+// customer had repeated declines, reporting whether it is shown
+func setCheckoutCaptcha(c buffalo.Context, tx *pop.Connection, email string) bool {
+	c.Set("captcha", nil)
+	if services.Captcha == nil {
+		return false
+	}
+
+	required, err := captchaEscalation.Required(tx, clientIP(c), email)
+	if err != nil {
+		c.Logger().Errorf("checking the captcha escalation: %v", err)
+		return false
+	}
+	if required {
+		c.Set("captcha", services.Captcha.Widget())
+	}
+	return required
 }
 
 // Process the subscription
@@ -43,24 +90,61 @@ func SubscribeProcess(c buffalo.Context) error {
 		return err
 	}
 
+	c.Set("GATEWAY_ENCRYPTION_KEY", os.Getenv("GATEWAY_ENCRYPTION_KEY"))
+	if setCheckoutCaptcha(c, tx, processData.Email) {
+		widget := services.Captcha.Widget()
+		solved, err := services.Captcha.Verify(c.Param(widget.ResponseField), clientIP(c))
+		if err != nil {
+			// A provider down must not take checkout down; the rate limits still apply
+			c.Logger().Errorf("verifying the captcha: %v", err)
+			solved = true
+		}
+		if !solved {
+			c.Flash().Add("danger", T.Translate(c, "checkout_captcha"))
+			if err := setCheckoutPlan(c, tx); err != nil {
+				return c.Error(http.StatusNotFound, err)
+			}
+			c.Set("form", processData)
+			return c.Render(http.StatusOK, r.HTML("subscribe/index.html"))
+		}
+	}
+
 	service := services.NewPaymentService()
 	service.Connection = tx
 	service.RabbitMQ = RabbitMQ
+	service.Gateway = services.NewGatewayClient().WithContext(c)
+	service.ClientIP = clientIP(c)
+	service.UserAgent = c.Request().UserAgent()
+	if languages, ok := c.Value("languages").([]string); ok && len(languages) > 0 {
+		service.Locale = languages[0]
+	}
 	err := service.Process(*processData)
+	recordCheckoutAttempt(c, service, err)
 
-	c.Set("GATEWAY_ENCRYPTION_KEY", os.Getenv("GATEWAY_ENCRYPTION_KEY"))
+	if services.PaymentOutcomeUnknown(err) || (err != nil && service.Charged()) {
+		// The customer may have been charged, so asking to pay again could charge twice
+		if err := RabbitMQ.PublishEvent(tx, service.PendingEvent(err)); err != nil {
+			c.Logger().Errorf("publishing checkout.pending: %v", err)
+		}
+		c.Set("email", processData.Email)
+		return c.Render(http.StatusAccepted, r.HTML("subscribe/pending.html"))
+	}
 	if err != nil {
-		c.Flash().Add("Declined","Transação negada. Tente novamente.")
-		// Allocate an empty Plan
-		plan := &models.Plan{}
-
-		// To find the Plan the parameter plan_id is used.
-		if err := tx.Find(plan, c.Param("plan_id")); err != nil {
+		reason := services.DeclineReasonOf(err)
+		c.Flash().Add("danger", T.Translate(c, reason.TranslationID()))
+		if err := setCheckoutPlan(c, tx); err != nil {
 			return c.Error(http.StatusNotFound, err)
 		}
-
-		c.Set("plan", plan)
-		return c.Render(http.StatusOK, r.HTML("subscribe/index.html"))
+		setCheckoutCaptcha(c, tx, processData.Email)
+		c.Set("form", processData)
+
+		// Failures of our own roll the transaction back; declines are kept for analytics
+		status := http.StatusOK
+		if reason == services.DeclineSystemError {
+			c.Logger().Errorf("checkout failed: %v", err)
+			status = http.StatusInternalServerError
+		}
+		return c.Render(status, r.HTML("subscribe/index.html"))
 	}
 
 	if service.PaymentReturn.Status == "unpaid" {
@@ -70,3 +154,12 @@ func SubscribeProcess(c buffalo.Context) error {
 
 	return c.Render(http.StatusOK, r.HTML("subscribe/success.html"))
 }
+
+// recordCheckoutAttempt keeps how the checkout ended. It does not use the transaction of the
+// request, which failed checkouts roll back, and never fails the checkout.
+func recordCheckoutAttempt(c buffalo.Context, service *services.PaymentService, err error) {
+	attempt := service.Attempt(err)
+	if verrs, err := models.DB.ValidateAndCreate(attempt); err != nil || verrs.HasAny() {
+		c.Logger().Errorf("recording checkout attempt: %v %v", err, verrs)
+	}
+}
@@ -1,6 +1,321 @@
 package actions
 
+import (
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"subscription_service/gatewaysim"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
 func (as *ActionSuite) Test_Subscribe_Index() {
 	as.Fail("Not Implemented!")
 }
 
+// useGatewaySimulator points the gateway endpoints to a new simulator for the test. The fraud
+// check is turned off, since the tests check out many times with the same customer.
+func (as *ActionSuite) useGatewaySimulator() *gatewaysim.Simulator {
+	as.useFraudChecker(services.FraudCheckers["off"]())
+	as.useRateLimits(services.NewRateLimiter().Limits)
+	simulator := gatewaysim.New("")
+	simulator.Delay, simulator.Timeout, simulator.BoletoDelay = time.Millisecond, time.Second, time.Millisecond
+	server := httptest.NewServer(simulator)
+	as.T().Cleanup(server.Close)
+	as.T().Cleanup(gatewaysim.Setenv(server.URL))
+	return simulator
+}
+
+// useFraudChecker replaces the fraud check for the test
+func (as *ActionSuite) useFraudChecker(checker services.FraudChecker) {
+	previous := services.Fraud
+	services.Fraud = checker
+	as.T().Cleanup(func() { services.Fraud = previous })
+}
+
+// fixedFraud gives every checkout the same assessment
+type fixedFraud services.FraudAssessment
+
+func (f fixedFraud) Check(tx *pop.Connection, check services.FraudCheck) (services.FraudAssessment, error) {
+	return services.FraudAssessment(f), nil
+}
+
+// fakeCaptcha takes "solved" as the right answer
+type fakeCaptcha struct{}
+
+func (fakeCaptcha) Widget() services.CaptchaWidget {
+	return services.CaptchaWidget{ScriptURL: "/captcha.js", Class: "fake-captcha", SiteKey: "site-key", ResponseField: "captcha-response"}
+}
+
+func (fakeCaptcha) Verify(response string, remoteIP string) (bool, error) {
+	return response == "solved", nil
+}
+
+// checkout posts the checkout form for the Básico Mensal plan of the catalog fixture
+func (as *ActionSuite) checkout(method string, cardHash string, document string, extra ...map[string]interface{}) *httptest.ResponseRecorder {
+	plan := &models.Plan{}
+	as.NoError(models.DB.Where("remote_plan_id = ?", "1001").First(plan))
+
+	form := map[string]interface{}{
+		"PlanID":         plan.ID,
+		"PaymentMethod":  method,
+		"CardHash":       cardHash,
+		"Installments":   1,
+		"Name":           "Ana Souza",
+		"Email":          "ana@example.com",
+		"DocumentNumber": document,
+		"City":           "Pirapora",
+	}
+	for _, fields := range extra {
+		for field, value := range fields {
+			form[field] = value
+		}
+	}
+
+	req := as.HTML("/subscribe/process")
+	req.Headers["Accept-Language"] = "pt-BR"
+	return req.Post(form).ResponseRecorder
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_ApprovedCard() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Parabéns!")
+
+	subscription := &models.Subscription{}
+	as.NoError(models.DB.Eager("Payments").First(subscription))
+	as.Equal(models.SubscriptionPaid, subscription.Status)
+	as.Len(subscription.Payments, 1)
+	as.Equal(2990, subscription.Payments[0].Total)
+	as.Equal("credit_card", subscription.Payments[0].PaymentType)
+
+	attempt := &models.CheckoutAttempt{}
+	as.NoError(models.DB.First(attempt))
+	as.Equal(models.CheckoutSucceeded, attempt.Outcome)
+	as.Equal(subscription.RemoteSubscriptionID, attempt.RemoteSubscriptionID.String)
+	as.NotEmpty(attempt.IP)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_Boleto() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	res := as.checkout("boleto", "", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "/simulator/boletos/")
+
+	payment := &models.Payment{}
+	as.NoError(models.DB.First(payment))
+	as.Equal("waiting_payment", payment.Status)
+	as.NotEmpty(payment.BoletoBarcode)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_Declined() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	for _, magic := range []struct{ cardHash, document, message string }{
+		{"card_declined", "52998224725", "Transação negada pelo emissor"},
+		{"card_antifraud", "52998224725", "Tente outro cartão ou pague com boleto"},
+		{"card_no_funds", "52998224725", "não tem saldo ou limite"},
+		{"card_invalid", "52998224725", "Os dados do cartão não foram aceitos"},
+		{"", "11111111111", "Transação negada pelo emissor"},
+	} {
+		res := as.checkout("credit_card", magic.cardHash, magic.document)
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), magic.message)
+		// The form comes back as the customer filled it
+		as.Contains(res.Body.String(), `value="Ana Souza"`)
+		as.Contains(res.Body.String(), `value="Pirapora"`)
+	}
+
+	count, err := models.DB.Count(&models.Subscriber{})
+	as.NoError(err)
+	as.Equal(0, count)
+
+	attempts := models.CheckoutAttempts{}
+	as.NoError(models.DB.Where("outcome = ?", models.CheckoutDeclined).Order("created_at").All(&attempts))
+	as.Len(attempts, 5)
+	as.Equal(models.CheckoutDeclined, attempts[2].Outcome)
+	as.Equal("insufficient_funds", attempts[2].DeclineReason)
+	as.Equal("1016", attempts[2].AcquirerResponseCode)
+	as.Equal("ana@example.com", attempts[2].Email)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_OutcomeUnknown() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	for _, cardHash := range []string{"card_error", "card_timeout"} {
+		res := as.checkout("credit_card", cardHash, "52998224725")
+		as.Equal(http.StatusAccepted, res.Code)
+		as.Contains(res.Body.String(), "Estamos processando o seu pagamento")
+		as.Contains(res.Body.String(), "ana@example.com")
+	}
+
+	count, err := models.DB.Where("outcome = ?", models.CheckoutPending).Count(&models.CheckoutAttempt{})
+	as.NoError(err)
+	as.Equal(2, count)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_ChargedButNotSaved() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	// The card is charged, but the payment can not be saved
+	as.NoError(models.DB.RawQuery("ALTER TABLE payments ADD CONSTRAINT payments_refused_by_test CHECK (false) NOT VALID").Exec())
+	as.T().Cleanup(func() {
+		as.NoError(models.DB.RawQuery("ALTER TABLE payments DROP CONSTRAINT payments_refused_by_test").Exec())
+	})
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusAccepted, res.Code)
+	as.Contains(res.Body.String(), "Estamos processando o seu pagamento")
+
+	// Nothing is left half saved; reconciliation imports the subscription from the gateway
+	for _, model := range []interface{}{&models.Subscriber{}, &models.Subscription{}, &models.Payment{}} {
+		count, err := models.DB.Count(model)
+		as.NoError(err)
+		as.Equal(0, count)
+	}
+	attempt := &models.CheckoutAttempt{}
+	as.NoError(models.DB.First(attempt))
+	as.Equal(models.CheckoutPending, attempt.Outcome)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_FailuresAfterTheCharge() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+
+	// The card is charged, but neither the invoice nor the welcome email can be saved
+	for _, table := range []string{"invoices", "email_notifications"} {
+		table := table
+		as.NoError(models.DB.RawQuery("ALTER TABLE " + table + " ADD CONSTRAINT refused_by_test CHECK (false) NOT VALID").Exec())
+		as.T().Cleanup(func() {
+			as.NoError(models.DB.RawQuery("ALTER TABLE " + table + " DROP CONSTRAINT refused_by_test").Exec())
+		})
+	}
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Parabéns!")
+
+	// The checkout is kept along with the steps that did not fail
+	for _, model := range []interface{}{&models.Subscriber{}, &models.Subscription{}, &models.Payment{}, &models.Receipt{}} {
+		count, err := models.DB.Count(model)
+		as.NoError(err)
+		as.Equal(1, count)
+	}
+	for _, model := range []interface{}{&models.Invoice{}, &models.EmailNotification{}} {
+		count, err := models.DB.Count(model)
+		as.NoError(err)
+		as.Equal(0, count)
+	}
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_SlowGateway() {
+	as.LoadFixture("plan catalog")
+	simulator := as.useGatewaySimulator()
+	simulator.Delay, simulator.Timeout = 100*time.Millisecond, 10*time.Second
+
+	// A slow acquirer still approves within the timeout of the client
+	for _, magic := range []struct{ cardHash, document string }{{"card_delay", "52998224725"}, {"", "44444444444"}} {
+		start := time.Now()
+		res := as.checkout("credit_card", magic.cardHash, magic.document)
+		as.Equal(http.StatusOK, res.Code)
+		as.Contains(res.Body.String(), "Parabéns!")
+		as.True(time.Since(start) >= simulator.Delay)
+	}
+
+	// One that does not answer is given up on, and the customer told it is being processed
+	previous, set := os.LookupEnv("GATEWAY_TIMEOUT")
+	os.Setenv("GATEWAY_TIMEOUT", "200ms")
+	as.T().Cleanup(func() {
+		if set {
+			os.Setenv("GATEWAY_TIMEOUT", previous)
+		} else {
+			os.Unsetenv("GATEWAY_TIMEOUT")
+		}
+	})
+	for _, magic := range []struct{ cardHash, document string }{{"card_timeout", "52998224725"}, {"", "55555555555"}} {
+		start := time.Now()
+		res := as.checkout("credit_card", magic.cardHash, magic.document)
+		as.Equal(http.StatusAccepted, res.Code)
+		as.Contains(res.Body.String(), "Estamos processando o seu pagamento")
+		as.True(time.Since(start) < simulator.Timeout)
+	}
+
+	paid, err := models.DB.Where("status = ?", models.SubscriptionPaid).Count(&models.Subscription{})
+	as.NoError(err)
+	as.Equal(2, paid)
+	pending, err := models.DB.Where("outcome = ?", models.CheckoutPending).Count(&models.CheckoutAttempt{})
+	as.NoError(err)
+	as.Equal(2, pending)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_FraudRejected() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.useFraudChecker(fixedFraud{Decision: services.FraudReject, Score: 120, Reasons: []string{"disposable email domain"}})
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Tente outro cartão ou pague com boleto")
+
+	count, err := models.DB.Count(&models.Subscriber{})
+	as.NoError(err)
+	as.Equal(0, count)
+
+	attempt := &models.CheckoutAttempt{}
+	as.NoError(models.DB.First(attempt))
+	as.Equal(models.CheckoutFraudRejected, attempt.Outcome)
+	as.Equal(120, attempt.FraudScore)
+	as.Equal(services.FraudReject, attempt.FraudDecision)
+	as.Equal("52998224725", attempt.DocumentNumber)
+	as.Equal(services.CardHashDigest("any hash"), attempt.CardHashDigest)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_FraudReview() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.useFraudChecker(fixedFraud{Decision: services.FraudReview, Score: 60, Reasons: []string{"3 attempts with the same email in 1h0m0s"}})
+
+	res := as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Parabéns!")
+
+	review := &models.FraudReview{}
+	as.NoError(models.DB.Eager("Subscription").First(review))
+	as.Equal(models.FraudReviewOpen, review.Status)
+	as.Equal(60, review.Score)
+	as.Equal(models.SubscriptionPaid, review.Subscription.Status)
+}
+
+func (as *ActionSuite) Test_SubscribeProcess_CaptchaAfterDeclines() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	previous := services.Captcha
+	services.Captcha = fakeCaptcha{}
+	as.T().Cleanup(func() { services.Captcha = previous })
+
+	res := as.checkout("credit_card", "card_declined", "52998224725")
+	as.NotContains(res.Body.String(), "fake-captcha")
+	as.checkout("credit_card", "card_declined", "52998224725")
+	res = as.checkout("credit_card", "card_declined", "52998224725")
+	as.Contains(res.Body.String(), `class="fake-captcha" data-sitekey="site-key"`)
+
+	res = as.checkout("credit_card", "any hash", "52998224725")
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Confirme que você não é um robô")
+	count, err := models.DB.Count(&models.CheckoutAttempt{})
+	as.NoError(err)
+	as.Equal(3, count)
+
+	res = as.checkout("credit_card", "any hash", "52998224725", map[string]interface{}{"captcha-response": "solved"})
+	as.Contains(res.Body.String(), "Parabéns!")
+}
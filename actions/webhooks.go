@@ -0,0 +1,56 @@
+package actions
+
+import (
+	"bytes"
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+// RawBody keeps the body of the request as it was sent, in "raw_body", for handlers checking
+// a signature of it. Buffalo parses form encoded bodies into the params before any middleware
+// runs, so the body is rewound first, and put back for whoever reads it next.
+func RawBody(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		req := c.Request()
+		if seeker, ok := req.Body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return c.Error(http.StatusBadRequest, err)
+			}
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return c.Error(http.StatusBadRequest, err)
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		c.Set("raw_body", body)
+		return next(c)
+	}
+}
+
+// GatewayWebhook receives the postbacks sent by the payment gateway
+func GatewayWebhook(c buffalo.Context) error {
+	body, _ := c.Value("raw_body").([]byte)
+	if !services.VerifyPostbackSignature(body, c.Request().Header.Get("X-Hub-Signature")) {
+		return c.Render(http.StatusUnauthorized, r.JSON(map[string]string{"error": "invalid signature"}))
+	}
+
+	postback, err := services.ParsePostback(body)
+	if err != nil {
+		return c.Error(http.StatusBadRequest, err)
+	}
+
+	setAuditActor(c, models.ActorWebhook, "gateway")
+
+	tx := c.Value("tx").(*pop.Connection)
+	if err := services.NewPostbackService(tx, RabbitMQ).Handle(postback); err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, r.JSON(map[string]string{"status": "ok"}))
+}
@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"subscription_service/gatewaysim"
+	"subscription_service/models"
+)
+
+func (as *ActionSuite) Test_GatewayWebhook_InvalidSignature() {
+	req := as.HTML("/webhooks/gateway")
+	req.Headers["X-Hub-Signature"] = "sha1=0000"
+	res := req.Post(map[string]string{"object": "transaction", "id": "1", "current_status": "chargedback"})
+
+	as.Equal(http.StatusUnauthorized, res.Code)
+}
+
+func (as *ActionSuite) Test_GatewayWebhook_Chargeback() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("credit_card", "any hash", "52998224725")
+	payment := &models.Payment{}
+	as.NoError(models.DB.First(payment))
+
+	// Signed over the form encoded body, the way the gateway sends it
+	postback := url.Values{
+		"object":              {"transaction"},
+		"id":                  {payment.TransactionID},
+		"event":               {"transaction_status_changed"},
+		"old_status":          {"paid"},
+		"current_status":      {"chargedback"},
+		"transaction[amount]": {"2990"},
+	}
+	req := as.HTML("/webhooks/gateway")
+	req.Headers["X-Hub-Signature"] = gatewaysim.Sign([]byte(postback.Encode()), os.Getenv("GATEWAY_APIKEY"))
+	res := req.Post(postback)
+	as.Equal(http.StatusOK, res.Code)
+
+	refunds := models.Refunds{}
+	as.NoError(models.DB.Where("payment_id = ?", payment.ID).All(&refunds))
+	as.Len(refunds, 1)
+	as.Equal(models.RefundKindChargeback, refunds[0].Kind)
+	as.Equal(2990, refunds[0].Amount)
+	as.NoError(models.DB.Reload(payment))
+	as.Equal(models.PaymentChargedback, payment.Status)
+}
+
+func (as *ActionSuite) Test_GatewayWebhook_BoletoPaid() {
+	as.LoadFixture("plan catalog")
+	as.useGatewaySimulator()
+	as.checkout("boleto", "", "52998224725")
+	payment := &models.Payment{}
+	as.NoError(models.DB.First(payment))
+	as.NotEqual(models.PaymentPaid, payment.Status)
+
+	postback := url.Values{
+		"object":         {"transaction"},
+		"id":             {payment.TransactionID},
+		"event":          {"transaction_status_changed"},
+		"old_status":     {payment.Status},
+		"current_status": {"paid"},
+	}
+	for i := 0; i < 2; i++ {
+		req := as.HTML("/webhooks/gateway")
+		req.Headers["X-Hub-Signature"] = gatewaysim.Sign([]byte(postback.Encode()), os.Getenv("GATEWAY_APIKEY"))
+		as.Equal(http.StatusOK, req.Post(postback).Code)
+	}
+
+	// Notified twice, the boleto is paid, with one receipt and one invoice
+	as.NoError(models.DB.Reload(payment))
+	as.Equal(models.PaymentPaid, payment.Status)
+	for _, model := range []interface{}{&models.Receipt{}, &models.Invoice{}} {
+		count, err := models.DB.Where("payment_id = ?", payment.ID).Count(model)
+		as.NoError(err)
+		as.Equal(1, count)
+	}
+}
@@ -0,0 +1,54 @@
+// Command gatewaysim serves the offline payment gateway simulator for development.
+// Point the PAYMENT_*_ENDPOINT variables it prints at startup to it, e.g.
+//
+//	go run ./cmd/gatewaysim -addr :4010
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"subscription_service/gatewaysim"
+)
+
+func main() {
+	addr := flag.String("addr", ":4010", "address to listen on")
+	baseURL := flag.String("base-url", "", "URL the simulator is reached at (default http://localhost<addr>)")
+	secretKey := flag.String("secret-key", "", "reject requests whose secret_key differs, PAYMENT_SECRET_KEY of the app")
+	delay := flag.Duration("delay", 0, "how long the delay outcome takes to approve")
+	timeout := flag.Duration("timeout", 0, "how long the timeout outcome hangs")
+	boletoDelay := flag.Duration("boleto-delay", 0, "how long the boleto_paid outcome takes to pay")
+	flag.Parse()
+
+	if *baseURL == "" {
+		*baseURL = "http://localhost" + *addr
+	}
+
+	simulator := gatewaysim.New(*baseURL)
+	simulator.SecretKey = *secretKey
+	if *delay > 0 {
+		simulator.Delay = *delay
+	}
+	if *timeout > 0 {
+		simulator.Timeout = *timeout
+	}
+	if *boletoDelay > 0 {
+		simulator.BoletoDelay = *boletoDelay
+	}
+
+	endpoints := gatewaysim.Endpoints(*baseURL)
+	names := make([]string, 0, len(endpoints))
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("# gateway simulator endpoints, add them to .env")
+	for _, name := range names {
+		fmt.Printf("%s=%s\n", name, endpoints[name])
+	}
+
+	log.Printf("gateway simulator listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, simulator))
+}
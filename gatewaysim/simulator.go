@@ -0,0 +1,905 @@
+// Package gatewaysim simulates the payment gateway proxy, so development and tests run
+// without the AWS endpoints or a pagar.me key. It keeps subscriptions and transactions in
+// memory and sends postbacks to the URL given at checkout, signed like the gateway does.
+//
+// The outcome of a checkout is picked by the card hash or, for boletos and forms that
+// cannot choose the hash, by the customer document number:
+//
+//	card hash         document number  outcome
+//	card_declined     11111111111      declined by the acquirer
+//	card_antifraud    22222222222      declined by the antifraud
+//	card_no_funds     77777777777      declined for insufficient funds
+//	card_invalid      88888888888      declined for invalid card data
+//	card_error        33333333333      the gateway answers 500
+//	card_delay        44444444444      approved after Delay
+//	card_timeout      55555555555      no answer until Timeout or the client gives up
+//	                  66666666666      boleto paid BoletoDelay after it is issued
+//
+// Anything else is approved; boletos wait for PayBoleto or POST /simulator/subscriptions/{id}/pay.
+package gatewaysim
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome is how the simulator answers a charge
+type Outcome string
+
+// Outcomes of a charge
+const (
+	OutcomeApprove   Outcome = "approve"
+	OutcomeDecline   Outcome = "decline"
+	OutcomeAntifraud Outcome = "antifraud"
+	OutcomeNoFunds   Outcome = "no_funds"
+	OutcomeInvalid   Outcome = "invalid_card"
+	OutcomeError     Outcome = "error"
+	OutcomeDelay     Outcome = "delay"
+	OutcomeTimeout   Outcome = "timeout"
+	// OutcomeBoletoPaid issues the boleto and pays it shortly after
+	OutcomeBoletoPaid Outcome = "boleto_paid"
+)
+
+// CardHashes are the magic card hashes and the outcome each one forces
+var CardHashes = map[string]Outcome{
+	"card_declined":  OutcomeDecline,
+	"card_antifraud": OutcomeAntifraud,
+	"card_no_funds":  OutcomeNoFunds,
+	"card_invalid":   OutcomeInvalid,
+	"card_error":     OutcomeError,
+	"card_delay":     OutcomeDelay,
+	"card_timeout":   OutcomeTimeout,
+}
+
+// DocumentNumbers are the magic customer document numbers and the outcome each one forces
+var DocumentNumbers = map[string]Outcome{
+	"11111111111": OutcomeDecline,
+	"22222222222": OutcomeAntifraud,
+	"33333333333": OutcomeError,
+	"44444444444": OutcomeDelay,
+	"55555555555": OutcomeTimeout,
+	"66666666666": OutcomeBoletoPaid,
+	"77777777777": OutcomeNoFunds,
+	"88888888888": OutcomeInvalid,
+}
+
+// refuseReasons are the refuse_reason the gateway gives for each kind of decline
+var refuseReasons = map[Outcome]string{
+	OutcomeDecline:   "acquirer",
+	OutcomeAntifraud: "antifraud",
+	OutcomeNoFunds:   "acquirer",
+	OutcomeInvalid:   "acquirer",
+}
+
+// acquirerResponseCodes are the acquirer_response_code of the charges the acquirer refused
+var acquirerResponseCodes = map[Outcome]string{
+	OutcomeDecline: "1000",
+	OutcomeNoFunds: "1016",
+	OutcomeInvalid: "1011",
+}
+
+// Plan is a plan as configured at the gateway
+type Plan struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+	Days   int    `json:"days"`
+}
+
+// DefaultPlans mirror the catalog created by the db:seed task, so plans:check agrees with it
+var DefaultPlans = map[int]Plan{
+	1000: {ID: 1000, Name: "Plano Único", Amount: 1990, Days: 30},
+	1001: {ID: 1001, Name: "Básico Mensal", Amount: 2990, Days: 30},
+	1002: {ID: 1002, Name: "Básico Anual", Amount: 29900, Days: 365},
+	1003: {ID: 1003, Name: "Profissional Mensal", Amount: 7990, Days: 30},
+	1004: {ID: 1004, Name: "Profissional Anual", Amount: 79900, Days: 365},
+	1005: {ID: 1005, Name: "Empresarial Mensal", Amount: 19990, Days: 30},
+	1006: {ID: 1006, Name: "Profissional Semestral", Amount: 41940, Days: 180},
+}
+
+// Customer is the customer attached to a subscription
+type Customer struct {
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	DocumentNumber string `json:"document_number"`
+	Address        struct {
+		Street        string `json:"street"`
+		StreetNumber  string `json:"street_number"`
+		Complementary string `json:"complementary"`
+		Neighborhood  string `json:"neighborhood"`
+		Zipcode       string `json:"zipcode"`
+	} `json:"address"`
+	Phone struct {
+		DDD    string `json:"ddd"`
+		Number string `json:"number"`
+	} `json:"phone"`
+}
+
+// Transaction is a charge of a subscription
+type Transaction struct {
+	ID                   int       `json:"id"`
+	Status               string    `json:"status"`
+	Amount               int       `json:"amount"`
+	RefundedAmount       int       `json:"refunded_amount"`
+	Installments         int       `json:"installments"`
+	PaymentMethod        string    `json:"payment_method"`
+	CardBrand            string    `json:"card_brand"`
+	CardLastDigits       string    `json:"card_last_digits"`
+	BoletoURL            string    `json:"boleto_url"`
+	BoletoBarcode        string    `json:"boleto_barcode"`
+	BoletoExpirationDate string    `json:"boleto_expiration_date"`
+	RefuseReason         string    `json:"refuse_reason"`
+	AcquirerResponseCode string    `json:"acquirer_response_code"`
+	SubscriptionID       int       `json:"subscription_id"`
+	CreatedAt            time.Time `json:"date_created"`
+}
+
+// Subscription is the subscription in the format the proxy answers with
+type Subscription struct {
+	Object             string      `json:"object"`
+	ID                 int         `json:"id"`
+	TransactionID      string      `json:"transaction_id"`
+	Status             string      `json:"status"`
+	CurrentTransaction Transaction `json:"current_transaction"`
+	PaymentMethod      string      `json:"payment_method"`
+	CardBrand          string      `json:"card_brand"`
+	CardLastDigits     string      `json:"card_last_digits"`
+	RemotePlanID       int         `json:"remote_plan_id"`
+	PostbackURL        string      `json:"postback_url"`
+	SoftDescriptor     string      `json:"soft_descriptor"`
+	Customer           Customer    `json:"customer"`
+	CurrentPeriodStart string      `json:"current_period_start"`
+	CurrentPeriodEnd   string      `json:"current_period_end"`
+	RefuseReason       string      `json:"refuse_reason"`
+	CreatedAt          time.Time   `json:"date_created"`
+	UpdatedAt          time.Time   `json:"date_updated"`
+
+	apiKey string
+	days   int
+}
+
+// Postback is a notification the simulator sent, with how the receiver answered
+type Postback struct {
+	URL        string     `json:"url"`
+	Values     url.Values `json:"values"`
+	StatusCode int        `json:"status_code"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Requests, as sent by the services GatewayClient and PaymentService
+type (
+	credentials struct {
+		SecretKey string `json:"secret_key"`
+		APIKey    string `json:"api_key"`
+	}
+	createRequest struct {
+		credentials
+		PlanID         int    `json:"plan_id"`
+		PaymentMethod  string `json:"payment_method"`
+		CardHash       string `json:"card_hash"`
+		Installments   int    `json:"installments"`
+		Amount         int    `json:"amount"`
+		SoftDescriptor string `json:"soft_descriptor"`
+		PostbackURL    string `json:"postback_url"`
+		Customer       struct {
+			Name           string `json:"name"`
+			Email          string `json:"email"`
+			DocumentNumber string `json:"document_number"`
+		} `json:"customer"`
+	}
+	subscriptionRequest struct {
+		credentials
+		SubscriptionID string `json:"subscription_id"`
+	}
+	planRequest struct {
+		credentials
+		PlanID string `json:"plan_id"`
+	}
+	refundRequest struct {
+		credentials
+		TransactionID string `json:"transaction_id"`
+		Amount        int    `json:"amount"`
+	}
+	listRequest struct {
+		credentials
+		CreatedFrom time.Time `json:"date_created_from"`
+		CreatedTo   time.Time `json:"date_created_to"`
+		Page        int       `json:"page"`
+		Count       int       `json:"count"`
+	}
+)
+
+func (c credentials) secret() string {
+	return c.SecretKey
+}
+
+// ErrNotFound is returned by the controls for subscriptions and transactions the simulator does not have
+var ErrNotFound = errors.New("not found")
+
+// Simulator is an http.Handler answering like the gateway proxy. Use it with httptest.NewServer
+// in tests, or run the gatewaysim command in development.
+type Simulator struct {
+	// BaseURL is where the simulator is served, used for the boleto links; the host of the
+	// first checkout when empty
+	BaseURL string
+	// SecretKey, when set, must match the secret_key of every request
+	SecretKey string
+	Plans     map[int]Plan
+	// Delay is how long OutcomeDelay takes to approve
+	Delay time.Duration
+	// Timeout is how long OutcomeTimeout hangs when the client does not give up before
+	Timeout time.Duration
+	// BoletoDelay is how long OutcomeBoletoPaid takes to pay the boleto
+	BoletoDelay time.Duration
+	Now         func() time.Time
+	// HTTPClient sends the postbacks
+	HTTPClient *http.Client
+
+	mu            sync.Mutex
+	lastID        int
+	subscriptions map[int]*Subscription
+	transactions  map[int]*Transaction
+	postbacks     []Postback
+	pending       sync.WaitGroup
+}
+
+// New creates a Simulator with the default plans, answering delays in seconds
+func New(baseURL string) *Simulator {
+	return &Simulator{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		Plans:         DefaultPlans,
+		Delay:         3 * time.Second,
+		Timeout:       2 * time.Minute,
+		BoletoDelay:   5 * time.Second,
+		Now:           time.Now,
+		HTTPClient:    &http.Client{Timeout: 10 * time.Second},
+		lastID:        100000,
+		subscriptions: map[int]*Subscription{},
+		transactions:  map[int]*Transaction{},
+	}
+}
+
+// Endpoints maps the environment variables read by the services to the simulator endpoints
+func Endpoints(baseURL string) map[string]string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return map[string]string{
+		"PAYMENT_SUBSCRIPTION_ENDPOINT":       baseURL + "/subscription",
+		"PAYMENT_FETCH_SUBSCRIPTION_ENDPOINT": baseURL + "/subscription/fetch",
+		"PAYMENT_CANCEL_ENDPOINT":             baseURL + "/subscription/cancel",
+		"PAYMENT_REFUND_ENDPOINT":             baseURL + "/refund",
+		"PAYMENT_LIST_SUBSCRIPTIONS_ENDPOINT": baseURL + "/subscriptions",
+		"PAYMENT_LIST_TRANSACTIONS_ENDPOINT":  baseURL + "/transactions",
+		"PAYMENT_PLAN_ENDPOINT":               baseURL + "/plan",
+	}
+}
+
+// Setenv points the endpoint variables to the simulator at baseURL and returns a function
+// restoring them, e.g. in tests:
+//
+//	server := httptest.NewServer(gatewaysim.New(""))
+//	defer gatewaysim.Setenv(server.URL)()
+func Setenv(baseURL string) func() {
+	previous := map[string]*string{}
+	for name, endpoint := range Endpoints(baseURL) {
+		if value, ok := os.LookupEnv(name); ok {
+			previous[name] = &value
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, endpoint)
+	}
+
+	return func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+// ServeHTTP routes the gateway endpoints and the /simulator controls
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimRight(r.URL.Path, "/")
+	if strings.HasPrefix(path, "/simulator/") {
+		s.serveControl(w, r, strings.Split(strings.TrimPrefix(path, "/simulator/"), "/"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	switch path {
+	case "/subscription":
+		s.createSubscription(w, r)
+	case "/subscription/fetch":
+		request := subscriptionRequest{}
+		if s.decode(w, r, &request) {
+			s.writeSubscription(w, request.SubscriptionID, nil)
+		}
+	case "/subscription/cancel":
+		request := subscriptionRequest{}
+		if s.decode(w, r, &request) {
+			s.writeSubscription(w, request.SubscriptionID, s.cancel)
+		}
+	case "/refund":
+		s.refund(w, r)
+	case "/plan":
+		s.fetchPlan(w, r)
+	case "/subscriptions":
+		s.listSubscriptions(w, r)
+	case "/transactions":
+		s.listTransactions(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+func (s *Simulator) decode(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	if s.SecretKey != "" && request.(interface{ secret() string }).secret() != s.SecretKey {
+		writeError(w, http.StatusUnauthorized, "invalid secret_key")
+		return false
+	}
+	return true
+}
+
+// outcome picks the outcome forced by the card hash or the document number
+func outcome(request createRequest) Outcome {
+	if o, ok := CardHashes[request.CardHash]; ok && request.PaymentMethod == "credit_card" {
+		return o
+	}
+	if o, ok := DocumentNumbers[request.Customer.DocumentNumber]; ok {
+		return o
+	}
+	return OutcomeApprove
+}
+
+func (s *Simulator) createSubscription(w http.ResponseWriter, r *http.Request) {
+	request := createRequest{}
+	if !s.decode(w, r, &request) {
+		return
+	}
+	if request.PaymentMethod != "credit_card" && request.PaymentMethod != "boleto" {
+		writeError(w, http.StatusBadRequest, "payment_method must be credit_card or boleto")
+		return
+	}
+
+	o := outcome(request)
+	switch o {
+	case OutcomeError:
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	case OutcomeTimeout:
+		select {
+		case <-r.Context().Done():
+		case <-time.After(s.Timeout):
+			writeError(w, http.StatusGatewayTimeout, "acquirer timeout")
+		}
+		return
+	case OutcomeDelay:
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(s.Delay):
+		}
+	}
+
+	s.mu.Lock()
+	if s.BaseURL == "" {
+		s.BaseURL = "http://" + r.Host
+	}
+	subscription := s.newSubscription(request, o)
+	answer := *subscription
+	s.mu.Unlock()
+
+	if o == OutcomeBoletoPaid {
+		s.pending.Add(1)
+		time.AfterFunc(s.BoletoDelay, func() {
+			defer s.pending.Done()
+			s.PayBoleto(subscription.ID)
+		})
+	}
+
+	writeJSON(w, http.StatusOK, answer)
+}
+
+// newSubscription must be called holding the lock
+func (s *Simulator) newSubscription(request createRequest, o Outcome) *Subscription {
+	now := s.Now()
+	plan, ok := s.Plans[request.PlanID]
+	if !ok {
+		plan = Plan{ID: request.PlanID, Amount: request.Amount, Days: 30}
+	}
+	amount := request.Amount
+	if amount == 0 {
+		amount = plan.Amount
+	}
+
+	s.lastID++
+	subscription := &Subscription{
+		Object:         "subscription",
+		ID:             s.lastID,
+		PaymentMethod:  request.PaymentMethod,
+		RemotePlanID:   plan.ID,
+		PostbackURL:    request.PostbackURL,
+		SoftDescriptor: request.SoftDescriptor,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		apiKey:         request.APIKey,
+		days:           plan.Days,
+	}
+	subscription.Customer.Name = request.Customer.Name
+	subscription.Customer.Email = request.Customer.Email
+	subscription.Customer.DocumentNumber = request.Customer.DocumentNumber
+	s.subscriptions[subscription.ID] = subscription
+
+	installments := request.Installments
+	if installments < 1 {
+		installments = 1
+	}
+	transaction := s.newTransaction(subscription, amount, installments)
+
+	switch {
+	case refuseReasons[o] != "":
+		// The proxy flattens a refused first charge into a declined answer
+		transaction.Status, transaction.RefuseReason = "refused", refuseReasons[o]
+		transaction.AcquirerResponseCode = acquirerResponseCodes[o]
+		subscription.Status, subscription.RefuseReason = "Declined", refuseReasons[o]
+	case request.PaymentMethod == "boleto":
+		transaction.Status = "waiting_payment"
+		subscription.Status = "unpaid"
+	default:
+		transaction.Status = "paid"
+		subscription.Status = "paid"
+		subscription.startPeriod(now)
+	}
+	subscription.CurrentTransaction = *transaction
+	return subscription
+}
+
+// newTransaction charges the subscription again, it must be called holding the lock
+func (s *Simulator) newTransaction(subscription *Subscription, amount int, installments int) *Transaction {
+	s.lastID++
+	transaction := &Transaction{
+		ID:             s.lastID,
+		Amount:         amount,
+		Installments:   installments,
+		PaymentMethod:  subscription.PaymentMethod,
+		SubscriptionID: subscription.ID,
+		CreatedAt:      s.Now(),
+	}
+	if subscription.PaymentMethod == "boleto" {
+		transaction.Installments = 1
+		transaction.BoletoURL = fmt.Sprintf("%s/simulator/boletos/%d", s.BaseURL, transaction.ID)
+		transaction.BoletoBarcode = fmt.Sprintf("23793.38128 60000.%06d 00000.000000 1 %014d", transaction.ID%1000000, amount)
+		transaction.BoletoExpirationDate = transaction.CreatedAt.AddDate(0, 0, 3).UTC().Format("2006-01-02T15:04:05.000Z")
+	} else {
+		transaction.CardBrand, transaction.CardLastDigits = "visa", "4242"
+		subscription.CardBrand, subscription.CardLastDigits = "visa", "4242"
+	}
+
+	s.transactions[transaction.ID] = transaction
+	subscription.TransactionID = strconv.Itoa(transaction.ID)
+	subscription.CurrentTransaction = *transaction
+	return transaction
+}
+
+// startPeriod starts a paid period of the plan at t
+func (sub *Subscription) startPeriod(t time.Time) {
+	sub.CurrentPeriodStart = t.UTC().Format(time.RFC3339)
+	sub.CurrentPeriodEnd = t.AddDate(0, 0, sub.days).UTC().Format(time.RFC3339)
+}
+
+// writeSubscription answers with the subscription after applying change, if any
+func (s *Simulator) writeSubscription(w http.ResponseWriter, id string, change func(*Subscription)) {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[atoi(id)]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	if change != nil {
+		change(subscription)
+	}
+	answer := *subscription
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, answer)
+}
+
+// cancel must be called holding the lock
+func (s *Simulator) cancel(subscription *Subscription) {
+	if subscription.Status == "canceled" {
+		return
+	}
+	s.setStatus(subscription, "canceled")
+}
+
+// setStatus changes the subscription status and posts it back, it must be called holding the lock
+func (s *Simulator) setStatus(subscription *Subscription, status string) {
+	old := subscription.Status
+	subscription.Status = status
+	subscription.UpdatedAt = s.Now()
+
+	values := url.Values{
+		"object":         {"subscription"},
+		"id":             {strconv.Itoa(subscription.ID)},
+		"event":          {"subscription_status_changed"},
+		"old_status":     {old},
+		"current_status": {status},
+		"desired_status": {status},
+	}
+	s.postback(subscription, values)
+}
+
+// setTransactionStatus changes the transaction status and posts it back, it must be called holding the lock
+func (s *Simulator) setTransactionStatus(transaction *Transaction, status string) {
+	old := transaction.Status
+	transaction.Status = status
+
+	subscription := s.subscriptions[transaction.SubscriptionID]
+	if subscription.CurrentTransaction.ID == transaction.ID {
+		subscription.CurrentTransaction = *transaction
+	}
+
+	values := url.Values{
+		"object":                        {"transaction"},
+		"id":                            {strconv.Itoa(transaction.ID)},
+		"event":                         {"transaction_status_changed"},
+		"old_status":                    {old},
+		"current_status":                {status},
+		"desired_status":                {status},
+		"transaction[amount]":           {strconv.Itoa(transaction.Amount)},
+		"transaction[refunded_amount]":  {strconv.Itoa(transaction.RefundedAmount)},
+		"transaction[payment_method]":   {transaction.PaymentMethod},
+		"transaction[subscription][id]": {strconv.Itoa(transaction.SubscriptionID)},
+	}
+	s.postback(subscription, values)
+}
+
+// postback notifies the subscription postback URL in the background, signing the body
+// with the API key given at checkout. It must be called holding the lock.
+func (s *Simulator) postback(subscription *Subscription, values url.Values) {
+	if subscription.PostbackURL == "" {
+		return
+	}
+
+	postbackURL, key := subscription.PostbackURL, subscription.apiKey
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		postback := Postback{URL: postbackURL, Values: values}
+		body := values.Encode()
+		req, err := http.NewRequest(http.MethodPost, postbackURL, strings.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("X-Hub-Signature", Sign([]byte(body), key))
+
+			var resp *http.Response
+			if resp, err = s.HTTPClient.Do(req); err == nil {
+				resp.Body.Close()
+				postback.StatusCode = resp.StatusCode
+			}
+		}
+		if err != nil {
+			postback.Error = err.Error()
+		}
+
+		s.mu.Lock()
+		s.postbacks = append(s.postbacks, postback)
+		s.mu.Unlock()
+	}()
+}
+
+// Sign computes the X-Hub-Signature of a postback body, an HMAC-SHA1 keyed with the API key
+func Sign(body []byte, apiKey string) string {
+	mac := hmac.New(sha1.New, []byte(apiKey))
+	mac.Write(body)
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Wait blocks until the postbacks and the scheduled boleto payments are done
+func (s *Simulator) Wait() {
+	s.pending.Wait()
+}
+
+// Postbacks lists the postbacks sent so far, oldest first
+func (s *Simulator) Postbacks() []Postback {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Postback{}, s.postbacks...)
+}
+
+// Subscription returns a copy of the subscription
+func (s *Simulator) Subscription(id int) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *subscription, true
+}
+
+// PayBoleto pays the boleto waiting on the subscription, starting a new period
+func (s *Simulator) PayBoleto(subscriptionID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return ErrNotFound
+	}
+	transaction := s.transactions[subscription.CurrentTransaction.ID]
+	if transaction.Status != "waiting_payment" {
+		return fmt.Errorf("transaction %d is %s", transaction.ID, transaction.Status)
+	}
+
+	s.setTransactionStatus(transaction, "paid")
+	subscription.startPeriod(s.Now())
+	s.setStatus(subscription, "paid")
+	return nil
+}
+
+// Renew charges the next period of the subscription. Approved cards start the period at
+// once; boletos wait to be paid; declines leave the subscription unpaid.
+func (s *Simulator) Renew(subscriptionID int, o Outcome) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return ErrNotFound
+	}
+	if subscription.Status == "canceled" {
+		return fmt.Errorf("subscription %d is canceled", subscriptionID)
+	}
+
+	previous := subscription.CurrentTransaction
+	transaction := s.newTransaction(subscription, previous.Amount, previous.Installments)
+	switch {
+	case refuseReasons[o] != "":
+		transaction.Status, transaction.RefuseReason = "refused", refuseReasons[o]
+		transaction.AcquirerResponseCode = acquirerResponseCodes[o]
+		subscription.CurrentTransaction = *transaction
+		s.setStatus(subscription, "unpaid")
+	case subscription.PaymentMethod == "boleto":
+		transaction.Status = "waiting_payment"
+		subscription.CurrentTransaction = *transaction
+		s.setStatus(subscription, "pending_payment")
+	default:
+		transaction.Status = "paid"
+		subscription.CurrentTransaction = *transaction
+		subscription.startPeriod(s.Now())
+		s.setStatus(subscription, "paid")
+	}
+	return nil
+}
+
+// Chargeback disputes the whole transaction
+func (s *Simulator) Chargeback(transactionID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, ok := s.transactions[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+	transaction.RefundedAmount = transaction.Amount
+	s.setTransactionStatus(transaction, "chargedback")
+	return nil
+}
+
+func (s *Simulator) refund(w http.ResponseWriter, r *http.Request) {
+	request := refundRequest{}
+	if !s.decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transaction, ok := s.transactions[atoi(request.TransactionID)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "transaction not found")
+		return
+	}
+	if transaction.Status != "paid" {
+		writeError(w, http.StatusBadRequest, "transaction is "+transaction.Status)
+		return
+	}
+	amount := request.Amount
+	if amount == 0 {
+		amount = transaction.Amount - transaction.RefundedAmount
+	}
+	if amount <= 0 || transaction.RefundedAmount+amount > transaction.Amount {
+		writeError(w, http.StatusBadRequest, "amount exceeds what is left to refund")
+		return
+	}
+
+	transaction.RefundedAmount += amount
+	if transaction.RefundedAmount == transaction.Amount {
+		s.setTransactionStatus(transaction, "refunded")
+	} else if subscription := s.subscriptions[transaction.SubscriptionID]; subscription.CurrentTransaction.ID == transaction.ID {
+		subscription.CurrentTransaction = *transaction
+	}
+
+	s.lastID++
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":              s.lastID,
+		"status":          transaction.Status,
+		"amount":          transaction.Amount,
+		"refunded_amount": transaction.RefundedAmount,
+	})
+}
+
+func (s *Simulator) fetchPlan(w http.ResponseWriter, r *http.Request) {
+	request := planRequest{}
+	if !s.decode(w, r, &request) {
+		return
+	}
+
+	plan, ok := s.Plans[atoi(request.PlanID)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "plan not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+func (s *Simulator) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	request := listRequest{}
+	if !s.decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	list := []Subscription{}
+	for _, subscription := range s.subscriptions {
+		if inRange(subscription.CreatedAt, request) {
+			list = append(list, *subscription)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	from, to := page(len(list), request)
+	writeJSON(w, http.StatusOK, list[from:to])
+}
+
+func (s *Simulator) listTransactions(w http.ResponseWriter, r *http.Request) {
+	request := listRequest{}
+	if !s.decode(w, r, &request) {
+		return
+	}
+
+	s.mu.Lock()
+	list := []Transaction{}
+	for _, transaction := range s.transactions {
+		if inRange(transaction.CreatedAt, request) {
+			list = append(list, *transaction)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	from, to := page(len(list), request)
+	writeJSON(w, http.StatusOK, list[from:to])
+}
+
+func inRange(t time.Time, request listRequest) bool {
+	return (request.CreatedFrom.IsZero() || !t.Before(request.CreatedFrom)) && (request.CreatedTo.IsZero() || t.Before(request.CreatedTo))
+}
+
+// page returns the bounds of the requested page, pages starting at 1
+func page(total int, request listRequest) (int, int) {
+	count := request.Count
+	if count < 1 {
+		count = 10
+	}
+	from := (request.Page - 1) * count
+	if request.Page < 1 {
+		from = 0
+	}
+	if from > total {
+		from = total
+	}
+	to := from + count
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+// serveControl lets development drive the simulated subscriptions:
+//
+//	POST /simulator/subscriptions/{id}/pay                    pays the waiting boleto
+//	POST /simulator/subscriptions/{id}/renew?outcome=decline  charges the next period
+//	POST /simulator/transactions/{id}/chargeback              disputes the transaction
+//	GET  /simulator/boletos/{id}                              shows the boleto
+//	GET  /simulator/postbacks                                 lists the postbacks sent
+func (s *Simulator) serveControl(w http.ResponseWriter, r *http.Request, parts []string) {
+	var err error
+
+	switch {
+	case len(parts) == 1 && parts[0] == "postbacks" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Postbacks())
+		return
+	case len(parts) == 2 && parts[0] == "boletos" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		transaction, ok := s.transactions[atoi(parts[1])]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "boleto not found")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Boleto simulado\n\nValor: %d centavos\nVencimento: %s\nLinha digitável: %s\nSituação: %s\n",
+			transaction.Amount, transaction.BoletoExpirationDate, transaction.BoletoBarcode, transaction.Status)
+		return
+	case r.Method != http.MethodPost || len(parts) != 3:
+		writeError(w, http.StatusNotFound, "no such control")
+		return
+	case parts[0] == "subscriptions" && parts[2] == "pay":
+		err = s.PayBoleto(atoi(parts[1]))
+	case parts[0] == "subscriptions" && parts[2] == "renew":
+		o := Outcome(r.URL.Query().Get("outcome"))
+		if o == "" {
+			o = OutcomeApprove
+		}
+		err = s.Renew(atoi(parts[1]), o)
+	case parts[0] == "transactions" && parts[2] == "chargeback":
+		err = s.Chargeback(atoi(parts[1]))
+	default:
+		writeError(w, http.StatusNotFound, "no such control")
+		return
+	}
+
+	switch {
+	case err == ErrNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
@@ -0,0 +1,247 @@
+package gatewaysim
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// receiver is where the checkouts of the tests ask for postbacks
+var receiver struct {
+	URL       string
+	Postbacks chan url.Values
+}
+
+// startSimulator serves a simulator answering delays at once, along with a receiver for its postbacks
+func startSimulator(t *testing.T) (*Simulator, *httptest.Server) {
+	receiver.Postbacks = make(chan url.Values, 10)
+	postbacks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Hub-Signature") != Sign(body, "ak_test") {
+			t.Errorf("unexpected signature %q", r.Header.Get("X-Hub-Signature"))
+		}
+		values, _ := url.ParseQuery(string(body))
+		receiver.Postbacks <- values
+	}))
+	receiver.URL = postbacks.URL
+	t.Cleanup(postbacks.Close)
+
+	simulator := New("")
+	simulator.Delay, simulator.Timeout, simulator.BoletoDelay = time.Millisecond, time.Second, time.Millisecond
+	server := httptest.NewServer(simulator)
+	t.Cleanup(server.Close)
+
+	return simulator, server
+}
+
+func checkout(t *testing.T, server *httptest.Server, method string, cardHash string, document string) (int, Subscription) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"api_key":        "ak_test",
+		"plan_id":        1003,
+		"payment_method": method,
+		"card_hash":      cardHash,
+		"amount":         7990,
+		"postback_url":   receiver.URL,
+		"customer":       map[string]string{"name": "Ana", "email": "ana@example.com", "document_number": document},
+	})
+	return post(t, server.URL+"/subscription", body)
+}
+
+func post(t *testing.T, u string, body []byte) (int, Subscription) {
+	resp, err := http.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	subscription := Subscription{}
+	json.NewDecoder(resp.Body).Decode(&subscription)
+	return resp.StatusCode, subscription
+}
+
+func Test_Simulator_ApprovesCards(t *testing.T) {
+	_, server := startSimulator(t)
+
+	status, subscription := checkout(t, server, "credit_card", "any hash", "12345678909")
+	if status != http.StatusOK || subscription.Status != "paid" || subscription.CurrentTransaction.Status != "paid" {
+		t.Fatalf("expected a paid subscription, got %d %+v", status, subscription)
+	}
+	if subscription.CurrentTransaction.Amount != 7990 || subscription.CurrentPeriodEnd == "" {
+		t.Errorf("unexpected subscription %+v", subscription)
+	}
+
+	_, fetched := post(t, server.URL+"/subscription/fetch", []byte(`{"subscription_id": "`+strconv.Itoa(subscription.ID)+`"}`))
+	if fetched.ID != subscription.ID || fetched.Customer.Email != "ana@example.com" {
+		t.Errorf("unexpected fetched subscription %+v", fetched)
+	}
+}
+
+func Test_Simulator_MagicValues(t *testing.T) {
+	_, server := startSimulator(t)
+
+	cases := []struct {
+		cardHash, document, status, refuseReason string
+		code                                     int
+	}{
+		{"card_declined", "", "Declined", "acquirer", http.StatusOK},
+		{"card_antifraud", "", "Declined", "antifraud", http.StatusOK},
+		{"", "11111111111", "Declined", "acquirer", http.StatusOK},
+		{"card_no_funds", "", "Declined", "acquirer", http.StatusOK},
+		{"", "88888888888", "Declined", "acquirer", http.StatusOK},
+		{"card_error", "", "", "", http.StatusInternalServerError},
+		{"", "33333333333", "", "", http.StatusInternalServerError},
+		{"card_delay", "", "paid", "", http.StatusOK},
+		{"card_timeout", "", "", "", http.StatusGatewayTimeout},
+	}
+	for _, c := range cases {
+		code, subscription := checkout(t, server, "credit_card", c.cardHash, c.document)
+		if code != c.code || subscription.Status != c.status || subscription.RefuseReason != c.refuseReason {
+			t.Errorf("%s %s: expected %d %q %q, got %d %q %q", c.cardHash, c.document, c.code, c.status, c.refuseReason,
+				code, subscription.Status, subscription.RefuseReason)
+		}
+	}
+}
+
+func Test_Simulator_TimeoutStopsWhenTheClientGivesUp(t *testing.T) {
+	simulator, server := startSimulator(t)
+	simulator.Timeout = time.Minute
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	body := []byte(`{"payment_method": "credit_card", "card_hash": "card_timeout"}`)
+	started := time.Now()
+	if _, err := client.Post(server.URL+"/subscription", "application/json", bytes.NewReader(body)); err == nil {
+		t.Fatal("expected the client to time out")
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Error("expected the client to give up before the simulator answered")
+	}
+}
+
+func Test_Simulator_BoletoPostbacks(t *testing.T) {
+	simulator, server := startSimulator(t)
+
+	_, subscription := checkout(t, server, "boleto", "", "12345678909")
+	if subscription.Status != "unpaid" || subscription.CurrentTransaction.Status != "waiting_payment" || subscription.CurrentTransaction.BoletoURL == "" {
+		t.Fatalf("expected a boleto waiting payment, got %+v", subscription)
+	}
+
+	resp, err := http.Post(server.URL+"/simulator/subscriptions/"+strconv.Itoa(subscription.ID)+"/pay", "", nil)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the boleto to be paid, got %v %v", resp, err)
+	}
+	simulator.Wait()
+
+	paid, _ := simulator.Subscription(subscription.ID)
+	if paid.Status != "paid" || paid.CurrentTransaction.Status != "paid" || paid.CurrentPeriodStart == "" {
+		t.Errorf("unexpected subscription %+v", paid)
+	}
+	if len(receiver.Postbacks) != 2 || len(simulator.Postbacks()) != 2 {
+		t.Errorf("expected the transaction and subscription postbacks, got %+v", simulator.Postbacks())
+	}
+}
+
+func Test_Simulator_BoletoPaidByDocumentNumber(t *testing.T) {
+	simulator, server := startSimulator(t)
+
+	_, subscription := checkout(t, server, "boleto", "", "66666666666")
+	simulator.Wait()
+
+	if paid, _ := simulator.Subscription(subscription.ID); paid.Status != "paid" {
+		t.Errorf("expected the boleto to be paid, got %s", paid.Status)
+	}
+}
+
+func Test_Simulator_RenewAndChargeback(t *testing.T) {
+	simulator, server := startSimulator(t)
+	_, subscription := checkout(t, server, "credit_card", "", "")
+
+	if err := simulator.Renew(subscription.ID, OutcomeDecline); err != nil {
+		t.Fatal(err)
+	}
+	values := <-receiver.Postbacks
+	if values.Get("object") != "subscription" || values.Get("old_status") != "paid" || values.Get("current_status") != "unpaid" {
+		t.Errorf("unexpected postback %v", values)
+	}
+
+	renewed, _ := simulator.Subscription(subscription.ID)
+	if renewed.CurrentTransaction.ID == subscription.CurrentTransaction.ID || renewed.CurrentTransaction.Status != "refused" {
+		t.Errorf("expected a refused charge, got %+v", renewed.CurrentTransaction)
+	}
+	if err := simulator.Chargeback(subscription.CurrentTransaction.ID); err != nil {
+		t.Fatal(err)
+	}
+	values = <-receiver.Postbacks
+	if values.Get("object") != "transaction" || values.Get("current_status") != "chargedback" || values.Get("transaction[amount]") != "7990" {
+		t.Errorf("unexpected postback %v", values)
+	}
+
+	if err := simulator.Renew(0, OutcomeApprove); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func Test_Simulator_Refund(t *testing.T) {
+	_, server := startSimulator(t)
+	_, subscription := checkout(t, server, "credit_card", "", "")
+	transactionID := strconv.Itoa(subscription.CurrentTransaction.ID)
+
+	refund := func(amount int) (int, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]interface{}{"transaction_id": transactionID, "amount": amount})
+		resp, err := http.Post(server.URL+"/refund", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		answer := map[string]interface{}{}
+		json.NewDecoder(resp.Body).Decode(&answer)
+		return resp.StatusCode, answer
+	}
+
+	if code, answer := refund(1000); code != http.StatusOK || answer["status"] != "paid" || answer["refunded_amount"] != 1000.0 {
+		t.Errorf("unexpected partial refund %d %v", code, answer)
+	}
+	if code, answer := refund(0); code != http.StatusOK || answer["status"] != "refunded" || answer["refunded_amount"] != 7990.0 {
+		t.Errorf("unexpected full refund %d %v", code, answer)
+	}
+	if code, _ := refund(10); code != http.StatusBadRequest {
+		t.Errorf("expected a refunded transaction to refuse refunds, got %d", code)
+	}
+}
+
+func Test_Simulator_SecretKey(t *testing.T) {
+	simulator, server := startSimulator(t)
+	simulator.SecretKey = "abcde"
+
+	if code, _ := post(t, server.URL+"/plan", []byte(`{"secret_key": "wrong", "plan_id": "1001"}`)); code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", code)
+	}
+	if code, _ := post(t, server.URL+"/plan", []byte(`{"secret_key": "abcde", "plan_id": "1001"}`)); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func Test_Setenv_Restores(t *testing.T) {
+	os.Setenv("PAYMENT_REFUND_ENDPOINT", "http://gateway.example.com/refund")
+	defer os.Unsetenv("PAYMENT_REFUND_ENDPOINT")
+	os.Unsetenv("PAYMENT_PLAN_ENDPOINT")
+
+	restore := Setenv("http://127.0.0.1:9999")
+	if os.Getenv("PAYMENT_PLAN_ENDPOINT") != "http://127.0.0.1:9999/plan" {
+		t.Fatalf("expected the plan endpoint to point to the simulator, got %q", os.Getenv("PAYMENT_PLAN_ENDPOINT"))
+	}
+
+	restore()
+	if value := os.Getenv("PAYMENT_REFUND_ENDPOINT"); value != "http://gateway.example.com/refund" {
+		t.Errorf("expected the refund endpoint back, got %q", value)
+	}
+	if value, ok := os.LookupEnv("PAYMENT_PLAN_ENDPOINT"); ok {
+		t.Errorf("expected the plan endpoint unset again, got %q", value)
+	}
+}
@@ -3,20 +3,26 @@ module subscription_service
 go 1.14
 
 require (
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gobuffalo/buffalo v0.15.5
 	github.com/gobuffalo/buffalo-pop/v2 v2.0.6
 	github.com/gobuffalo/envy v1.9.0
+	github.com/gobuffalo/httptest v1.5.0
 	github.com/gobuffalo/mw-csrf v0.0.0-20190129204204-25460a055517
 	github.com/gobuffalo/mw-forcessl v0.0.0-20180802152810-73921ae7a130
 	github.com/gobuffalo/mw-i18n v0.0.0-20190129204410-552713a3ebb4
 	github.com/gobuffalo/mw-paramlogger v0.0.0-20190129202837-395da1998525
+	github.com/gobuffalo/nulls v0.2.0
 	github.com/gobuffalo/packr/v2 v2.8.0
+	github.com/gobuffalo/plush v3.8.3+incompatible
 	github.com/gobuffalo/pop/v5 v5.1.1
 	github.com/gobuffalo/suite v2.8.2+incompatible
 	github.com/gobuffalo/validate/v3 v3.1.0
 	github.com/gofrs/uuid v3.2.0+incompatible
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/markbates/grift v1.5.0
 	github.com/stanislas-m/amqp-work-adapter v1.0.1
 	github.com/streadway/amqp v1.0.0
 	github.com/unrolled/secure v0.0.0-20190103195806-76e6d4e9b90c
+	golang.org/x/crypto v0.0.0-20200206161412-a0c6ece9d31a
 )
@@ -0,0 +1,143 @@
+package grifts
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/markbates/grift/grift"
+	"strings"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("admin_users", func() {
+
+	grift.Desc("create", "Creates an admin console user and prints its password once. Usage: admin_users:create <email> <role> <name> (roles: viewer, support, finance, admin)")
+	grift.Add("create", func(c *grift.Context) error {
+		if len(c.Args) < 3 {
+			return errors.New("usage: admin_users:create <email> <role> <name>")
+		}
+
+		password, err := randomPassword()
+		if err != nil {
+			return err
+		}
+
+		user := &models.AdminUser{Email: c.Args[0], Role: c.Args[1], Name: strings.Join(c.Args[2:], " "), Password: password}
+		verrs, err := user.Create(models.DB)
+		if err != nil {
+			return err
+		}
+		if verrs.HasAny() {
+			return verrs
+		}
+		fmt.Printf("created %s (%s) with role %s\n", user.Email, user.Name, user.Role)
+		fmt.Println("password, shown only this time:", password)
+		return nil
+	})
+
+	grift.Desc("list", "Lists the admin users, their roles and when they last logged in")
+	grift.Add("list", func(c *grift.Context) error {
+		users := models.AdminUsers{}
+		if err := models.DB.Order("email asc").All(&users); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			lastLogin := "never"
+			if user.LastLoginAt.Valid {
+				lastLogin = user.LastLoginAt.Time.Format(time.RFC3339)
+			}
+			status := "active"
+			if user.Disabled() {
+				status = "disabled"
+			}
+			twoFactor := "no 2fa"
+			if user.TwoFactorEnabled() {
+				twoFactor = "2fa"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\tlast login %s\n", user.Email, user.Name, user.Role, status, twoFactor, lastLogin)
+		}
+		return nil
+	})
+
+	grift.Desc("password", "Sets a new password for an admin user and prints it once. Usage: admin_users:password <email>")
+	grift.Add("password", func(c *grift.Context) error {
+		user, err := findAdminUser(c.Args)
+		if err != nil {
+			return err
+		}
+
+		password, err := randomPassword()
+		if err != nil {
+			return err
+		}
+		verrs, err := user.ChangePassword(models.DB, password)
+		if err != nil {
+			return err
+		}
+		if verrs.HasAny() {
+			return verrs
+		}
+		fmt.Println("password, shown only this time:", password)
+		return nil
+	})
+
+	grift.Desc("totp", "Enables two-factor login for an admin user and prints the URI to load into the authenticator app. Usage: admin_users:totp <email>")
+	grift.Add("totp", func(c *grift.Context) error {
+		user, err := findAdminUser(c.Args)
+		if err != nil {
+			return err
+		}
+
+		secret, err := services.GenerateTOTPSecret()
+		if err != nil {
+			return err
+		}
+		sealed, err := services.EncryptTOTPSecret(secret)
+		if err != nil {
+			return err
+		}
+		user.TOTPSecret = nulls.NewString(sealed)
+		user.TOTPLastStep = nulls.Int64{}
+		if err := models.DB.Update(user); err != nil {
+			return err
+		}
+		fmt.Println("authenticator URI, shown only this time:", services.TOTPProvisioningURI(secret, user.Email, "Code Shop Admin"))
+		return nil
+	})
+
+	grift.Desc("disable", "Stops an admin user from logging in. Usage: admin_users:disable <email>")
+	grift.Add("disable", func(c *grift.Context) error {
+		user, err := findAdminUser(c.Args)
+		if err != nil {
+			return err
+		}
+
+		user.DisabledAt = nulls.NewTime(time.Now())
+		return models.DB.Update(user)
+	})
+
+})
+
+func findAdminUser(args []string) (*models.AdminUser, error) {
+	if len(args) != 1 {
+		return nil, errors.New("an admin user e-mail is required")
+	}
+	user := &models.AdminUser{}
+	if err := models.DB.Where("email = ?", strings.ToLower(args[0])).First(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
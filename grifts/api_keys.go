@@ -0,0 +1,58 @@
+package grifts
+
+import (
+	"errors"
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("api_keys", func() {
+
+	grift.Desc("create", "Creates an API key for another service and prints its token once. Usage: api_keys:create <name> <scope>... (scopes: read:subscriptions, write:subscriptions, read:reports, admin)")
+	grift.Add("create", func(c *grift.Context) error {
+		if len(c.Args) < 2 {
+			return errors.New("usage: api_keys:create <name> <scope>...")
+		}
+
+		key, token, err := services.GenerateAPIKey(models.DB, c.Args[0], c.Args[1:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created key %s (%s) with scopes %q\n", key.Prefix, key.Name, key.Scopes)
+		fmt.Println("token, shown only this time:", token)
+		return nil
+	})
+
+	grift.Desc("list", "Lists the API keys and when they were last used")
+	grift.Add("list", func(c *grift.Context) error {
+		keys := models.APIKeys{}
+		if err := models.DB.Order("created_at asc").All(&keys); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			lastUsed := "never"
+			if key.LastUsedAt.Valid {
+				lastUsed = key.LastUsedAt.Time.Format(time.RFC3339)
+			}
+			status := "active"
+			if key.Revoked() {
+				status = "revoked"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\tlast used %s\n", key.Prefix, key.Name, key.Scopes, status, lastUsed)
+		}
+		return nil
+	})
+
+	grift.Desc("revoke", "Revokes an API key. Usage: api_keys:revoke <prefix>")
+	grift.Add("revoke", func(c *grift.Context) error {
+		if len(c.Args) != 1 {
+			return errors.New("usage: api_keys:revoke <prefix>")
+		}
+		return services.RevokeAPIKey(models.DB, c.Args[0])
+	})
+
+})
@@ -0,0 +1,26 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("checkout_attempts", func() {
+
+	grift.Desc("prune", "Clears the e-mail, IP and user agent of checkout attempts past CHECKOUT_ATTEMPTS_PII_DAYS and deletes those past CHECKOUT_ATTEMPTS_RETENTION_DAYS")
+	grift.Add("prune", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		retention := services.NewCheckoutAttemptRetention()
+		cleared, deleted, err := services.PruneCheckoutAttempts(models.DB, retention, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d attempt(s) cleared, %d deleted\n", cleared, deleted)
+		return nil
+	})
+
+})
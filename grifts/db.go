@@ -1,15 +1,29 @@
 package grifts
 
 import (
+	"flag"
+	"fmt"
 	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
 )
 
 var _ = grift.Namespace("db", func() {
 
-	grift.Desc("seed", "Seeds a database")
+	grift.Desc("seed", "Seeds a development database with the plan catalog and sample subscribers, subscriptions and payments. Usage: db:seed [-subscribers 50] [-seed 1]")
 	grift.Add("seed", func(c *grift.Context) error {
-		// Add DB seeding stuff here
-		return nil
+		flags := flag.NewFlagSet("db:seed", flag.ContinueOnError)
+		subscribers := flags.Int("subscribers", 50, "how many sample subscribers to create")
+		seed := flags.Int64("seed", 1, "the same seed creates the same data; runs with another seed add other subscribers")
+		if err := flags.Parse(c.Args); err != nil {
+			return err
+		}
+
+		auditAsJob(c)
+		summary, err := services.NewSeedService(models.DB).Run(services.SeedOptions{Subscribers: *subscribers, Seed: *seed})
+		fmt.Printf("created %d plan(s), %d subscriber(s), %d subscription(s) and %d payment(s)\n",
+			summary.Plans, summary.Subscribers, summary.Subscriptions, summary.Payments)
+		return err
 	})
 
 })
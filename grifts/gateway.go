@@ -0,0 +1,64 @@
+package grifts
+
+import (
+	"flag"
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"os"
+	"subscription_service/actions"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("gateway", func() {
+
+	grift.Desc("reconcile", "Diffs the gateway subscriptions and transactions of a date range against ours. Usage: gateway:reconcile [-from 2020-07-01] [-to 2020-07-31] [-repair] [-output report.csv]")
+	grift.Add("reconcile", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		flags := flag.NewFlagSet("gateway:reconcile", flag.ContinueOnError)
+		from := flags.String("from", "", "first day, YYYY-MM-DD (defaults to the last 24 hours)")
+		to := flags.String("to", "", "last day, YYYY-MM-DD, inclusive")
+		repair := flags.Bool("repair", false, "import missing records and take the gateway status on mismatches")
+		output := flags.String("output", "", "write the report to this CSV file instead of stdout")
+		if err := flags.Parse(c.Args); err != nil {
+			return err
+		}
+
+		start, end, err := services.ReconciliationRange(*from, *to, time.Now())
+		if err != nil {
+			return err
+		}
+
+		report, err := services.NewReconciliationService(models.DB, actions.RabbitMQ).Run(start, end, *repair)
+		if err != nil {
+			return err
+		}
+
+		out := os.Stdout
+		if *output != "" {
+			out, err = os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		if err := report.WriteCSV(out); err != nil {
+			return err
+		}
+
+		repaired := 0
+		for _, issue := range report.Issues {
+			if issue.Repaired {
+				repaired++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s to %s: gateway %d subscription(s) and %d transaction(s), local %d subscription(s) and %d payment(s); %d issue(s), %d repaired\n",
+			start.Format(time.RFC3339), end.Format(time.RFC3339),
+			report.RemoteSubscriptions, report.RemoteTransactions, report.LocalSubscriptions, report.LocalPayments,
+			len(report.Issues), repaired)
+		return nil
+	})
+
+})
@@ -2,10 +2,17 @@ package grifts
 
 import (
 	"subscription_service/actions"
+	"subscription_service/models"
 
 	"github.com/gobuffalo/buffalo"
+	"github.com/markbates/grift/grift"
 )
 
 func init() {
 	buffalo.Grifts(actions.App())
 }
+
+// auditAsJob attributes the changes made by the task, in the audit log, to the task itself
+func auditAsJob(c *grift.Context) {
+	models.DefaultAuditActor = models.AuditActor{Type: models.ActorJob, ID: c.Name}
+}
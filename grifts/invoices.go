@@ -0,0 +1,30 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("invoices", func() {
+
+	grift.Desc("retry", "Retries issuing the fiscal invoices (NFS-e) that failed and are due")
+	grift.Add("retry", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		invoices, err := services.NewInvoiceService(models.DB)
+		if err != nil {
+			return err
+		}
+
+		issued, err := invoices.RetryDue(time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d invoice(s) issued\n", issued)
+		return nil
+	})
+
+})
@@ -0,0 +1,24 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+var _ = grift.Namespace("notifications", func() {
+
+	grift.Desc("send", "Sends the queued emails that are due, retrying the failed ones with a growing delay")
+	grift.Add("send", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		report, err := services.NewNotificationService(models.DB).SendPending(500)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("sent: %d, to retry: %d, given up: %d\n", report.Sent, report.Retried, report.Failed)
+		return nil
+	})
+
+})
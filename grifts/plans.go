@@ -0,0 +1,41 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+var _ = grift.Namespace("plans", func() {
+
+	grift.Desc("check", "Compares the billing interval and price of the active plans with the gateway configuration")
+	grift.Add("check", func(c *grift.Context) error {
+		plans := models.Plans{}
+		if err := models.DB.Where("active = ?", true).All(&plans); err != nil {
+			return err
+		}
+
+		gateway := services.NewGatewayClient()
+		failed := 0
+		for _, plan := range plans {
+			mismatches, err := services.CheckPlan(gateway, plan)
+			if err != nil {
+				return err
+			}
+			for _, mismatch := range mismatches {
+				fmt.Printf("%s (%s): %s\n", plan.Name, plan.ID, mismatch)
+			}
+			if len(mismatches) > 0 {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d plan(s) do not match the gateway configuration", failed)
+		}
+		fmt.Printf("%d plan(s) checked, all match the gateway\n", len(plans))
+		return nil
+	})
+
+})
@@ -0,0 +1,37 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+var _ = grift.Namespace("receipts", func() {
+
+	grift.Desc("backfill", "Issues receipts for paid payments that do not have one yet")
+	grift.Add("backfill", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		payments := models.Payments{}
+		err := models.DB.
+			Where("status = ?", "paid").
+			Where("id NOT IN (SELECT payment_id FROM receipts)").
+			Order("created_at asc").
+			All(&payments)
+		if err != nil {
+			return err
+		}
+
+		receipts := services.NewReceiptService(models.DB)
+		for _, payment := range payments {
+			receipt, err := receipts.Issue(payment)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("payment %s => receipt %s\n", payment.ID, receipt.Number)
+		}
+		return nil
+	})
+
+})
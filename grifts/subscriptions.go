@@ -0,0 +1,86 @@
+package grifts
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"os"
+	"path/filepath"
+	"strings"
+	"subscription_service/actions"
+	"subscription_service/models"
+	"subscription_service/services"
+	"time"
+)
+
+var _ = grift.Namespace("subscriptions", func() {
+
+	grift.Desc("renew", "Reconciles subscriptions near or past their expiration with the gateway, renewing or expiring them")
+	grift.Add("renew", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		report, err := services.NewRenewalService(models.DB, actions.RabbitMQ).Run(time.Now())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("checked: %d, renewed: %d, expired: %d, other changes: %d, unchanged: %d, failed: %d\n",
+			report.Checked, report.Renewed, report.Expired, report.Changed, report.Unchanged, report.Failed)
+		return nil
+	})
+
+	grift.Desc("import", "Imports legacy customers whose subscriptions already exist at the gateway, from a CSV or JSON file. Usage: subscriptions:import [-dry-run] [-output results.csv] <file>")
+	grift.Add("import", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		flags := flag.NewFlagSet("subscriptions:import", flag.ContinueOnError)
+		dryRun := flags.Bool("dry-run", false, "check the rows against the gateway without importing them")
+		output := flags.String("output", "", "write the results to this CSV file instead of stdout")
+		format := flags.String("format", "", "csv or json (defaults to the file extension)")
+		if err := flags.Parse(c.Args); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: subscriptions:import [-dry-run] [-output results.csv] <file>")
+		}
+
+		path := flags.Arg(0)
+		if *format == "" {
+			*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		records, err := services.ReadLegacyRecords(in, *format)
+		if err != nil {
+			return err
+		}
+
+		importer := services.NewImportService(models.DB)
+		importer.DryRun = *dryRun
+		results := importer.Run(records)
+
+		out := os.Stdout
+		if *output != "" {
+			out, err = os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		if err := services.WriteImportResults(out, results); err != nil {
+			return err
+		}
+
+		counts := services.CountImportResults(results)
+		fmt.Fprintf(os.Stderr, "%d row(s): %d created, %d skipped, %d valid, %d invalid, %d failed\n",
+			len(results), counts[services.ImportCreated], counts[services.ImportSkipped], counts[services.ImportValid],
+			counts[services.ImportInvalid], counts[services.ImportFailed])
+		return nil
+	})
+
+})
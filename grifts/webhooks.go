@@ -0,0 +1,24 @@
+package grifts
+
+import (
+	"fmt"
+	"github.com/markbates/grift/grift"
+	"subscription_service/models"
+	"subscription_service/services"
+)
+
+var _ = grift.Namespace("webhooks", func() {
+
+	grift.Desc("deliver", "Delivers the queued webhooks that are due, retrying the failed ones with a growing delay")
+	grift.Add("deliver", func(c *grift.Context) error {
+		auditAsJob(c)
+
+		report, err := services.NewWebhookService(models.DB).DeliverPending(500)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("delivered: %d, to retry: %d, given up: %d, endpoints disabled: %d\n", report.Succeeded, report.Retried, report.Failed, report.Disabled)
+		return nil
+	})
+
+})
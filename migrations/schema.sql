@@ -16,8 +16,198 @@ SET xmloption = content;
 SET client_min_messages = warning;
 SET row_security = off;
 
+--
+-- Name: audit_logs_append_only(); Type: FUNCTION; Schema: public; Owner: postgres
+--
+
+CREATE FUNCTION public.audit_logs_append_only() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$ BEGIN RAISE EXCEPTION 'audit_logs is append-only'; END; $$;
+
+
+ALTER FUNCTION public.audit_logs_append_only() OWNER TO postgres;
+
 SET default_tablespace = '';
 
+--
+-- Name: admin_actions; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.admin_actions (
+    id uuid NOT NULL,
+    operator character varying(255) NOT NULL,
+    action character varying(255) NOT NULL,
+    subscriber_id uuid,
+    subject_type character varying(255) NOT NULL,
+    subject_id character varying(255) NOT NULL,
+    details text NOT NULL,
+    succeeded boolean DEFAULT true NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.admin_actions OWNER TO postgres;
+
+--
+-- Name: admin_users; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.admin_users (
+    id uuid NOT NULL,
+    email character varying(255) NOT NULL,
+    name character varying(255) NOT NULL,
+    role character varying(255) NOT NULL,
+    password_hash character varying(255) NOT NULL,
+    totp_secret character varying(255),
+    totp_last_step bigint,
+    last_login_at timestamp without time zone,
+    disabled_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.admin_users OWNER TO postgres;
+
+--
+-- Name: api_keys; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.api_keys (
+    id uuid NOT NULL,
+    name character varying(255) NOT NULL,
+    prefix character varying(255) NOT NULL,
+    secret_hash character varying(255) NOT NULL,
+    scopes character varying(255) NOT NULL,
+    last_used_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.api_keys OWNER TO postgres;
+
+--
+-- Name: audit_logs; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.audit_logs (
+    id uuid NOT NULL,
+    actor_type character varying(255) NOT NULL,
+    actor_id character varying(255) NOT NULL,
+    action character varying(255) NOT NULL,
+    auditable_type character varying(255) NOT NULL,
+    auditable_id uuid NOT NULL,
+    changes jsonb NOT NULL,
+    request_id character varying(255) NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.audit_logs OWNER TO postgres;
+
+--
+-- Name: checkout_attempts; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.checkout_attempts (
+    id uuid NOT NULL,
+    plan_id uuid NOT NULL,
+    email character varying(255) NOT NULL,
+    payment_method character varying(255) NOT NULL,
+    installments integer DEFAULT 1 NOT NULL,
+    outcome character varying(255) NOT NULL,
+    decline_reason character varying(255) NOT NULL,
+    refuse_reason character varying(255) NOT NULL,
+    acquirer_response_code character varying(255) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    ip character varying(255) DEFAULT ''::character varying NOT NULL,
+    user_agent text DEFAULT ''::text NOT NULL,
+    gateway_status_code integer DEFAULT 0 NOT NULL,
+    remote_subscription_id character varying(255),
+    error text DEFAULT ''::text NOT NULL,
+    document_number character varying(255) DEFAULT ''::character varying NOT NULL,
+    card_hash_digest character varying(255) DEFAULT ''::character varying NOT NULL,
+    fraud_score integer DEFAULT 0 NOT NULL,
+    fraud_decision character varying(255) DEFAULT ''::character varying NOT NULL
+);
+
+
+ALTER TABLE public.checkout_attempts OWNER TO postgres;
+
+--
+-- Name: email_notifications; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.email_notifications (
+    id uuid NOT NULL,
+    kind character varying(255) NOT NULL,
+    dedup_key character varying(255) NOT NULL,
+    subscriber_id uuid NOT NULL,
+    subscription_id uuid,
+    payment_id uuid,
+    recipient character varying(255) NOT NULL,
+    locale character varying(255) NOT NULL,
+    subject character varying(255) DEFAULT ''::character varying NOT NULL,
+    status character varying(255) NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    send_after timestamp without time zone NOT NULL,
+    sent_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.email_notifications OWNER TO postgres;
+
+--
+-- Name: fraud_reviews; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.fraud_reviews (
+    id uuid NOT NULL,
+    subscription_id uuid NOT NULL,
+    score integer NOT NULL,
+    reasons text NOT NULL,
+    status character varying(255) NOT NULL,
+    reviewed_by character varying(255),
+    reviewed_at timestamp without time zone,
+    notes text DEFAULT ''::text NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.fraud_reviews OWNER TO postgres;
+
+--
+-- Name: invoices; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.invoices (
+    id uuid NOT NULL,
+    payment_id uuid NOT NULL,
+    provider character varying(255) NOT NULL,
+    status character varying(255) NOT NULL,
+    number character varying(255),
+    verification_code character varying(255),
+    pdf_url character varying(255),
+    attempts integer DEFAULT 0 NOT NULL,
+    last_error text,
+    next_attempt_at timestamp without time zone,
+    issued_at timestamp without time zone,
+    canceled_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.invoices OWNER TO postgres;
+
 --
 -- Name: payments; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -43,6 +233,38 @@ CREATE TABLE public.payments (
 
 ALTER TABLE public.payments OWNER TO postgres;
 
+--
+-- Name: plan_features; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.plan_features (
+    id uuid NOT NULL,
+    plan_id uuid NOT NULL,
+    feature_key character varying(255) NOT NULL,
+    feature_limit integer,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.plan_features OWNER TO postgres;
+
+--
+-- Name: plan_installments; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.plan_installments (
+    id uuid NOT NULL,
+    plan_id uuid NOT NULL,
+    installments integer NOT NULL,
+    interest_rate numeric(5,2) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.plan_installments OWNER TO postgres;
+
 --
 -- Name: plans; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -56,12 +278,75 @@ CREATE TABLE public.plans (
     recurrence character varying(255) NOT NULL,
     active boolean DEFAULT true NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    max_installments integer DEFAULT 1 NOT NULL,
+    interval_unit character varying(255) DEFAULT 'month'::character varying NOT NULL,
+    interval_count integer DEFAULT 1 NOT NULL
 );
 
 
 ALTER TABLE public.plans OWNER TO postgres;
 
+--
+-- Name: receipts; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.receipts (
+    id uuid NOT NULL,
+    payment_id uuid NOT NULL,
+    number character varying(255) NOT NULL,
+    year integer NOT NULL,
+    sequence integer NOT NULL,
+    issued_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    customer_name character varying(255) DEFAULT ''::character varying NOT NULL,
+    customer_document character varying(255) DEFAULT ''::character varying NOT NULL,
+    customer_address character varying(255) DEFAULT ''::character varying NOT NULL,
+    customer_email character varying(255) DEFAULT ''::character varying NOT NULL,
+    plan_name character varying(255) DEFAULT ''::character varying NOT NULL,
+    plan_description text DEFAULT ''::text NOT NULL,
+    period_start date NOT NULL,
+    period_end date NOT NULL,
+    total integer DEFAULT 0 NOT NULL,
+    paid_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.receipts OWNER TO postgres;
+
+--
+-- Name: receipt_sequences; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.receipt_sequences (
+    year integer NOT NULL,
+    last_sequence integer NOT NULL
+);
+
+
+ALTER TABLE public.receipt_sequences OWNER TO postgres;
+
+--
+-- Name: refunds; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.refunds (
+    id uuid NOT NULL,
+    payment_id uuid NOT NULL,
+    kind character varying(255) NOT NULL,
+    status character varying(255) NOT NULL,
+    amount integer NOT NULL,
+    reason text NOT NULL,
+    remote_refund_id character varying(255),
+    cancel_subscription boolean DEFAULT false NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.refunds OWNER TO postgres;
+
 --
 -- Name: schema_migration; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -90,12 +375,31 @@ CREATE TABLE public.subscribers (
     ddd character varying(255) NOT NULL,
     number character varying(255) NOT NULL,
     created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
+    updated_at timestamp without time zone NOT NULL,
+    locale character varying(255) DEFAULT 'pt-BR'::character varying NOT NULL
 );
 
 
 ALTER TABLE public.subscribers OWNER TO postgres;
 
+--
+-- Name: subscription_status_changes; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.subscription_status_changes (
+    id uuid NOT NULL,
+    subscription_id uuid NOT NULL,
+    from_status character varying(255) NOT NULL,
+    to_status character varying(255) NOT NULL,
+    reason text NOT NULL,
+    changed_by character varying(255) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.subscription_status_changes OWNER TO postgres;
+
 --
 -- Name: subscriptions; Type: TABLE; Schema: public; Owner: postgres
 --
@@ -116,6 +420,132 @@ CREATE TABLE public.subscriptions (
 
 ALTER TABLE public.subscriptions OWNER TO postgres;
 
+--
+-- Name: webhook_deliveries; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.webhook_deliveries (
+    id uuid NOT NULL,
+    webhook_endpoint_id uuid NOT NULL,
+    event_id uuid NOT NULL,
+    event_type character varying(255) NOT NULL,
+    payload text NOT NULL,
+    status character varying(255) NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_attempt_at timestamp without time zone NOT NULL,
+    last_status_code integer DEFAULT 0 NOT NULL,
+    last_error text DEFAULT ''::text NOT NULL,
+    delivered_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.webhook_deliveries OWNER TO postgres;
+
+--
+-- Name: webhook_delivery_attempts; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.webhook_delivery_attempts (
+    id uuid NOT NULL,
+    webhook_delivery_id uuid NOT NULL,
+    status_code integer DEFAULT 0 NOT NULL,
+    error text DEFAULT ''::text NOT NULL,
+    response_body text DEFAULT ''::text NOT NULL,
+    duration_ms integer DEFAULT 0 NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.webhook_delivery_attempts OWNER TO postgres;
+
+--
+-- Name: webhook_endpoints; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.webhook_endpoints (
+    id uuid NOT NULL,
+    url character varying(255) NOT NULL,
+    secret character varying(255) NOT NULL,
+    event_types text NOT NULL,
+    description character varying(255) DEFAULT ''::character varying NOT NULL,
+    enabled boolean DEFAULT true NOT NULL,
+    consecutive_failures integer DEFAULT 0 NOT NULL,
+    disabled_at timestamp without time zone,
+    disabled_reason character varying(255) DEFAULT ''::character varying NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+
+ALTER TABLE public.webhook_endpoints OWNER TO postgres;
+
+--
+-- Name: admin_actions admin_actions_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.admin_actions
+    ADD CONSTRAINT admin_actions_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: admin_users admin_users_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.admin_users
+    ADD CONSTRAINT admin_users_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: api_keys api_keys_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.api_keys
+    ADD CONSTRAINT api_keys_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: audit_logs audit_logs_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.audit_logs
+    ADD CONSTRAINT audit_logs_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: checkout_attempts checkout_attempts_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.checkout_attempts
+    ADD CONSTRAINT checkout_attempts_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: email_notifications email_notifications_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_notifications
+    ADD CONSTRAINT email_notifications_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: fraud_reviews fraud_reviews_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.fraud_reviews
+    ADD CONSTRAINT fraud_reviews_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: invoices invoices_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.invoices
+    ADD CONSTRAINT invoices_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: payments payments_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -124,6 +554,22 @@ ALTER TABLE ONLY public.payments
     ADD CONSTRAINT payments_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: plan_features plan_features_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.plan_features
+    ADD CONSTRAINT plan_features_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: plan_installments plan_installments_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.plan_installments
+    ADD CONSTRAINT plan_installments_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: plans plans_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -132,6 +578,30 @@ ALTER TABLE ONLY public.plans
     ADD CONSTRAINT plans_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: receipt_sequences receipt_sequences_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.receipt_sequences
+    ADD CONSTRAINT receipt_sequences_pkey PRIMARY KEY (year);
+
+
+--
+-- Name: receipts receipts_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.receipts
+    ADD CONSTRAINT receipts_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: refunds refunds_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refunds
+    ADD CONSTRAINT refunds_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: subscribers subscribers_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -140,6 +610,14 @@ ALTER TABLE ONLY public.subscribers
     ADD CONSTRAINT subscribers_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: subscription_status_changes subscription_status_changes_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.subscription_status_changes
+    ADD CONSTRAINT subscription_status_changes_pkey PRIMARY KEY (id);
+
+
 --
 -- Name: subscriptions subscriptions_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -148,6 +626,205 @@ ALTER TABLE ONLY public.subscriptions
     ADD CONSTRAINT subscriptions_pkey PRIMARY KEY (id);
 
 
+--
+-- Name: webhook_deliveries webhook_deliveries_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: webhook_delivery_attempts webhook_delivery_attempts_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.webhook_delivery_attempts
+    ADD CONSTRAINT webhook_delivery_attempts_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: webhook_endpoints webhook_endpoints_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.webhook_endpoints
+    ADD CONSTRAINT webhook_endpoints_pkey PRIMARY KEY (id);
+
+
+--
+-- Name: admin_actions_operator_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX admin_actions_operator_idx ON public.admin_actions USING btree (operator);
+
+
+--
+-- Name: admin_actions_subscriber_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX admin_actions_subscriber_id_idx ON public.admin_actions USING btree (subscriber_id);
+
+
+--
+-- Name: admin_users_email_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX admin_users_email_idx ON public.admin_users USING btree (email);
+
+
+--
+-- Name: api_keys_prefix_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX api_keys_prefix_idx ON public.api_keys USING btree (prefix);
+
+
+--
+-- Name: audit_logs_actor_type_actor_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX audit_logs_actor_type_actor_id_idx ON public.audit_logs USING btree (actor_type, actor_id);
+
+
+--
+-- Name: audit_logs_auditable_type_auditable_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX audit_logs_auditable_type_auditable_id_idx ON public.audit_logs USING btree (auditable_type, auditable_id);
+
+
+--
+-- Name: audit_logs_request_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX audit_logs_request_id_idx ON public.audit_logs USING btree (request_id);
+
+
+--
+-- Name: checkout_attempts_card_hash_digest_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX checkout_attempts_card_hash_digest_idx ON public.checkout_attempts USING btree (card_hash_digest);
+
+
+--
+-- Name: checkout_attempts_created_at_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX checkout_attempts_created_at_idx ON public.checkout_attempts USING btree (created_at);
+
+
+--
+-- Name: checkout_attempts_document_number_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX checkout_attempts_document_number_idx ON public.checkout_attempts USING btree (document_number);
+
+
+--
+-- Name: checkout_attempts_email_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX checkout_attempts_email_idx ON public.checkout_attempts USING btree (email);
+
+
+--
+-- Name: checkout_attempts_ip_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX checkout_attempts_ip_idx ON public.checkout_attempts USING btree (ip);
+
+
+--
+-- Name: email_notifications_dedup_key_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX email_notifications_dedup_key_idx ON public.email_notifications USING btree (dedup_key);
+
+
+--
+-- Name: email_notifications_status_send_after_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX email_notifications_status_send_after_idx ON public.email_notifications USING btree (status, send_after);
+
+
+--
+-- Name: email_notifications_subscriber_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX email_notifications_subscriber_id_idx ON public.email_notifications USING btree (subscriber_id);
+
+
+--
+-- Name: fraud_reviews_status_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX fraud_reviews_status_idx ON public.fraud_reviews USING btree (status);
+
+
+--
+-- Name: fraud_reviews_subscription_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX fraud_reviews_subscription_id_idx ON public.fraud_reviews USING btree (subscription_id);
+
+
+--
+-- Name: invoices_payment_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX invoices_payment_id_idx ON public.invoices USING btree (payment_id);
+
+
+--
+-- Name: invoices_status_next_attempt_at_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX invoices_status_next_attempt_at_idx ON public.invoices USING btree (status, next_attempt_at);
+
+
+--
+-- Name: payments_transaction_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX payments_transaction_id_idx ON public.payments USING btree (transaction_id);
+
+
+--
+-- Name: plan_features_plan_id_feature_key_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX plan_features_plan_id_feature_key_idx ON public.plan_features USING btree (plan_id, feature_key);
+
+
+--
+-- Name: plan_installments_plan_id_installments_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX plan_installments_plan_id_installments_idx ON public.plan_installments USING btree (plan_id, installments);
+
+
+--
+-- Name: receipts_payment_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX receipts_payment_id_idx ON public.receipts USING btree (payment_id);
+
+
+--
+-- Name: receipts_year_sequence_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE UNIQUE INDEX receipts_year_sequence_idx ON public.receipts USING btree (year, sequence);
+
+
+--
+-- Name: refunds_payment_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX refunds_payment_id_idx ON public.refunds USING btree (payment_id);
+
+
 --
 -- Name: schema_migration_version_idx; Type: INDEX; Schema: public; Owner: postgres
 --
@@ -155,6 +832,80 @@ ALTER TABLE ONLY public.subscriptions
 CREATE UNIQUE INDEX schema_migration_version_idx ON public.schema_migration USING btree (version);
 
 
+--
+-- Name: subscription_status_changes_subscription_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX subscription_status_changes_subscription_id_idx ON public.subscription_status_changes USING btree (subscription_id);
+
+
+--
+-- Name: webhook_deliveries_event_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX webhook_deliveries_event_id_idx ON public.webhook_deliveries USING btree (event_id);
+
+
+--
+-- Name: webhook_deliveries_status_next_attempt_at_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX webhook_deliveries_status_next_attempt_at_idx ON public.webhook_deliveries USING btree (status, next_attempt_at);
+
+
+--
+-- Name: webhook_deliveries_webhook_endpoint_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX webhook_deliveries_webhook_endpoint_id_idx ON public.webhook_deliveries USING btree (webhook_endpoint_id);
+
+
+--
+-- Name: webhook_delivery_attempts_webhook_delivery_id_idx; Type: INDEX; Schema: public; Owner: postgres
+--
+
+CREATE INDEX webhook_delivery_attempts_webhook_delivery_id_idx ON public.webhook_delivery_attempts USING btree (webhook_delivery_id);
+
+
+--
+-- Name: audit_logs audit_logs_append_only; Type: TRIGGER; Schema: public; Owner: postgres
+--
+
+CREATE TRIGGER audit_logs_append_only BEFORE UPDATE OR DELETE ON public.audit_logs FOR EACH ROW EXECUTE PROCEDURE public.audit_logs_append_only();
+
+
+--
+-- Name: checkout_attempts fk_checkout_attempts_plans; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.checkout_attempts
+    ADD CONSTRAINT fk_checkout_attempts_plans FOREIGN KEY (plan_id) REFERENCES public.plans(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: email_notifications fk_email_notifications_subscribers; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.email_notifications
+    ADD CONSTRAINT fk_email_notifications_subscribers FOREIGN KEY (subscriber_id) REFERENCES public.subscribers(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: fraud_reviews fk_fraud_reviews_subscriptions; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.fraud_reviews
+    ADD CONSTRAINT fk_fraud_reviews_subscriptions FOREIGN KEY (subscription_id) REFERENCES public.subscriptions(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: invoices fk_invoices_payments; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.invoices
+    ADD CONSTRAINT fk_invoices_payments FOREIGN KEY (payment_id) REFERENCES public.payments(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: payments fk_payments_subscriptions; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -163,6 +914,46 @@ ALTER TABLE ONLY public.payments
     ADD CONSTRAINT fk_payments_subscriptions FOREIGN KEY (subscription_id) REFERENCES public.subscriptions(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: plan_features fk_plan_features_plans; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.plan_features
+    ADD CONSTRAINT fk_plan_features_plans FOREIGN KEY (plan_id) REFERENCES public.plans(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: plan_installments fk_plan_installments_plans; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.plan_installments
+    ADD CONSTRAINT fk_plan_installments_plans FOREIGN KEY (plan_id) REFERENCES public.plans(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: receipts fk_receipts_payments; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.receipts
+    ADD CONSTRAINT fk_receipts_payments FOREIGN KEY (payment_id) REFERENCES public.payments(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: refunds fk_refunds_payments; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.refunds
+    ADD CONSTRAINT fk_refunds_payments FOREIGN KEY (payment_id) REFERENCES public.payments(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: subscription_status_changes fk_subscription_status_changes_subscriptions; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.subscription_status_changes
+    ADD CONSTRAINT fk_subscription_status_changes_subscriptions FOREIGN KEY (subscription_id) REFERENCES public.subscriptions(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: subscriptions fk_psubscriptions_plans; Type: FK CONSTRAINT; Schema: public; Owner: postgres
 --
@@ -179,6 +970,22 @@ ALTER TABLE ONLY public.subscriptions
     ADD CONSTRAINT fk_psubscriptions_subscribers FOREIGN KEY (subscriber_id) REFERENCES public.subscribers(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: webhook_deliveries fk_webhook_deliveries_webhook_endpoints; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT fk_webhook_deliveries_webhook_endpoints FOREIGN KEY (webhook_endpoint_id) REFERENCES public.webhook_endpoints(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
+--
+-- Name: webhook_delivery_attempts fk_webhook_delivery_attempts_webhook_deliveries; Type: FK CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.webhook_delivery_attempts
+    ADD CONSTRAINT fk_webhook_delivery_attempts_webhook_deliveries FOREIGN KEY (webhook_delivery_id) REFERENCES public.webhook_deliveries(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+
 --
 -- Name: SCHEMA public; Type: ACL; Schema: -; Owner: postgres
 --
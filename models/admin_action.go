@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Admin console actions
+const (
+	AdminActionCancel           = "subscription.cancel"
+	AdminActionResync           = "subscription.resync"
+	AdminActionRefund           = "payment.refund"
+	AdminActionResendBoleto     = "payment.resend_boleto"
+	AdminActionFraudApprove     = "fraud_review.approve"
+	AdminActionFraudReject      = "fraud_review.reject"
+	AdminActionWebhookCreate    = "webhook_endpoint.create"
+	AdminActionWebhookEnable    = "webhook_endpoint.enable"
+	AdminActionWebhookDisable   = "webhook_endpoint.disable"
+	AdminActionWebhookRedeliver = "webhook_delivery.redeliver"
+)
+
+// AdminAction is used by pop to map your admin_actions database table to your go code.
+// Every action taken from the admin console is kept with the operator who took it.
+type AdminAction struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	Operator     string     `json:"operator" db:"operator"`
+	Action       string     `json:"action" db:"action"`
+	SubscriberID nulls.UUID `json:"subscriber_id" db:"subscriber_id"`
+	SubjectType  string     `json:"subject_type" db:"subject_type"`
+	SubjectID    string     `json:"subject_id" db:"subject_id"`
+	Details      string     `json:"details" db:"details"`
+	Succeeded    bool       `json:"succeeded" db:"succeeded"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (a AdminAction) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// AdminActions is not required by pop and may be deleted
+type AdminActions []AdminAction
+
+// String is not required by pop and may be deleted
+func (a AdminActions) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (a *AdminAction) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: a.Operator, Name: "Operator"},
+		&validators.StringIsPresent{Field: a.Action, Name: "Action"},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (a *AdminAction) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (a *AdminAction) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
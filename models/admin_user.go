@@ -0,0 +1,154 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+// Admin console roles. Every admin user can look around; the other roles unlock actions.
+const (
+	RoleViewer = "viewer"
+	// RoleSupport cancels and resyncs subscriptions and resends boletos
+	RoleSupport = "support"
+	// RoleFinance refunds payments
+	RoleFinance = "finance"
+	// RoleAdmin can do everything the other roles can
+	RoleAdmin = "admin"
+)
+
+// AdminRoles lists the accepted roles
+var AdminRoles = []string{RoleViewer, RoleSupport, RoleFinance, RoleAdmin}
+
+// AdminUser is used by pop to map your admin_users database table to your go code.
+// Only a bcrypt hash of the password is kept; TOTPSecret, encrypted, enables the two-factor code
+// when set, and TOTPLastStep is the time step of the last code used.
+type AdminUser struct {
+	ID           uuid.UUID    `json:"id" db:"id"`
+	Email        string       `json:"email" db:"email"`
+	Name         string       `json:"name" db:"name"`
+	Role         string       `json:"role" db:"role"`
+	PasswordHash string       `json:"-" db:"password_hash"`
+	TOTPSecret   nulls.String `json:"-" db:"totp_secret"`
+	TOTPLastStep nulls.Int64  `json:"-" db:"totp_last_step"`
+	LastLoginAt  nulls.Time   `json:"last_login_at" db:"last_login_at"`
+	DisabledAt   nulls.Time   `json:"disabled_at" db:"disabled_at"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at" db:"updated_at"`
+
+	Password string `json:"-" db:"-"`
+}
+
+// String is not required by pop and may be deleted
+func (a AdminUser) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// HasRole reports whether the user was granted the role, the admin role granting all of them
+func (a AdminUser) HasRole(role string) bool {
+	return a.Role == role || a.Role == RoleAdmin || role == RoleViewer
+}
+
+// TwoFactorEnabled reports whether logging in also takes a TOTP code
+func (a AdminUser) TwoFactorEnabled() bool {
+	return a.TOTPSecret.Valid && a.TOTPSecret.String != ""
+}
+
+// Disabled reports whether the user can no longer log in
+func (a AdminUser) Disabled() bool {
+	return a.DisabledAt.Valid
+}
+
+// CheckPassword compares the password with the stored hash
+func (a AdminUser) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)) == nil
+}
+
+// Create hashes the password, normalizes the e-mail and validates and saves the user
+func (a *AdminUser) Create(tx *pop.Connection) (*validate.Errors, error) {
+	a.Email = strings.ToLower(strings.TrimSpace(a.Email))
+	if err := a.hashPassword(); err != nil {
+		return validate.NewErrors(), err
+	}
+	if a.ID == uuid.Nil {
+		a.ID, _ = uuid.NewV4()
+	}
+	return tx.ValidateAndCreate(a)
+}
+
+// ChangePassword hashes the new password and validates and saves the user
+func (a *AdminUser) ChangePassword(tx *pop.Connection, password string) (*validate.Errors, error) {
+	a.Password = password
+	if err := a.hashPassword(); err != nil {
+		return validate.NewErrors(), err
+	}
+	return tx.ValidateAndUpdate(a)
+}
+
+func (a *AdminUser) hashPassword() error {
+	if a.Password == "" {
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(a.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	a.PasswordHash = string(hash)
+	return nil
+}
+
+// AdminUsers is not required by pop and may be deleted
+type AdminUsers []AdminUser
+
+// String is not required by pop and may be deleted
+func (a AdminUsers) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (a *AdminUser) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	verrs := validate.Validate(
+		&validators.EmailIsPresent{Field: a.Email, Name: "Email"},
+		&validators.StringIsPresent{Field: a.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: a.PasswordHash, Name: "Password"},
+		&validators.StringInclusion{Field: a.Role, Name: "Role", List: AdminRoles},
+	)
+
+	if a.Password != "" && len(a.Password) < 12 {
+		verrs.Add("password", "Password must have at least 12 characters.")
+	}
+
+	return verrs, nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (a *AdminUser) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	verrs := validate.NewErrors()
+
+	exists, err := tx.Where("email = ?", a.Email).Exists(&AdminUser{})
+	if err != nil {
+		return verrs, err
+	}
+	if exists {
+		verrs.Add("email", fmt.Sprintf("%s is already taken.", a.Email))
+	}
+
+	return verrs, nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (a *AdminUser) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
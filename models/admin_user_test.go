@@ -0,0 +1,37 @@
+package models
+
+func (ms *ModelSuite) Test_AdminUser_Create() {
+	user := &AdminUser{Email: " Ana@Example.com ", Name: "Ana", Role: RoleSupport, Password: "correct horse battery"}
+	verrs, err := user.Create(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+
+	ms.Equal("ana@example.com", user.Email)
+	ms.NotEqual("correct horse battery", user.PasswordHash)
+	ms.True(user.CheckPassword("correct horse battery"))
+	ms.False(user.CheckPassword("wrong"))
+
+	duplicate := &AdminUser{Email: "ana@example.com", Name: "Ana", Role: RoleSupport, Password: "correct horse battery"}
+	verrs, err = duplicate.Create(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+}
+
+func (ms *ModelSuite) Test_AdminUser_Validate() {
+	user := &AdminUser{Email: "ana@example.com", Name: "Ana", Role: "owner", Password: "short"}
+	verrs, err := user.Create(DB)
+	ms.NoError(err)
+	ms.NotEmpty(verrs.Get("role"))
+	ms.NotEmpty(verrs.Get("password"))
+}
+
+func (ms *ModelSuite) Test_AdminUser_HasRole() {
+	support := AdminUser{Role: RoleSupport}
+	ms.True(support.HasRole(RoleViewer))
+	ms.True(support.HasRole(RoleSupport))
+	ms.False(support.HasRole(RoleFinance))
+
+	admin := AdminUser{Role: RoleAdmin}
+	ms.True(admin.HasRole(RoleFinance))
+	ms.True(admin.HasRole(RoleSupport))
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+// API scopes granted to other services
+const (
+	ScopeReadSubscriptions  = "read:subscriptions"
+	ScopeWriteSubscriptions = "write:subscriptions"
+	ScopeReadReports        = "read:reports"
+	// ScopeAdmin grants every other scope
+	ScopeAdmin = "admin"
+)
+
+// APIScopes lists the accepted scopes
+var APIScopes = []string{ScopeReadSubscriptions, ScopeWriteSubscriptions, ScopeReadReports, ScopeAdmin}
+
+// APIKey is used by pop to map your api_keys database table to your go code.
+// Only a SHA-256 hash of the secret is kept; Prefix identifies the key in the token and in the logs.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	SecretHash string     `json:"-" db:"secret_hash"`
+	Scopes     string     `json:"scopes" db:"scopes"`
+	LastUsedAt nulls.Time `json:"last_used_at" db:"last_used_at"`
+	RevokedAt  nulls.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (a APIKey) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// ScopeList splits the space separated scopes
+func (a APIKey) ScopeList() []string {
+	return strings.Fields(a.Scopes)
+}
+
+// Revoked reports whether the key can no longer be used
+func (a APIKey) Revoked() bool {
+	return a.RevokedAt.Valid
+}
+
+// HasScope reports whether scopes grant scope, the admin scope granting all of them
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeys is not required by pop and may be deleted
+type APIKeys []APIKey
+
+// String is not required by pop and may be deleted
+func (a APIKeys) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (a *APIKey) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	verrs := validate.Validate(
+		&validators.StringIsPresent{Field: a.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: a.Prefix, Name: "Prefix"},
+		&validators.StringIsPresent{Field: a.SecretHash, Name: "SecretHash"},
+		&validators.StringIsPresent{Field: a.Scopes, Name: "Scopes"},
+	)
+
+	for _, scope := range a.ScopeList() {
+		known := false
+		for _, s := range APIScopes {
+			known = known || s == scope
+		}
+		if !known {
+			verrs.Add("scopes", fmt.Sprintf("%s is not a known scope.", scope))
+		}
+	}
+
+	return verrs, nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (a *APIKey) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (a *APIKey) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
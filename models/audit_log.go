@@ -0,0 +1,186 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/pop/v5/slices"
+	"github.com/gofrs/uuid"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Audit actor types
+const (
+	ActorCustomer = "customer"
+	ActorAdmin    = "admin"
+	ActorService  = "service"
+	ActorWebhook  = "webhook"
+	ActorJob      = "job"
+	ActorSystem   = "system"
+)
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+)
+
+// AuditLog is used by pop to map your audit_logs database table to your go code.
+// Rows are written by the callbacks of the audited models and can not be changed afterwards:
+// a database trigger rejects updates and deletes.
+type AuditLog struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	ActorType     string     `json:"actor_type" db:"actor_type"`
+	ActorID       string     `json:"actor_id" db:"actor_id"`
+	Action        string     `json:"action" db:"action"`
+	AuditableType string     `json:"auditable_type" db:"auditable_type"`
+	AuditableID   uuid.UUID  `json:"auditable_id" db:"auditable_id"`
+	Changes       slices.Map `json:"changes" db:"changes"`
+	RequestID     string     `json:"request_id" db:"request_id"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+// String is not required by pop and may be deleted
+func (a AuditLog) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// ChangesJSON formats the changes for reading
+func (a AuditLog) ChangesJSON() string {
+	ja, _ := json.MarshalIndent(a.Changes, "", "  ")
+	return string(ja)
+}
+
+// AuditLogs is not required by pop and may be deleted
+type AuditLogs []AuditLog
+
+// String is not required by pop and may be deleted
+func (a AuditLogs) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// AuditActor is who or what is changing the records, e.g. {Type: "admin", ID: "ana@codeshop.com.br"}
+type AuditActor struct {
+	Type      string
+	ID        string
+	RequestID string
+}
+
+// DefaultAuditActor is used for changes made outside a connection with a registered actor,
+// e.g. by tasks running straight on DB
+var DefaultAuditActor = AuditActor{Type: ActorSystem}
+
+var auditActors = struct {
+	sync.RWMutex
+	byConnection map[string]AuditActor
+}{byConnection: map[string]AuditActor{}}
+
+// SetAuditActor registers the actor of the changes made through the connection, or its
+// transaction, until the returned function is called
+func SetAuditActor(tx *pop.Connection, actor AuditActor) func() {
+	key := auditKey(tx)
+
+	auditActors.Lock()
+	auditActors.byConnection[key] = actor
+	auditActors.Unlock()
+
+	return func() {
+		auditActors.Lock()
+		delete(auditActors.byConnection, key)
+		auditActors.Unlock()
+	}
+}
+
+// CurrentAuditActor returns the actor registered for the connection, or DefaultAuditActor
+func CurrentAuditActor(tx *pop.Connection) AuditActor {
+	auditActors.RLock()
+	defer auditActors.RUnlock()
+
+	if actor, ok := auditActors.byConnection[auditKey(tx)]; ok {
+		return actor
+	}
+	return DefaultAuditActor
+}
+
+// auditKey identifies the transaction, shared by every copy of the connection made by pop
+func auditKey(tx *pop.Connection) string {
+	if tx.TX != nil {
+		return fmt.Sprintf("tx:%d", tx.TX.ID)
+	}
+	return "connection:" + tx.ID
+}
+
+// auditSnapshot maps the database columns of the model to their JSON values
+func auditSnapshot(model interface{}) map[string]interface{} {
+	snapshot := map[string]interface{}{}
+
+	v := reflect.Indirect(reflect.ValueOf(model))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		column := field.Tag.Get("db")
+		if column == "" || column == "-" || column == "created_at" || column == "updated_at" || field.PkgPath != "" {
+			continue
+		}
+
+		// Round trip through JSON so snapshots compare the way they are stored
+		var value interface{}
+		if b, err := json.Marshal(v.Field(i).Interface()); err == nil {
+			json.Unmarshal(b, &value)
+		}
+		snapshot[column] = value
+	}
+
+	return snapshot
+}
+
+// AuditDiff lists the columns that differ between the snapshots as {"column": {"from": x, "to": y}}.
+// A nil before, for created records, lists every column with only "to".
+func AuditDiff(before map[string]interface{}, after map[string]interface{}) slices.Map {
+	diff := slices.Map{}
+	for column, to := range after {
+		if before == nil {
+			diff[column] = map[string]interface{}{"to": to}
+			continue
+		}
+		if from := before[column]; !reflect.DeepEqual(from, to) {
+			diff[column] = map[string]interface{}{"from": from, "to": to}
+		}
+	}
+	return diff
+}
+
+// recordAudit stores what changed in the model. Updates that changed nothing are not recorded.
+func recordAudit(tx *pop.Connection, action string, auditableType string, id uuid.UUID, before map[string]interface{}, model interface{}) error {
+	changes := AuditDiff(before, auditSnapshot(model))
+	if len(changes) == 0 {
+		return nil
+	}
+
+	actor := CurrentAuditActor(tx)
+	entry := &AuditLog{
+		ActorType:     actor.Type,
+		ActorID:       actor.ID,
+		Action:        action,
+		AuditableType: auditableType,
+		AuditableID:   id,
+		Changes:       changes,
+		RequestID:     actor.RequestID,
+	}
+	entry.ID, _ = uuid.NewV4()
+
+	return tx.Create(entry)
+}
+
+// storedSnapshot loads the stored version of the model, before it is updated
+func storedSnapshot(tx *pop.Connection, model interface{}, id uuid.UUID) (map[string]interface{}, error) {
+	stored := reflect.New(reflect.Indirect(reflect.ValueOf(model)).Type()).Interface()
+	if err := tx.Find(stored, id); err != nil {
+		return nil, err
+	}
+	return auditSnapshot(stored), nil
+}
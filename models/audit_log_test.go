@@ -0,0 +1,60 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+)
+
+func (ms *ModelSuite) Test_AuditDiff() {
+	before := map[string]interface{}{"name": "Ana", "email": "ana@example.com"}
+	after := map[string]interface{}{"name": "Ana Maria", "email": "ana@example.com"}
+
+	diff := AuditDiff(before, after)
+	ms.Len(diff, 1)
+	ms.Equal(map[string]interface{}{"from": "Ana", "to": "Ana Maria"}, diff["name"])
+
+	created := AuditDiff(nil, after)
+	ms.Len(created, 2)
+	ms.Equal(map[string]interface{}{"to": "ana@example.com"}, created["email"])
+
+	ms.Empty(AuditDiff(after, after))
+}
+
+func (ms *ModelSuite) Test_AuditLog_RecordsCreatesAndUpdates() {
+	release := SetAuditActor(DB, AuditActor{Type: ActorAdmin, ID: "support@example.com", RequestID: "req-1"})
+	defer release()
+
+	subscriber := &Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	ms.NoError(DB.Create(subscriber))
+
+	subscriber.Name = "Ana Maria"
+	ms.NoError(DB.Update(subscriber))
+
+	// Saving without changes is not recorded
+	ms.NoError(DB.Update(subscriber))
+
+	logs := AuditLogs{}
+	ms.NoError(DB.Where("auditable_id = ?", subscriber.ID).Order("created_at asc").All(&logs))
+	ms.Len(logs, 2)
+
+	ms.Equal(AuditCreate, logs[0].Action)
+	ms.Equal("subscriber", logs[0].AuditableType)
+	ms.Equal(ActorAdmin, logs[0].ActorType)
+	ms.Equal("support@example.com", logs[0].ActorID)
+	ms.Equal("req-1", logs[0].RequestID)
+
+	ms.Equal(AuditUpdate, logs[1].Action)
+	ms.Len(logs[1].Changes, 1)
+	ms.Equal(map[string]interface{}{"from": "Ana", "to": "Ana Maria"}, logs[1].Changes["name"])
+}
+
+func (ms *ModelSuite) Test_AuditLog_AppendOnly() {
+	subscriber := &Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	ms.NoError(DB.Create(subscriber))
+
+	entry := &AuditLog{}
+	ms.NoError(DB.Where("auditable_id = ?", subscriber.ID).First(entry))
+	ms.Equal(ActorSystem, entry.ActorType)
+
+	ms.Error(DB.RawQuery("UPDATE audit_logs SET actor_id = 'someone' WHERE id = ?", entry.ID).Exec())
+	ms.Error(DB.RawQuery("DELETE FROM audit_logs WHERE id = ?", entry.ID).Exec())
+}
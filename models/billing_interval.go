@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Billing interval units
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// IntervalUnits lists the accepted billing interval units
+var IntervalUnits = []string{IntervalDay, IntervalWeek, IntervalMonth, IntervalYear}
+
+// BillingInterval is how often a plan charges, e.g. quarterly is {Unit: "month", Count: 3}
+type BillingInterval struct {
+	Unit  string `json:"unit"`
+	Count int    `json:"count"`
+}
+
+// AddTo returns the end of the period starting at t. Months and years follow the
+// calendar, so Jan 31 plus one month is normalized the way time.AddDate does.
+func (b BillingInterval) AddTo(t time.Time) time.Time {
+	switch b.Unit {
+	case IntervalDay:
+		return t.AddDate(0, 0, b.Count)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7*b.Count)
+	case IntervalYear:
+		return t.AddDate(b.Count, 0, 0)
+	default:
+		return t.AddDate(0, b.Count, 0)
+	}
+}
+
+// Months is the interval length in months, zero for day and week based intervals
+func (b BillingInterval) Months() int {
+	switch b.Unit {
+	case IntervalMonth:
+		return b.Count
+	case IntervalYear:
+		return 12 * b.Count
+	}
+	return 0
+}
+
+// Days is the nominal interval length in days, the way the gateway configures plans
+// (30 days a month, 365 a year)
+func (b BillingInterval) Days() int {
+	switch b.Unit {
+	case IntervalDay:
+		return b.Count
+	case IntervalWeek:
+		return 7 * b.Count
+	case IntervalYear:
+		return 365 * b.Count
+	default:
+		return 30 * b.Count
+	}
+}
+
+// TranslationID is the locale entry holding the label of the interval unit.
+// Translate it passing Count, e.g. t(interval.TranslationID(), interval.Count).
+func (b BillingInterval) TranslationID() string {
+	return "billing_interval_" + b.Unit
+}
+
+// Valid reports whether the unit is known and the count is positive
+func (b BillingInterval) Valid() bool {
+	if b.Count < 1 {
+		return false
+	}
+	for _, unit := range IntervalUnits {
+		if b.Unit == unit {
+			return true
+		}
+	}
+	return false
+}
+
+func (b BillingInterval) String() string {
+	return fmt.Sprintf("%d %s", b.Count, b.Unit)
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+func (ms *ModelSuite) Test_BillingInterval_AddTo() {
+	start := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	ms.Equal(time.Date(2020, 2, 15, 0, 0, 0, 0, time.UTC), BillingInterval{Unit: IntervalDay, Count: 15}.AddTo(start))
+	ms.Equal(time.Date(2020, 2, 14, 0, 0, 0, 0, time.UTC), BillingInterval{Unit: IntervalWeek, Count: 2}.AddTo(start))
+	ms.Equal(time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), BillingInterval{Unit: IntervalMonth, Count: 3}.AddTo(start))
+	ms.Equal(time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC), BillingInterval{Unit: IntervalYear, Count: 1}.AddTo(start))
+}
+
+func (ms *ModelSuite) Test_BillingInterval_Lengths() {
+	quarterly := BillingInterval{Unit: IntervalMonth, Count: 3}
+	ms.Equal(3, quarterly.Months())
+	ms.Equal(90, quarterly.Days())
+	ms.Equal("billing_interval_month", quarterly.TranslationID())
+
+	yearly := BillingInterval{Unit: IntervalYear, Count: 1}
+	ms.Equal(12, yearly.Months())
+	ms.Equal(365, yearly.Days())
+
+	ms.True(quarterly.Valid())
+	ms.False(BillingInterval{Unit: "fortnight", Count: 1}.Valid())
+	ms.False(BillingInterval{Unit: IntervalMonth, Count: 0}.Valid())
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Checkout attempt outcomes
+const (
+	// CheckoutSucceeded is a card charged and its subscription created
+	CheckoutSucceeded = "succeeded"
+	// CheckoutBoletoIssued is a subscription waiting for its boleto to be paid
+	CheckoutBoletoIssued = "boleto_issued"
+	// CheckoutDeclined is a charge the gateway refused
+	CheckoutDeclined = "declined"
+	// CheckoutPending is a checkout the gateway may or may not have charged
+	CheckoutPending = "pending"
+	// CheckoutFailed is a checkout that failed before charging, on our side or the gateway's
+	CheckoutFailed = "failed"
+	// CheckoutFraudRejected is a checkout the fraud check stopped before charging
+	CheckoutFraudRejected = "fraud_rejected"
+)
+
+// CheckoutOutcomes lists the outcomes in funnel order
+var CheckoutOutcomes = []string{CheckoutSucceeded, CheckoutBoletoIssued, CheckoutDeclined, CheckoutPending, CheckoutFailed, CheckoutFraudRejected}
+
+// CheckoutAttempt is used by pop to map your checkout_attempts database table to your go code.
+// Every checkout is kept here, whatever its outcome, for conversion funnels and fraud
+// investigations. Email, document number, IP and user agent are cleared once the attempt is older than the
+// retention of personal data, see services.PruneCheckoutAttempts.
+type CheckoutAttempt struct {
+	ID                   uuid.UUID    `json:"id" db:"id"`
+	PlanID               uuid.UUID    `json:"plan_id" db:"plan_id"`
+	Email                string       `json:"email" db:"email"`
+	DocumentNumber       string       `json:"document_number" db:"document_number"`
+	CardHashDigest       string       `json:"-" db:"card_hash_digest"`
+	PaymentMethod        string       `json:"payment_method" db:"payment_method"`
+	Installments         int          `json:"installments" db:"installments"`
+	Outcome              string       `json:"outcome" db:"outcome"`
+	DeclineReason        string       `json:"decline_reason" db:"decline_reason"`
+	RefuseReason         string       `json:"refuse_reason" db:"refuse_reason"`
+	AcquirerResponseCode string       `json:"acquirer_response_code" db:"acquirer_response_code"`
+	GatewayStatusCode    int          `json:"gateway_status_code" db:"gateway_status_code"`
+	RemoteSubscriptionID nulls.String `json:"remote_subscription_id" db:"remote_subscription_id"`
+	Error                string       `json:"error" db:"error"`
+	FraudScore           int          `json:"fraud_score" db:"fraud_score"`
+	FraudDecision        string       `json:"fraud_decision" db:"fraud_decision"`
+	IP                   string       `json:"ip" db:"ip"`
+	UserAgent            string       `json:"user_agent" db:"user_agent"`
+	CreatedAt            time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (c CheckoutAttempt) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
+
+// CheckoutAttempts is not required by pop and may be deleted
+type CheckoutAttempts []CheckoutAttempt
+
+// String is not required by pop and may be deleted
+func (c CheckoutAttempts) String() string {
+	jc, _ := json.Marshal(c)
+	return string(jc)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (c *CheckoutAttempt) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: c.PlanID, Name: "PlanID"},
+		&validators.StringInclusion{Field: c.Outcome, Name: "Outcome", List: CheckoutOutcomes},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (c *CheckoutAttempt) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (c *CheckoutAttempt) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,18 @@
+package models
+
+import "github.com/gofrs/uuid"
+
+func (ms *ModelSuite) Test_CheckoutAttempt_Validate() {
+	attempt := &CheckoutAttempt{Email: "ana@example.com", Outcome: "unknown"}
+
+	verrs, err := attempt.Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+	ms.NotEmpty(verrs.Get("plan_id"))
+	ms.NotEmpty(verrs.Get("outcome"))
+
+	attempt.PlanID, attempt.Outcome = uuid.Must(uuid.NewV4()), CheckoutDeclined
+	verrs, err = attempt.Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
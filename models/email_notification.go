@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+// Locales are the languages we write to subscribers in
+const (
+	LocalePtBR    = "pt-BR"
+	LocaleEn      = "en"
+	DefaultLocale = LocalePtBR
+)
+
+// NormalizeLocale picks, among our locales, the one matching a language tag, e.g. "en-US"
+func NormalizeLocale(tag string) string {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(tag)), "en") {
+		return LocaleEn
+	}
+	return DefaultLocale
+}
+
+// Email notification kinds
+const (
+	NotificationWelcome              = "welcome"
+	NotificationBoletoIssued         = "boleto_issued"
+	NotificationBoletoResent         = "boleto_resent"
+	NotificationRenewalReceipt       = "renewal_receipt"
+	NotificationSubscriptionCanceled = "subscription_canceled"
+	NotificationSubscriptionExpired  = "subscription_expired"
+)
+
+// NotificationKinds lists the kinds of email we send
+var NotificationKinds = []string{
+	NotificationWelcome, NotificationBoletoIssued, NotificationBoletoResent, NotificationRenewalReceipt,
+	NotificationSubscriptionCanceled, NotificationSubscriptionExpired,
+}
+
+// Email notification statuses
+const (
+	NotificationPending = "pending"
+	NotificationSending = "sending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// EmailNotification is used by pop to map your email_notifications database table to your go code.
+// Each email is queued here in the transaction of the change it tells about and sent
+// afterwards, see services.NotificationService. DedupKey keeps the same email from being
+// queued twice.
+type EmailNotification struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	Kind           string     `json:"kind" db:"kind"`
+	DedupKey       string     `json:"dedup_key" db:"dedup_key"`
+	SubscriberID   uuid.UUID  `json:"subscriber_id" db:"subscriber_id"`
+	SubscriptionID nulls.UUID `json:"subscription_id" db:"subscription_id"`
+	PaymentID      nulls.UUID `json:"payment_id" db:"payment_id"`
+	Recipient      string     `json:"recipient" db:"recipient"`
+	Locale         string     `json:"locale" db:"locale"`
+	Subject        string     `json:"subject" db:"subject"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	LastError      string     `json:"last_error" db:"last_error"`
+	SendAfter      time.Time  `json:"send_after" db:"send_after"`
+	SentAt         nulls.Time `json:"sent_at" db:"sent_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (e EmailNotification) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
+
+// EmailNotifications is not required by pop and may be deleted
+type EmailNotifications []EmailNotification
+
+// String is not required by pop and may be deleted
+func (e EmailNotifications) String() string {
+	je, _ := json.Marshal(e)
+	return string(je)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (e *EmailNotification) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringInclusion{Field: e.Kind, Name: "Kind", List: NotificationKinds},
+		&validators.StringIsPresent{Field: e.DedupKey, Name: "DedupKey"},
+		&validators.UUIDIsPresent{Field: e.SubscriberID, Name: "SubscriberID"},
+		&validators.EmailIsPresent{Field: e.Recipient, Name: "Recipient"},
+		&validators.StringInclusion{Field: e.Locale, Name: "Locale", List: []string{LocalePtBR, LocaleEn}},
+		&validators.StringInclusion{Field: e.Status, Name: "Status", List: []string{NotificationPending, NotificationSending, NotificationSent, NotificationFailed}},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (e *EmailNotification) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (e *EmailNotification) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+func (ms *ModelSuite) Test_EmailNotification_Validate() {
+	notification := &EmailNotification{Kind: "newsletter", Recipient: "ana", Locale: "es", Status: NotificationPending}
+
+	verrs, err := notification.Validate(DB)
+	ms.NoError(err)
+	for _, field := range []string{"kind", "dedup_key", "subscriber_id", "recipient", "locale"} {
+		ms.NotEmpty(verrs.Get(field), field)
+	}
+
+	notification.Kind, notification.DedupKey, notification.SubscriberID = NotificationWelcome, "welcome:1", uuid.Must(uuid.NewV4())
+	notification.Recipient, notification.Locale, notification.SendAfter = "ana@example.com", LocaleEn, time.Now()
+	verrs, err = notification.Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
+
+func (ms *ModelSuite) Test_NormalizeLocale() {
+	ms.Equal(LocaleEn, NormalizeLocale("en-US"))
+	ms.Equal(LocaleEn, NormalizeLocale("en"))
+	ms.Equal(LocalePtBR, NormalizeLocale("pt-BR"))
+	ms.Equal(LocalePtBR, NormalizeLocale(""))
+	ms.Equal(LocalePtBR, NormalizeLocale("es"))
+}
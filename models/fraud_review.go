@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"strings"
+	"time"
+)
+
+// Fraud review statuses
+const (
+	FraudReviewOpen     = "open"
+	FraudReviewApproved = "approved"
+	FraudReviewRejected = "rejected"
+)
+
+// FraudReview is used by pop to map your fraud_reviews database table to your go code.
+// Subscriptions the fraud check flagged wait here for an operator to approve them, or to
+// reject them, which refunds and cancels the subscription.
+type FraudReview struct {
+	ID             uuid.UUID    `json:"id" db:"id"`
+	SubscriptionID uuid.UUID    `json:"subscription_id" db:"subscription_id"`
+	Subscription   Subscription `json:"-" belongs_to:"subscription" db:"-"`
+	Score          int          `json:"score" db:"score"`
+	// Reasons are the rules that matched, one per line
+	Reasons    string       `json:"reasons" db:"reasons"`
+	Status     string       `json:"status" db:"status"`
+	ReviewedBy nulls.String `json:"reviewed_by" db:"reviewed_by"`
+	ReviewedAt nulls.Time   `json:"reviewed_at" db:"reviewed_at"`
+	Notes      string       `json:"notes" db:"notes"`
+	CreatedAt  time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (f FraudReview) String() string {
+	jf, _ := json.Marshal(f)
+	return string(jf)
+}
+
+// FraudReviews is not required by pop and may be deleted
+type FraudReviews []FraudReview
+
+// String is not required by pop and may be deleted
+func (f FraudReviews) String() string {
+	jf, _ := json.Marshal(f)
+	return string(jf)
+}
+
+// ReasonList splits the reasons
+func (f FraudReview) ReasonList() []string {
+	if f.Reasons == "" {
+		return []string{}
+	}
+	return strings.Split(f.Reasons, "\n")
+}
+
+// Open reports whether the review still waits for a decision
+func (f FraudReview) Open() bool {
+	return f.Status == FraudReviewOpen
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (f *FraudReview) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: f.SubscriptionID, Name: "SubscriptionID"},
+		&validators.StringInclusion{Field: f.Status, Name: "Status", List: []string{FraudReviewOpen, FraudReviewApproved, FraudReviewRejected}},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (f *FraudReview) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (f *FraudReview) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import "github.com/gofrs/uuid"
+
+func (ms *ModelSuite) Test_FraudReview_Validate() {
+	review := &FraudReview{Status: "pending"}
+
+	verrs, err := review.Validate(DB)
+	ms.NoError(err)
+	ms.NotEmpty(verrs.Get("subscription_id"))
+	ms.NotEmpty(verrs.Get("status"))
+
+	review.SubscriptionID, review.Status = uuid.Must(uuid.NewV4()), FraudReviewOpen
+	verrs, err = review.Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
+
+func (ms *ModelSuite) Test_FraudReview_ReasonList() {
+	ms.Equal([]string{}, FraudReview{}.ReasonList())
+	ms.Equal([]string{"a", "b"}, FraudReview{Reasons: "a\nb"}.ReasonList())
+}
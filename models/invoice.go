@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Invoice statuses
+const (
+	InvoicePending  = "pending"
+	InvoiceIssued   = "issued"
+	InvoiceFailed   = "failed"
+	InvoiceCanceled = "canceled"
+)
+
+// Invoice is used by pop to map your invoices database table to your go code.
+// It holds the fiscal invoice (NFS-e) issued for a paid Payment.
+type Invoice struct {
+	ID               uuid.UUID    `json:"id" db:"id"`
+	PaymentID        uuid.UUID    `json:"payment_id" db:"payment_id"`
+	Payment          Payment      `json:"-" belongs_to:"payment" db:"-"`
+	Provider         string       `json:"provider" db:"provider"`
+	Status           string       `json:"status" db:"status"`
+	Number           nulls.String `json:"number" db:"number"`
+	VerificationCode nulls.String `json:"verification_code" db:"verification_code"`
+	PDFURL           nulls.String `json:"pdf_url" db:"pdf_url"`
+	Attempts         int          `json:"attempts" db:"attempts"`
+	LastError        nulls.String `json:"last_error" db:"last_error"`
+	NextAttemptAt    nulls.Time   `json:"next_attempt_at" db:"next_attempt_at"`
+	IssuedAt         nulls.Time   `json:"issued_at" db:"issued_at"`
+	CanceledAt       nulls.Time   `json:"canceled_at" db:"canceled_at"`
+	CreatedAt        time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (i Invoice) String() string {
+	ji, _ := json.Marshal(i)
+	return string(ji)
+}
+
+// Invoices is not required by pop and may be deleted
+type Invoices []Invoice
+
+// String is not required by pop and may be deleted
+func (i Invoices) String() string {
+	ji, _ := json.Marshal(i)
+	return string(ji)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (i *Invoice) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: i.Provider, Name: "Provider"},
+		&validators.StringInclusion{Field: i.Status, Name: "Status", List: []string{InvoicePending, InvoiceIssued, InvoiceFailed, InvoiceCanceled}},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (i *Invoice) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (i *Invoice) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
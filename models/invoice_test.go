@@ -0,0 +1,14 @@
+package models
+
+func (ms *ModelSuite) Test_Invoice_Validate() {
+	invoice := &Invoice{Provider: "fake", Status: "unknown"}
+
+	verrs, err := invoice.Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+
+	invoice.Status = InvoicePending
+	verrs, err = invoice.Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
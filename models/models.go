@@ -1,6 +1,8 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 
 	"github.com/gobuffalo/envy"
@@ -20,3 +22,8 @@ func init() {
 	}
 	pop.Debug = env == "development"
 }
+
+// IsNotFound reports whether err means the query matched no rows.
+func IsNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
@@ -8,6 +8,15 @@ import (
 	"time"
 )
 
+// Payment statuses. The gateway reports "paid", "unpaid", "pending_payment" and so on;
+// the refund statuses are set by us.
+const (
+	PaymentPaid              = "paid"
+	PaymentRefunded          = "refunded"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentChargedback       = "chargedback"
+)
+
 // Payment is used by pop to map your payments database table to your go code.
 type Payment struct {
 	ID             uuid.UUID    `json:"id" db:"id"`
@@ -26,8 +35,11 @@ type Payment struct {
 	Installments   int          `json:"installments" db:"installments"`
 	Subscription   Subscription `belongs_to:"subscription" db:"-"`
 	SubscriptionID uuid.UUID    `db:"subscription_id"`
+	Refunds        Refunds      `json:"-" has_many:"refunds" db:"-"`
 	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time    `json:"updated_at" db:"updated_at"`
+
+	auditBefore map[string]interface{} `db:"-"`
 }
 
 // String is not required by pop and may be deleted
@@ -36,6 +48,16 @@ func (p Payment) String() string {
 	return string(jp)
 }
 
+// Refundable reports whether money can still be returned for this payment
+func (p Payment) Refundable() bool {
+	return p.Status == PaymentPaid || p.Status == PaymentPartiallyRefunded
+}
+
+// Settled reports whether the payment was paid, even if its money was returned since
+func (p Payment) Settled() bool {
+	return p.Refundable() || p.Status == PaymentRefunded || p.Status == PaymentChargedback
+}
+
 // Payments is not required by pop and may be deleted
 type Payments []Payment
 
@@ -62,3 +84,20 @@ func (p *Payment) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
 func (p *Payment) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
 	return validate.NewErrors(), nil
 }
+
+// AfterCreate records the new payment in the audit log
+func (p *Payment) AfterCreate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditCreate, "payment", p.ID, nil, p)
+}
+
+// BeforeUpdate remembers the stored version, so AfterUpdate knows what changed
+func (p *Payment) BeforeUpdate(tx *pop.Connection) error {
+	before, err := storedSnapshot(tx, p, p.ID)
+	p.auditBefore = before
+	return err
+}
+
+// AfterUpdate records the changes in the audit log
+func (p *Payment) AfterUpdate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditUpdate, "payment", p.ID, p.auditBefore, p)
+}
@@ -4,22 +4,109 @@ import (
 	"encoding/json"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
 	"github.com/gofrs/uuid"
+	"math"
 	"time"
 )
 
 // Plan is used by pop to map your plans database table to your go code.
 type Plan struct {
-	ID            uuid.UUID     `json:"id" db:"id"`
-	Name          string        `json:"name" db:"name"`
-	Description   string        `json:"description" db:"description"`
-	Price         float32       `json:"price" db:"price"`
-	RemotePanID   string        `json:"remote_plan_id" db:"remote_plan_id"`
-	Recurrence    string        `json:"recurrence" db:"recurrence"`
-	Active        bool          `json:"active" db:"active"`
-	Subscriptions Subscriptions `has_many:"subscriptions" db:"-"`
-	CreatedAt     time.Time     `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time     `json:"updated_at" db:"updated_at"`
+	ID               uuid.UUID        `json:"id" db:"id"`
+	Name             string           `json:"name" db:"name"`
+	Description      string           `json:"description" db:"description"`
+	Price            float32          `json:"price" db:"price"`
+	RemotePanID      string           `json:"remote_plan_id" db:"remote_plan_id"`
+	Recurrence       string           `json:"recurrence" db:"recurrence"`
+	IntervalUnit     string           `json:"interval_unit" db:"interval_unit"`
+	IntervalCount    int              `json:"interval_count" db:"interval_count"`
+	Active           bool             `json:"active" db:"active"`
+	MaxInstallments  int              `json:"max_installments" db:"max_installments"`
+	InstallmentRates PlanInstallments `json:"installment_rates" has_many:"plan_installments" order_by:"installments asc" db:"-"`
+	Features         PlanFeatures     `json:"features" has_many:"plan_features" order_by:"feature_key asc" db:"-"`
+	Subscriptions    Subscriptions    `has_many:"subscriptions" db:"-"`
+	CreatedAt        time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at" db:"updated_at"`
+
+	auditBefore map[string]interface{} `db:"-"`
+}
+
+// MaxInstallments is the most installments the gateway accepts for a card payment
+const MaxInstallments = 12
+
+// InstallmentOption is one of the choices offered at checkout, amounts in cents
+type InstallmentOption struct {
+	Installments int     `json:"installments"`
+	InterestRate float64 `json:"interest_rate"`
+	Amount       int     `json:"amount"`
+	Total        int     `json:"total"`
+}
+
+// PriceInCents converts the plan price to cents, the unit used by the gateway
+func (p Plan) PriceInCents() int {
+	return int(math.Round(float64(p.Price) * 100))
+}
+
+// MonthlyPriceInCents spreads the price over the months of the interval, the way recurring
+// revenue is reported. Day and week based plans use 30 day months.
+func (p Plan) MonthlyPriceInCents() int {
+	if months := p.Interval().Months(); months > 0 {
+		return int(math.Round(float64(p.PriceInCents()) / float64(months)))
+	}
+	if days := p.Interval().Days(); days > 0 {
+		return int(math.Round(float64(p.PriceInCents()) * 30 / float64(days)))
+	}
+	return p.PriceInCents()
+}
+
+// Interval is how often the plan charges. Recurrence is kept only as the legacy free text description.
+func (p Plan) Interval() BillingInterval {
+	return BillingInterval{Unit: p.IntervalUnit, Count: p.IntervalCount}
+}
+
+// IsAnnual reports whether the plan is billed once a year or less often
+func (p Plan) IsAnnual() bool {
+	return p.Interval().Months() >= 12
+}
+
+// InstallmentOptions lists the installments offered for card payments. Only annual plans
+// can be split; InstallmentRates must be loaded to apply interest.
+func (p Plan) InstallmentOptions() []InstallmentOption {
+	max := p.MaxInstallments
+	if !p.IsAnnual() || max < 1 {
+		max = 1
+	}
+	if max > MaxInstallments {
+		max = MaxInstallments
+	}
+
+	rates := map[int]float64{}
+	for _, rate := range p.InstallmentRates {
+		rates[rate.Installments] = rate.InterestRate
+	}
+
+	price := p.PriceInCents()
+	options := make([]InstallmentOption, 0, max)
+	for n := 1; n <= max; n++ {
+		total := int(math.Round(float64(price) * (1 + rates[n]/100)))
+		options = append(options, InstallmentOption{
+			Installments: n,
+			InterestRate: rates[n],
+			Amount:       int(math.Ceil(float64(total) / float64(n))),
+			Total:        total,
+		})
+	}
+	return options
+}
+
+// InstallmentOption returns the option for n installments, false when the plan does not offer it
+func (p Plan) InstallmentOption(n int) (InstallmentOption, bool) {
+	for _, option := range p.InstallmentOptions() {
+		if option.Installments == n {
+			return option, true
+		}
+	}
+	return InstallmentOption{}, false
 }
 
 // String is not required by pop and may be deleted
@@ -40,7 +127,11 @@ func (p Plans) String() string {
 // Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
 // This method is not required and may be deleted.
 func (p *Plan) Validate(tx *pop.Connection) (*validate.Errors, error) {
-	return validate.NewErrors(), nil
+	return validate.Validate(
+		&validators.IntIsLessThan{Field: p.MaxInstallments, Name: "MaxInstallments", Compared: MaxInstallments + 1},
+		&validators.StringInclusion{Field: p.IntervalUnit, Name: "IntervalUnit", List: IntervalUnits},
+		&validators.IntIsGreaterThan{Field: p.IntervalCount, Name: "IntervalCount", Compared: 0},
+	), nil
 }
 
 // ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
@@ -54,3 +145,20 @@ func (p *Plan) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
 func (p *Plan) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
 	return validate.NewErrors(), nil
 }
+
+// AfterCreate records the new plan in the audit log
+func (p *Plan) AfterCreate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditCreate, "plan", p.ID, nil, p)
+}
+
+// BeforeUpdate remembers the stored version, so AfterUpdate knows what changed
+func (p *Plan) BeforeUpdate(tx *pop.Connection) error {
+	before, err := storedSnapshot(tx, p, p.ID)
+	p.auditBefore = before
+	return err
+}
+
+// AfterUpdate records the changes in the audit log
+func (p *Plan) AfterUpdate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditUpdate, "plan", p.ID, p.auditBefore, p)
+}
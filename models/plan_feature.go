@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"regexp"
+	"time"
+)
+
+// PlanFeature is used by pop to map your plan_features database table to your go code.
+// Each row grants a feature to the subscribers of the plan, e.g. {FeatureKey: "projects", Limit: 10};
+// a null Limit means the feature is unlimited.
+type PlanFeature struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	PlanID     uuid.UUID `json:"plan_id" db:"plan_id"`
+	Plan       Plan      `json:"-" belongs_to:"plan" db:"-"`
+	FeatureKey string    `json:"feature_key" db:"feature_key"`
+	Limit      nulls.Int `json:"limit" db:"feature_limit"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+var featureKeyFormat = regexp.MustCompile(`^[a-z0-9_.:-]+$`)
+
+// String is not required by pop and may be deleted
+func (p PlanFeature) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// PlanFeatures is not required by pop and may be deleted
+type PlanFeatures []PlanFeature
+
+// String is not required by pop and may be deleted
+func (p PlanFeatures) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (p *PlanFeature) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	checks := []validate.Validator{
+		&validators.StringIsPresent{Field: p.FeatureKey, Name: "FeatureKey"},
+		&validators.RegexMatch{Field: p.FeatureKey, Name: "FeatureKey", Expr: featureKeyFormat.String(),
+			Message: "FeatureKey must be lower case letters, digits, '_', '.', ':' or '-'."},
+	}
+	if p.Limit.Valid {
+		checks = append(checks, &validators.IntIsGreaterThan{Field: p.Limit.Int, Name: "Limit", Compared: -1})
+	}
+	return validate.Validate(checks...), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (p *PlanFeature) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (p *PlanFeature) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// Entitlement is what a subscriber may use of a feature. Limit is meaningless when Unlimited is set.
+type Entitlement struct {
+	Limit     int  `json:"limit"`
+	Unlimited bool `json:"unlimited"`
+}
+
+// Entitlements maps feature keys to what the subscriber is entitled to
+type Entitlements map[string]Entitlement
+
+// MergeEntitlements combines the features of several plans, keeping the most generous grant of each
+// feature: unlimited wins over any limit and the highest limit wins otherwise
+func MergeEntitlements(features ...PlanFeatures) Entitlements {
+	entitlements := Entitlements{}
+	for _, planFeatures := range features {
+		for _, feature := range planFeatures {
+			current, ok := entitlements[feature.FeatureKey]
+			switch {
+			case !feature.Limit.Valid:
+				current = Entitlement{Unlimited: true}
+			case !ok:
+				current = Entitlement{Limit: feature.Limit.Int}
+			case !current.Unlimited && feature.Limit.Int > current.Limit:
+				current.Limit = feature.Limit.Int
+			}
+			entitlements[feature.FeatureKey] = current
+		}
+	}
+	return entitlements
+}
@@ -0,0 +1,36 @@
+package models
+
+import "github.com/gobuffalo/nulls"
+
+func (ms *ModelSuite) Test_MergeEntitlements() {
+	basic := PlanFeatures{
+		{FeatureKey: "projects", Limit: nulls.NewInt(3)},
+		{FeatureKey: "reports", Limit: nulls.NewInt(1)},
+	}
+	pro := PlanFeatures{
+		{FeatureKey: "projects", Limit: nulls.NewInt(10)},
+		{FeatureKey: "reports"},
+		{FeatureKey: "api", Limit: nulls.NewInt(0)},
+	}
+
+	entitlements := MergeEntitlements(basic, pro)
+
+	ms.Equal(Entitlement{Limit: 10}, entitlements["projects"])
+	ms.Equal(Entitlement{Unlimited: true}, entitlements["reports"])
+	ms.Equal(Entitlement{Limit: 0}, entitlements["api"])
+	ms.Len(entitlements, 3)
+}
+
+func (ms *ModelSuite) Test_PlanFeature_Validate() {
+	verrs, err := (&PlanFeature{FeatureKey: "Projects Count"}).Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+
+	verrs, err = (&PlanFeature{FeatureKey: "projects", Limit: nulls.NewInt(-1)}).Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+
+	verrs, err = (&PlanFeature{FeatureKey: "reports.export"}).Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// PlanInstallment is used by pop to map your plan_installments database table to your go code.
+// Each row is a line of the plan interest table: the total interest, in percent, added to the
+// price when paying in that many installments. Installment counts without a row are interest free.
+type PlanInstallment struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	PlanID       uuid.UUID `json:"plan_id" db:"plan_id"`
+	Plan         Plan      `json:"-" belongs_to:"plan" db:"-"`
+	Installments int       `json:"installments" db:"installments"`
+	InterestRate float64   `json:"interest_rate" db:"interest_rate"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (p PlanInstallment) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// PlanInstallments is not required by pop and may be deleted
+type PlanInstallments []PlanInstallment
+
+// String is not required by pop and may be deleted
+func (p PlanInstallments) String() string {
+	jp, _ := json.Marshal(p)
+	return string(jp)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (p *PlanInstallment) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.IntIsGreaterThan{Field: p.Installments, Name: "Installments", Compared: 1},
+		&validators.IntIsLessThan{Field: p.Installments, Name: "Installments", Compared: MaxInstallments + 1},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (p *PlanInstallment) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (p *PlanInstallment) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
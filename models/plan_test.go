@@ -3,3 +3,34 @@ package models
 func (ms *ModelSuite) Test_Plan() {
 	ms.Fail("This test needs to be implemented!")
 }
+
+func (ms *ModelSuite) Test_Plan_InstallmentOptions() {
+	plan := Plan{Price: 499.00, IntervalUnit: IntervalYear, IntervalCount: 1, MaxInstallments: 12}
+	plan.InstallmentRates = PlanInstallments{
+		{Installments: 12, InterestRate: 10},
+	}
+
+	options := plan.InstallmentOptions()
+	ms.Len(options, 12)
+	ms.Equal(InstallmentOption{Installments: 1, Amount: 49900, Total: 49900}, options[0])
+	ms.Equal(InstallmentOption{Installments: 3, Amount: 16634, Total: 49900}, options[2])
+	ms.Equal(InstallmentOption{Installments: 12, InterestRate: 10, Amount: 4575, Total: 54890}, options[11])
+
+	_, ok := plan.InstallmentOption(13)
+	ms.False(ok)
+}
+
+func (ms *ModelSuite) Test_Plan_InstallmentOptions_Monthly() {
+	plan := Plan{Price: 49.90, IntervalUnit: IntervalMonth, IntervalCount: 1, MaxInstallments: 12}
+
+	options := plan.InstallmentOptions()
+	ms.Len(options, 1)
+	ms.Equal(4990, options[0].Total)
+}
+
+func (ms *ModelSuite) Test_Plan_MonthlyPriceInCents() {
+	ms.Equal(4990, Plan{Price: 49.90, IntervalUnit: IntervalMonth, IntervalCount: 1}.MonthlyPriceInCents())
+	ms.Equal(3000, Plan{Price: 90, IntervalUnit: IntervalMonth, IntervalCount: 3}.MonthlyPriceInCents())
+	ms.Equal(4158, Plan{Price: 499, IntervalUnit: IntervalYear, IntervalCount: 1}.MonthlyPriceInCents())
+	ms.Equal(4286, Plan{Price: 10, IntervalUnit: IntervalWeek, IntervalCount: 1}.MonthlyPriceInCents())
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Receipt is used by pop to map your receipts database table to your go code.
+// Besides the numbering it keeps a copy of what it prints, taken when it was issued, so the
+// document does not change when the subscriber, the plan or the subscription change later.
+type Receipt struct {
+	ID               uuid.UUID `json:"id" db:"id"`
+	PaymentID        uuid.UUID `json:"payment_id" db:"payment_id"`
+	Payment          Payment   `json:"-" belongs_to:"payment" db:"-"`
+	Number           string    `json:"number" db:"number"`
+	Year             int       `json:"year" db:"year"`
+	Sequence         int       `json:"sequence" db:"sequence"`
+	IssuedAt         time.Time `json:"issued_at" db:"issued_at"`
+	CustomerName     string    `json:"customer_name" db:"customer_name"`
+	CustomerDocument string    `json:"customer_document" db:"customer_document"`
+	CustomerAddress  string    `json:"customer_address" db:"customer_address"`
+	CustomerEmail    string    `json:"customer_email" db:"customer_email"`
+	PlanName         string    `json:"plan_name" db:"plan_name"`
+	PlanDescription  string    `json:"plan_description" db:"plan_description"`
+	PeriodStart      time.Time `json:"period_start" db:"period_start"`
+	PeriodEnd        time.Time `json:"period_end" db:"period_end"`
+	Total            int       `json:"total" db:"total"`
+	PaidAt           time.Time `json:"paid_at" db:"paid_at"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (r Receipt) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Receipts is not required by pop and may be deleted
+type Receipts []Receipt
+
+// String is not required by pop and may be deleted
+func (r Receipts) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// FormatReceiptNumber builds the printed receipt number, e.g. 2020-000042.
+func FormatReceiptNumber(year int, sequence int) string {
+	return fmt.Sprintf("%d-%06d", year, sequence)
+}
+
+// receiptSequence is the counter of the receipts of a year
+type receiptSequence struct {
+	Sequence int `db:"last_sequence"`
+}
+
+// NextReceiptSequence takes the next sequence of the given year from its counter in
+// receipt_sequences. The counter row stays locked until the transaction of tx ends, so
+// concurrent issuers wait for each other instead of taking the same number.
+func NextReceiptSequence(tx *pop.Connection, year int) (int, error) {
+	taken := []receiptSequence{}
+	err := tx.RawQuery(`INSERT INTO receipt_sequences (year, last_sequence) VALUES (?, 1)
+		ON CONFLICT (year) DO UPDATE SET last_sequence = receipt_sequences.last_sequence + 1
+		RETURNING last_sequence`, year).All(&taken)
+	if err != nil {
+		return 0, err
+	}
+	if len(taken) != 1 {
+		return 0, fmt.Errorf("no receipt sequence taken for %d", year)
+	}
+	return taken[0].Sequence, nil
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *Receipt) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: r.Number, Name: "Number"},
+		&validators.IntIsGreaterThan{Field: r.Sequence, Name: "Sequence", Compared: 0},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (r *Receipt) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (r *Receipt) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
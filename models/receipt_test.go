@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+func (ms *ModelSuite) Test_Receipt_Sequence() {
+	sequence, err := NextReceiptSequence(DB, 2020)
+	ms.NoError(err)
+	ms.Equal(1, sequence)
+
+	ms.Equal("2020-000001", FormatReceiptNumber(2020, sequence))
+
+	sequence, err = NextReceiptSequence(DB, 2020)
+	ms.NoError(err)
+	ms.Equal(2, sequence)
+	sequence, err = NextReceiptSequence(DB, 2021)
+	ms.NoError(err)
+	ms.Equal(1, sequence)
+}
+
+func (ms *ModelSuite) Test_Receipt_SequenceConcurrent() {
+	first, err := DB.NewTransaction()
+	ms.NoError(err)
+	second, err := DB.NewTransaction()
+	ms.NoError(err)
+
+	sequence, err := NextReceiptSequence(first, 2020)
+	ms.NoError(err)
+	ms.Equal(1, sequence)
+
+	// The second issuer waits for the first one instead of taking the same number
+	taken := make(chan int)
+	go func() {
+		sequence, err := NextReceiptSequence(second, 2020)
+		ms.NoError(err)
+		taken <- sequence
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ms.NoError(first.TX.Commit())
+	ms.Equal(2, <-taken)
+	ms.NoError(second.TX.Commit())
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Refund kinds
+const (
+	RefundKindRefund     = "refund"
+	RefundKindChargeback = "chargeback"
+)
+
+// Refund statuses
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// Refund is used by pop to map your refunds database table to your go code.
+// Chargebacks are stored here as well, with Kind set to "chargeback".
+type Refund struct {
+	ID                 uuid.UUID    `json:"id" db:"id"`
+	PaymentID          uuid.UUID    `json:"payment_id" db:"payment_id"`
+	Payment            Payment      `json:"-" belongs_to:"payment" db:"-"`
+	Kind               string       `json:"kind" db:"kind"`
+	Status             string       `json:"status" db:"status"`
+	Amount             int          `json:"amount" db:"amount"`
+	Reason             string       `json:"reason" db:"reason"`
+	RemoteRefundID     nulls.String `json:"remote_refund_id" db:"remote_refund_id"`
+	CancelSubscription bool         `json:"cancel_subscription" db:"cancel_subscription"`
+	CreatedAt          time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (r Refund) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Refunds is not required by pop and may be deleted
+type Refunds []Refund
+
+// String is not required by pop and may be deleted
+func (r Refunds) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Total sums the amount of the refunds that went through or are still being made, as a
+// pending refund may go through at the gateway any moment
+func (r Refunds) Total() int {
+	total := 0
+	for _, refund := range r {
+		if refund.Status == RefundSucceeded || refund.Status == RefundPending {
+			total += refund.Amount
+		}
+	}
+	return total
+}
+
+// Succeeded sums the amount of the refunds that went through
+func (r Refunds) Succeeded() int {
+	total := 0
+	for _, refund := range r {
+		if refund.Status == RefundSucceeded {
+			total += refund.Amount
+		}
+	}
+	return total
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *Refund) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringInclusion{Field: r.Kind, Name: "Kind", List: []string{RefundKindRefund, RefundKindChargeback}},
+		&validators.StringInclusion{Field: r.Status, Name: "Status", List: []string{RefundPending, RefundSucceeded, RefundFailed}},
+		&validators.IntIsGreaterThan{Field: r.Amount, Name: "Amount", Compared: 0},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (r *Refund) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: r.Reason, Name: "Reason"},
+	), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (r *Refund) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
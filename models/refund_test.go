@@ -0,0 +1,25 @@
+package models
+
+func (ms *ModelSuite) Test_Refunds_Total() {
+	refunds := Refunds{
+		{Status: RefundSucceeded, Amount: 1000},
+		{Status: RefundFailed, Amount: 2000},
+		{Status: RefundSucceeded, Amount: 490},
+		{Status: RefundPending, Amount: 300},
+	}
+
+	ms.Equal(1790, refunds.Total())
+	ms.Equal(1490, refunds.Succeeded())
+}
+
+func (ms *ModelSuite) Test_Refund_Validate() {
+	refund := &Refund{Kind: RefundKindRefund, Status: RefundPending, Amount: 0}
+
+	verrs, err := refund.ValidateCreate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+
+	verrs, err = refund.Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+}
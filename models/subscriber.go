@@ -9,6 +9,7 @@ import (
 )
 
 // Subscriber is used by pop to map your subscribers database table to your go code.
+// Locale is the language of the emails sent to the subscriber, one of Locales.
 type Subscriber struct {
 	ID             uuid.UUID     `json:"id" db:"id"`
 	Name           string        `json:"name" db:"name"`
@@ -21,9 +22,12 @@ type Subscriber struct {
 	Zipcode        string        `json:"zipcode" db:"zipcode"`
 	DDD            string        `json:"ddd" db:"ddd"`
 	Number         string        `json:"number" db:"number"`
+	Locale         string        `json:"locale" db:"locale"`
 	Subscriptions  Subscriptions `has_many:"subscriptions" db:"-"`
 	CreatedAt      time.Time     `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time     `json:"updated_at" db:"updated_at"`
+
+	auditBefore map[string]interface{} `db:"-"`
 }
 
 // String is not required by pop and may be deleted
@@ -58,3 +62,28 @@ func (s *Subscriber) ValidateCreate(tx *pop.Connection) (*validate.Errors, error
 func (s *Subscriber) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
 	return validate.NewErrors(), nil
 }
+
+// BeforeCreate defaults the locale to the one most subscribers speak
+func (s *Subscriber) BeforeCreate(tx *pop.Connection) error {
+	if s.Locale == "" {
+		s.Locale = DefaultLocale
+	}
+	return nil
+}
+
+// AfterCreate records the new subscriber in the audit log
+func (s *Subscriber) AfterCreate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditCreate, "subscriber", s.ID, nil, s)
+}
+
+// BeforeUpdate remembers the stored version, so AfterUpdate knows what changed
+func (s *Subscriber) BeforeUpdate(tx *pop.Connection) error {
+	before, err := storedSnapshot(tx, s, s.ID)
+	s.auditBefore = before
+	return err
+}
+
+// AfterUpdate records the changes in the audit log
+func (s *Subscriber) AfterUpdate(tx *pop.Connection) error {
+	return recordAudit(tx, AuditUpdate, "subscriber", s.ID, s.auditBefore, s)
+}
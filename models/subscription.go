@@ -8,6 +8,24 @@ import (
 	"time"
 )
 
+// Subscription statuses, following the gateway naming
+const (
+	SubscriptionTrialing       = "trialing"
+	SubscriptionPaid           = "paid"
+	SubscriptionPendingPayment = "pending_payment"
+	SubscriptionUnpaid         = "unpaid"
+	SubscriptionCanceled       = "canceled"
+	SubscriptionEnded          = "ended"
+	// SubscriptionExpired is set by us when the period ran out and the gateway did not renew it
+	SubscriptionExpired = "expired"
+)
+
+// ActiveSubscriptionStatuses are the statuses that grant access to the plan features while the period lasts
+var ActiveSubscriptionStatuses = []string{SubscriptionTrialing, SubscriptionPaid, SubscriptionPendingPayment}
+
+// RenewableSubscriptionStatuses are the statuses of subscriptions the gateway may still charge
+var RenewableSubscriptionStatuses = []string{SubscriptionTrialing, SubscriptionPaid, SubscriptionPendingPayment, SubscriptionUnpaid}
+
 // Subscription is used by pop to map your subscriptions database table to your go code.
 type Subscription struct {
 	ID                   uuid.UUID  `json:"id" db:"id"`
@@ -23,6 +41,14 @@ type Subscription struct {
 	Payments             Payments   `json:"-" has_many:"payments" db:"-"`
 	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time  `json:"updated_at" db:"updated_at"`
+
+	StatusChanges SubscriptionStatusChanges `json:"-" has_many:"subscription_status_changes" order_by:"created_at desc" db:"-"`
+	// StatusReason and ChangedBy describe the status change recorded on the next save
+	StatusReason string `json:"-" db:"-"`
+	ChangedBy    string `json:"-" db:"-"`
+
+	previousStatus string                 `db:"-"`
+	auditBefore    map[string]interface{} `db:"-"`
 }
 
 // String is not required by pop and may be deleted
@@ -57,3 +83,51 @@ func (s *Subscription) ValidateCreate(tx *pop.Connection) (*validate.Errors, err
 func (s *Subscription) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
 	return validate.NewErrors(), nil
 }
+
+// AfterCreate records the new subscription in the audit log and its initial status in the status history
+func (s *Subscription) AfterCreate(tx *pop.Connection) error {
+	if err := recordAudit(tx, AuditCreate, "subscription", s.ID, nil, s); err != nil {
+		return err
+	}
+	return s.recordStatusChange(tx, "")
+}
+
+// BeforeUpdate remembers the stored version, so AfterUpdate knows what changed
+func (s *Subscription) BeforeUpdate(tx *pop.Connection) error {
+	before, err := storedSnapshot(tx, s, s.ID)
+	if err != nil {
+		return err
+	}
+	s.auditBefore = before
+	s.previousStatus, _ = before["status"].(string)
+	return nil
+}
+
+// AfterUpdate records the changes in the audit log and the status history
+func (s *Subscription) AfterUpdate(tx *pop.Connection) error {
+	if err := recordAudit(tx, AuditUpdate, "subscription", s.ID, s.auditBefore, s); err != nil {
+		return err
+	}
+	if s.previousStatus == s.Status {
+		return nil
+	}
+	return s.recordStatusChange(tx, s.previousStatus)
+}
+
+func (s *Subscription) recordStatusChange(tx *pop.Connection, from string) error {
+	change := &SubscriptionStatusChange{
+		SubscriptionID: s.ID,
+		FromStatus:     from,
+		ToStatus:       s.Status,
+		Reason:         s.StatusReason,
+		ChangedBy:      s.ChangedBy,
+	}
+	if change.ChangedBy == "" {
+		change.ChangedBy = "system"
+	}
+	change.ID, _ = uuid.NewV4()
+
+	s.previousStatus = s.Status
+	s.StatusReason = ""
+	return tx.Create(change)
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// SubscriptionStatusChange is used by pop to map your subscription_status_changes database table to your go code.
+// A row is written by the Subscription callbacks whenever its status changes.
+type SubscriptionStatusChange struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	SubscriptionID uuid.UUID `json:"subscription_id" db:"subscription_id"`
+	FromStatus     string    `json:"from_status" db:"from_status"`
+	ToStatus       string    `json:"to_status" db:"to_status"`
+	Reason         string    `json:"reason" db:"reason"`
+	ChangedBy      string    `json:"changed_by" db:"changed_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (s SubscriptionStatusChange) String() string {
+	js, _ := json.Marshal(s)
+	return string(js)
+}
+
+// SubscriptionStatusChanges is not required by pop and may be deleted
+type SubscriptionStatusChanges []SubscriptionStatusChange
+
+// String is not required by pop and may be deleted
+func (s SubscriptionStatusChanges) String() string {
+	js, _ := json.Marshal(s)
+	return string(js)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (s *SubscriptionStatusChange) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (s *SubscriptionStatusChange) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (s *SubscriptionStatusChange) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
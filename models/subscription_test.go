@@ -1,5 +1,45 @@
 package models
 
+import (
+	"github.com/gofrs/uuid"
+	"time"
+)
+
 func (ms *ModelSuite) Test_Subscription() {
 	ms.Fail("This test needs to be implemented!")
 }
+
+func (ms *ModelSuite) Test_Subscription_StatusHistory() {
+	plan := &Plan{ID: uuid.Must(uuid.NewV4()), Name: "Mensal", IntervalUnit: IntervalMonth, IntervalCount: 1}
+	ms.NoError(DB.Create(plan))
+	subscriber := &Subscriber{ID: uuid.Must(uuid.NewV4()), Name: "Ana", Email: "ana@example.com"}
+	ms.NoError(DB.Create(subscriber))
+
+	subscription := &Subscription{
+		ID:           uuid.Must(uuid.NewV4()),
+		SubscriberID: subscriber.ID,
+		PlanID:       plan.ID,
+		Status:       SubscriptionPaid,
+		ExpiresAt:    time.Now().AddDate(0, 1, 0),
+	}
+	ms.NoError(DB.Create(subscription))
+
+	subscription.ExpiresAt = subscription.ExpiresAt.AddDate(0, 1, 0)
+	ms.NoError(DB.Update(subscription))
+
+	subscription.Status = SubscriptionCanceled
+	subscription.StatusReason = "customer asked"
+	subscription.ChangedBy = "support@example.com"
+	ms.NoError(DB.Update(subscription))
+
+	changes := SubscriptionStatusChanges{}
+	ms.NoError(DB.Where("subscription_id = ?", subscription.ID).Order("created_at asc").All(&changes))
+	ms.Len(changes, 2)
+	ms.Equal("", changes[0].FromStatus)
+	ms.Equal(SubscriptionPaid, changes[0].ToStatus)
+	ms.Equal("system", changes[0].ChangedBy)
+	ms.Equal(SubscriptionPaid, changes[1].FromStatus)
+	ms.Equal(SubscriptionCanceled, changes[1].ToStatus)
+	ms.Equal("customer asked", changes[1].Reason)
+	ms.Equal("support@example.com", changes[1].ChangedBy)
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookDeliveryPending    = "pending"
+	WebhookDeliveryDelivering = "delivering"
+	WebhookDeliverySucceeded  = "succeeded"
+	WebhookDeliveryFailed     = "failed"
+)
+
+// WebhookDelivery is used by pop to map your webhook_deliveries database table to your go code.
+// Each event is queued here once per endpoint subscribing to it and POSTed afterwards, see
+// services.WebhookService. Redelivering an event queues it again under the same EventID, so
+// endpoints can tell it is the same event.
+type WebhookDelivery struct {
+	ID                uuid.UUID       `json:"id" db:"id"`
+	WebhookEndpointID uuid.UUID       `json:"webhook_endpoint_id" db:"webhook_endpoint_id"`
+	WebhookEndpoint   WebhookEndpoint `json:"-" belongs_to:"webhook_endpoint" db:"-"`
+	EventID           uuid.UUID       `json:"event_id" db:"event_id"`
+	EventType         string          `json:"event_type" db:"event_type"`
+	// Payload is the body POSTed, kept as first sent so redeliveries send the same
+	Payload        string                  `json:"payload" db:"payload"`
+	Status         string                  `json:"status" db:"status"`
+	Attempts       int                     `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time               `json:"next_attempt_at" db:"next_attempt_at"`
+	LastStatusCode int                     `json:"last_status_code" db:"last_status_code"`
+	LastError      string                  `json:"last_error" db:"last_error"`
+	DeliveredAt    nulls.Time              `json:"delivered_at" db:"delivered_at"`
+	AttemptLog     WebhookDeliveryAttempts `json:"-" has_many:"webhook_delivery_attempts" order_by:"created_at desc" db:"-"`
+	CreatedAt      time.Time               `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time               `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (w WebhookDelivery) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// WebhookDeliveries is not required by pop and may be deleted
+type WebhookDeliveries []WebhookDelivery
+
+// String is not required by pop and may be deleted
+func (w WebhookDeliveries) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (w *WebhookDelivery) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: w.WebhookEndpointID, Name: "WebhookEndpointID"},
+		&validators.UUIDIsPresent{Field: w.EventID, Name: "EventID"},
+		&validators.StringIsPresent{Field: w.EventType, Name: "EventType"},
+		&validators.StringIsPresent{Field: w.Payload, Name: "Payload"},
+		&validators.StringInclusion{Field: w.Status, Name: "Status", List: []string{WebhookDeliveryPending, WebhookDeliveryDelivering, WebhookDeliverySucceeded, WebhookDeliveryFailed}},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (w *WebhookDelivery) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (w *WebhookDelivery) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// WebhookDeliveryAttempt is used by pop to map your webhook_delivery_attempts database table to your go code.
+// Every POST of a delivery is logged with what the endpoint answered. StatusCode is 0 when
+// it did not answer, and Error tells why.
+type WebhookDeliveryAttempt struct {
+	ID                uuid.UUID `json:"id" db:"id"`
+	WebhookDeliveryID uuid.UUID `json:"webhook_delivery_id" db:"webhook_delivery_id"`
+	StatusCode        int       `json:"status_code" db:"status_code"`
+	Error             string    `json:"error" db:"error"`
+	// ResponseBody is the start of what the endpoint answered
+	ResponseBody string    `json:"response_body" db:"response_body"`
+	DurationMS   int       `json:"duration_ms" db:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (w WebhookDeliveryAttempt) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// WebhookDeliveryAttempts is not required by pop and may be deleted
+type WebhookDeliveryAttempts []WebhookDeliveryAttempt
+
+// String is not required by pop and may be deleted
+func (w WebhookDeliveryAttempts) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebhookEndpoint is used by pop to map your webhook_endpoints database table to your go code.
+// The events of the types it subscribes to are POSTed to its URL, signed with its secret.
+// Endpoints failing too many deliveries in a row are disabled until an operator enables them.
+type WebhookEndpoint struct {
+	ID     uuid.UUID `json:"id" db:"id"`
+	URL    string    `json:"url" db:"url"`
+	Secret string    `json:"-" db:"secret"`
+	// EventTypes are separated by spaces, e.g. "subscription.* payment.refunded", or "*" for all
+	EventTypes          string     `json:"event_types" db:"event_types"`
+	Description         string     `json:"description" db:"description"`
+	Enabled             bool       `json:"enabled" db:"enabled"`
+	ConsecutiveFailures int        `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledAt          nulls.Time `json:"disabled_at" db:"disabled_at"`
+	DisabledReason      string     `json:"disabled_reason" db:"disabled_reason"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (w WebhookEndpoint) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// WebhookEndpoints is not required by pop and may be deleted
+type WebhookEndpoints []WebhookEndpoint
+
+// String is not required by pop and may be deleted
+func (w WebhookEndpoints) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// EventTypeList splits the event types
+func (w WebhookEndpoint) EventTypeList() []string {
+	return strings.Fields(w.EventTypes)
+}
+
+// Subscribes reports whether the endpoint wants the events of the type, by its exact name,
+// by a prefix as in "payment.*", or by "*"
+func (w WebhookEndpoint) Subscribes(eventType string) bool {
+	for _, pattern := range w.EventTypeList() {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (w *WebhookEndpoint) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.FuncValidator{Field: w.URL, Name: "URL", Message: "%s must be an http or https URL", Fn: func() bool {
+			u, err := url.Parse(w.URL)
+			return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		}},
+		&validators.StringLengthInRange{Field: w.Secret, Name: "Secret", Min: 16},
+		&validators.StringIsPresent{Field: strings.TrimSpace(w.EventTypes), Name: "EventTypes"},
+	), nil
+}
+
+// ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
+// This method is not required and may be deleted.
+func (w *WebhookEndpoint) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
+
+// ValidateUpdate gets run every time you call "pop.ValidateAndUpdate" method.
+// This method is not required and may be deleted.
+func (w *WebhookEndpoint) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.NewErrors(), nil
+}
@@ -0,0 +1,28 @@
+package models
+
+func (ms *ModelSuite) Test_WebhookEndpoint_Subscribes() {
+	endpoint := WebhookEndpoint{EventTypes: "subscription.*  payment.refunded"}
+
+	ms.True(endpoint.Subscribes("subscription.canceled"))
+	ms.True(endpoint.Subscribes("payment.refunded"))
+	ms.False(endpoint.Subscribes("payment.chargeback"))
+	ms.False(endpoint.Subscribes("subscriptions.canceled"))
+
+	endpoint.EventTypes = "*"
+	ms.True(endpoint.Subscribes("checkout.pending"))
+}
+
+func (ms *ModelSuite) Test_WebhookEndpoint_Validate() {
+	endpoint := &WebhookEndpoint{URL: "ftp://example.com", Secret: "short", EventTypes: " "}
+
+	verrs, err := endpoint.Validate(DB)
+	ms.NoError(err)
+	for _, field := range []string{"url", "secret", "event_types"} {
+		ms.NotEmpty(verrs.Get(field), field)
+	}
+
+	endpoint.URL, endpoint.Secret, endpoint.EventTypes = "https://example.com/hooks", "whsec_0123456789abcdef", "*"
+	verrs, err = endpoint.Validate(DB)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+}
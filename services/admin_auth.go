@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"github.com/gobuffalo/pop/v5"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// ErrInvalidLogin is returned for unknown e-mails, wrong passwords or codes and disabled users alike,
+// so the login form does not tell which admin users exist
+var ErrInvalidLogin = errors.New("invalid e-mail, password or code")
+
+// AuthenticateAdmin checks the password, and the TOTP code when the user enabled two-factor,
+// and records when the user logged in
+func AuthenticateAdmin(tx *pop.Connection, email string, password string, code string, now time.Time) (*models.AdminUser, error) {
+	user := &models.AdminUser{}
+	if err := tx.Where("email = ?", strings.ToLower(strings.TrimSpace(email))).First(user); err != nil {
+		if models.IsNotFound(err) {
+			return nil, ErrInvalidLogin
+		}
+		return nil, err
+	}
+
+	if user.Disabled() || !user.CheckPassword(password) {
+		return nil, ErrInvalidLogin
+	}
+	if user.TwoFactorEnabled() {
+		if err := useTOTPCode(tx, user, code, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.RawQuery("UPDATE admin_users SET last_login_at = ? WHERE id = ?", now, user.ID).Exec(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// useTOTPCode checks the code of the user, refusing codes of steps already used, so a code
+// seen over a shoulder or phished can not log in again. Secrets stored before they were
+// encrypted are encrypted on the way.
+func useTOTPCode(tx *pop.Connection, user *models.AdminUser, code string, now time.Time) error {
+	secret, err := DecryptTOTPSecret(user.TOTPSecret.String)
+	if err != nil {
+		return err
+	}
+
+	step, ok := MatchTOTP(secret, code, now)
+	if !ok || (user.TOTPLastStep.Valid && step <= user.TOTPLastStep.Int64) {
+		return ErrInvalidLogin
+	}
+	// Concurrent logins with the same code wait for each other here; only one updates the step
+	used, err := tx.RawQuery("UPDATE admin_users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)", step, user.ID, step).ExecWithCount()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return ErrInvalidLogin
+	}
+
+	if !TOTPSecretEncrypted(user.TOTPSecret.String) {
+		sealed, err := EncryptTOTPSecret(secret)
+		if err != nil {
+			return err
+		}
+		return tx.RawQuery("UPDATE admin_users SET totp_secret = ? WHERE id = ?", sealed, user.ID).Exec()
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// ErrUnauthenticated is returned for missing, malformed, unknown, revoked or expired credentials
+var ErrUnauthenticated = errors.New("invalid credentials")
+
+// apiKeyTokenPrefix starts every API key token, telling them apart from JWTs
+const apiKeyTokenPrefix = "ssk_"
+
+// Principal is the service authenticated by an API key or a JWT
+type Principal struct {
+	// Kind is "api_key" or "jwt"
+	Kind string
+	// ID is the key prefix or the JWT subject
+	ID     string
+	Name   string
+	Scopes []string
+}
+
+// HasScope reports whether the principal was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	return models.HasScope(p.Scopes, scope)
+}
+
+// GenerateAPIKey creates a key with the given scopes and returns it along with its token.
+// The token is shown only once: we keep just a hash of its secret.
+func GenerateAPIKey(tx *pop.Connection, name string, scopes []string) (*models.APIKey, string, error) {
+	prefix := make([]byte, 4)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, "", err
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &models.APIKey{
+		Name:       name,
+		Prefix:     hex.EncodeToString(prefix),
+		SecretHash: hashSecret(encodedSecret),
+		Scopes:     strings.Join(scopes, " "),
+	}
+	key.ID, _ = uuid.NewV4()
+
+	verrs, err := tx.ValidateAndCreate(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if verrs.HasAny() {
+		return nil, "", verrs
+	}
+
+	return key, apiKeyTokenPrefix + key.Prefix + "_" + encodedSecret, nil
+}
+
+// RevokeAPIKey stops the key with the given prefix from authenticating
+func RevokeAPIKey(tx *pop.Connection, prefix string) error {
+	key := &models.APIKey{}
+	if err := tx.Where("prefix = ?", prefix).First(key); err != nil {
+		return err
+	}
+	key.RevokedAt = nulls.NewTime(time.Now())
+	return tx.Update(key)
+}
+
+// IsAPIKeyToken reports whether the bearer token is one of our API keys rather than a JWT
+func IsAPIKeyToken(token string) bool {
+	return strings.HasPrefix(token, apiKeyTokenPrefix)
+}
+
+// AuthenticateAPIKey checks the token against the stored hash and records when the key was used
+func AuthenticateAPIKey(tx *pop.Connection, token string, now time.Time) (*Principal, error) {
+	parts := strings.SplitN(strings.TrimPrefix(token, apiKeyTokenPrefix), "_", 2)
+	if !IsAPIKeyToken(token) || len(parts) != 2 {
+		return nil, ErrUnauthenticated
+	}
+
+	key := &models.APIKey{}
+	if err := tx.Where("prefix = ?", parts[0]).First(key); err != nil {
+		if models.IsNotFound(err) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, err
+	}
+
+	if key.Revoked() || subtle.ConstantTimeCompare([]byte(hashSecret(parts[1])), []byte(key.SecretHash)) != 1 {
+		return nil, ErrUnauthenticated
+	}
+
+	// Written straight so a busy key does not bump updated_at on every request
+	if err := tx.RawQuery("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.ID).Exec(); err != nil {
+		return nil, err
+	}
+
+	return &Principal{Kind: "api_key", ID: key.Prefix, Name: key.Name, Scopes: key.ScopeList()}, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// JWTVerifier validates the JWTs other services sign, either with the shared HMAC secret
+// (HS256) or with one of the RSA keys of the JWKS file (RS256, picked by the "kid" header)
+type JWTVerifier struct {
+	HMACSecret []byte
+	RSAKeys    map[string]*rsa.PublicKey
+	Issuer     string
+	Audience   string
+}
+
+// NewJWTVerifierFromEnv builds the verifier from API_JWT_HMAC_SECRET, API_JWT_JWKS_FILE,
+// API_JWT_ISSUER and API_JWT_AUDIENCE. It returns nil when neither a secret nor a JWKS file is set.
+func NewJWTVerifierFromEnv() (*JWTVerifier, error) {
+	verifier := &JWTVerifier{
+		HMACSecret: []byte(os.Getenv("API_JWT_HMAC_SECRET")),
+		RSAKeys:    map[string]*rsa.PublicKey{},
+		Issuer:     os.Getenv("API_JWT_ISSUER"),
+		Audience:   os.Getenv("API_JWT_AUDIENCE"),
+	}
+
+	if path := os.Getenv("API_JWT_JWKS_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if verifier.RSAKeys, err = ParseJWKS(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(verifier.HMACSecret) == 0 && len(verifier.RSAKeys) == 0 {
+		return nil, nil
+	}
+	return verifier, nil
+}
+
+// ParseJWKS reads the RSA public keys of a JSON Web Key Set, indexed by key id
+func ParseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+// Verify checks the signature, expiration, issuer and audience of the token. Scopes come from
+// the space separated "scope" claim, as issued by OAuth servers.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.key)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return nil, ErrUnauthenticated
+	}
+	if v.Audience != "" && !verifyAudience(claims["aud"], v.Audience) {
+		return nil, ErrUnauthenticated
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	subject, _ := claims["sub"].(string)
+	scope, _ := claims["scope"].(string)
+	return &Principal{Kind: "jwt", ID: subject, Name: subject, Scopes: strings.Fields(scope)}, nil
+}
+
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() || len(v.HMACSecret) == 0 {
+			return nil, ErrUnauthenticated
+		}
+		return v.HMACSecret, nil
+	case *jwt.SigningMethodRSA:
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, ErrUnauthenticated
+		}
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.RSAKeys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// verifyAudience accepts "aud" both as a string and as a list, which jwt-go v3 does not
+func verifyAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
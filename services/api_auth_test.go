@@ -0,0 +1,97 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_JWTVerifier_HS256(t *testing.T) {
+	verifier := &JWTVerifier{HMACSecret: []byte("s3cret"), Issuer: "billing", Audience: "subscription_service"}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   "reports-service",
+		"iss":   "billing",
+		"aud":   []string{"subscription_service"},
+		"scope": "read:subscriptions",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("s3cret"))
+
+	principal, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.ID != "reports-service" || !principal.HasScope(models.ScopeReadSubscriptions) || principal.HasScope(models.ScopeWriteSubscriptions) {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+
+	rejected := map[string]jwt.MapClaims{
+		"expired":        {"iss": "billing", "aud": "subscription_service", "exp": time.Now().Add(-time.Minute).Unix()},
+		"without exp":    {"iss": "billing", "aud": "subscription_service"},
+		"wrong issuer":   {"iss": "other", "aud": "subscription_service", "exp": time.Now().Add(time.Minute).Unix()},
+		"wrong audience": {"iss": "billing", "aud": "other", "exp": time.Now().Add(time.Minute).Unix()},
+	}
+	for name, claims := range rejected {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("s3cret"))
+		if _, err := verifier.Verify(token); err != ErrUnauthenticated {
+			t.Errorf("%s: expected ErrUnauthenticated, got %v", name, err)
+		}
+	}
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "billing", "aud": "subscription_service", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("guess"))
+	if _, err := verifier.Verify(forged); err != ErrUnauthenticated {
+		t.Errorf("forged: expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+func Test_JWTVerifier_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "k1", "use": "sig", "n": %q, "e": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &JWTVerifier{RSAKeys: keys}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":   "crm",
+		"scope": "admin",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	signed, _ := token.SignedString(key)
+
+	principal, err := verifier.Verify(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !principal.HasScope(models.ScopeWriteSubscriptions) {
+		t.Error("expected the admin scope to grant every scope")
+	}
+
+	token.Header["kid"] = "unknown"
+	signed, _ = token.SignedString(key)
+	if _, err := verifier.Verify(signed); err != ErrUnauthenticated {
+		t.Errorf("unknown kid: expected ErrUnauthenticated, got %v", err)
+	}
+
+	// Without a shared secret an HS256 token must not be checked against an empty key
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}).SignedString([]byte(""))
+	if _, err := verifier.Verify(hmacToken); err != ErrUnauthenticated {
+		t.Errorf("hs256 without secret: expected ErrUnauthenticated, got %v", err)
+	}
+}
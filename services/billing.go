@@ -0,0 +1,51 @@
+package services
+
+import (
+	"github.com/gobuffalo/pop/v5"
+	"strings"
+	"subscription_service/models"
+)
+
+// BillingParties groups the records related to a payment that documents such as receipts and invoices print
+type BillingParties struct {
+	Subscription models.Subscription
+	Subscriber   models.Subscriber
+	Plan         models.Plan
+}
+
+// LoadBillingParties loads the subscription, subscriber and plan the payment belongs to
+func LoadBillingParties(tx *pop.Connection, payment models.Payment) (*BillingParties, error) {
+	parties := &BillingParties{}
+
+	if err := tx.Find(&parties.Subscription, payment.SubscriptionID); err != nil {
+		return nil, err
+	}
+	if err := tx.Find(&parties.Subscriber, parties.Subscription.SubscriberID); err != nil {
+		return nil, err
+	}
+	if err := tx.Find(&parties.Plan, parties.Subscription.PlanID); err != nil {
+		return nil, err
+	}
+
+	return parties, nil
+}
+
+// SubscriberAddress formats the subscriber address in a single line
+func SubscriberAddress(subscriber models.Subscriber) string {
+	parts := []string{subscriber.Street + ", " + subscriber.StreetNumber}
+	if subscriber.Complementary != "" {
+		parts = append(parts, subscriber.Complementary)
+	}
+	parts = append(parts, subscriber.Neighborhood, "CEP "+subscriber.Zipcode)
+	return strings.Join(parts, " - ")
+}
+
+// MaskDocumentNumber hides all but the middle digits of a CPF, e.g. "***.456.789-**", for
+// documents anyone with their link can open. CNPJs belong to companies and are kept as they are.
+func MaskDocumentNumber(document string) string {
+	digits := nonDigits.ReplaceAllString(document, "")
+	if len(digits) != 11 {
+		return document
+	}
+	return "***." + digits[3:6] + "." + digits[6:9] + "-**"
+}
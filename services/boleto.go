@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"subscription_service/models"
+	"time"
+)
+
+// ErrNoBoletoToResend is returned when the payment is not a boleto still waiting to be paid
+var ErrNoBoletoToResend = errors.New("payment has no open boleto")
+
+// BoletoResent is the payload of the payment.boleto_resent event
+type BoletoResent struct {
+	PaymentID            uuid.UUID `json:"payment_id"`
+	SubscriptionID       uuid.UUID `json:"subscription_id"`
+	Name                 string    `json:"name"`
+	Email                string    `json:"email"`
+	Total                int       `json:"total"`
+	BoletoURL            string    `json:"boleto_url"`
+	BoletoBarcode        string    `json:"boleto_barcode"`
+	BoletoExpirationDate string    `json:"boleto_expiration_date"`
+}
+
+// ResendBoleto emails the open boleto of the payment to the subscriber again and publishes
+// the payment.boleto_resent event
+func ResendBoleto(tx *pop.Connection, rabbitMQ *RabbitMQ, payment models.Payment, subscriber models.Subscriber) error {
+	settled := payment.Refundable() || payment.Status == models.PaymentRefunded || payment.Status == models.PaymentChargedback
+	if payment.PaymentType != "boleto" || payment.BoletoURL == "" || settled {
+		return ErrNoBoletoToResend
+	}
+
+	if err := NotifyBoletoResent(tx, payment, time.Now()); err != nil {
+		return err
+	}
+
+	return rabbitMQ.PublishEvent(tx, NewEvent("payment.boleto_resent", BoletoResent{
+		PaymentID:            payment.ID,
+		SubscriptionID:       payment.SubscriptionID,
+		Name:                 subscriber.Name,
+		Email:                subscriber.Email,
+		Total:                payment.Total,
+		BoletoURL:            payment.BoletoURL,
+		BoletoBarcode:        payment.BoletoBarcode,
+		BoletoExpirationDate: payment.BoletoExpirationDate,
+	}))
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// CaptchaWidget is what the checkout form needs to show the challenge
+type CaptchaWidget struct {
+	ScriptURL string
+	Class     string
+	SiteKey   string
+	// ResponseField is the form field the widget fills with its answer
+	ResponseField string
+}
+
+// CaptchaVerifier challenges the checkouts of customers with repeated declines
+type CaptchaVerifier interface {
+	Widget() CaptchaWidget
+	// Verify checks the answer the widget put in the form
+	Verify(response string, remoteIP string) (bool, error)
+}
+
+// SiteVerifyCaptcha verifies answers with the siteverify API shared by reCAPTCHA, hCaptcha and
+// Turnstile
+type SiteVerifyCaptcha struct {
+	CaptchaWidget
+	VerifyURL  string
+	Secret     string
+	HTTPClient *http.Client
+}
+
+// CaptchaProviders are the providers CAPTCHA_PROVIDER may name
+var CaptchaProviders = map[string]SiteVerifyCaptcha{
+	"recaptcha": {
+		CaptchaWidget: CaptchaWidget{ScriptURL: "https://www.google.com/recaptcha/api.js", Class: "g-recaptcha", ResponseField: "g-recaptcha-response"},
+		VerifyURL:     "https://www.google.com/recaptcha/api/siteverify",
+	},
+	"hcaptcha": {
+		CaptchaWidget: CaptchaWidget{ScriptURL: "https://js.hcaptcha.com/1/api.js", Class: "h-captcha", ResponseField: "h-captcha-response"},
+		VerifyURL:     "https://hcaptcha.com/siteverify",
+	},
+	"turnstile": {
+		CaptchaWidget: CaptchaWidget{ScriptURL: "https://challenges.cloudflare.com/turnstile/v0/api.js", Class: "cf-turnstile", ResponseField: "cf-turnstile-response"},
+		VerifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	},
+}
+
+// Captcha is the challenge of the checkout, picked by CAPTCHA_PROVIDER with the keys
+// CAPTCHA_SITE_KEY and CAPTCHA_SECRET. It is nil, and nobody is challenged, when not set.
+var Captcha = newCaptcha(os.Getenv("CAPTCHA_PROVIDER"))
+
+func newCaptcha(provider string) CaptchaVerifier {
+	if provider == "" {
+		return nil
+	}
+	captcha, ok := CaptchaProviders[provider]
+	if !ok {
+		log.Printf("CAPTCHA_PROVIDER: unknown provider %s, checkouts are not challenged", provider)
+		return nil
+	}
+	captcha.SiteKey, captcha.Secret = os.Getenv("CAPTCHA_SITE_KEY"), os.Getenv("CAPTCHA_SECRET")
+	captcha.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	return &captcha
+}
+
+// Widget describes the widget of the provider
+func (s *SiteVerifyCaptcha) Widget() CaptchaWidget {
+	return s.CaptchaWidget
+}
+
+// Verify asks the provider whether the answer is good
+func (s *SiteVerifyCaptcha) Verify(response string, remoteIP string) (bool, error) {
+	if response == "" {
+		return false, nil
+	}
+
+	res, err := s.HTTPClient.PostForm(s.VerifyURL, url.Values{"secret": {s.Secret}, "response": {response}, "remoteip": {remoteIP}})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha verification answered %d", res.StatusCode)
+	}
+
+	verification := struct {
+		Success bool `json:"success"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&verification); err != nil {
+		return false, err
+	}
+	return verification.Success, nil
+}
+
+// CaptchaEscalation challenges the customers with Declines declined or rejected checkouts,
+// from their IP or email, within Window. CAPTCHA_AFTER_DECLINES sets Declines (3 when not set).
+type CaptchaEscalation struct {
+	Declines int
+	Window   time.Duration
+	Now      func() time.Time
+}
+
+// NewCaptchaEscalation creates the escalation configured from the environment
+func NewCaptchaEscalation() CaptchaEscalation {
+	return CaptchaEscalation{Declines: envInt("CAPTCHA_AFTER_DECLINES", 3), Window: time.Hour, Now: time.Now}
+}
+
+// Required reports whether the checkout from ip, by email if already known, must be challenged
+func (e CaptchaEscalation) Required(tx *pop.Connection, ip string, email string) (bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if e.Declines < 1 || (ip == "" && email == "") {
+		return false, nil
+	}
+
+	query := tx.Where("outcome IN (?, ?) AND created_at >= ?", models.CheckoutDeclined, models.CheckoutFraudRejected, e.Now().Add(-e.Window))
+	if email == "" {
+		query = query.Where("ip = ?", ip)
+	} else {
+		query = query.Where("(ip = ? OR email = ?)", ip, email)
+	}
+	count, err := query.Count(&models.CheckoutAttempt{})
+	return count >= e.Declines, err
+}
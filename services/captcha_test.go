@@ -0,0 +1,40 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_SiteVerifyCaptcha_Verify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") != "secret" || r.FormValue("remoteip") != "192.0.2.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("response") == "solved" {
+			w.Write([]byte(`{"success": true}`))
+		} else {
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	captcha := CaptchaProviders["hcaptcha"]
+	captcha.VerifyURL, captcha.Secret, captcha.HTTPClient = server.URL, "secret", server.Client()
+
+	for response, expected := range map[string]bool{"solved": true, "guessed": false, "": false} {
+		solved, err := captcha.Verify(response, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if solved != expected {
+			t.Errorf("%q: expected %v, got %v", response, expected, solved)
+		}
+	}
+
+	captcha.Secret = "wrong"
+	if _, err := captcha.Verify("solved", "192.0.2.1"); err == nil {
+		t.Error("expected an error when the provider refuses the request")
+	}
+}
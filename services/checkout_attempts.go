@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"os"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Attempt describes how the checkout processed with Process ended, err being what Process returned
+func (p *PaymentService) Attempt(err error) *models.CheckoutAttempt {
+	attempt := &models.CheckoutAttempt{
+		PlanID:         p.ProcessData.PlanID,
+		Email:          strings.ToLower(strings.TrimSpace(p.ProcessData.Email)),
+		DocumentNumber: nonDigits.ReplaceAllString(p.ProcessData.DocumentNumber, ""),
+		CardHashDigest: CardHashDigest(p.ProcessData.CardHash),
+		PaymentMethod:  p.ProcessData.PaymentMethod,
+		Installments:   p.ProcessData.Installments,
+		IP:             p.ClientIP,
+		UserAgent:      p.UserAgent,
+		FraudScore:     p.FraudAssessment.Score,
+		FraudDecision:  p.FraudAssessment.Decision,
+	}
+	if p.PaymentReturn.RemoteSubscriptionID != 0 {
+		attempt.RemoteSubscriptionID = nulls.NewString(strconv.Itoa(p.PaymentReturn.RemoteSubscriptionID))
+	}
+
+	declineErr := &DeclineError{}
+	gatewayErr := &GatewayError{}
+	switch {
+	case errors.As(err, &declineErr):
+		attempt.Outcome = models.CheckoutDeclined
+		attempt.RefuseReason = declineErr.RefuseReason
+		attempt.AcquirerResponseCode = declineErr.AcquirerResponseCode
+	case errors.Is(err, ErrFraudRejected):
+		attempt.Outcome = models.CheckoutFraudRejected
+	case PaymentOutcomeUnknown(err) || (err != nil && p.Charged()):
+		attempt.Outcome = models.CheckoutPending
+	case err != nil:
+		attempt.Outcome = models.CheckoutFailed
+	case p.PaymentReturn.Status == "unpaid":
+		attempt.Outcome = models.CheckoutBoletoIssued
+	default:
+		attempt.Outcome = models.CheckoutSucceeded
+	}
+
+	if err != nil {
+		attempt.DeclineReason = string(DeclineReasonOf(err))
+		attempt.Error = err.Error()
+	}
+	if errors.As(err, &gatewayErr) {
+		attempt.GatewayStatusCode = gatewayErr.StatusCode
+	}
+	if attempt.AcquirerResponseCode == "" {
+		attempt.AcquirerResponseCode = p.PaymentReturn.CurrentTransaction.AcquirerResponseCode
+	}
+	return attempt
+}
+
+// CheckoutAttemptRetention is how long checkout attempts are kept. PersonalData is how long
+// the email, CPF, IP and user agent are kept, CHECKOUT_ATTEMPTS_PII_DAYS (90 when not set); the
+// attempts themselves are deleted after Attempts, CHECKOUT_ATTEMPTS_RETENTION_DAYS (365).
+type CheckoutAttemptRetention struct {
+	PersonalData time.Duration
+	Attempts     time.Duration
+}
+
+// NewCheckoutAttemptRetention reads the retention from the environment
+func NewCheckoutAttemptRetention() CheckoutAttemptRetention {
+	return CheckoutAttemptRetention{
+		PersonalData: retentionDays("CHECKOUT_ATTEMPTS_PII_DAYS", 90),
+		Attempts:     retentionDays("CHECKOUT_ATTEMPTS_RETENTION_DAYS", 365),
+	}
+}
+
+func retentionDays(variable string, fallback int) time.Duration {
+	days, err := strconv.Atoi(os.Getenv(variable))
+	if err != nil || days < 1 {
+		days = fallback
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PruneCheckoutAttempts deletes the attempts past the retention and clears the personal data
+// of those past its retention, so they still count in the funnels
+func PruneCheckoutAttempts(tx *pop.Connection, retention CheckoutAttemptRetention, now time.Time) (cleared int, deleted int, err error) {
+	deleted, err = tx.RawQuery("DELETE FROM checkout_attempts WHERE created_at < ?", now.Add(-retention.Attempts)).ExecWithCount()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cleared, err = tx.RawQuery(
+		"UPDATE checkout_attempts SET email = '', document_number = '', ip = '', user_agent = '' WHERE created_at < ? AND (email <> '' OR document_number <> '' OR ip <> '' OR user_agent <> '')",
+		now.Add(-retention.PersonalData),
+	).ExecWithCount()
+	return cleared, deleted, err
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_PaymentService_Attempt(t *testing.T) {
+	paid := PaymentReturn{RemoteSubscriptionID: 100001, Status: "paid", PaymentMethod: "credit_card"}
+	boleto := PaymentReturn{RemoteSubscriptionID: 100002, Status: "unpaid", PaymentMethod: "boleto"}
+
+	cases := []struct {
+		paymentReturn PaymentReturn
+		err           error
+		outcome       string
+		statusCode    int
+	}{
+		{paid, nil, models.CheckoutSucceeded, 0},
+		{boleto, nil, models.CheckoutBoletoIssued, 0},
+		{PaymentReturn{Status: "Declined"}, &DeclineError{RefuseReason: "antifraud"}, models.CheckoutDeclined, 0},
+		{PaymentReturn{}, &GatewayError{Kind: ErrGatewayUnavailable, StatusCode: 500}, models.CheckoutPending, 500},
+		{paid, errors.New("pq: connection reset"), models.CheckoutPending, 0},
+		{PaymentReturn{}, &GatewayError{Kind: ErrGatewayRejected, StatusCode: 400}, models.CheckoutFailed, 400},
+		{PaymentReturn{}, ErrInvalidInstallments, models.CheckoutFailed, 0},
+	}
+	for _, c := range cases {
+		service := &PaymentService{PaymentReturn: c.paymentReturn, ProcessData: ProcessData{Email: "ana@example.com"}}
+		attempt := service.Attempt(c.err)
+		if attempt.Outcome != c.outcome || attempt.GatewayStatusCode != c.statusCode || attempt.Email != "ana@example.com" {
+			t.Errorf("%v: expected %s %d, got %+v", c.err, c.outcome, c.statusCode, attempt)
+		}
+	}
+
+	attempt := (&PaymentService{PaymentReturn: paid}).Attempt(nil)
+	if attempt.RemoteSubscriptionID.String != "100001" || attempt.Error != "" {
+		t.Errorf("unexpected attempt %+v", attempt)
+	}
+	attempt = (&PaymentService{}).Attempt(&DeclineError{RefuseReason: "acquirer", AcquirerResponseCode: "1016"})
+	if attempt.DeclineReason != string(DeclineInsufficientFunds) || attempt.AcquirerResponseCode != "1016" || attempt.RemoteSubscriptionID.Valid {
+		t.Errorf("unexpected declined attempt %+v", attempt)
+	}
+}
+
+func Test_NewCheckoutAttemptRetention(t *testing.T) {
+	os.Setenv("CHECKOUT_ATTEMPTS_PII_DAYS", "30")
+	os.Setenv("CHECKOUT_ATTEMPTS_RETENTION_DAYS", "invalid")
+	defer os.Unsetenv("CHECKOUT_ATTEMPTS_PII_DAYS")
+	defer os.Unsetenv("CHECKOUT_ATTEMPTS_RETENTION_DAYS")
+
+	retention := NewCheckoutAttemptRetention()
+	if retention.PersonalData != 30*24*time.Hour || retention.Attempts != 365*24*time.Hour {
+		t.Errorf("unexpected retention %+v", retention)
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops calling a gateway that keeps failing. After Threshold failures in a
+// row the circuit opens and calls fail at once; once Cooldown has passed a single call goes
+// through, closing the circuit again if it succeeds.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	Now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Creates a closed CircuitBreaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, Now: time.Now}
+}
+
+// GatewayBreaker is shared by the gateway clients of the process. It opens after
+// GATEWAY_BREAKER_THRESHOLD failures in a row (5 when not set) and tries again after
+// GATEWAY_BREAKER_COOLDOWN, e.g. "30s".
+var GatewayBreaker = NewCircuitBreaker(gatewayBreakerThreshold(), gatewayBreakerCooldown())
+
+func gatewayBreakerThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("GATEWAY_BREAKER_THRESHOLD"))
+	if err != nil || threshold < 1 {
+		return 5
+	}
+	return threshold
+}
+
+func gatewayBreakerCooldown() time.Duration {
+	cooldown, err := time.ParseDuration(os.Getenv("GATEWAY_BREAKER_COOLDOWN"))
+	if err != nil {
+		return 30 * time.Second
+	}
+	return cooldown
+}
+
+// Allow tells whether a call may go through, returning ErrCircuitOpen when it may not
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if b.probing || b.Now().Sub(b.openedAt) < b.Cooldown {
+		return &GatewayError{Kind: ErrCircuitOpen}
+	}
+	b.probing = true
+	return nil
+}
+
+// Success closes the circuit
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.probing = 0, false
+}
+
+// Failure counts a failed call, opening the circuit once the threshold is reached
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openedAt, b.probing = b.Now(), false
+	}
+}
+
+// Ignore forgets a call that ended without telling whether the gateway works, e.g. one
+// canceled by the caller
+func (b *CircuitBreaker) Ignore() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Open reports whether calls are being refused
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DeclineReason tells the customer why a checkout failed and what to do about it
+type DeclineReason string
+
+// Decline reasons
+const (
+	DeclineInsufficientFunds   DeclineReason = "insufficient_funds"
+	DeclineInvalidCard         DeclineReason = "invalid_card"
+	DeclineTryAnotherMethod    DeclineReason = "try_another_method"
+	DeclineCardRefused         DeclineReason = "card_refused"
+	DeclineTryAgainLater       DeclineReason = "try_again_later"
+	DeclineInvalidInstallments DeclineReason = "invalid_installments"
+	DeclineSystemError         DeclineReason = "system_error"
+)
+
+// acquirerDeclines are the acquirer_response_code values that tell what the issuer did not like
+var acquirerDeclines = map[string]DeclineReason{
+	// pagar.me codes
+	"1016": DeclineInsufficientFunds,
+	"1001": DeclineInvalidCard,
+	"1011": DeclineInvalidCard,
+	"1045": DeclineInvalidCard,
+	"1025": DeclineTryAnotherMethod,
+	"1070": DeclineTryAnotherMethod,
+	// ISO 8583 codes, passed through by some acquirers
+	"51": DeclineInsufficientFunds,
+	"14": DeclineInvalidCard,
+	"54": DeclineInvalidCard,
+	"82": DeclineInvalidCard,
+	"57": DeclineTryAnotherMethod,
+}
+
+// DeclineError is a charge the gateway refused. It matches ErrPaymentDeclined.
+type DeclineError struct {
+	RefuseReason         string
+	AcquirerResponseCode string
+}
+
+// NewDeclineError describes the refusal of the first charge of the subscription
+func NewDeclineError(r PaymentReturn) *DeclineError {
+	refuseReason := r.RefuseReason
+	if refuseReason == "" {
+		refuseReason = r.CurrentTransaction.RefuseReason
+	}
+	return &DeclineError{RefuseReason: refuseReason, AcquirerResponseCode: r.CurrentTransaction.AcquirerResponseCode}
+}
+
+func (e *DeclineError) Error() string {
+	if e.AcquirerResponseCode == "" {
+		return fmt.Sprintf("%s: %s", ErrPaymentDeclined, e.RefuseReason)
+	}
+	return fmt.Sprintf("%s: %s (%s)", ErrPaymentDeclined, e.RefuseReason, e.AcquirerResponseCode)
+}
+
+// Is matches ErrPaymentDeclined
+func (e *DeclineError) Is(target error) bool {
+	return target == ErrPaymentDeclined
+}
+
+// Reason maps the refuse_reason and acquirer code given by the gateway to what the customer can do
+func (e *DeclineError) Reason() DeclineReason {
+	switch e.RefuseReason {
+	case "antifraud", "no_acquirer":
+		return DeclineTryAnotherMethod
+	case "internal_error", "acquirer_timeout":
+		return DeclineTryAgainLater
+	}
+	if reason, ok := acquirerDeclines[e.AcquirerResponseCode]; ok {
+		return reason
+	}
+	return DeclineCardRefused
+}
+
+// DeclineReasonOf tells why the checkout failed with err. Anything that is not about the
+// payment itself, e.g. a database failure, is a system error.
+func DeclineReasonOf(err error) DeclineReason {
+	declineErr := &DeclineError{}
+	switch {
+	case errors.As(err, &declineErr):
+		return declineErr.Reason()
+	case errors.Is(err, ErrFraudRejected):
+		// Nothing tells the customer which rule stopped them
+		return DeclineTryAnotherMethod
+	case errors.Is(err, ErrInvalidInstallments):
+		return DeclineInvalidInstallments
+	case errors.Is(err, ErrGatewayRejected):
+		// The gateway refuses card hashes it cannot read or cards past their expiration
+		return DeclineInvalidCard
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrGatewayUnreachable), errors.Is(err, ErrGatewayUnavailable):
+		return DeclineTryAgainLater
+	}
+	return DeclineSystemError
+}
+
+// TranslationID is the locale entry holding the message shown to the customer
+func (r DeclineReason) TranslationID() string {
+	return "checkout_" + string(r)
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Test_DeclineReasonOf(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason DeclineReason
+	}{
+		{&DeclineError{RefuseReason: "acquirer", AcquirerResponseCode: "1016"}, DeclineInsufficientFunds},
+		{&DeclineError{RefuseReason: "acquirer", AcquirerResponseCode: "51"}, DeclineInsufficientFunds},
+		{&DeclineError{RefuseReason: "acquirer", AcquirerResponseCode: "1011"}, DeclineInvalidCard},
+		{&DeclineError{RefuseReason: "acquirer", AcquirerResponseCode: "1000"}, DeclineCardRefused},
+		{&DeclineError{RefuseReason: "acquirer"}, DeclineCardRefused},
+		{&DeclineError{RefuseReason: "antifraud"}, DeclineTryAnotherMethod},
+		{&DeclineError{RefuseReason: "acquirer_timeout"}, DeclineTryAgainLater},
+		{fmt.Errorf("checkout: %w", ErrInvalidInstallments), DeclineInvalidInstallments},
+		{&GatewayError{Kind: ErrGatewayRejected, StatusCode: 400}, DeclineInvalidCard},
+		{&GatewayError{Kind: ErrCircuitOpen}, DeclineTryAgainLater},
+		{&GatewayError{Kind: ErrGatewayUnreachable, Err: errors.New("dial tcp: connection refused")}, DeclineTryAgainLater},
+		{errors.New("pq: relation does not exist"), DeclineSystemError},
+	}
+	for _, c := range cases {
+		if reason := DeclineReasonOf(c.err); reason != c.reason {
+			t.Errorf("%v: expected %s, got %s", c.err, c.reason, reason)
+		}
+	}
+
+	if !errors.Is(&DeclineError{RefuseReason: "acquirer"}, ErrPaymentDeclined) {
+		t.Error("expected a DeclineError to match ErrPaymentDeclined")
+	}
+}
+
+func Test_NewDeclineError(t *testing.T) {
+	r := PaymentReturn{Status: "Declined"}
+	r.CurrentTransaction.RefuseReason, r.CurrentTransaction.AcquirerResponseCode = "acquirer", "1016"
+
+	declineErr := NewDeclineError(r)
+	if declineErr.RefuseReason != "acquirer" || declineErr.Reason() != DeclineInsufficientFunds {
+		t.Errorf("unexpected decline %+v", declineErr)
+	}
+	if declineErr.Error() != "payment declined: acquirer (1016)" {
+		t.Errorf("unexpected message %q", declineErr.Error())
+	}
+}
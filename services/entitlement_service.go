@@ -0,0 +1,242 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"log"
+	"os"
+	"strings"
+	"subscription_service/models"
+	"sync"
+	"time"
+)
+
+// EntitlementSet is everything a subscriber may use through their active subscriptions. Looked
+// up by e-mail, it merges the subscriptions of every subscriber with the address, since each
+// checkout may have created one; SubscriberID is then the latest of them.
+type EntitlementSet struct {
+	SubscriberID    uuid.UUID           `json:"subscriber_id"`
+	SubscriberIDs   []uuid.UUID         `json:"subscriber_ids"`
+	Email           string              `json:"email"`
+	SubscriptionIDs []uuid.UUID         `json:"subscription_ids"`
+	Entitlements    models.Entitlements `json:"entitlements"`
+	ResolvedAt      time.Time           `json:"resolved_at"`
+}
+
+// Allows reports whether the set grants the feature and, when it does, its entitlement
+func (s *EntitlementSet) Allows(feature string) (models.Entitlement, bool) {
+	entitlement, ok := s.Entitlements[feature]
+	return entitlement, ok && (entitlement.Unlimited || entitlement.Limit > 0)
+}
+
+func (s *EntitlementSet) includes(subscriberID uuid.UUID) bool {
+	if s.SubscriberID == subscriberID {
+		return true
+	}
+	for _, id := range s.SubscriberIDs {
+		if id == subscriberID {
+			return true
+		}
+	}
+	return false
+}
+
+// EntitlementLookup identifies the subscriber to resolve, by id or e-mail
+type EntitlementLookup struct {
+	SubscriberID string
+	Email        string
+}
+
+func (l EntitlementLookup) cacheKey() string {
+	if l.SubscriberID != "" {
+		return "id:" + l.SubscriberID
+	}
+	return "email:" + strings.ToLower(l.Email)
+}
+
+// EntitlementService resolves the active subscriptions of a subscriber into their merged entitlements
+type EntitlementService struct {
+	Connection *pop.Connection
+	Cache      *EntitlementCache
+}
+
+// Creates an EntitlementService sharing the process wide cache
+func NewEntitlementService(tx *pop.Connection) *EntitlementService {
+	return &EntitlementService{Connection: tx, Cache: Entitlements}
+}
+
+// Resolve returns the entitlements of the subscriber, from the cache when fresh
+func (s *EntitlementService) Resolve(lookup EntitlementLookup, now time.Time) (*EntitlementSet, error) {
+	if set, ok := s.Cache.Get(lookup.cacheKey(), now); ok {
+		return set, nil
+	}
+
+	subscribers := models.Subscribers{}
+	var err error
+	if lookup.SubscriberID != "" {
+		subscriber := models.Subscriber{}
+		err = s.Connection.Find(&subscriber, lookup.SubscriberID)
+		subscribers = append(subscribers, subscriber)
+	} else {
+		err = s.Connection.Where("lower(email) = ?", strings.ToLower(lookup.Email)).Order("created_at desc").All(&subscribers)
+		if err == nil && len(subscribers) == 0 {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	set := &EntitlementSet{
+		SubscriberID:    subscribers[0].ID,
+		SubscriberIDs:   []uuid.UUID{},
+		Email:           subscribers[0].Email,
+		SubscriptionIDs: []uuid.UUID{},
+		ResolvedAt:      now,
+	}
+	subscriberIDs := make([]interface{}, len(subscribers))
+	for i, subscriber := range subscribers {
+		set.SubscriberIDs = append(set.SubscriberIDs, subscriber.ID)
+		subscriberIDs[i] = subscriber.ID
+	}
+
+	statuses := make([]interface{}, len(models.ActiveSubscriptionStatuses))
+	for i, status := range models.ActiveSubscriptionStatuses {
+		statuses[i] = status
+	}
+
+	subscriptions := models.Subscriptions{}
+	err = s.Connection.Eager("Plan.Features").
+		Where("subscriber_id IN (?)", subscriberIDs...).
+		Where("status IN (?)", statuses...).
+		Where("expires_at > ?", now).
+		Order("created_at asc").
+		All(&subscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	features := []models.PlanFeatures{}
+	for _, subscription := range subscriptions {
+		set.SubscriptionIDs = append(set.SubscriptionIDs, subscription.ID)
+		features = append(features, subscription.Plan.Features)
+	}
+	set.Entitlements = models.MergeEntitlements(features...)
+
+	s.Cache.Set(lookup.cacheKey(), set, now)
+	return set, nil
+}
+
+// EntitlementCache keeps resolved entitlement sets for a short while, so services asking on
+// every request do not hit the database each time
+type EntitlementCache struct {
+	TTL time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]entitlementCacheEntry
+}
+
+type entitlementCacheEntry struct {
+	set       *EntitlementSet
+	expiresAt time.Time
+}
+
+// Entitlements is the cache shared by the process. Its TTL comes from ENTITLEMENTS_CACHE_TTL,
+// e.g. "30s", and defaults to a minute.
+var Entitlements = NewEntitlementCache(entitlementCacheTTL())
+
+func entitlementCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("ENTITLEMENTS_CACHE_TTL"))
+	if err != nil {
+		return time.Minute
+	}
+	return ttl
+}
+
+// Creates an empty EntitlementCache
+func NewEntitlementCache(ttl time.Duration) *EntitlementCache {
+	return &EntitlementCache{TTL: ttl, entries: map[string]entitlementCacheEntry{}}
+}
+
+// Get returns the cached set when it has not expired yet
+func (c *EntitlementCache) Get(key string, now time.Time) (*EntitlementSet, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.set, true
+}
+
+// Set caches the set under key until the TTL runs out
+func (c *EntitlementCache) Set(key string, set *EntitlementSet, now time.Time) {
+	if c.TTL <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = entitlementCacheEntry{set: set, expiresAt: now.Add(c.TTL)}
+}
+
+// Invalidate drops every cached set of the subscriber, whatever it was looked up by. The
+// e-mail, when given, also drops lookups that resolved to another subscriber with that address.
+func (c *EntitlementCache) Invalidate(subscriberID uuid.UUID, email string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.set.includes(subscriberID) || (email != "" && strings.EqualFold(entry.set.Email, email)) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// InvalidateOn drops the cached sets the event makes stale. Only subscription.* events change
+// what a subscriber is entitled to.
+func (c *EntitlementCache) InvalidateOn(event Event) {
+	if changed, ok := event.Data.(SubscriptionChanged); ok {
+		c.Invalidate(changed.SubscriberID, "")
+	}
+}
+
+// ListenSubscriptionEvents invalidates the cache on the subscription.* events published by
+// any replica. It binds a private queue to the notification exchange with the given routing
+// key, e.g. "subscription.*", and stops when the channel closes.
+func (c *EntitlementCache) ListenSubscriptionEvents(rabbitMQ *RabbitMQ, bindingKey string) error {
+	queue, err := rabbitMQ.Channel.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+	if err := rabbitMQ.Channel.QueueBind(queue.Name, bindingKey, os.Getenv("RABBITMQ_NOTIFICATION_EX"), false, nil); err != nil {
+		return err
+	}
+
+	deliveries, err := rabbitMQ.Channel.Consume(queue.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delivery := range deliveries {
+			event := struct {
+				Type string              `json:"type"`
+				Data SubscriptionChanged `json:"data"`
+			}{}
+			if err := json.Unmarshal(delivery.Body, &event); err != nil {
+				log.Println("Error decoding subscription event:", err)
+				continue
+			}
+			if strings.HasPrefix(event.Type, "subscription.") {
+				c.Invalidate(event.Data.SubscriberID, "")
+			}
+		}
+	}()
+
+	return nil
+}
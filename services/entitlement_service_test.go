@@ -0,0 +1,50 @@
+package services
+
+import (
+	"github.com/gofrs/uuid"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_EntitlementCache(t *testing.T) {
+	now := time.Date(2020, 7, 10, 12, 0, 0, 0, time.UTC)
+	cache := NewEntitlementCache(time.Minute)
+	subscriberID := uuid.Must(uuid.NewV4())
+	set := &EntitlementSet{SubscriberID: subscriberID, Email: "Ana@example.com"}
+
+	cache.Set("id:"+subscriberID.String(), set, now)
+	cache.Set("email:ana@example.com", set, now)
+
+	if _, ok := cache.Get("email:ana@example.com", now.Add(30*time.Second)); !ok {
+		t.Error("expected a fresh entry")
+	}
+	if _, ok := cache.Get("email:ana@example.com", now.Add(time.Minute)); ok {
+		t.Error("expected the entry to expire after the TTL")
+	}
+
+	cache.InvalidateOn(NewEvent("subscription.canceled", SubscriptionChanged{SubscriberID: subscriberID}))
+	if _, ok := cache.Get("id:"+subscriberID.String(), now); ok {
+		t.Error("expected the subscription event to invalidate the entry")
+	}
+
+	cache.Set("email:ana@example.com", set, now)
+	cache.Invalidate(uuid.Must(uuid.NewV4()), "ana@example.com")
+	if _, ok := cache.Get("email:ana@example.com", now); ok {
+		t.Error("expected a new subscriber with the same e-mail to invalidate the entry")
+	}
+}
+
+func Test_EntitlementSet_Allows(t *testing.T) {
+	set := &EntitlementSet{Entitlements: models.Entitlements{
+		"projects": {Limit: 3},
+		"reports":  {Unlimited: true},
+		"api":      {Limit: 0},
+	}}
+
+	for feature, want := range map[string]bool{"projects": true, "reports": true, "api": false, "sso": false} {
+		if _, allowed := set.Allows(feature); allowed != want {
+			t.Errorf("%s: got %v, want %v", feature, allowed, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"os"
+	"time"
+)
+
+// Event is the envelope used for every lifecycle message we publish to RabbitMQ.
+// Consumers should switch on Type; Data holds the type specific payload.
+type Event struct {
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// NewEvent builds an event stamped with the current time
+func NewEvent(eventType string, data interface{}) Event {
+	return Event{
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// PublishEvent sends the event to the notification exchange. The event type is used
+// as the routing key when RABBITMQ_NOTIFICATION_ROUTING_KEY is empty, so topic exchanges can filter on it.
+// Entitlements cached by this process that the event makes stale are dropped once the
+// transaction of tx commits, and the event is queued in it for the webhook endpoints subscribing to it.
+func (r *RabbitMQ) PublishEvent(tx *pop.Connection, event Event) error {
+	AfterCommit(tx, func() { Entitlements.InvalidateOn(event) })
+
+	if Webhooks != nil {
+		// A savepoint keeps a failure to queue from aborting the transaction of the change
+		err := transaction(tx, func(tx *pop.Connection) error { return Webhooks.Enqueue(tx, event) })
+		if err != nil {
+			log.Printf("Error queueing webhooks of %s: %v", event.Type, err)
+		}
+	}
+
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	routingKey := os.Getenv("RABBITMQ_NOTIFICATION_ROUTING_KEY")
+	if routingKey == "" {
+		routingKey = event.Type
+	}
+
+	return r.Notify(string(message), "application/json", os.Getenv("RABBITMQ_NOTIFICATION_EX"), routingKey)
+}
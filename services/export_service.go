@@ -0,0 +1,373 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Exported datasets
+const (
+	ExportSubscribers   = "subscribers"
+	ExportSubscriptions = "subscriptions"
+	ExportPayments      = "payments"
+)
+
+// ErrUnknownExport is returned for datasets we do not export
+var ErrUnknownExport = errors.New("unknown export")
+
+// exportDateLayout is how the date range of the exports is given
+const exportDateLayout = "2006-01-02"
+
+// ExportFilter narrows the exported rows. Status is the payment status for payments and the
+// subscription status otherwise; PaymentType and Gateway only apply to payments.
+type ExportFilter struct {
+	// From and To bound the creation date, To being exclusive. Zero leaves the range open.
+	From        time.Time
+	To          time.Time
+	PlanID      string
+	Status      string
+	PaymentType string
+	Gateway     string
+}
+
+// ParseExportFilter reads the filter from the from and to dates (YYYY-MM-DD, both inclusive),
+// plan_id, status, payment_type and gateway parameters
+func ParseExportFilter(get func(string) string) (ExportFilter, error) {
+	filter := ExportFilter{
+		PlanID:      get("plan_id"),
+		Status:      get("status"),
+		PaymentType: get("payment_type"),
+		Gateway:     get("gateway"),
+	}
+
+	if from := get("from"); from != "" {
+		t, err := time.Parse(exportDateLayout, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date %q, use YYYY-MM-DD", from)
+		}
+		filter.From = t
+	}
+	if to := get("to"); to != "" {
+		t, err := time.Parse(exportDateLayout, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date %q, use YYYY-MM-DD", to)
+		}
+		filter.To = t.AddDate(0, 0, 1)
+	}
+	if filter.PlanID != "" {
+		if _, err := uuid.FromString(filter.PlanID); err != nil {
+			return filter, fmt.Errorf("invalid plan_id %q", filter.PlanID)
+		}
+	}
+
+	return filter, nil
+}
+
+// ExportService streams subscribers, subscriptions and payments, joined with their subscriber
+// and plan, to spreadsheets. Rows are read in batches, ordered by creation, so large exports
+// never sit in memory.
+type ExportService struct {
+	Connection *pop.Connection
+	BatchSize  int
+}
+
+// Creates an ExportService reading 500 rows at a time
+func NewExportService(tx *pop.Connection) *ExportService {
+	return &ExportService{Connection: tx, BatchSize: 500}
+}
+
+// exportRow is a row of one of the datasets
+type exportRow interface {
+	cells() []interface{}
+	key() (time.Time, uuid.UUID)
+}
+
+// exportQuery reads a dataset: the SELECT, the alias of its main table and the filter conditions
+type exportQuery struct {
+	header     []string
+	sql        string
+	alias      string
+	conditions []string
+	args       []interface{}
+	fetch      func(q *pop.Query) ([]exportRow, error)
+}
+
+func (q *exportQuery) where(condition string, arg interface{}) {
+	q.conditions = append(q.conditions, condition)
+	q.args = append(q.args, arg)
+}
+
+// Export writes the header and every row of the dataset matching the filter
+func (s *ExportService) Export(dataset string, filter ExportFilter, w SheetWriter) error {
+	var query *exportQuery
+	switch dataset {
+	case ExportSubscribers:
+		query = subscribersExport(filter)
+	case ExportSubscriptions:
+		query = subscriptionsExport(filter)
+	case ExportPayments:
+		query = paymentsExport(filter)
+	default:
+		return ErrUnknownExport
+	}
+
+	if !filter.From.IsZero() {
+		query.where(query.alias+".created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query.where(query.alias+".created_at < ?", filter.To)
+	}
+
+	header := make([]interface{}, len(query.header))
+	for i, column := range query.header {
+		header[i] = column
+	}
+	if err := w.WriteRow(header); err != nil {
+		return err
+	}
+
+	// Keyset pagination: each batch starts after the last row of the previous one
+	var lastCreatedAt time.Time
+	var lastID uuid.UUID
+	for batch := 0; ; batch++ {
+		conditions := append([]string{}, query.conditions...)
+		args := append([]interface{}{}, query.args...)
+		if batch > 0 {
+			conditions = append(conditions, fmt.Sprintf("(%s.created_at, %s.id) > (?, ?)", query.alias, query.alias))
+			args = append(args, lastCreatedAt, lastID)
+		}
+
+		sql := query.sql
+		if len(conditions) > 0 {
+			sql += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		sql += fmt.Sprintf(" ORDER BY %s.created_at, %s.id LIMIT %d", query.alias, query.alias, s.BatchSize)
+
+		rows, err := query.fetch(s.Connection.RawQuery(sql, args...))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := w.WriteRow(row.cells()); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if len(rows) < s.BatchSize {
+			return nil
+		}
+		lastCreatedAt, lastID = rows[len(rows)-1].key()
+	}
+}
+
+type subscriberExportRow struct {
+	ID                  uuid.UUID `db:"id"`
+	CreatedAt           time.Time `db:"created_at"`
+	Name                string    `db:"name"`
+	Email               string    `db:"email"`
+	DocumentNumber      string    `db:"document_number"`
+	DDD                 string    `db:"ddd"`
+	Number              string    `db:"number"`
+	Street              string    `db:"street"`
+	StreetNumber        string    `db:"street_number"`
+	Complementary       string    `db:"complementary"`
+	Neighborhood        string    `db:"neighborhood"`
+	Zipcode             string    `db:"zipcode"`
+	ActiveSubscriptions int       `db:"active_subscriptions"`
+}
+
+func (r subscriberExportRow) key() (time.Time, uuid.UUID) { return r.CreatedAt, r.ID }
+
+func (r subscriberExportRow) cells() []interface{} {
+	return []interface{}{
+		r.ID.String(), r.CreatedAt, r.Name, r.Email, r.DocumentNumber, r.DDD + r.Number,
+		r.Street, r.StreetNumber, r.Complementary, r.Neighborhood, r.Zipcode, r.ActiveSubscriptions,
+	}
+}
+
+// subscribersExport filters subscribers by the plan and status of their subscriptions
+func subscribersExport(filter ExportFilter) *exportQuery {
+	query := &exportQuery{
+		header: []string{
+			"id", "created_at", "name", "email", "document_number", "phone",
+			"street", "street_number", "complementary", "neighborhood", "zipcode", "active_subscriptions",
+		},
+		sql: `SELECT sr.id, sr.created_at, sr.name, sr.email, sr.document_number, sr.ddd, sr.number,
+				sr.street, sr.street_number, sr.complementary, sr.neighborhood, sr.zipcode,
+				(SELECT COUNT(*) FROM subscriptions WHERE subscriber_id = sr.id AND status IN (?, ?, ?)) AS active_subscriptions
+			FROM subscribers sr`,
+		alias: "sr",
+		args:  []interface{}{models.SubscriptionTrialing, models.SubscriptionPaid, models.SubscriptionPendingPayment},
+		fetch: func(q *pop.Query) ([]exportRow, error) {
+			rows := []subscriberExportRow{}
+			if err := q.All(&rows); err != nil {
+				return nil, err
+			}
+			out := make([]exportRow, len(rows))
+			for i := range rows {
+				out[i] = rows[i]
+			}
+			return out, nil
+		},
+	}
+
+	if filter.PlanID != "" {
+		query.where("EXISTS (SELECT 1 FROM subscriptions WHERE subscriber_id = sr.id AND plan_id = ?)", filter.PlanID)
+	}
+	if filter.Status != "" {
+		query.where("EXISTS (SELECT 1 FROM subscriptions WHERE subscriber_id = sr.id AND status = ?)", filter.Status)
+	}
+	return query
+}
+
+type subscriptionExportRow struct {
+	ID                   uuid.UUID `db:"id"`
+	CreatedAt            time.Time `db:"created_at"`
+	Status               string    `db:"status"`
+	StartDate            time.Time `db:"start_date"`
+	ExpiresAt            time.Time `db:"expires_at"`
+	RemoteSubscriptionID string    `db:"remote_subscription_id"`
+	PlanID               uuid.UUID `db:"plan_id"`
+	PlanName             string    `db:"plan_name"`
+	PlanPrice            float64   `db:"plan_price"`
+	IntervalUnit         string    `db:"interval_unit"`
+	IntervalCount        int       `db:"interval_count"`
+	SubscriberID         uuid.UUID `db:"subscriber_id"`
+	SubscriberName       string    `db:"subscriber_name"`
+	SubscriberEmail      string    `db:"subscriber_email"`
+	DocumentNumber       string    `db:"document_number"`
+}
+
+func (r subscriptionExportRow) key() (time.Time, uuid.UUID) { return r.CreatedAt, r.ID }
+
+func (r subscriptionExportRow) cells() []interface{} {
+	return []interface{}{
+		r.ID.String(), r.CreatedAt, r.Status, r.StartDate, r.ExpiresAt, r.RemoteSubscriptionID,
+		r.PlanID.String(), r.PlanName, r.PlanPrice, r.IntervalUnit, r.IntervalCount,
+		r.SubscriberID.String(), r.SubscriberName, r.SubscriberEmail, r.DocumentNumber,
+	}
+}
+
+func subscriptionsExport(filter ExportFilter) *exportQuery {
+	query := &exportQuery{
+		header: []string{
+			"id", "created_at", "status", "start_date", "expires_at", "remote_subscription_id",
+			"plan_id", "plan_name", "plan_price", "interval_unit", "interval_count",
+			"subscriber_id", "subscriber_name", "subscriber_email", "document_number",
+		},
+		sql: `SELECT s.id, s.created_at, s.status, s.start_date, s.expires_at, s.remote_subscription_id,
+				s.plan_id, p.name AS plan_name, p.price AS plan_price, p.interval_unit, p.interval_count,
+				s.subscriber_id, sr.name AS subscriber_name, sr.email AS subscriber_email, sr.document_number
+			FROM subscriptions s
+			JOIN plans p ON p.id = s.plan_id
+			JOIN subscribers sr ON sr.id = s.subscriber_id`,
+		alias: "s",
+		fetch: func(q *pop.Query) ([]exportRow, error) {
+			rows := []subscriptionExportRow{}
+			if err := q.All(&rows); err != nil {
+				return nil, err
+			}
+			out := make([]exportRow, len(rows))
+			for i := range rows {
+				out[i] = rows[i]
+			}
+			return out, nil
+		},
+	}
+
+	if filter.PlanID != "" {
+		query.where("s.plan_id = ?", filter.PlanID)
+	}
+	if filter.Status != "" {
+		query.where("s.status = ?", filter.Status)
+	}
+	return query
+}
+
+type paymentExportRow struct {
+	ID              uuid.UUID `db:"id"`
+	CreatedAt       time.Time `db:"created_at"`
+	Status          string    `db:"status"`
+	PaymentType     string    `db:"payment_type"`
+	Gateway         string    `db:"gateway"`
+	TransactionID   string    `db:"transaction_id"`
+	Total           int       `db:"total"`
+	Refunded        int       `db:"refunded"`
+	Installments    int       `db:"installments"`
+	CardBrand       string    `db:"card_brand"`
+	CardLastDigits  string    `db:"card_last_digits"`
+	SubscriptionID  uuid.UUID `db:"subscription_id"`
+	PlanID          uuid.UUID `db:"plan_id"`
+	PlanName        string    `db:"plan_name"`
+	SubscriberID    uuid.UUID `db:"subscriber_id"`
+	SubscriberName  string    `db:"subscriber_name"`
+	SubscriberEmail string    `db:"subscriber_email"`
+	DocumentNumber  string    `db:"document_number"`
+}
+
+func (r paymentExportRow) key() (time.Time, uuid.UUID) { return r.CreatedAt, r.ID }
+
+// cells gives the amounts in reais, the way finance reads the sheets
+func (r paymentExportRow) cells() []interface{} {
+	return []interface{}{
+		r.ID.String(), r.CreatedAt, r.Status, r.PaymentType, r.Gateway, r.TransactionID,
+		float64(r.Total) / 100, float64(r.Refunded) / 100, r.Installments, r.CardBrand, r.CardLastDigits,
+		r.SubscriptionID.String(), r.PlanID.String(), r.PlanName,
+		r.SubscriberID.String(), r.SubscriberName, r.SubscriberEmail, r.DocumentNumber,
+	}
+}
+
+func paymentsExport(filter ExportFilter) *exportQuery {
+	query := &exportQuery{
+		header: []string{
+			"id", "created_at", "status", "payment_type", "gateway", "transaction_id",
+			"total", "refunded", "installments", "card_brand", "card_last_digits",
+			"subscription_id", "plan_id", "plan_name",
+			"subscriber_id", "subscriber_name", "subscriber_email", "document_number",
+		},
+		sql: `SELECT pm.id, pm.created_at, pm.status, pm.payment_type, pm.gateway, pm.transaction_id,
+				pm.total, pm.installments, pm.card_brand, pm.card_last_digits,
+				COALESCE((SELECT SUM(amount) FROM refunds WHERE payment_id = pm.id AND status = ?), 0) AS refunded,
+				pm.subscription_id, s.plan_id, p.name AS plan_name,
+				s.subscriber_id, sr.name AS subscriber_name, sr.email AS subscriber_email, sr.document_number
+			FROM payments pm
+			JOIN subscriptions s ON s.id = pm.subscription_id
+			JOIN plans p ON p.id = s.plan_id
+			JOIN subscribers sr ON sr.id = s.subscriber_id`,
+		alias: "pm",
+		args:  []interface{}{models.RefundSucceeded},
+		fetch: func(q *pop.Query) ([]exportRow, error) {
+			rows := []paymentExportRow{}
+			if err := q.All(&rows); err != nil {
+				return nil, err
+			}
+			out := make([]exportRow, len(rows))
+			for i := range rows {
+				out[i] = rows[i]
+			}
+			return out, nil
+		},
+	}
+
+	if filter.PlanID != "" {
+		query.where("s.plan_id = ?", filter.PlanID)
+	}
+	if filter.Status != "" {
+		query.where("pm.status = ?", filter.Status)
+	}
+	if filter.PaymentType != "" {
+		query.where("pm.payment_type = ?", filter.PaymentType)
+	}
+	if filter.Gateway != "" {
+		query.where("pm.gateway = ?", filter.Gateway)
+	}
+	return query
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"os"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Fraud check decisions
+const (
+	FraudApprove = "approve"
+	FraudReview  = "review"
+	FraudReject  = "reject"
+)
+
+// ErrFraudRejected is returned, before charging, for checkouts the fraud check rejects
+var ErrFraudRejected = errors.New("checkout rejected by the fraud check")
+
+// FraudCheck is what the fraud check knows about a checkout before it is charged
+type FraudCheck struct {
+	ProcessData
+	IP        string
+	UserAgent string
+}
+
+// FraudAssessment is the verdict of a fraud check. Reasons explain the score.
+type FraudAssessment struct {
+	Decision string
+	Score    int
+	Reasons  []string
+}
+
+// FraudChecker decides whether a checkout may be charged. Approved checkouts are charged;
+// rejected ones are not; those flagged for review are charged and wait in the review queue.
+type FraudChecker interface {
+	Check(tx *pop.Connection, check FraudCheck) (FraudAssessment, error)
+}
+
+// FraudCheckers are the checkers FRAUD_CHECKER may name
+var FraudCheckers = map[string]func() FraudChecker{
+	"rules": func() FraudChecker { return NewRulesFraudChecker() },
+	"off":   func() FraudChecker { return approveAll{} },
+}
+
+// Fraud is the checker PaymentService uses unless given another, picked by FRAUD_CHECKER
+// ("rules" when not set, or "off")
+var Fraud = newFraudChecker(os.Getenv("FRAUD_CHECKER"))
+
+func newFraudChecker(name string) FraudChecker {
+	if newChecker, ok := FraudCheckers[name]; ok {
+		return newChecker()
+	}
+	return NewRulesFraudChecker()
+}
+
+type approveAll struct{}
+
+func (approveAll) Check(tx *pop.Connection, check FraudCheck) (FraudAssessment, error) {
+	return FraudAssessment{Decision: FraudApprove}, nil
+}
+
+// CardHashDigest identifies the card hash without keeping it
+func CardHashDigest(cardHash string) string {
+	if cardHash == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cardHash))
+	return hex.EncodeToString(sum[:])
+}
+
+// VelocityLimit allows Max checkout attempts within the window sharing the value of Column
+type VelocityLimit struct {
+	Column string
+	Max    int
+	Weight int
+}
+
+// RulesFraudChecker scores checkouts with local rules: too many recent attempts with the
+// same email, CPF, IP or card hash, disposable email domains and phone area codes (DDD)
+// from another state than the address. Checkouts scoring ReviewScore go to review and
+// those scoring RejectScore are rejected.
+type RulesFraudChecker struct {
+	Window            time.Duration
+	Velocity          []VelocityLimit
+	DisposableDomains map[string]bool
+	DisposableWeight  int
+	DDDMismatchWeight int
+	ReviewScore       int
+	RejectScore       int
+	Now               func() time.Time
+}
+
+// NewRulesFraudChecker creates the checker with the default rules. FRAUD_REVIEW_SCORE and
+// FRAUD_REJECT_SCORE change the thresholds and FRAUD_DISPOSABLE_DOMAINS, comma separated,
+// adds domains to the disposable list.
+func NewRulesFraudChecker() *RulesFraudChecker {
+	domains := map[string]bool{}
+	for _, domain := range disposableDomains {
+		domains[domain] = true
+	}
+	for _, domain := range strings.Split(os.Getenv("FRAUD_DISPOSABLE_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains[domain] = true
+		}
+	}
+
+	return &RulesFraudChecker{
+		Window: time.Hour,
+		Velocity: []VelocityLimit{
+			{Column: "email", Max: 3, Weight: 40},
+			{Column: "document_number", Max: 3, Weight: 40},
+			{Column: "ip", Max: 10, Weight: 30},
+			{Column: "card_hash_digest", Max: 2, Weight: 60},
+		},
+		DisposableDomains: domains,
+		DisposableWeight:  50,
+		DDDMismatchWeight: 20,
+		ReviewScore:       envInt("FRAUD_REVIEW_SCORE", 50),
+		RejectScore:       envInt("FRAUD_REJECT_SCORE", 100),
+		Now:               time.Now,
+	}
+}
+
+func envInt(variable string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(variable))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Check scores the checkout against the rules
+func (r *RulesFraudChecker) Check(tx *pop.Connection, check FraudCheck) (FraudAssessment, error) {
+	assessment := FraudAssessment{Reasons: []string{}}
+	add := func(weight int, reason string, args ...interface{}) {
+		assessment.Score += weight
+		assessment.Reasons = append(assessment.Reasons, fmt.Sprintf(reason, args...))
+	}
+
+	values := map[string]string{
+		"email":            strings.ToLower(strings.TrimSpace(check.Email)),
+		"document_number":  nonDigits.ReplaceAllString(check.DocumentNumber, ""),
+		"ip":               check.IP,
+		"card_hash_digest": CardHashDigest(check.CardHash),
+	}
+	since := r.Now().Add(-r.Window)
+	for _, limit := range r.Velocity {
+		value := values[limit.Column]
+		if value == "" {
+			continue
+		}
+		count, err := tx.Where(limit.Column+" = ? AND created_at >= ?", value, since).Count(&models.CheckoutAttempt{})
+		if err != nil {
+			return assessment, err
+		}
+		if count >= limit.Max {
+			add(limit.Weight, "%d attempts with the same %s in %s", count, limit.Column, r.Window)
+		}
+	}
+
+	if at := strings.LastIndex(values["email"], "@"); at >= 0 && r.DisposableDomains[values["email"][at+1:]] {
+		add(r.DisposableWeight, "disposable email domain %s", values["email"][at+1:])
+	}
+
+	if check.DDD != "" && check.State != "" {
+		state, known := dddStates[nonDigits.ReplaceAllString(check.DDD, "")]
+		switch {
+		case !known:
+			add(r.DDDMismatchWeight, "unknown DDD %s", check.DDD)
+		case state != strings.ToUpper(check.State):
+			add(r.DDDMismatchWeight, "DDD %s is from %s, address in %s", check.DDD, state, check.State)
+		}
+	}
+
+	switch {
+	case assessment.Score >= r.RejectScore:
+		assessment.Decision = FraudReject
+	case assessment.Score >= r.ReviewScore:
+		assessment.Decision = FraudReview
+	default:
+		assessment.Decision = FraudApprove
+	}
+	return assessment, nil
+}
+
+// disposableDomains are well known throwaway email providers
+var disposableDomains = []string{
+	"10minutemail.com", "dispostable.com", "getnada.com", "guerrillamail.com", "maildrop.cc",
+	"mailinator.com", "sharklasers.com", "temp-mail.org", "throwawaymail.com", "trashmail.com",
+	"yopmail.com",
+}
+
+// dddStates maps the phone area codes to their state
+var dddStates = map[string]string{
+	"11": "SP", "12": "SP", "13": "SP", "14": "SP", "15": "SP", "16": "SP", "17": "SP", "18": "SP", "19": "SP",
+	"21": "RJ", "22": "RJ", "24": "RJ", "27": "ES", "28": "ES",
+	"31": "MG", "32": "MG", "33": "MG", "34": "MG", "35": "MG", "37": "MG", "38": "MG",
+	"41": "PR", "42": "PR", "43": "PR", "44": "PR", "45": "PR", "46": "PR",
+	"47": "SC", "48": "SC", "49": "SC", "51": "RS", "53": "RS", "54": "RS", "55": "RS",
+	"61": "DF", "62": "GO", "64": "GO", "63": "TO", "65": "MT", "66": "MT", "67": "MS",
+	"68": "AC", "69": "RO",
+	"71": "BA", "73": "BA", "74": "BA", "75": "BA", "77": "BA", "79": "SE",
+	"81": "PE", "87": "PE", "82": "AL", "83": "PB", "84": "RN", "85": "CE", "88": "CE", "86": "PI", "89": "PI",
+	"91": "PA", "93": "PA", "94": "PA", "92": "AM", "97": "AM", "95": "RR", "96": "AP", "98": "MA", "99": "MA",
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"subscription_service/models"
+	"time"
+)
+
+// ErrFraudReviewClosed is returned when deciding a review that was already decided
+var ErrFraudReviewClosed = errors.New("fraud review already decided")
+
+// ApproveFraudReview closes the review, keeping the subscription
+func ApproveFraudReview(tx *pop.Connection, review *models.FraudReview, operator string, notes string) error {
+	return closeFraudReview(tx, review, models.FraudReviewApproved, operator, notes)
+}
+
+// RejectFraudReview closes the review, refunding what the subscription paid and canceling it.
+// Subscriptions with nothing to refund, e.g. boletos not paid yet, are just canceled.
+func RejectFraudReview(refunds *RefundService, review *models.FraudReview, operator string, notes string) error {
+	if !review.Open() {
+		return ErrFraudReviewClosed
+	}
+
+	subscription := &models.Subscription{}
+	if err := refunds.Connection.Eager("Payments").Find(subscription, review.SubscriptionID); err != nil {
+		return err
+	}
+
+	reason := "fraud review rejected"
+	if notes != "" {
+		reason += ": " + notes
+	}
+
+	// The subscription is canceled by the refunds or, when nothing was paid, right here; either
+	// way by the operator
+	subscription.ChangedBy = operator
+	attributed := *refunds
+	attributed.ChangedBy = operator
+
+	refunded := false
+	for _, payment := range subscription.Payments {
+		if !payment.Refundable() {
+			continue
+		}
+		if _, err := attributed.Refund(payment.ID, RefundRequest{Reason: reason, CancelSubscription: true}); err != nil {
+			return err
+		}
+		refunded = true
+	}
+	if !refunded {
+		if err := CancelSubscription(refunds.Connection, refunds.Gateway, refunds.RabbitMQ, subscription, reason); err != nil {
+			return err
+		}
+	}
+
+	return closeFraudReview(refunds.Connection, review, models.FraudReviewRejected, operator, notes)
+}
+
+func closeFraudReview(tx *pop.Connection, review *models.FraudReview, status string, operator string, notes string) error {
+	if !review.Open() {
+		return ErrFraudReviewClosed
+	}
+
+	review.Status = status
+	review.ReviewedBy = nulls.NewString(operator)
+	review.ReviewedAt = nulls.NewTime(time.Now())
+	review.Notes = notes
+	verrs, err := tx.ValidateAndUpdate(review)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_RulesFraudChecker_Check(t *testing.T) {
+	checker := NewRulesFraudChecker()
+	// No velocity rules, so no database is needed
+	checker.Velocity = nil
+
+	cases := []struct {
+		email    string
+		ddd      string
+		state    string
+		decision string
+		score    int
+	}{
+		{"ana@example.com", "11", "SP", FraudApprove, 0},
+		{"ana@example.com", "", "", FraudApprove, 0},
+		{"ana@example.com", "31", "SP", FraudApprove, 20},
+		{"ana@example.com", "20", "SP", FraudApprove, 20},
+		{"Ana@Mailinator.com", "11", "sp", FraudReview, 50},
+		{"ana@yopmail.com", "21", "SP", FraudReview, 70},
+	}
+	for _, c := range cases {
+		check := FraudCheck{ProcessData: ProcessData{Email: c.email, DDD: c.ddd, State: c.state}}
+		assessment, err := checker.Check(nil, check)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assessment.Decision != c.decision || assessment.Score != c.score {
+			t.Errorf("%s %s/%s: expected %s (%d), got %s (%d): %s", c.email, c.ddd, c.state, c.decision, c.score,
+				assessment.Decision, assessment.Score, strings.Join(assessment.Reasons, "; "))
+		}
+	}
+
+	checker.RejectScore = 70
+	assessment, _ := checker.Check(nil, FraudCheck{ProcessData: ProcessData{Email: "ana@yopmail.com", DDD: "21", State: "SP"}})
+	if assessment.Decision != FraudReject {
+		t.Errorf("expected a reject at the reject score, got %s", assessment.Decision)
+	}
+}
+
+func Test_CardHashDigest(t *testing.T) {
+	if CardHashDigest("") != "" {
+		t.Error("expected no digest for checkouts without a card")
+	}
+	digest := CardHashDigest("1234_abcd")
+	if len(digest) != 64 || strings.Contains(digest, "abcd") || digest != CardHashDigest("1234_abcd") {
+		t.Errorf("unexpected digest %s", digest)
+	}
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"subscription_service/models"
+	"time"
+)
+
+// GatewayClient talks to the payment gateway proxy. Every call gives up after Timeout and
+// fails with a *GatewayError; calls that are safe to repeat are retried up to Retries times,
+// waiting Backoff, then twice as long, and so on. Breaker stops calling a gateway that keeps failing.
+type GatewayClient struct {
+	HTTPClient *http.Client
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	Breaker    *CircuitBreaker
+
+	ctx context.Context
+}
+
+// GatewayRequest holds the credentials sent along with every call to the gateway proxy
+type GatewayRequest struct {
+	SecretKey string  `json:"secret_key"`
+	Gateway   Gateway `json:"gateway"`
+	APIKey    string  `json:"api_key"`
+}
+
+// RefundTransactionRequest asks the gateway to return money for a transaction.
+// Amount is in cents; the gateway refunds the whole transaction when it is zero.
+type RefundTransactionRequest struct {
+	GatewayRequest
+	TransactionID string `json:"transaction_id"`
+	Amount        int    `json:"amount,omitempty"`
+}
+
+// RefundReturn is the gateway answer to a refund
+type RefundReturn struct {
+	ID             int    `json:"id"`
+	Status         string `json:"status"`
+	Amount         int    `json:"amount"`
+	RefundedAmount int    `json:"refunded_amount"`
+}
+
+// CancelSubscriptionRequest asks the gateway to stop charging a subscription
+type CancelSubscriptionRequest struct {
+	GatewayRequest
+	RemoteSubscriptionID string `json:"subscription_id"`
+}
+
+// FetchSubscriptionRequest asks the gateway for the current state of a subscription
+type FetchSubscriptionRequest struct {
+	GatewayRequest
+	RemoteSubscriptionID string `json:"subscription_id"`
+}
+
+// FetchPlanRequest asks the gateway for the configuration of a plan
+type FetchPlanRequest struct {
+	GatewayRequest
+	RemotePlanID string `json:"plan_id"`
+}
+
+// RemotePlan is the plan as configured at the gateway
+type RemotePlan struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+	Days   int    `json:"days"`
+}
+
+// ListRequest pages through the subscriptions or transactions created in a date range
+type ListRequest struct {
+	GatewayRequest
+	CreatedFrom time.Time `json:"date_created_from"`
+	CreatedTo   time.Time `json:"date_created_to"`
+	Page        int       `json:"page"`
+	Count       int       `json:"count"`
+}
+
+// RemoteTransaction is a charge as listed by the gateway
+type RemoteTransaction struct {
+	ID                   int       `json:"id"`
+	Status               string    `json:"status"`
+	Amount               int       `json:"amount"`
+	RefundedAmount       int       `json:"refunded_amount"`
+	Installments         int       `json:"installments"`
+	PaymentMethod        string    `json:"payment_method"`
+	CardBrand            string    `json:"card_brand"`
+	CardLastDigits       string    `json:"card_last_digits"`
+	BoletoURL            string    `json:"boleto_url"`
+	BoletoBarcode        string    `json:"boleto_barcode"`
+	BoletoExpirationDate string    `json:"boleto_expiration_date"`
+	SubscriptionID       int       `json:"subscription_id"`
+	CreatedAt            time.Time `json:"date_created"`
+}
+
+// NewPayment maps the transaction to a Payment of the given local subscription
+func (t RemoteTransaction) NewPayment(subscriptionID uuid.UUID) models.Payment {
+	paymentID, _ := uuid.NewV4()
+
+	return models.Payment{
+		ID:                   paymentID,
+		TransactionID:        strconv.Itoa(t.ID),
+		Gateway:              os.Getenv("GATEWAY"),
+		PaymentType:          t.PaymentMethod,
+		Status:               t.Status,
+		Total:                t.Amount,
+		CardBrand:            t.CardBrand,
+		CardLastDigits:       t.CardLastDigits,
+		BoletoURL:            t.BoletoURL,
+		BoletoBarcode:        t.BoletoBarcode,
+		BoletoExpirationDate: t.BoletoExpirationDate,
+		Installments:         t.Installments,
+		SubscriptionID:       subscriptionID,
+		CreatedAt:            t.CreatedAt,
+		UpdatedAt:            t.CreatedAt,
+	}
+}
+
+// Creates a GatewayClient. GATEWAY_TIMEOUT sets how long a call may take, e.g. "20s", and
+// GATEWAY_RETRIES how many times the calls safe to repeat are retried.
+func NewGatewayClient() *GatewayClient {
+	timeout, err := time.ParseDuration(os.Getenv("GATEWAY_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 20 * time.Second
+	}
+	retries, err := strconv.Atoi(os.Getenv("GATEWAY_RETRIES"))
+	if err != nil || retries < 0 {
+		retries = 2
+	}
+
+	return &GatewayClient{
+		HTTPClient: &http.Client{},
+		Timeout:    timeout,
+		Retries:    retries,
+		Backoff:    500 * time.Millisecond,
+		Breaker:    GatewayBreaker,
+	}
+}
+
+// WithContext returns a copy of the client whose calls are abandoned when ctx is done,
+// e.g. the context of the request being served
+func (g *GatewayClient) WithContext(ctx context.Context) *GatewayClient {
+	client := *g
+	client.ctx = ctx
+	return &client
+}
+
+func (g *GatewayClient) context() context.Context {
+	if g.ctx == nil {
+		return context.Background()
+	}
+	return g.ctx
+}
+
+// CreateSubscription starts the subscription and charges its first period. It is never
+// retried, since a call that failed midway may have charged the customer already.
+func (g *GatewayClient) CreateSubscription(request TransactionSubscriptionRequest) (*PaymentReturn, error) {
+	subscription := &PaymentReturn{}
+	if err := g.post(os.Getenv("PAYMENT_SUBSCRIPTION_ENDPOINT"), request, subscription, false); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func newGatewayRequest() GatewayRequest {
+	return GatewayRequest{
+		SecretKey: os.Getenv("PAYMENT_SECRET_KEY"),
+		Gateway:   Gateway{Name: os.Getenv("GATEWAY")},
+		APIKey:    os.Getenv("GATEWAY_APIKEY"),
+	}
+}
+
+// Refund returns amount cents of the transaction to the customer
+func (g *GatewayClient) Refund(transactionID string, amount int) (*RefundReturn, error) {
+	request := RefundTransactionRequest{
+		GatewayRequest: newGatewayRequest(),
+		TransactionID:  transactionID,
+		Amount:         amount,
+	}
+
+	refund := &RefundReturn{}
+	if err := g.post(os.Getenv("PAYMENT_REFUND_ENDPOINT"), request, refund, false); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// FetchSubscription returns the subscription as the gateway sees it, in the same format
+// returned when it was created
+func (g *GatewayClient) FetchSubscription(remoteSubscriptionID string) (*PaymentReturn, error) {
+	request := FetchSubscriptionRequest{
+		GatewayRequest:       newGatewayRequest(),
+		RemoteSubscriptionID: remoteSubscriptionID,
+	}
+
+	subscription := &PaymentReturn{}
+	if err := g.post(os.Getenv("PAYMENT_FETCH_SUBSCRIPTION_ENDPOINT"), request, subscription, true); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// FetchPlan returns the plan configuration kept by the gateway
+func (g *GatewayClient) FetchPlan(remotePlanID string) (*RemotePlan, error) {
+	request := FetchPlanRequest{
+		GatewayRequest: newGatewayRequest(),
+		RemotePlanID:   remotePlanID,
+	}
+
+	plan := &RemotePlan{}
+	if err := g.post(os.Getenv("PAYMENT_PLAN_ENDPOINT"), request, plan, true); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ListSubscriptions returns a page of the subscriptions created between from and to
+func (g *GatewayClient) ListSubscriptions(from time.Time, to time.Time, page int, count int) ([]PaymentReturn, error) {
+	subscriptions := []PaymentReturn{}
+	err := g.post(os.Getenv("PAYMENT_LIST_SUBSCRIPTIONS_ENDPOINT"), newListRequest(from, to, page, count), &subscriptions, true)
+	return subscriptions, err
+}
+
+// ListTransactions returns a page of the transactions created between from and to
+func (g *GatewayClient) ListTransactions(from time.Time, to time.Time, page int, count int) ([]RemoteTransaction, error) {
+	transactions := []RemoteTransaction{}
+	err := g.post(os.Getenv("PAYMENT_LIST_TRANSACTIONS_ENDPOINT"), newListRequest(from, to, page, count), &transactions, true)
+	return transactions, err
+}
+
+func newListRequest(from time.Time, to time.Time, page int, count int) ListRequest {
+	return ListRequest{
+		GatewayRequest: newGatewayRequest(),
+		CreatedFrom:    from,
+		CreatedTo:      to,
+		Page:           page,
+		Count:          count,
+	}
+}
+
+// CancelSubscription stops the gateway from charging the subscription again
+func (g *GatewayClient) CancelSubscription(remoteSubscriptionID string) error {
+	request := CancelSubscriptionRequest{
+		GatewayRequest:       newGatewayRequest(),
+		RemoteSubscriptionID: remoteSubscriptionID,
+	}
+
+	// Canceling twice leaves the subscription canceled all the same
+	return g.post(os.Getenv("PAYMENT_CANCEL_ENDPOINT"), request, nil, true)
+}
+
+// post sends the payload and decodes the answer into out, retrying when idempotent
+func (g *GatewayClient) post(url string, payload interface{}, out interface{}, idempotent bool) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx := g.context()
+	for attempt := 0; ; attempt++ {
+		err := g.attempt(ctx, url, jsonData, out)
+		gatewayErr, ok := err.(*GatewayError)
+		if !ok || !idempotent || !gatewayErr.retryable() || attempt >= g.Retries {
+			return err
+		}
+
+		// Exponential backoff with jitter, so clients failing together do not retry together
+		wait := g.Backoff << uint(attempt)
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (g *GatewayClient) attempt(ctx context.Context, url string, jsonData []byte, out interface{}) error {
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	// A request that can not be built, e.g. for a malformed endpoint, never reaches the
+	// gateway, so it is refused before asking the breaker
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if g.Breaker != nil {
+		if err := g.Breaker.Allow(); err != nil {
+			return err
+		}
+	}
+
+	resp, err := g.HTTPClient.Do(req)
+	if err != nil {
+		err = &GatewayError{Kind: ErrGatewayUnreachable, Err: err}
+		g.record(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = &GatewayError{Kind: ErrGatewayUnreachable, StatusCode: resp.StatusCode, Err: err}
+		g.record(err)
+		return err
+	}
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		err = &GatewayError{Kind: ErrGatewayUnavailable, StatusCode: resp.StatusCode, Body: string(body)}
+		g.record(err)
+		return err
+	case resp.StatusCode >= 300:
+		// The gateway works, it just refused this request
+		g.record(nil)
+		return &GatewayError{Kind: ErrGatewayRejected, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	g.record(nil)
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return &GatewayError{Kind: ErrGatewayBadResponse, Body: string(body), Err: err}
+	}
+	return nil
+}
+
+// record tells the breaker how a call went. Calls the caller gave up on say nothing about the gateway.
+func (g *GatewayClient) record(err error) {
+	switch {
+	case g.Breaker == nil:
+	case g.context().Err() != nil:
+		g.Breaker.Ignore()
+	case err != nil:
+		g.Breaker.Failure()
+	default:
+		g.Breaker.Success()
+	}
+}
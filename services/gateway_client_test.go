@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"github.com/gofrs/uuid"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"subscription_service/gatewaysim"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+// startGatewaySimulator points the gateway endpoints to a new simulator for the test
+func startGatewaySimulator(t *testing.T) *gatewaysim.Simulator {
+	simulator := gatewaysim.New("")
+	simulator.Delay, simulator.Timeout, simulator.BoletoDelay = time.Millisecond, time.Second, time.Millisecond
+	server := httptest.NewServer(simulator)
+	t.Cleanup(server.Close)
+	t.Cleanup(gatewaysim.Setenv(server.URL))
+	return simulator
+}
+
+func newSimulatedCheckout(method string, cardHash string, document string) TransactionSubscriptionRequest {
+	return TransactionSubscriptionRequest{
+		APIKey:        os.Getenv("GATEWAY_APIKEY"),
+		RemotePlanID:  1003,
+		PaymentMethod: method,
+		CardHash:      cardHash,
+		Amount:        7990,
+		Customer:      &CustomerSubscription{CustomerName: "Ana", CustomerEmail: "ana@example.com", DocumentNumber: document},
+	}
+}
+
+// simulatedCheckout creates a subscription at the simulator the way PaymentService does
+func simulatedCheckout(t *testing.T, method string, document string) *PaymentReturn {
+	subscription, err := NewGatewayClient().CreateSubscription(newSimulatedCheckout(method, "", document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subscription
+}
+
+// newTestGatewayClient does not retry at once and has a breaker of its own, so failures
+// do not open the circuit for the other tests
+func newTestGatewayClient() *GatewayClient {
+	gateway := NewGatewayClient()
+	gateway.Backoff, gateway.Timeout = time.Millisecond, 200*time.Millisecond
+	gateway.Breaker = NewCircuitBreaker(3, time.Minute)
+	return gateway
+}
+
+// countingServer answers every call with status and counts them
+func countingServer(t *testing.T, status int) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error": "boom"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func Test_GatewayClient_FetchAndCancelSubscription(t *testing.T) {
+	startGatewaySimulator(t)
+	created := simulatedCheckout(t, "credit_card", "12345678909")
+	remoteID := strconv.Itoa(created.RemoteSubscriptionID)
+
+	gateway := NewGatewayClient()
+	remote, err := gateway.FetchSubscription(remoteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Status != models.SubscriptionPaid || remote.Customer.Email != "ana@example.com" || remote.CurrentTransaction.Amount != 7990 {
+		t.Errorf("unexpected subscription %+v", remote)
+	}
+	if _, err := time.Parse(time.RFC3339, remote.CurrentPeriodSEnd); err != nil {
+		t.Errorf("unexpected period end %q", remote.CurrentPeriodSEnd)
+	}
+
+	if err := gateway.CancelSubscription(remoteID); err != nil {
+		t.Fatal(err)
+	}
+	if remote, _ := gateway.FetchSubscription(remoteID); remote.Status != models.SubscriptionCanceled {
+		t.Errorf("expected the subscription canceled, got %s", remote.Status)
+	}
+
+	if _, err := gateway.FetchSubscription("1"); err == nil {
+		t.Error("expected an unknown subscription to fail")
+	}
+}
+
+func Test_GatewayClient_Refund(t *testing.T) {
+	startGatewaySimulator(t)
+	created := simulatedCheckout(t, "credit_card", "12345678909")
+	transactionID := strconv.Itoa(created.CurrentTransaction.RemoteTransactionID)
+
+	gateway := NewGatewayClient()
+	refund, err := gateway.Refund(transactionID, 990)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != models.PaymentPaid || refund.RefundedAmount != 990 {
+		t.Errorf("unexpected partial refund %+v", refund)
+	}
+
+	refund, err = gateway.Refund(transactionID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != models.PaymentRefunded || refund.RefundedAmount != 7990 {
+		t.Errorf("unexpected full refund %+v", refund)
+	}
+}
+
+func Test_GatewayClient_ListTransactions(t *testing.T) {
+	startGatewaySimulator(t)
+	simulatedCheckout(t, "credit_card", "12345678909")
+	boleto := simulatedCheckout(t, "boleto", "12345678909")
+
+	now := time.Now()
+	transactions, err := NewGatewayClient().ListTransactions(now.Add(-time.Hour), now.Add(time.Hour), 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("expected 2 transactions, got %+v", transactions)
+	}
+
+	payment := transactions[1].NewPayment(uuid.Nil)
+	if payment.PaymentType != "boleto" || payment.BoletoURL != boleto.CurrentTransaction.BoletoURL || payment.Status != "waiting_payment" {
+		t.Errorf("unexpected payment %+v", payment)
+	}
+}
+
+func Test_GatewayClient_CreateSubscriptionOutcomes(t *testing.T) {
+	startGatewaySimulator(t)
+	gateway := newTestGatewayClient()
+
+	declined, err := gateway.CreateSubscription(newSimulatedCheckout("credit_card", "card_declined", ""))
+	if err != nil || !declined.Declined() || declined.RefuseReason != "acquirer" {
+		t.Errorf("expected a declined charge, got %+v %v", declined, err)
+	}
+	noFunds, _ := gateway.CreateSubscription(newSimulatedCheckout("credit_card", "card_no_funds", ""))
+	if reason := NewDeclineError(*noFunds).Reason(); reason != DeclineInsufficientFunds {
+		t.Errorf("expected insufficient funds, got %s", reason)
+	}
+	if approved, _ := gateway.CreateSubscription(newSimulatedCheckout("credit_card", "", "")); approved.Declined() {
+		t.Errorf("expected an approved charge, got %+v", approved)
+	}
+	if boleto, _ := gateway.CreateSubscription(newSimulatedCheckout("boleto", "", "")); boleto.Declined() {
+		t.Errorf("expected a boleto waiting payment, got %+v", boleto)
+	}
+
+	for _, cardHash := range []string{"card_error", "card_timeout"} {
+		_, err := gateway.CreateSubscription(newSimulatedCheckout("credit_card", cardHash, ""))
+		if !PaymentOutcomeUnknown(err) {
+			t.Errorf("%s: expected an unknown outcome, got %v", cardHash, err)
+		}
+	}
+}
+
+func Test_GatewayClient_RetriesIdempotentCalls(t *testing.T) {
+	server, calls := countingServer(t, http.StatusBadGateway)
+	os.Setenv("PAYMENT_FETCH_SUBSCRIPTION_ENDPOINT", server.URL)
+	os.Setenv("PAYMENT_REFUND_ENDPOINT", server.URL)
+	defer os.Unsetenv("PAYMENT_FETCH_SUBSCRIPTION_ENDPOINT")
+	defer os.Unsetenv("PAYMENT_REFUND_ENDPOINT")
+
+	gateway := newTestGatewayClient()
+	gateway.Retries = 2
+
+	_, err := gateway.FetchSubscription("100001")
+	if !errors.Is(err, ErrGatewayUnavailable) || *calls != 3 {
+		t.Errorf("expected 3 calls failing with ErrGatewayUnavailable, got %d %v", *calls, err)
+	}
+
+	gateway.Breaker = NewCircuitBreaker(10, time.Minute)
+	*calls = 0
+	if _, err := gateway.Refund("1", 0); !PaymentOutcomeUnknown(err) || *calls != 1 {
+		t.Errorf("expected a single refund call with an unknown outcome, got %d %v", *calls, err)
+	}
+}
+
+func Test_GatewayClient_ClassifiesErrors(t *testing.T) {
+	rejecting, calls := countingServer(t, http.StatusBadRequest)
+	os.Setenv("PAYMENT_PLAN_ENDPOINT", rejecting.URL)
+	defer os.Unsetenv("PAYMENT_PLAN_ENDPOINT")
+
+	gateway := newTestGatewayClient()
+	_, err := gateway.FetchPlan("1001")
+	if !errors.Is(err, ErrGatewayRejected) || *calls != 1 || PaymentOutcomeUnknown(err) {
+		t.Errorf("expected a single rejected call, got %d %v", *calls, err)
+	}
+	if gateway.Breaker.Open() {
+		t.Error("expected rejected calls not to open the circuit")
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	os.Setenv("PAYMENT_PLAN_ENDPOINT", closed.URL)
+	_, err = gateway.FetchPlan("1001")
+	if !errors.Is(err, ErrGatewayUnreachable) || PaymentOutcomeUnknown(err) {
+		t.Errorf("expected a refused connection to be unreachable with a known outcome, got %v", err)
+	}
+
+	gateway.Breaker = NewCircuitBreaker(1, time.Minute)
+	os.Setenv("PAYMENT_PLAN_ENDPOINT", rejecting.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gateway.WithContext(ctx).FetchPlan("1001"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled call to fail, got %v", err)
+	}
+	if gateway.Breaker.Open() {
+		t.Error("expected canceled calls not to open the circuit")
+	}
+}
+
+func Test_GatewayClient_CircuitBreaker(t *testing.T) {
+	server, calls := countingServer(t, http.StatusServiceUnavailable)
+	os.Setenv("PAYMENT_PLAN_ENDPOINT", server.URL)
+	defer os.Unsetenv("PAYMENT_PLAN_ENDPOINT")
+
+	gateway := newTestGatewayClient()
+	gateway.Retries = 0
+	for i := 0; i < 3; i++ {
+		gateway.FetchPlan("1001")
+	}
+
+	_, err := gateway.FetchPlan("1001")
+	if !errors.Is(err, ErrCircuitOpen) || *calls != 3 {
+		t.Errorf("expected the circuit to open after 3 calls, got %d %v", *calls, err)
+	}
+}
+
+func Test_GatewayClient_MalformedEndpoint(t *testing.T) {
+	os.Setenv("PAYMENT_PLAN_ENDPOINT", "http://gateway.example.com/%zz")
+	defer os.Unsetenv("PAYMENT_PLAN_ENDPOINT")
+
+	// The circuit is open, but its cooldown is over, so the next call probes the gateway
+	gateway := newTestGatewayClient()
+	gateway.Breaker = NewCircuitBreaker(1, 0)
+	gateway.Breaker.Failure()
+
+	if _, err := gateway.FetchPlan("1001"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the malformed endpoint to fail, got %v", err)
+	}
+	if !gateway.Breaker.Open() {
+		t.Error("expected a call that never went out not to close the circuit")
+	}
+	if err := gateway.Breaker.Allow(); err != nil {
+		t.Errorf("expected a call that never went out not to take the probe, got %v", err)
+	}
+}
+
+func Test_CheckPlan_Simulator(t *testing.T) {
+	startGatewaySimulator(t)
+	gateway := NewGatewayClient()
+
+	plan := models.Plan{RemotePanID: "1004", Price: 799.00, IntervalUnit: models.IntervalYear, IntervalCount: 1}
+	if mismatches, err := CheckPlan(gateway, plan); err != nil || len(mismatches) != 0 {
+		t.Errorf("expected the plan to match, got %v %v", mismatches, err)
+	}
+
+	plan.Price = 899.00
+	if mismatches, _ := CheckPlan(gateway, plan); len(mismatches) != 1 {
+		t.Errorf("expected the price to mismatch, got %v", mismatches)
+	}
+}
+
+func Test_Postback_FromSimulator(t *testing.T) {
+	os.Setenv("GATEWAY_APIKEY", "ak_test_simulator")
+	defer os.Unsetenv("GATEWAY_APIKEY")
+
+	body := []byte("object=subscription&id=100001&event=subscription_status_changed&old_status=paid&current_status=unpaid")
+	if !VerifyPostbackSignature(body, gatewaysim.Sign(body, "ak_test_simulator")) {
+		t.Fatal("expected the simulator signature to verify")
+	}
+	if VerifyPostbackSignature(body, gatewaysim.Sign(body, "another key")) {
+		t.Error("expected a signature with another key to fail")
+	}
+
+	postback, err := ParsePostback(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if postback.Object != "subscription" || postback.ID != "100001" || postback.CurrentStatus != "unpaid" {
+		t.Errorf("unexpected postback %+v", postback)
+	}
+}
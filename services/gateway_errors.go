@@ -0,0 +1,80 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Kinds of gateway failures. Test for them with errors.Is.
+var (
+	// ErrGatewayRejected is a 4xx answer: the request was refused and nothing was done
+	ErrGatewayRejected = errors.New("gateway rejected the request")
+	// ErrGatewayUnavailable is a 5xx or 429 answer
+	ErrGatewayUnavailable = errors.New("gateway unavailable")
+	// ErrGatewayUnreachable is a network failure or a timeout
+	ErrGatewayUnreachable = errors.New("gateway unreachable")
+	// ErrGatewayBadResponse is an answer that could not be understood
+	ErrGatewayBadResponse = errors.New("unexpected gateway response")
+	// ErrCircuitOpen is returned without calling the gateway while it is failing
+	ErrCircuitOpen = errors.New("gateway circuit open")
+	// ErrPaymentDeclined is a charge the gateway refused
+	ErrPaymentDeclined = errors.New("payment declined")
+)
+
+// GatewayError describes a failed call to the gateway
+type GatewayError struct {
+	// Kind is one of the ErrGateway* errors or ErrCircuitOpen
+	Kind       error
+	StatusCode int
+	Body       string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *GatewayError) Error() string {
+	switch {
+	case e.StatusCode != 0:
+		return fmt.Sprintf("%s: answered %d: %s", e.Kind, e.StatusCode, e.Body)
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+	default:
+		return e.Kind.Error()
+	}
+}
+
+// Is matches the kind of the error
+func (e *GatewayError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *GatewayError) Unwrap() error {
+	return e.Err
+}
+
+// retryable tells whether trying an idempotent call again may succeed
+func (e *GatewayError) retryable() bool {
+	return e.Kind == ErrGatewayUnavailable || e.Kind == ErrGatewayUnreachable
+}
+
+// PaymentOutcomeUnknown reports whether the gateway may have charged despite the error,
+// e.g. it timed out or failed after getting the request. The charge shows up later in the
+// reconciliation, so the customer must not be told it failed nor asked to pay again.
+func PaymentOutcomeUnknown(err error) bool {
+	gatewayErr := &GatewayError{}
+	if !errors.As(err, &gatewayErr) {
+		return false
+	}
+
+	switch gatewayErr.Kind {
+	case ErrGatewayUnavailable:
+		return gatewayErr.StatusCode != 429
+	case ErrGatewayBadResponse:
+		return true
+	case ErrGatewayUnreachable:
+		// Connections that could not even be opened never delivered the request
+		opErr := &net.OpError{}
+		return !(errors.As(gatewayErr.Err, &opErr) && opErr.Op == "dial")
+	}
+	return false
+}
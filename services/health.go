@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Health statuses
+const (
+	HealthUp = "up"
+	// HealthDegraded means an optional check failed; the app still serves
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// HealthCheck is a dependency the readiness of the app depends on
+type HealthCheck struct {
+	Name string
+	// Optional checks are reported without making the app unready when they fail
+	Optional bool
+	Check    func(ctx context.Context) error
+}
+
+// HealthCheckResult is how a check went
+type HealthCheckResult struct {
+	Status     string `json:"status"`
+	Optional   bool   `json:"optional,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int    `json:"duration_ms"`
+}
+
+// HealthReport is the outcome of the checks, by name
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// Ready reports whether every required check passed
+func (r HealthReport) Ready() bool {
+	return r.Status != HealthDown
+}
+
+// RunHealthChecks runs the checks side by side, each given up to timeout
+func RunHealthChecks(ctx context.Context, checks []HealthCheck, timeout time.Duration) HealthReport {
+	report := HealthReport{Status: HealthUp, Checks: map[string]HealthCheckResult{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Check(checkCtx)
+			result := HealthCheckResult{Status: HealthUp, Optional: check.Optional, DurationMS: int(time.Since(start) / time.Millisecond)}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Status, result.Error = HealthDown, err.Error()
+				switch {
+				case !check.Optional:
+					report.Status = HealthDown
+				case report.Status == HealthUp:
+					report.Status = HealthDegraded
+				}
+			}
+			report.Checks[check.Name] = result
+		}(check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// ReadinessChecks are the checks telling whether the app can serve: the database and
+// RabbitMQ, and the gateway, optionally, when HEALTH_GATEWAY_URL is set
+func ReadinessChecks(db *pop.Connection, rabbitMQ *RabbitMQ) []HealthCheck {
+	checks := []HealthCheck{
+		{Name: "database", Check: func(ctx context.Context) error {
+			return db.WithContext(ctx).RawQuery("SELECT 1").Exec()
+		}},
+		rabbitMQCheck(rabbitMQ),
+	}
+	if url := os.Getenv("HEALTH_GATEWAY_URL"); url != "" {
+		checks = append(checks, HealthCheck{Name: "gateway", Optional: true, Check: func(ctx context.Context) error {
+			return checkReachable(ctx, url)
+		}})
+	}
+	return checks
+}
+
+// LivenessChecks are the checks telling whether the app must be restarted: the RabbitMQ
+// connection, which is opened at start and not opened again once the server closes it
+func LivenessChecks(rabbitMQ *RabbitMQ) []HealthCheck {
+	return []HealthCheck{rabbitMQCheck(rabbitMQ)}
+}
+
+func rabbitMQCheck(rabbitMQ *RabbitMQ) HealthCheck {
+	return HealthCheck{Name: "rabbitmq", Check: func(ctx context.Context) error {
+		return rabbitMQ.CheckConnection()
+	}}
+}
+
+// checkReachable fails when the URL does not answer, or answers with a server error
+func checkReachable(ctx context.Context, url string) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("answered %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_RunHealthChecks(t *testing.T) {
+	up := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	cases := []struct {
+		checks []HealthCheck
+		status string
+	}{
+		{[]HealthCheck{{Name: "database", Check: up}, {Name: "gateway", Optional: true, Check: up}}, HealthUp},
+		{[]HealthCheck{{Name: "database", Check: up}, {Name: "gateway", Optional: true, Check: down}}, HealthDegraded},
+		{[]HealthCheck{{Name: "database", Check: down}, {Name: "gateway", Optional: true, Check: down}}, HealthDown},
+		{[]HealthCheck{{Name: "database", Check: slow}}, HealthDown},
+	}
+	for i, c := range cases {
+		report := RunHealthChecks(context.Background(), c.checks, 10*time.Millisecond)
+		if report.Status != c.status || len(report.Checks) != len(c.checks) {
+			t.Errorf("case %d: expected %s, got %+v", i, c.status, report)
+		}
+		if report.Ready() != (c.status != HealthDown) {
+			t.Errorf("case %d: unexpected readiness for %s", i, report.Status)
+		}
+	}
+
+	report := RunHealthChecks(context.Background(), []HealthCheck{{Name: "database", Check: down}}, time.Second)
+	if result := report.Checks["database"]; result.Status != HealthDown || result.Error != "connection refused" {
+		t.Errorf("expected the error to be reported, got %+v", result)
+	}
+}
+
+func Test_RabbitMQ_CheckConnection(t *testing.T) {
+	if err := NewRabbitMQ().CheckConnection(); err != ErrRabbitMQClosed {
+		t.Errorf("expected an unconnected RabbitMQ to be closed, got %v", err)
+	}
+}
+
+func Test_checkReachable(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if err := checkReachable(context.Background(), server.URL); err != nil {
+		t.Errorf("expected a gateway answering 404 to be reachable, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := checkReachable(context.Background(), server.URL); err == nil {
+		t.Error("expected a gateway answering 503 to be unreachable")
+	}
+	server.Close()
+	if err := checkReachable(context.Background(), server.URL); err == nil {
+		t.Error("expected a closed gateway to be unreachable")
+	}
+}
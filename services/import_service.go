@@ -0,0 +1,265 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+)
+
+// Results of importing a legacy row
+const (
+	ImportCreated = "created"
+	// ImportSkipped rows were imported before, so running the import again is harmless
+	ImportSkipped = "skipped"
+	// ImportValid rows passed every check of a dry run
+	ImportValid   = "valid"
+	ImportInvalid = "invalid"
+	ImportFailed  = "failed"
+)
+
+// LegacyRecord is a customer of the legacy billing system whose subscription already
+// exists at the gateway. CSV files name their columns after the JSON fields.
+type LegacyRecord struct {
+	RemoteSubscriptionID string `json:"remote_subscription_id"`
+	Name                 string `json:"name"`
+	Email                string `json:"email"`
+	DocumentNumber       string `json:"document_number"`
+	DDD                  string `json:"ddd"`
+	Number               string `json:"number"`
+	Street               string `json:"street"`
+	StreetNumber         string `json:"street_number"`
+	Complementary        string `json:"complementary"`
+	Neighborhood         string `json:"neighborhood"`
+	Zipcode              string `json:"zipcode"`
+}
+
+var (
+	legacyEmailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	nonDigits          = regexp.MustCompile(`\D`)
+)
+
+// Validate lists what is wrong with the record
+func (r LegacyRecord) Validate() []string {
+	problems := []string{}
+	if _, err := strconv.Atoi(r.RemoteSubscriptionID); err != nil {
+		problems = append(problems, fmt.Sprintf("remote_subscription_id %q is not a number", r.RemoteSubscriptionID))
+	}
+	if strings.TrimSpace(r.Name) == "" {
+		problems = append(problems, "name is blank")
+	}
+	if !legacyEmailPattern.MatchString(r.Email) {
+		problems = append(problems, fmt.Sprintf("email %q is invalid", r.Email))
+	}
+	if document := nonDigits.ReplaceAllString(r.DocumentNumber, ""); r.DocumentNumber != "" && len(document) != 11 && len(document) != 14 {
+		problems = append(problems, fmt.Sprintf("document_number %q is not a CPF or CNPJ", r.DocumentNumber))
+	}
+	return problems
+}
+
+// NewSubscriber maps the record to a new Subscriber, filling the blanks from the gateway
+// customer. The e-mail is the gateway's, the one the subscription is matched by.
+func (r LegacyRecord) NewSubscriber(customer RemoteCustomer) *models.Subscriber {
+	subscriber := customer.NewSubscriber()
+
+	override := func(field *string, value string) {
+		if value != "" {
+			*field = value
+		}
+	}
+	override(&subscriber.Name, r.Name)
+	override(&subscriber.DocumentNumber, r.DocumentNumber)
+	override(&subscriber.DDD, r.DDD)
+	override(&subscriber.Number, r.Number)
+	override(&subscriber.Street, r.Street)
+	override(&subscriber.StreetNumber, r.StreetNumber)
+	override(&subscriber.Complementary, r.Complementary)
+	override(&subscriber.Neighborhood, r.Neighborhood)
+	override(&subscriber.Zipcode, r.Zipcode)
+
+	return subscriber
+}
+
+// ReadLegacyRecords reads a JSON array of records, or a CSV file with a header row, when
+// format is "csv"
+func ReadLegacyRecords(r io.Reader, format string) ([]LegacyRecord, error) {
+	records := []LegacyRecord{}
+	if format != "csv" {
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+
+	reader := csv.NewReader(r)
+	// Legacy exports often drop trailing empty columns
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(column, "\xEF\xBB\xBF"))] = i
+	}
+	if _, ok := columns["remote_subscription_id"]; !ok {
+		return nil, errors.New("the remote_subscription_id column is missing")
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Decode through JSON so both formats share the field names
+		fields := map[string]string{}
+		for column, i := range columns {
+			if i < len(row) {
+				fields[column] = strings.TrimSpace(row[i])
+			}
+		}
+		b, _ := json.Marshal(fields)
+		record := LegacyRecord{}
+		json.Unmarshal(b, &record)
+		records = append(records, record)
+	}
+}
+
+// ImportResult is the outcome of a row, numbered from 1 after the header
+type ImportResult struct {
+	Row                  int
+	RemoteSubscriptionID string
+	Email                string
+	Result               string
+	SubscriberID         string
+	SubscriptionID       string
+	Detail               string
+}
+
+// ImportService brings the customers of the legacy billing system in, taking the state of
+// their subscriptions from the gateway. Rows are imported one transaction each, so a failing
+// row leaves nothing behind and does not stop the others.
+type ImportService struct {
+	Connection *pop.Connection
+	Gateway    *GatewayClient
+	// DryRun checks the rows against the gateway without writing anything
+	DryRun bool
+}
+
+// Creates an ImportService using the default gateway client
+func NewImportService(tx *pop.Connection) *ImportService {
+	return &ImportService{Connection: tx, Gateway: NewGatewayClient()}
+}
+
+// Run imports the records and returns the result of each one
+func (s *ImportService) Run(records []LegacyRecord) []ImportResult {
+	results := make([]ImportResult, 0, len(records))
+	seen := map[string]int{}
+
+	for i, record := range records {
+		result := ImportResult{Row: i + 1, RemoteSubscriptionID: record.RemoteSubscriptionID, Email: record.Email}
+
+		problems := record.Validate()
+		if row, ok := seen[record.RemoteSubscriptionID]; ok {
+			problems = append(problems, fmt.Sprintf("remote_subscription_id repeats row %d", row))
+		}
+		seen[record.RemoteSubscriptionID] = result.Row
+
+		if len(problems) > 0 {
+			result.Result, result.Detail = ImportInvalid, strings.Join(problems, "; ")
+		} else if err := s.importRecord(record, &result); err != nil {
+			result.Result, result.Detail = ImportFailed, err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (s *ImportService) importRecord(record LegacyRecord, result *ImportResult) error {
+	existing := &models.Subscription{}
+	err := s.Connection.Where("remote_subscription_id = ?", record.RemoteSubscriptionID).First(existing)
+	if err == nil {
+		result.Result, result.SubscriberID, result.SubscriptionID = ImportSkipped, existing.SubscriberID.String(), existing.ID.String()
+		result.Detail = "already imported"
+		return nil
+	}
+	if !models.IsNotFound(err) {
+		return err
+	}
+
+	remote, err := s.Gateway.FetchSubscription(record.RemoteSubscriptionID)
+	if err != nil {
+		return fmt.Errorf("gateway: %w", err)
+	}
+	if strconv.Itoa(remote.RemoteSubscriptionID) != record.RemoteSubscriptionID {
+		return errors.New("the gateway does not know this subscription")
+	}
+	if !strings.EqualFold(remote.Customer.Email, record.Email) {
+		return fmt.Errorf("the gateway customer is %s", remote.Customer.Email)
+	}
+
+	if s.DryRun {
+		result.Result, result.Detail = ImportValid, "gateway status "+remote.Status
+		return nil
+	}
+
+	return s.Connection.Transaction(func(tx *pop.Connection) error {
+		// ImportRemoteSubscription finds the subscriber by e-mail, so create it first from our data
+		subscriber, err := subscriberByEmail(tx, remote.Customer.Email)
+		if models.IsNotFound(err) {
+			subscriber = record.NewSubscriber(remote.Customer)
+			err = validateAndCreate(tx, subscriber)
+		}
+		if err != nil {
+			return err
+		}
+
+		subscription, _, err := ImportRemoteSubscription(tx, remote)
+		if err != nil {
+			return err
+		}
+
+		result.Result, result.SubscriberID, result.SubscriptionID = ImportCreated, subscription.SubscriberID.String(), subscription.ID.String()
+		result.Detail = "gateway status " + remote.Status
+		return nil
+	})
+}
+
+// WriteImportResults writes the results as CSV
+func WriteImportResults(w io.Writer, results []ImportResult) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"row", "remote_subscription_id", "email", "result", "subscriber_id", "subscription_id", "detail"})
+	for _, result := range results {
+		writer.Write([]string{
+			strconv.Itoa(result.Row),
+			result.RemoteSubscriptionID,
+			result.Email,
+			result.Result,
+			result.SubscriberID,
+			result.SubscriptionID,
+			result.Detail,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// CountImportResults tells how many rows ended with each result
+func CountImportResults(results []ImportResult) map[string]int {
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Result]++
+	}
+	return counts
+}
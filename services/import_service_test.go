@@ -0,0 +1,87 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_ReadLegacyRecords_CSV(t *testing.T) {
+	file := "\xEF\xBB\xBFemail,remote_subscription_id,name,legacy_code\nana@example.com, 123 ,Ana Souza,A1\nbia@example.com,456,Bia\n"
+
+	records, err := ReadLegacyRecords(strings.NewReader(file), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0] != (LegacyRecord{RemoteSubscriptionID: "123", Name: "Ana Souza", Email: "ana@example.com"}) {
+		t.Errorf("unexpected record %+v", records[0])
+	}
+
+	if _, err := ReadLegacyRecords(strings.NewReader("email,name\n"), "csv"); err == nil {
+		t.Error("expected a file without remote_subscription_id to fail")
+	}
+}
+
+func Test_ReadLegacyRecords_JSON(t *testing.T) {
+	file := `[{"remote_subscription_id": "123", "name": "Ana", "email": "ana@example.com", "zipcode": "01001000"}]`
+
+	records, err := ReadLegacyRecords(strings.NewReader(file), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Zipcode != "01001000" {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
+
+func Test_LegacyRecord_Validate(t *testing.T) {
+	valid := LegacyRecord{RemoteSubscriptionID: "123", Name: "Ana", Email: "ana@example.com", DocumentNumber: "123.456.789-09"}
+	if problems := valid.Validate(); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	invalid := LegacyRecord{RemoteSubscriptionID: "sub_123", Email: "ana", DocumentNumber: "123"}
+	if problems := invalid.Validate(); len(problems) != 4 {
+		t.Errorf("expected 4 problems, got %v", problems)
+	}
+}
+
+func Test_LegacyRecord_NewSubscriber(t *testing.T) {
+	customer := RemoteCustomer{Name: "ANA S", Email: "Ana@Example.com", DocumentNumber: "12345678909"}
+	customer.Phone.DDD, customer.Phone.Number = "11", "999990000"
+
+	subscriber := LegacyRecord{Name: "Ana Souza", Email: "ana@example.com", Number: "988887777"}.NewSubscriber(customer)
+
+	if subscriber.Name != "Ana Souza" || subscriber.Email != "Ana@Example.com" || subscriber.DocumentNumber != "12345678909" {
+		t.Errorf("unexpected subscriber %+v", subscriber)
+	}
+	if subscriber.DDD != "11" || subscriber.Number != "988887777" {
+		t.Errorf("unexpected phone %s %s", subscriber.DDD, subscriber.Number)
+	}
+}
+
+func Test_WriteImportResults(t *testing.T) {
+	results := []ImportResult{
+		{Row: 1, RemoteSubscriptionID: "123", Email: "ana@example.com", Result: ImportCreated, SubscriberID: "s1", SubscriptionID: "p1"},
+		{Row: 2, RemoteSubscriptionID: "x", Result: ImportInvalid, Detail: "remote_subscription_id \"x\" is not a number"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteImportResults(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	expected := "row,remote_subscription_id,email,result,subscriber_id,subscription_id,detail\n" +
+		"1,123,ana@example.com,created,s1,p1,\n" +
+		"2,x,,invalid,,,\"remote_subscription_id \"\"x\"\" is not a number\"\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected csv %q", buf.String())
+	}
+
+	counts := CountImportResults(results)
+	if counts[ImportCreated] != 1 || counts[ImportInvalid] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InvoiceRequest carries the data a city hall needs to issue an NFS-e
+type InvoiceRequest struct {
+	Reference          string
+	ServiceDescription string
+	Amount             int
+	TakerName          string
+	TakerDocument      string
+	TakerEmail         string
+	TakerAddress       string
+	IssuedAt           time.Time
+}
+
+// InvoiceResult is what the provider returns once the NFS-e is authorized
+type InvoiceResult struct {
+	Number           string
+	VerificationCode string
+	PDFURL           string
+}
+
+// InvoiceProvider is implemented by every NFS-e issuer we integrate with
+type InvoiceProvider interface {
+	Name() string
+	Issue(request InvoiceRequest) (InvoiceResult, error)
+	Cancel(number string, reason string) error
+}
+
+// NewInvoiceProvider returns the provider configured by INVOICE_PROVIDER.
+// Only the local fake provider ships with the service for now.
+func NewInvoiceProvider() (InvoiceProvider, error) {
+	switch name := os.Getenv("INVOICE_PROVIDER"); name {
+	case "", "fake":
+		return defaultFakeInvoiceProvider, nil
+	default:
+		return nil, fmt.Errorf("unknown invoice provider %q", name)
+	}
+}
+
+var defaultFakeInvoiceProvider = NewFakeInvoiceProvider()
+
+// FakeInvoiceProvider issues invoices locally without talking to any city hall.
+// Set FailNext to make the next calls fail, which is handy to exercise retries.
+type FakeInvoiceProvider struct {
+	mutex    sync.Mutex
+	sequence int
+	FailNext int
+	Canceled []string
+}
+
+// Creates an empty FakeInvoiceProvider
+func NewFakeInvoiceProvider() *FakeInvoiceProvider {
+	return &FakeInvoiceProvider{}
+}
+
+func (f *FakeInvoiceProvider) Name() string {
+	return "fake"
+}
+
+func (f *FakeInvoiceProvider) Issue(request InvoiceRequest) (InvoiceResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.FailNext > 0 {
+		f.FailNext--
+		return InvoiceResult{}, fmt.Errorf("fake provider: issuing invoice for %s failed", request.Reference)
+	}
+
+	f.sequence++
+	number := fmt.Sprintf("%d%08d", request.IssuedAt.Year(), f.sequence)
+	sum := sha1.Sum([]byte(request.Reference + number))
+
+	return InvoiceResult{
+		Number:           number,
+		VerificationCode: strings.ToUpper(hex.EncodeToString(sum[:])[:8]),
+		PDFURL:           strings.TrimRight(os.Getenv("APP_URL"), "/") + "/fake-nfse/" + number + ".pdf",
+	}, nil
+}
+
+func (f *FakeInvoiceProvider) Cancel(number string, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.FailNext > 0 {
+		f.FailNext--
+		return fmt.Errorf("fake provider: canceling invoice %s failed", number)
+	}
+
+	f.Canceled = append(f.Canceled, number)
+	return nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"log"
+	"subscription_service/models"
+	"time"
+)
+
+// InvoiceMaxAttempts is how many times we try to issue an invoice before giving up and
+// leaving it for someone to look at
+const InvoiceMaxAttempts = 8
+
+// InvoiceService issues and cancels the fiscal invoices (NFS-e) of paid payments
+type InvoiceService struct {
+	Connection *pop.Connection
+	Provider   InvoiceProvider
+}
+
+// Creates an InvoiceService using the configured provider
+func NewInvoiceService(tx *pop.Connection) (*InvoiceService, error) {
+	provider, err := NewInvoiceProvider()
+	if err != nil {
+		return nil, err
+	}
+	return &InvoiceService{Connection: tx, Provider: provider}, nil
+}
+
+// Request registers the invoice of a paid payment and tries to issue it right away.
+// A failed attempt is not an error: the invoice is kept and retried later by RetryDue.
+func (s *InvoiceService) Request(payment models.Payment) (*models.Invoice, error) {
+	if payment.Status != models.PaymentPaid {
+		return nil, ErrPaymentNotPaid
+	}
+
+	invoice := &models.Invoice{}
+	err := s.Connection.Where("payment_id = ?", payment.ID).First(invoice)
+	if err == nil {
+		return invoice, nil
+	}
+	if !models.IsNotFound(err) {
+		return nil, err
+	}
+
+	invoice.ID, _ = uuid.NewV4()
+	invoice.PaymentID = payment.ID
+	invoice.Provider = s.Provider.Name()
+	invoice.Status = models.InvoicePending
+
+	verrs, err := s.Connection.ValidateAndCreate(invoice)
+	if err != nil {
+		return nil, err
+	}
+	if verrs.HasAny() {
+		return nil, verrs
+	}
+
+	return invoice, s.Attempt(invoice, payment)
+}
+
+// Attempt sends the invoice to the provider and records the outcome
+func (s *InvoiceService) Attempt(invoice *models.Invoice, payment models.Payment) error {
+	parties, err := LoadBillingParties(s.Connection, payment)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	invoice.Attempts++
+
+	result, err := s.Provider.Issue(invoiceRequest(payment, parties, now))
+	if err != nil {
+		log.Printf("Error issuing invoice %s (attempt %d): %v", invoice.ID, invoice.Attempts, err)
+		invoice.Status = models.InvoiceFailed
+		invoice.LastError = nulls.NewString(err.Error())
+		invoice.NextAttemptAt = nulls.Time{}
+		if invoice.Attempts < InvoiceMaxAttempts {
+			invoice.NextAttemptAt = nulls.NewTime(now.Add(InvoiceRetryDelay(invoice.Attempts)))
+		}
+		return s.Connection.Update(invoice)
+	}
+
+	invoice.Status = models.InvoiceIssued
+	invoice.Number = nulls.NewString(result.Number)
+	invoice.VerificationCode = nulls.NewString(result.VerificationCode)
+	invoice.PDFURL = nulls.NewString(result.PDFURL)
+	invoice.IssuedAt = nulls.NewTime(now)
+	invoice.LastError = nulls.String{}
+	invoice.NextAttemptAt = nulls.Time{}
+
+	return s.Connection.Update(invoice)
+}
+
+// RetryDue retries every failed invoice whose next attempt is due, and the pending ones never
+// attempted, and returns how many got issued. Failed invoices without a next attempt were given up.
+func (s *InvoiceService) RetryDue(now time.Time) (int, error) {
+	invoices := models.Invoices{}
+	err := s.Connection.
+		Where("(status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)) OR (status = ? AND next_attempt_at <= ?)",
+			models.InvoicePending, now, models.InvoiceFailed, now).
+		Order("next_attempt_at asc nulls first").
+		All(&invoices)
+	if err != nil {
+		return 0, err
+	}
+
+	issued := 0
+	for i := range invoices {
+		payment := models.Payment{}
+		if err := s.Connection.Find(&payment, invoices[i].PaymentID); err != nil {
+			return issued, err
+		}
+		if err := s.Attempt(&invoices[i], payment); err != nil {
+			return issued, err
+		}
+		if invoices[i].Status == models.InvoiceIssued {
+			issued++
+		}
+	}
+
+	return issued, nil
+}
+
+// CancelForPayment cancels the invoice of a refunded payment. Invoices that never got
+// issued are simply marked as canceled so they stop being retried.
+func (s *InvoiceService) CancelForPayment(paymentID uuid.UUID, reason string) error {
+	invoice := &models.Invoice{}
+	if err := s.Connection.Where("payment_id = ?", paymentID).First(invoice); err != nil {
+		if models.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	switch invoice.Status {
+	case models.InvoiceCanceled:
+		return nil
+	case models.InvoiceIssued:
+		if err := s.Provider.Cancel(invoice.Number.String, reason); err != nil {
+			return err
+		}
+	}
+
+	invoice.Status = models.InvoiceCanceled
+	invoice.CanceledAt = nulls.NewTime(time.Now())
+	invoice.NextAttemptAt = nulls.Time{}
+
+	return s.Connection.Update(invoice)
+}
+
+// InvoiceRetryDelay backs off exponentially from 5 minutes up to 6 hours
+func InvoiceRetryDelay(attempts int) time.Duration {
+	delay := 5 * time.Minute
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= 6*time.Hour {
+			return 6 * time.Hour
+		}
+	}
+	return delay
+}
+
+func invoiceRequest(payment models.Payment, parties *BillingParties, now time.Time) InvoiceRequest {
+	return InvoiceRequest{
+		Reference:          payment.ID.String(),
+		ServiceDescription: "Assinatura " + parties.Plan.Name,
+		Amount:             payment.Total,
+		TakerName:          parties.Subscriber.Name,
+		TakerDocument:      parties.Subscriber.DocumentNumber,
+		TakerEmail:         parties.Subscriber.Email,
+		TakerAddress:       SubscriberAddress(parties.Subscriber),
+		IssuedAt:           now,
+	}
+}
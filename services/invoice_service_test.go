@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_InvoiceRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  5 * time.Minute,
+		2:  10 * time.Minute,
+		4:  40 * time.Minute,
+		7:  320 * time.Minute,
+		8:  6 * time.Hour,
+		20: 6 * time.Hour,
+	}
+	for attempts, expected := range cases {
+		if got := InvoiceRetryDelay(attempts); got != expected {
+			t.Errorf("InvoiceRetryDelay(%d) = %v, want %v", attempts, got, expected)
+		}
+	}
+}
+
+func Test_FakeInvoiceProvider(t *testing.T) {
+	provider := NewFakeInvoiceProvider()
+	provider.FailNext = 1
+	request := InvoiceRequest{Reference: "payment-1", Amount: 4990, IssuedAt: time.Date(2020, 6, 4, 0, 0, 0, 0, time.UTC)}
+
+	if _, err := provider.Issue(request); err == nil {
+		t.Fatal("expected the first issue to fail")
+	}
+
+	result, err := provider.Issue(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Number != "202000000001" {
+		t.Errorf("unexpected invoice number %q", result.Number)
+	}
+	if len(result.VerificationCode) != 8 {
+		t.Errorf("unexpected verification code %q", result.VerificationCode)
+	}
+
+	if err := provider.Cancel(result.Number, "refund"); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.Canceled) != 1 || provider.Canceled[0] != result.Number {
+		t.Errorf("invoice was not canceled: %v", provider.Canceled)
+	}
+}
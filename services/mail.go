@@ -0,0 +1,182 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MailMessage is an email with a plain text and an HTML version of the same content
+type MailMessage struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// MailSender delivers emails
+type MailSender interface {
+	Send(message MailMessage) error
+}
+
+// NewMailSender picks the sender by MAIL_SENDER: "smtp" sends through SMTP_HOST, otherwise
+// the emails are written to MAIL_DIR (tmp/mail when not set) for local use, or to the log
+// when MAIL_DIR is "-"
+func NewMailSender() MailSender {
+	if os.Getenv("MAIL_SENDER") == "smtp" {
+		return &SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envString("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+
+	dir := envString("MAIL_DIR", "tmp/mail")
+	if dir == "-" {
+		dir = ""
+	}
+	return &FileSender{Dir: dir}
+}
+
+func envString(variable string, fallback string) string {
+	if value := os.Getenv(variable); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// SMTPSender sends through an SMTP server, upgrading to TLS when it offers STARTTLS
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// Send delivers the message
+func (s *SMTPSender) Send(message MailMessage) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, addressOf(message.From), []string{addressOf(message.To)}, data)
+}
+
+// FileSender writes each message to a .eml file in Dir, or to the log when Dir is empty
+type FileSender struct {
+	Dir string
+}
+
+// Send writes the message
+func (s *FileSender) Send(message MailMessage) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	if s.Dir == "" {
+		log.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Text)
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), strings.NewReplacer("@", "_at_", "/", "_").Replace(addressOf(message.To)))
+	path := filepath.Join(s.Dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("Email to %s written to %s", message.To, path)
+	return nil
+}
+
+// Bytes formats the message as a multipart/alternative MIME message
+func (m MailMessage) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(wrapBase64([]byte(part.content))); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", m.From},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("UTF-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(m.From)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// wrapBase64 encodes the content in lines of 76 characters, as MIME requires
+func wrapBase64(content []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var wrapped bytes.Buffer
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+	return wrapped.Bytes()
+}
+
+func messageID(from string) string {
+	random := make([]byte, 12)
+	rand.Read(random)
+	domain := "localhost"
+	if at := strings.LastIndex(addressOf(from), "@"); at >= 0 {
+		domain = addressOf(from)[at+1:]
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">"
+}
+
+// addressOf takes the address out of "Name <address>"
+func addressOf(mailbox string) string {
+	if start := strings.LastIndex(mailbox, "<"); start >= 0 {
+		return strings.TrimSuffix(mailbox[start+1:], ">")
+	}
+	return mailbox
+}
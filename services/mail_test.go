@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_MailMessage_Bytes(t *testing.T) {
+	message := MailMessage{
+		From:    "CodeShop <nao-responda@codeshop.com.br>",
+		To:      "ana@example.com",
+		Subject: "Sua assinatura do plano Básico Mensal está ativa",
+		Text:    "Olá, Ana!",
+		HTML:    "<p>Olá, Ana!</p>",
+	}
+	data, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if subject != message.Subject {
+		t.Errorf("expected the subject %q, got %q", message.Subject, subject)
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@codeshop.com.br>") {
+		t.Errorf("unexpected Message-ID %s", parsed.Header.Get("Message-ID"))
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	bodies := []string{}
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		bodies = append(bodies, part.Header.Get("Content-Type")+" "+string(content))
+	}
+	if len(bodies) != 2 || bodies[0] != "text/plain; charset=UTF-8 Olá, Ana!" || bodies[1] != "text/html; charset=UTF-8 <p>Olá, Ana!</p>" {
+		t.Errorf("unexpected parts %q", bodies)
+	}
+}
+
+func Test_FileSender_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender := &FileSender{Dir: dir}
+	if err := sender.Send(MailMessage{From: "nao-responda@codeshop.com.br", To: "Ana <ana@example.com>", Subject: "Oi", Text: "Olá", HTML: "<p>Olá</p>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*ana_at_example.com.eml"))
+	if len(files) != 1 {
+		t.Errorf("expected the message in a file, got %v", files)
+	}
+}
+
+func Test_MailTemplates(t *testing.T) {
+	for _, locale := range []string{"pt-BR", "en"} {
+		for _, kind := range []string{"welcome", "boleto_issued", "boleto_resent", "renewal_receipt", "subscription_canceled", "subscription_expired"} {
+			if !strings.Contains(mailSubjects[locale][kind], "%s") {
+				t.Errorf("%s %s: missing subject", locale, kind)
+			}
+			name := mailTemplateNames[kind]
+			if name == "" {
+				name = kind
+			}
+			for _, format := range []string{".plush.txt", ".plush.html"} {
+				if _, err := mailTemplates.FindString(name + "." + strings.ToLower(locale) + format); err != nil {
+					t.Errorf("%s %s: %v", locale, kind, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/plush"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"html"
+	"html/template"
+	"log"
+	"os"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// mailTemplates holds a template per kind and locale, e.g. welcome.pt-br.plush.html and
+// welcome.pt-br.plush.txt, and the layout wrapping the HTML ones
+var mailTemplates = packr.New("app:mail", "../templates/mail")
+
+// mailSubjects are the subjects of the emails, formatted with the plan name
+var mailSubjects = map[string]map[string]string{
+	models.LocalePtBR: {
+		models.NotificationWelcome:              "Sua assinatura do plano %s está ativa",
+		models.NotificationBoletoIssued:         "Seu boleto do plano %s",
+		models.NotificationBoletoResent:         "Seu boleto do plano %s",
+		models.NotificationRenewalReceipt:       "Recibo da renovação do plano %s",
+		models.NotificationSubscriptionCanceled: "Sua assinatura do plano %s foi cancelada",
+		models.NotificationSubscriptionExpired:  "Sua assinatura do plano %s expirou",
+	},
+	models.LocaleEn: {
+		models.NotificationWelcome:              "Your %s subscription is active",
+		models.NotificationBoletoIssued:         "Your boleto for the %s plan",
+		models.NotificationBoletoResent:         "Your boleto for the %s plan",
+		models.NotificationRenewalReceipt:       "Receipt for the renewal of the %s plan",
+		models.NotificationSubscriptionCanceled: "Your %s subscription was canceled",
+		models.NotificationSubscriptionExpired:  "Your %s subscription expired",
+	},
+}
+
+// mailTemplateNames are the templates of the kinds sharing another kind's
+var mailTemplateNames = map[string]string{
+	models.NotificationBoletoResent: models.NotificationBoletoIssued,
+}
+
+// paymentNotifications are the kinds sent once per payment; the others are sent once per subscription
+var paymentNotifications = map[string]bool{
+	models.NotificationBoletoIssued:   true,
+	models.NotificationRenewalReceipt: true,
+}
+
+// Notify queues the kind of email about the subscription, and the payment when given, to its
+// subscriber. However many times it is queued, it is sent once per subscription, or per
+// payment for boletos and receipts. Queue it in the transaction of the change, so it is only
+// sent if the change is kept.
+func Notify(tx *pop.Connection, kind string, subscription models.Subscription, payment *models.Payment) error {
+	key := kind + ":" + subscription.ID.String()
+	if paymentNotifications[kind] && payment != nil {
+		key = kind + ":" + payment.ID.String()
+	}
+	return queueNotification(tx, kind, key, subscription, payment)
+}
+
+// NotifyBoletoResent queues the boleto of the payment to be sent again. Asking again within
+// the same minute, e.g. a double click, sends it once.
+func NotifyBoletoResent(tx *pop.Connection, payment models.Payment, now time.Time) error {
+	subscription := models.Subscription{}
+	if err := tx.Find(&subscription, payment.SubscriptionID); err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s:%s:%s", models.NotificationBoletoResent, payment.ID, now.UTC().Format("200601021504"))
+	return queueNotification(tx, models.NotificationBoletoResent, key, subscription, &payment)
+}
+
+func queueNotification(tx *pop.Connection, kind string, key string, subscription models.Subscription, payment *models.Payment) error {
+	subscriber := models.Subscriber{}
+	if err := tx.Find(&subscriber, subscription.SubscriberID); err != nil {
+		return err
+	}
+
+	notification := &models.EmailNotification{
+		Kind:           kind,
+		DedupKey:       key,
+		SubscriberID:   subscriber.ID,
+		SubscriptionID: nulls.NewUUID(subscription.ID),
+		Recipient:      subscriber.Email,
+		Locale:         models.NormalizeLocale(subscriber.Locale),
+		Status:         models.NotificationPending,
+		SendAfter:      time.Now(),
+	}
+	if payment != nil {
+		notification.PaymentID = nulls.NewUUID(payment.ID)
+	}
+	verrs, err := notification.Validate(tx)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+
+	// Changes queueing the same email at once would all find it missing, so the unique index
+	// on dedup_key keeps the first one and the others insert nothing
+	notification.ID, _ = uuid.NewV4()
+	notification.CreatedAt, notification.UpdatedAt = notification.SendAfter, notification.SendAfter
+	return tx.RawQuery(`INSERT INTO email_notifications (id, kind, dedup_key, subscriber_id, subscription_id, payment_id, recipient, locale, status, send_after, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (dedup_key) DO NOTHING`,
+		notification.ID, notification.Kind, notification.DedupKey, notification.SubscriberID, notification.SubscriptionID, notification.PaymentID,
+		notification.Recipient, notification.Locale, notification.Status, notification.SendAfter, notification.CreatedAt, notification.UpdatedAt).Exec()
+}
+
+// NotificationService sends the queued emails. Failed sends are retried, waiting longer
+// each time, until MaxAttempts.
+type NotificationService struct {
+	Connection  *pop.Connection
+	Sender      MailSender
+	From        string
+	MaxAttempts int
+	// Stale is how long an email may stay claimed by a sender that died before it is sent again
+	Stale time.Duration
+	Now   func() time.Time
+}
+
+// NotificationReport counts what a run of the sender did
+type NotificationReport struct {
+	Sent    int
+	Retried int
+	Failed  int
+}
+
+// NewNotificationService creates a service sending with NewMailSender, from MAIL_FROM
+func NewNotificationService(tx *pop.Connection) *NotificationService {
+	return &NotificationService{
+		Connection:  tx,
+		Sender:      NewMailSender(),
+		From:        envString("MAIL_FROM", "CodeShop <nao-responda@codeshop.com.br>"),
+		MaxAttempts: 5,
+		Stale:       15 * time.Minute,
+		Now:         time.Now,
+	}
+}
+
+// SendPending sends up to limit emails due. Each one is claimed before being sent, so
+// senders running side by side do not send it twice.
+func (s *NotificationService) SendPending(limit int) (NotificationReport, error) {
+	report := NotificationReport{}
+	now := s.Now()
+
+	notifications := models.EmailNotifications{}
+	err := s.Connection.
+		Where("(status = ? AND send_after <= ?) OR (status = ? AND updated_at < ?)",
+			models.NotificationPending, now, models.NotificationSending, now.Add(-s.Stale)).
+		Order("send_after asc").
+		Limit(limit).
+		All(&notifications)
+	if err != nil {
+		return report, err
+	}
+
+	for i := range notifications {
+		notification := &notifications[i]
+		claimed, err := s.Connection.RawQuery(
+			"UPDATE email_notifications SET status = ?, updated_at = ? WHERE id = ? AND status = ? AND updated_at = ?",
+			models.NotificationSending, now, notification.ID, notification.Status, notification.UpdatedAt,
+		).ExecWithCount()
+		if err != nil {
+			return report, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		notification.Attempts++
+		err = s.send(notification)
+		switch {
+		case err == nil:
+			notification.Status, notification.SentAt, notification.LastError = models.NotificationSent, nulls.NewTime(now), ""
+			report.Sent++
+		case notification.Attempts >= s.MaxAttempts:
+			log.Printf("Giving up on email %s to %s: %v", notification.ID, notification.Recipient, err)
+			notification.Status, notification.LastError = models.NotificationFailed, err.Error()
+			report.Failed++
+		default:
+			log.Printf("Error sending email %s to %s: %v", notification.ID, notification.Recipient, err)
+			backoff := time.Duration(notification.Attempts*notification.Attempts) * time.Minute
+			notification.Status, notification.SendAfter, notification.LastError = models.NotificationPending, now.Add(backoff), err.Error()
+			report.Retried++
+		}
+		if err := s.Connection.Update(notification); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *NotificationService) send(notification *models.EmailNotification) error {
+	message, err := s.Render(*notification)
+	if err != nil {
+		return err
+	}
+	notification.Subject = message.Subject
+	return s.Sender.Send(message)
+}
+
+// Render writes the email, from what is stored now about its subscription and payment
+func (s *NotificationService) Render(notification models.EmailNotification) (MailMessage, error) {
+	ctx := plush.NewContext()
+
+	subscriber := models.Subscriber{}
+	if err := s.Connection.Find(&subscriber, notification.SubscriberID); err != nil {
+		return MailMessage{}, err
+	}
+	ctx.Set("subscriber", subscriber)
+
+	subscription := models.Subscription{}
+	if notification.SubscriptionID.Valid {
+		if err := s.Connection.Eager("Plan").Find(&subscription, notification.SubscriptionID.UUID); err != nil {
+			return MailMessage{}, err
+		}
+	}
+	ctx.Set("subscription", subscription)
+	ctx.Set("plan", subscription.Plan)
+
+	payment := models.Payment{}
+	receiptURL := ""
+	if notification.PaymentID.Valid {
+		if err := s.Connection.Find(&payment, notification.PaymentID.UUID); err != nil {
+			return MailMessage{}, err
+		}
+		receipt := models.Receipt{}
+		if err := s.Connection.Where("payment_id = ?", payment.ID).First(&receipt); err == nil {
+			receiptURL = ReceiptURL(receipt.ID)
+		}
+	}
+	ctx.Set("payment", payment)
+	ctx.Set("receiptURL", receiptURL)
+	ctx.Set("appURL", strings.TrimRight(os.Getenv("APP_URL"), "/"))
+	ctx.Set("money", FormatCents)
+	ctx.Set("date", func(t time.Time) string {
+		if notification.Locale == models.LocaleEn {
+			return t.Format("January 2, 2006")
+		}
+		return t.Format("02/01/2006")
+	})
+
+	name := mailTemplateNames[notification.Kind]
+	if name == "" {
+		name = notification.Kind
+	}
+	name += "." + strings.ToLower(notification.Locale)
+
+	text, err := renderMailTemplate(name+".plush.txt", ctx)
+	if err != nil {
+		return MailMessage{}, err
+	}
+	// Plush escapes for HTML, which the text version is not
+	text = html.UnescapeString(text)
+	body, err := renderMailTemplate(name+".plush.html", ctx)
+	if err != nil {
+		return MailMessage{}, err
+	}
+	ctx.Set("body", template.HTML(body))
+	layout, err := renderMailTemplate("layout.plush.html", ctx)
+	if err != nil {
+		return MailMessage{}, err
+	}
+
+	return MailMessage{
+		From:    s.From,
+		To:      notification.Recipient,
+		Subject: fmt.Sprintf(mailSubjects[notification.Locale][notification.Kind], subscription.Plan.Name),
+		Text:    text,
+		HTML:    layout,
+	}, nil
+}
+
+func renderMailTemplate(name string, ctx *plush.Context) (string, error) {
+	input, err := mailTemplates.FindString(name)
+	if err != nil {
+		return "", err
+	}
+	return plush.Render(input, ctx)
+}
+
+// StartNotificationSender sends the queued emails every interval in the background until the process exits
+func StartNotificationSender(tx *pop.Connection, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := NewNotificationService(tx).SendPending(100)
+			if err != nil {
+				log.Println("Error sending emails:", err)
+				continue
+			}
+			if report != (NotificationReport{}) {
+				log.Printf("Notification sender: %+v", report)
+			}
+		}
+	}()
+}
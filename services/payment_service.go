@@ -1,22 +1,21 @@
 package services
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gofrs/uuid"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"subscription_service/models"
 	"time"
 )
 
 // This is the struct responsible to aggregate all entities and services in order to process a new subscription
 type PaymentService struct {
+	Plan          models.Plan
 	Subscriber    models.Subscriber
 	Subscription  models.Subscription
 	Payment       models.Payment
@@ -24,6 +23,15 @@ type PaymentService struct {
 	PaymentReturn PaymentReturn
 	ProcessData   ProcessData
 	RabbitMQ      *RabbitMQ
+	Gateway       *GatewayClient
+	// FraudChecker defaults to Fraud
+	FraudChecker    FraudChecker
+	FraudAssessment FraudAssessment
+	// ClientIP and UserAgent identify the customer for the fraud check
+	ClientIP  string
+	UserAgent string
+	// Locale is the language the customer checked out in, e.g. "pt-BR"
+	Locale string
 }
 
 // The PaymentReturn is the struct with the exact format which is received after a payment request is made
@@ -35,23 +43,45 @@ type PaymentReturn struct {
 	Status               string `json:"status"`
 	CurrentTransaction   struct {
 		RemoteTransactionID  int    `json:"id"`
+		Status               string `json:"status"`
+		RefuseReason         string `json:"refuse_reason"`
+		AcquirerResponseCode string `json:"acquirer_response_code"`
 		Amount               int    `json:"amount"`
 		Installments         int    `json:"installments"`
 		BoletoURL            string `json:"boleto_url"`
 		BoletoBarcode        string `json:"boleto_barcode"`
 		BoletoExpirationDate string `json:"boleto_expiration_date"`
 	} `json:"current_transaction"`
-	PaymentMethod      string    `json:"payment_method"`
-	CardBrand          string    `json:"card_brand"`
-	RemotePlanID       int       `json:"remote_plan_id"`
-	PostbackURL        string    `json:"postback_url"`
-	CardLastDigits     string    `json:"card_last_digits"`
-	SoftDescriptor     string    `json:"soft_descriptor"`
-	CurrentPeriodStart string    `json:"current_period_start"`
-	CurrentPeriodSEnd  string    `json:"current_period_end"`
-	RefuseReason       string    `json:"refuse_reason"`
-	CreatedAt          time.Time `json:"date_created"`
-	UpdatedAt          time.Time `json:"date_created"`
+	PaymentMethod      string         `json:"payment_method"`
+	CardBrand          string         `json:"card_brand"`
+	RemotePlanID       int            `json:"remote_plan_id"`
+	PostbackURL        string         `json:"postback_url"`
+	CardLastDigits     string         `json:"card_last_digits"`
+	SoftDescriptor     string         `json:"soft_descriptor"`
+	Customer           RemoteCustomer `json:"customer"`
+	CurrentPeriodStart string         `json:"current_period_start"`
+	CurrentPeriodSEnd  string         `json:"current_period_end"`
+	RefuseReason       string         `json:"refuse_reason"`
+	CreatedAt          time.Time      `json:"date_created"`
+	UpdatedAt          time.Time      `json:"date_updated"`
+}
+
+// RemoteCustomer is the customer the gateway keeps attached to a subscription
+type RemoteCustomer struct {
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	DocumentNumber string `json:"document_number"`
+	Address        struct {
+		Street        string `json:"street"`
+		StreetNumber  string `json:"street_number"`
+		Complementary string `json:"complementary"`
+		Neighborhood  string `json:"neighborhood"`
+		Zipcode       string `json:"zipcode"`
+	} `json:"address"`
+	Phone struct {
+		DDD    string `json:"ddd"`
+		Number string `json:"number"`
+	} `json:"phone"`
 }
 
 // ProcessData is responsible to bind the information sent via subscription
@@ -64,12 +94,14 @@ type ProcessData struct {
 	PaymentMethod  string    `json:"payment_method" db:"payment_method"`
 	DocumentNumber string    `json:"document_number" db:"document_number"`
 	CardHash       string    `json:"card_hash" db:"card_hash"`
+	Installments   int       `json:"installments" db:"installments"`
 	Street         string    `json:"street" db:"street"`
 	StreetNumber   string    `json:"street_number" db:"street_number"`
 	Complementary  string    `json:"complementary" db:"complementary"`
 	Neighborhood   string    `json:"neighborhood" db:"neighborhood"`
 	State          string    `json:"state" db:"state"`
 	Zipcode        string    `json:"zipcode" db:"zipcode"`
+	City           string    `json:"city" db:"-"`
 	DDD            string    `json:"ddd" db:"ddd"`
 	PhoneNumber    string    `json:"number" db:"number"`
 }
@@ -92,6 +124,8 @@ type TransactionSubscriptionRequest struct {
 	RemotePlanID          int                   `json:"plan_id"`
 	PaymentMethod         string                `json:"payment_method"`
 	CardHash              string                `json:"card_hash"`
+	Installments          int                   `json:"installments,omitempty"`
+	Amount                int                   `json:"amount,omitempty"`
 	SoftDescriptor        string                `json:"soft_descriptor"`
 	PostbackURL           string                `json:"postback_url"`
 	Customer              *CustomerSubscription `json:"customer"`
@@ -105,39 +139,101 @@ type CustomerSubscription struct {
 	DocumentNumber string `json:"document_number"`
 }
 
+// ErrInvalidInstallments is returned when the plan does not offer the chosen number of installments
+var ErrInvalidInstallments = errors.New("installments not available for this plan")
+
+// CheckoutPending is the payload of the checkout.pending event, published when the gateway
+// failed in a way that leaves unknown whether the customer was charged. The notification
+// service tells the customer the payment is being processed; the reconciliation brings the
+// subscription in if it was created.
+type CheckoutPending struct {
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	PlanID        string `json:"plan_id"`
+	RemotePlanID  string `json:"remote_plan_id"`
+	PaymentMethod string `json:"payment_method"`
+	Reason        string `json:"reason"`
+}
+
+// Declined reports whether the gateway refused the charge
+func (r PaymentReturn) Declined() bool {
+	return r.Status == "Declined" || r.Status == "refused" || r.CurrentTransaction.Status == "refused" ||
+		// Cards are charged at once, so an unpaid card subscription was not paid
+		(r.Status == "unpaid" && r.PaymentMethod == "credit_card")
+}
+
+// Charged reports whether the gateway took the charge, even if Process failed afterwards
+func (p *PaymentService) Charged() bool {
+	return p.PaymentReturn.RemoteSubscriptionID != 0 && !p.PaymentReturn.Declined()
+}
+
+// PendingEvent builds the checkout.pending event for a checkout that failed with err
+func (p *PaymentService) PendingEvent(err error) Event {
+	return NewEvent("checkout.pending", CheckoutPending{
+		Name:          p.ProcessData.Name,
+		Email:         p.ProcessData.Email,
+		PlanID:        p.ProcessData.PlanID.String(),
+		RemotePlanID:  p.ProcessData.RemotePlanID,
+		PaymentMethod: p.ProcessData.PaymentMethod,
+		Reason:        err.Error(),
+	})
+}
+
 // Creates an empty PaymentService
 func NewPaymentService() *PaymentService {
 	return &PaymentService{}
 }
 
 // Process the the subscription by doing:
-// 1) Create the remote subscription
-// 2) Create the subscription locally bu registering the customer data as well as the payment return information
-// 3) Send to a queue the subscriber information
+// 1) Check the checkout for fraud, stopping rejected ones before they are charged
+// 2) Create the remote subscription
+// 3) Create the subscription locally bu registering the customer data as well as the payment return information
+// 4) Queue the subscriptions flagged by the fraud check for review
+// 5) Send to a queue the subscriber information
+//
+// Errors from the gateway are *GatewayError, or *DeclineError when the charge was refused.
 func (p *PaymentService) Process(data ProcessData) error {
 
-	url := os.Getenv("PAYMENT_SUBSCRIPTION_ENDPOINT")
 	p.ProcessData = data
+	if p.Gateway == nil {
+		p.Gateway = NewGatewayClient()
+	}
 
 	plan := models.Plan{}
-	if err := p.Connection.Find(&plan, p.ProcessData.PlanID); err != nil {
+	if err := p.Connection.Eager("InstallmentRates").Find(&plan, p.ProcessData.PlanID); err != nil {
 		return err
 	}
 	p.ProcessData.RemotePlanID = plan.RemotePanID
+	p.Plan = plan
+
+	// Only cards can be split; anything else is charged at once
+	if p.ProcessData.PaymentMethod != "credit_card" || p.ProcessData.Installments < 1 {
+		p.ProcessData.Installments = 1
+	}
+	installment, ok := plan.InstallmentOption(p.ProcessData.Installments)
+	if !ok {
+		return ErrInvalidInstallments
+	}
+
+	if err := p.checkFraud(); err != nil {
+		return err
+	}
 
 	rPlanID, _ := strconv.Atoi(p.ProcessData.RemotePlanID)
 
 	SubscriptionRequest := TransactionSubscriptionRequest{
 		SecretKey: os.Getenv("PAYMENT_SECRET_KEY"),
 		Gateway: Gateway{
-			Name:   os.Getenv("GATEWAY"),
+			Name: os.Getenv("GATEWAY"),
 		},
 		APIKey:         os.Getenv("GATEWAY_APIKEY"),
 		RemotePlanID:   rPlanID,
 		PaymentMethod:  p.ProcessData.PaymentMethod,
 		CardHash:       p.ProcessData.CardHash,
+		Installments:   installment.Installments,
+		Amount:         installment.Total,
 		SoftDescriptor: "codeshop",
-		PostbackURL:    "",
+		PostbackURL:    PostbackURL(),
 		Customer: &CustomerSubscription{
 			CustomerName:   p.ProcessData.Name,
 			CustomerEmail:  p.ProcessData.Email,
@@ -145,55 +241,174 @@ func (p *PaymentService) Process(data ProcessData) error {
 		},
 	}
 
-	jsonData, err := json.Marshal(SubscriptionRequest)
+	paymentReturn, err := p.Gateway.CreateSubscription(SubscriptionRequest)
+	if err != nil {
+		log.Println("Error creating the remote subscription:", err)
+		return err
+	}
+	p.PaymentReturn = *paymentReturn
+
+	if p.PaymentReturn.Declined() {
+		declineErr := NewDeclineError(p.PaymentReturn)
+		log.Println("Transaction declined:", declineErr)
+		return declineErr
+	}
+	if p.PaymentReturn.RemoteSubscriptionID == 0 {
+		return &GatewayError{Kind: ErrGatewayBadResponse, Err: errors.New("no subscription in the answer")}
+	}
+	err = p.insertData()
 
 	if err != nil {
+		log.Println("Error inserting data:", err)
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
+	// The charge already went through, so none of the steps below may fail the checkout. Each
+	// runs in its own savepoint, so a failed one is undone, logged and skipped without aborting
+	// the transaction the checkout is saved in.
+	if p.FraudAssessment.Decision == FraudReview {
+		p.afterCharge("queueing the fraud review", p.queueFraudReview)
+	}
+
+	if p.PaymentReturn.PaymentMethod == "credit_card" {
+		subscriberJson, _ := json.Marshal(p.Subscriber)
+		p.RabbitMQ.Notify(string(subscriberJson), "application/json", os.Getenv("RABBITMQ_NOTIFICATION_EX"), os.Getenv("RABBITMQ_NOTIFICATION_ROUTING_KEY"))
+	}
+
+	if p.Payment.Status == models.PaymentPaid {
+		issuePaidDocuments(p.Connection, p.RabbitMQ, p.Payment)
+	}
+
+	p.afterCharge("queueing the checkout email", p.notify)
+
+	return nil
+}
+
+// afterCharge runs a step following a successful charge in a savepoint, logging its failure
+func (p *PaymentService) afterCharge(description string, step func(tx *pop.Connection) error) {
+	if err := transaction(p.Connection, step); err != nil {
+		log.Printf("Error %s: %v", description, err)
+	}
+}
+
+// notify queues the boleto to be emailed, or the welcome once the card was charged
+func (p *PaymentService) notify(tx *pop.Connection) error {
+	switch {
+	case p.Payment.PaymentType == "boleto" && p.Payment.BoletoURL != "":
+		return Notify(tx, models.NotificationBoletoIssued, p.Subscription, &p.Payment)
+	case p.Payment.Status == models.PaymentPaid:
+		return Notify(tx, models.NotificationWelcome, p.Subscription, &p.Payment)
+	}
+	return nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// checkFraud runs the fraud check, returning ErrFraudRejected for checkouts that must not
+// be charged. A checker that fails lets the checkout through.
+func (p *PaymentService) checkFraud() error {
+	if p.FraudChecker == nil {
+		p.FraudChecker = Fraud
+	}
 
+	assessment, err := p.FraudChecker.Check(p.Connection, FraudCheck{ProcessData: p.ProcessData, IP: p.ClientIP, UserAgent: p.UserAgent})
 	if err != nil {
-		log.Println(err)
-		return err
+		log.Println("Error checking for fraud:", err)
+		assessment = FraudAssessment{Decision: FraudApprove}
 	}
-	defer resp.Body.Close()
+	p.FraudAssessment = assessment
 
-	body, _ := ioutil.ReadAll(resp.Body)
+	if assessment.Decision == FraudReject {
+		log.Printf("Checkout of %s rejected by the fraud check: %v", p.ProcessData.Email, assessment.Reasons)
+		return ErrFraudRejected
+	}
+	return nil
+}
 
-	json.Unmarshal(body, &p.PaymentReturn)
+func (p *PaymentService) queueFraudReview(tx *pop.Connection) error {
+	return validateAndCreate(tx, &models.FraudReview{
+		SubscriptionID: p.Subscription.ID,
+		Score:          p.FraudAssessment.Score,
+		Reasons:        strings.Join(p.FraudAssessment.Reasons, "\n"),
+		Status:         models.FraudReviewOpen,
+	})
+}
 
-	if p.PaymentReturn.Status == "Declined" {
-		log.Println("Transaction declined")
-		return errors.New("Transaction declined")
+// issuePaidDocuments issues the receipt of a paid payment and requests its invoice. The payment
+// stays paid whatever happens to them, so each runs in a savepoint and a failure is only logged:
+// missing receipts are issued by the receipts:backfill task and failed invoices retried by
+// invoices:retry.
+func issuePaidDocuments(tx *pop.Connection, rabbitMQ *RabbitMQ, payment models.Payment) {
+	if err := transaction(tx, func(tx *pop.Connection) error { return issueReceipt(tx, rabbitMQ, payment) }); err != nil {
+		log.Println("Error issuing receipt:", err)
 	}
-	err = p.insertData()
+	if err := transaction(tx, func(tx *pop.Connection) error { return requestInvoice(tx, payment) }); err != nil {
+		log.Println("Error requesting invoice:", err)
+	}
+}
+
+// issueReceipt issues the receipt of a paid payment and publishes it
+func issueReceipt(tx *pop.Connection, rabbitMQ *RabbitMQ, payment models.Payment) error {
+	receipts := NewReceiptService(tx)
 
+	receipt, err := receipts.Issue(payment)
 	if err != nil {
-		log.Println("Error inserting data:", err)
 		return err
 	}
 
-	if p.PaymentReturn.PaymentMethod == "credit_card" {
-		subscriberJson, _ := json.Marshal(p.Subscriber)
-		p.RabbitMQ.Notify(string(subscriberJson), "application/json", os.Getenv("RABBITMQ_NOTIFICATION_EX"), os.Getenv("RABBITMQ_NOTIFICATION_ROUTING_KEY"))
+	doc, err := receipts.Document(receipt.ID)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return rabbitMQ.PublishEvent(tx, doc.IssuedEvent())
+}
+
+func requestInvoice(tx *pop.Connection, payment models.Payment) error {
+	invoices, err := NewInvoiceService(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = invoices.Request(payment)
+	return err
+}
+
+// NewPayment maps the current transaction of the subscription to a Payment of the given local subscription
+func (r PaymentReturn) NewPayment(subscriptionID uuid.UUID) models.Payment {
+	paymentID, _ := uuid.NewV4()
+
+	return models.Payment{
+		ID:                   paymentID,
+		TransactionID:        strconv.Itoa(r.CurrentTransaction.RemoteTransactionID),
+		Gateway:              os.Getenv("GATEWAY"),
+		PaymentType:          r.PaymentMethod,
+		Status:               r.Status,
+		Total:                r.CurrentTransaction.Amount,
+		CardBrand:            r.CardBrand,
+		CardLastDigits:       r.CardLastDigits,
+		BoletoURL:            r.CurrentTransaction.BoletoURL,
+		BoletoBarcode:        r.CurrentTransaction.BoletoBarcode,
+		BoletoExpirationDate: r.CurrentTransaction.BoletoExpirationDate,
+		Installments:         r.CurrentTransaction.Installments,
+		SubscriptionID:       subscriptionID,
+		CreatedAt:            r.CreatedAt,
+		UpdatedAt:            r.UpdatedAt,
+	}
 }
 
 func (p *PaymentService) insertData() error {
 
 	subscriberId, _ := uuid.NewV4()
 	subscriptionId, _ := uuid.NewV4()
-	paymentId, _ := uuid.NewV4()
 
-	startPeriod, _ := time.Parse(time.RFC3339, p.PaymentReturn.CurrentPeriodStart)
-	endPeriod, _ := time.Parse(time.RFC3339, p.PaymentReturn.CurrentPeriodSEnd)
+	startPeriod, err := time.Parse(time.RFC3339, p.PaymentReturn.CurrentPeriodStart)
+	if err != nil {
+		startPeriod = time.Now()
+	}
+	// Boletos have no period until they are paid, so the plan interval gives the expected end
+	endPeriod, err := time.Parse(time.RFC3339, p.PaymentReturn.CurrentPeriodSEnd)
+	if err != nil {
+		endPeriod = p.Plan.Interval().AddTo(startPeriod)
+	}
 	// Subscription
 	p.Subscription.ID = subscriptionId
 	p.Subscription.SubscriberID = subscriberId
@@ -208,21 +423,10 @@ func (p *PaymentService) insertData() error {
 	p.Subscription.UpdatedAt = p.PaymentReturn.UpdatedAt
 
 	// Payment
-	p.Payment.ID = paymentId
-	p.Payment.TransactionID = strconv.Itoa(p.PaymentReturn.CurrentTransaction.RemoteTransactionID)
-	p.Payment.Gateway = os.Getenv("GATEWAY")
-	p.Payment.PaymentType = p.PaymentReturn.PaymentMethod
-	p.Payment.Status = p.PaymentReturn.Status
-	p.Payment.Total = p.PaymentReturn.CurrentTransaction.Amount
-	p.Payment.CardBrand = p.PaymentReturn.CardBrand
-	p.Payment.CardLastDigits = p.PaymentReturn.CardLastDigits
-	p.Payment.BoletoURL = p.PaymentReturn.CurrentTransaction.BoletoURL
-	p.Payment.BoletoBarcode = p.PaymentReturn.CurrentTransaction.BoletoBarcode
-	p.Payment.BoletoExpirationDate = p.PaymentReturn.CurrentTransaction.BoletoExpirationDate
-	p.Payment.Installments = p.PaymentReturn.CurrentTransaction.Installments
-	p.Payment.SubscriptionID = subscriptionId
-	p.Payment.CreatedAt = p.PaymentReturn.CreatedAt
-	p.Payment.UpdatedAt = p.PaymentReturn.UpdatedAt
+	p.Payment = p.PaymentReturn.NewPayment(subscriptionId)
+	if p.Payment.Installments == 0 {
+		p.Payment.Installments = p.ProcessData.Installments
+	}
 
 	// Subscriber
 	p.Subscriber.ID = subscriberId
@@ -236,13 +440,26 @@ func (p *PaymentService) insertData() error {
 	p.Subscriber.Zipcode = p.ProcessData.Zipcode
 	p.Subscriber.DDD = p.ProcessData.DDD
 	p.Subscriber.Number = p.ProcessData.PhoneNumber
+	p.Subscriber.Locale = models.NormalizeLocale(p.Locale)
 	p.Subscriber.CreatedAt = p.PaymentReturn.CreatedAt
 	p.Subscriber.UpdatedAt = p.PaymentReturn.UpdatedAt
 	p.Subscriber.Subscriptions = models.Subscriptions{p.Subscription}
 
-	p.Connection.ValidateAndCreate(&p.Subscriber)
-	p.Connection.ValidateAndCreate(&p.Subscription)
-	p.Connection.ValidateAndCreate(&p.Payment)
+	// A charged checkout that can not be saved is answered as pending, which commits the
+	// transaction of the request, so the records saved before the failure are undone here
+	return transaction(p.Connection, func(tx *pop.Connection) error {
+		for _, model := range []interface{}{&p.Subscriber, &p.Subscription, &p.Payment} {
+			verrs, err := tx.ValidateAndCreate(model)
+			if err != nil {
+				return err
+			}
+			if verrs.HasAny() {
+				return verrs
+			}
+		}
 
-	return nil
+		subscriber := p.Subscriber
+		AfterCommit(tx, func() { Entitlements.Invalidate(subscriber.ID, subscriber.Email) })
+		return nil
+	})
 }
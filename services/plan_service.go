@@ -0,0 +1,30 @@
+package services
+
+import (
+	"fmt"
+	"subscription_service/models"
+)
+
+// PlanMismatches compares the local plan with its configuration at the gateway and
+// describes every difference found; an empty result means they agree
+func PlanMismatches(plan models.Plan, remote RemotePlan) []string {
+	mismatches := []string{}
+
+	if days := plan.Interval().Days(); days != remote.Days {
+		mismatches = append(mismatches, fmt.Sprintf("interval %s is %d days, gateway charges every %d days", plan.Interval(), days, remote.Days))
+	}
+	if price := plan.PriceInCents(); price != remote.Amount {
+		mismatches = append(mismatches, fmt.Sprintf("price is %s, gateway charges %s", FormatCents(price), FormatCents(remote.Amount)))
+	}
+
+	return mismatches
+}
+
+// CheckPlan fetches the plan from the gateway and compares it with the local one
+func CheckPlan(gateway *GatewayClient, plan models.Plan) ([]string, error) {
+	remote, err := gateway.FetchPlan(plan.RemotePanID)
+	if err != nil {
+		return nil, err
+	}
+	return PlanMismatches(plan, *remote), nil
+}
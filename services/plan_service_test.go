@@ -0,0 +1,22 @@
+package services
+
+import (
+	"subscription_service/models"
+	"testing"
+)
+
+func Test_PlanMismatches(t *testing.T) {
+	plan := models.Plan{Price: 129.90, IntervalUnit: models.IntervalMonth, IntervalCount: 3}
+
+	if mismatches := PlanMismatches(plan, RemotePlan{Amount: 12990, Days: 90}); len(mismatches) != 0 {
+		t.Errorf("expected no mismatches, got %v", mismatches)
+	}
+
+	mismatches := PlanMismatches(plan, RemotePlan{Amount: 4990, Days: 30})
+	if len(mismatches) != 2 {
+		t.Fatalf("expected two mismatches, got %v", mismatches)
+	}
+	if mismatches[1] != "price is R$ 129,90, gateway charges R$ 49,90" {
+		t.Errorf("unexpected price mismatch %q", mismatches[1])
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Postback is a status change notification sent by the gateway
+type Postback struct {
+	Object         string
+	ID             string
+	Event          string
+	OldStatus      string
+	CurrentStatus  string
+	Amount         int
+	RefundedAmount int
+	Values         url.Values
+}
+
+// PostbackService applies the gateway notifications to our records
+type PostbackService struct {
+	Connection *pop.Connection
+	RabbitMQ   *RabbitMQ
+}
+
+// Creates a PostbackService
+func NewPostbackService(tx *pop.Connection, rabbitMQ *RabbitMQ) *PostbackService {
+	return &PostbackService{Connection: tx, RabbitMQ: rabbitMQ}
+}
+
+// PostbackURL is the address the gateway notifies about status changes, empty when APP_URL is not set
+func PostbackURL() string {
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		return ""
+	}
+	return appURL + "/webhooks/gateway"
+}
+
+// VerifyPostbackSignature checks the X-Hub-Signature header, an HMAC-SHA1 of the body
+// keyed with our gateway API key, e.g. "sha1=5e4f...".
+func VerifyPostbackSignature(body []byte, signature string) bool {
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 || parts[0] != "sha1" {
+		return false
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, []byte(os.Getenv("GATEWAY_APIKEY")))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// ParsePostback decodes the form encoded body of a postback
+func ParsePostback(body []byte) (*Postback, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	postback := &Postback{
+		Object:        values.Get("object"),
+		ID:            values.Get("id"),
+		Event:         values.Get("event"),
+		OldStatus:     values.Get("old_status"),
+		CurrentStatus: values.Get("current_status"),
+		Values:        values,
+	}
+	postback.Amount, _ = strconv.Atoi(values.Get(postback.Object + "[amount]"))
+	postback.RefundedAmount, _ = strconv.Atoi(values.Get(postback.Object + "[refunded_amount]"))
+
+	return postback, nil
+}
+
+// Handle dispatches the postback. Notifications about records we do not know are ignored.
+func (s *PostbackService) Handle(postback *Postback) error {
+	var err error
+
+	switch postback.Object {
+	case "transaction":
+		err = s.handleTransaction(postback)
+	case "subscription":
+		err = s.handleSubscription(postback)
+	default:
+		log.Printf("Ignoring %s postback for %s %s", postback.CurrentStatus, postback.Object, postback.ID)
+	}
+
+	if models.IsNotFound(err) {
+		log.Printf("Ignoring postback for unknown %s %s", postback.Object, postback.ID)
+		return nil
+	}
+	return err
+}
+
+func (s *PostbackService) handleSubscription(postback *Postback) error {
+	subscription := &models.Subscription{}
+	if err := s.Connection.Where("remote_subscription_id = ?", postback.ID).First(subscription); err != nil {
+		return err
+	}
+
+	_, err := NewRenewalService(s.Connection, s.RabbitMQ).Reconcile(subscription, time.Now())
+	return err
+}
+
+func (s *PostbackService) handleTransaction(postback *Postback) error {
+	refunds := NewRefundService(s.Connection, s.RabbitMQ)
+
+	switch postback.CurrentStatus {
+	case "paid":
+		return s.markPaid(postback.ID)
+	case "chargedback":
+		cancel := os.Getenv("CHARGEBACK_CANCELS_SUBSCRIPTION") == "true"
+		_, err := refunds.Chargeback(postback.ID, postback.Amount, "chargeback notified by the gateway", cancel)
+		return err
+	case "refunded":
+		_, err := refunds.RecordRemoteRefund(postback.ID, postback.RefundedAmount)
+		return err
+	}
+
+	return nil
+}
+
+// markPaid settles a transaction paid after the checkout, e.g. a boleto, issuing its receipt
+// and invoice. Notifying a settled transaction again changes nothing.
+func (s *PostbackService) markPaid(transactionID string) error {
+	payment := &models.Payment{}
+	if err := s.Connection.Where("transaction_id = ?", transactionID).First(payment); err != nil {
+		return err
+	}
+	if payment.Settled() {
+		return nil
+	}
+
+	payment.Status = models.PaymentPaid
+	if err := s.Connection.Update(payment); err != nil {
+		return err
+	}
+
+	issuePaidDocuments(s.Connection, s.RabbitMQ, *payment)
+	return nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+func Test_VerifyPostbackSignature(t *testing.T) {
+	os.Setenv("GATEWAY_APIKEY", "ak_test_key")
+	body := []byte("id=1234&object=transaction&current_status=chargedback")
+
+	mac := hmac.New(sha1.New, []byte("ak_test_key"))
+	mac.Write(body)
+	signature := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !VerifyPostbackSignature(body, signature) {
+		t.Error("valid signature was rejected")
+	}
+	if VerifyPostbackSignature([]byte("id=1234&object=transaction&current_status=paid"), signature) {
+		t.Error("signature of another body was accepted")
+	}
+	for _, invalid := range []string{"", "sha1", "sha256=" + signature[5:], "sha1=zz"} {
+		if VerifyPostbackSignature(body, invalid) {
+			t.Errorf("invalid signature %q was accepted", invalid)
+		}
+	}
+}
+
+func Test_ParsePostback(t *testing.T) {
+	body := []byte("id=1234&object=transaction&event=transaction_status_changed&old_status=paid&current_status=refunded" +
+		"&transaction%5Bamount%5D=4990&transaction%5Brefunded_amount%5D=1000")
+
+	postback, err := ParsePostback(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if postback.Object != "transaction" || postback.ID != "1234" {
+		t.Errorf("unexpected object %s %s", postback.Object, postback.ID)
+	}
+	if postback.OldStatus != "paid" || postback.CurrentStatus != "refunded" {
+		t.Errorf("unexpected statuses %s => %s", postback.OldStatus, postback.CurrentStatus)
+	}
+	if postback.Amount != 4990 || postback.RefundedAmount != 1000 {
+		t.Errorf("unexpected amounts %d / %d", postback.Amount, postback.RefundedAmount)
+	}
+}
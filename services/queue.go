@@ -1,11 +1,16 @@
 package services
 
 import (
+	"errors"
 	"github.com/streadway/amqp"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
+// ErrRabbitMQClosed is returned by CheckConnection when events can not be published
+var ErrRabbitMQClosed = errors.New("rabbitmq connection or channel closed")
+
 type RabbitMQ struct {
 	User              string
 	Password          string
@@ -18,6 +23,9 @@ type RabbitMQ struct {
 	Args              amqp.Table
 	Channel           *amqp.Channel
 	Connection        *amqp.Connection
+
+	// channelClosed is set once the server closes the current channel
+	channelClosed *int32
 }
 
 func NewRabbitMQ() *RabbitMQ {
@@ -54,9 +62,30 @@ func (r *RabbitMQ) GetChannel() (*amqp.Channel, error) {
 	r.Channel, err = r.Connection.Channel()
 	failOnError(err, "Failed to open a channel")
 
+	closed := new(int32)
+	r.channelClosed = closed
+	go func(notifications chan *amqp.Error) {
+		for err := range notifications {
+			log.Println("RabbitMQ channel closed:", err)
+		}
+		atomic.StoreInt32(closed, 1)
+	}(r.Channel.NotifyClose(make(chan *amqp.Error, 1)))
+
 	return r.Channel, nil
 }
 
+// CheckConnection tells whether events can be published, failing with ErrRabbitMQClosed once
+// the connection or the channel is closed
+func (r *RabbitMQ) CheckConnection() error {
+	if r.Connection == nil || r.Connection.IsClosed() || r.Channel == nil {
+		return ErrRabbitMQClosed
+	}
+	if r.channelClosed != nil && atomic.LoadInt32(r.channelClosed) == 1 {
+		return ErrRabbitMQClosed
+	}
+	return nil
+}
+
 func (r *RabbitMQ) Consume(messageChannel chan amqp.Delivery) {
 
 	q, err := r.Channel.QueueDeclare(
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the rate limits
+const (
+	LimitCheckoutIP    = "checkout_ip"
+	LimitCheckoutEmail = "checkout_email"
+	LimitAPIKey        = "api_key"
+	// LimitAdminLoginIP and LimitAdminLoginAccount slow down guessing admin passwords and codes
+	LimitAdminLoginIP      = "admin_login_ip"
+	LimitAdminLoginAccount = "admin_login_account"
+)
+
+// RateLimit is a token bucket allowing Burst requests at once, refilled evenly over Period
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseRateLimit reads a limit written as "burst/period", e.g. "10/10m". "off" or a burst of 0
+// disables the limit.
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == "off" {
+		return RateLimit{}, nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected burst/period", value)
+	}
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit burst %q", parts[0])
+	}
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit period %q", parts[1])
+	}
+	return RateLimit{Burst: burst, Period: period}, nil
+}
+
+// Enabled reports whether the limit limits anything
+func (l RateLimit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// RateLimitStore keeps the token buckets. Take takes a token from the bucket of the key,
+// returning 0 when there was one or how long until there is one.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (retryAfter time.Duration, err error)
+}
+
+// RateLimiter limits requests by the values given to Allow, e.g. the IP of the customer
+type RateLimiter struct {
+	Store  RateLimitStore
+	Limits map[string]RateLimit
+	Now    func() time.Time
+}
+
+// Limiter is shared by the requests of the process. Its buckets are kept in memory, or in
+// Redis at RATE_LIMIT_REDIS_ADDR so all replicas share them. The limits are
+// RATE_LIMIT_CHECKOUT_IP (10/10m when not set), RATE_LIMIT_CHECKOUT_EMAIL (5/1h),
+// RATE_LIMIT_API_KEY (600/1m), RATE_LIMIT_ADMIN_LOGIN_IP (20/10m) and
+// RATE_LIMIT_ADMIN_LOGIN_ACCOUNT (5/15m).
+var Limiter = NewRateLimiter()
+
+// NewRateLimiter creates a limiter configured from the environment
+func NewRateLimiter() *RateLimiter {
+	var store RateLimitStore = NewMemoryRateLimitStore()
+	if addr := os.Getenv("RATE_LIMIT_REDIS_ADDR"); addr != "" {
+		store = &RedisRateLimitStore{Client: NewRedisClient(addr, os.Getenv("RATE_LIMIT_REDIS_PASSWORD")), Prefix: "rate_limit:"}
+	}
+
+	return &RateLimiter{
+		Store: store,
+		Limits: map[string]RateLimit{
+			LimitCheckoutIP:        rateLimitFromEnv("RATE_LIMIT_CHECKOUT_IP", "10/10m"),
+			LimitCheckoutEmail:     rateLimitFromEnv("RATE_LIMIT_CHECKOUT_EMAIL", "5/1h"),
+			LimitAPIKey:            rateLimitFromEnv("RATE_LIMIT_API_KEY", "600/1m"),
+			LimitAdminLoginIP:      rateLimitFromEnv("RATE_LIMIT_ADMIN_LOGIN_IP", "20/10m"),
+			LimitAdminLoginAccount: rateLimitFromEnv("RATE_LIMIT_ADMIN_LOGIN_ACCOUNT", "5/15m"),
+		},
+		Now: time.Now,
+	}
+}
+
+func rateLimitFromEnv(variable string, fallback string) RateLimit {
+	value := os.Getenv(variable)
+	if value == "" {
+		value = fallback
+	}
+	limit, err := ParseRateLimit(value)
+	if err != nil {
+		log.Printf("%s: %v, using %s", variable, err, fallback)
+		limit, _ = ParseRateLimit(fallback)
+	}
+	return limit
+}
+
+// Allow takes a request of value from the named limit, returning 0 when it may go through or
+// how long the client must wait. Empty values and disabled limits are not limited, and
+// requests go through when the store fails: a limiter down must not take checkout down.
+func (l *RateLimiter) Allow(name string, value string) time.Duration {
+	limit := l.Limits[name]
+	if value == "" || !limit.Enabled() {
+		return 0
+	}
+
+	retryAfter, err := l.Store.Take(name+":"+value, limit, l.Now())
+	if err != nil {
+		log.Printf("rate limit %s: %v", name, err)
+		return 0
+	}
+	return retryAfter
+}
+
+// take takes a token from a bucket holding tokens at updated, returning what it holds now and
+// how long until a token is available when it had none
+func take(tokens float64, updated time.Time, limit RateLimit, now time.Time) (float64, time.Duration) {
+	perToken := limit.Period / time.Duration(limit.Burst)
+	if elapsed := now.Sub(updated); elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+float64(elapsed)/float64(perToken))
+	}
+	if tokens >= 1 {
+		return tokens - 1, 0
+	}
+	return tokens, time.Duration((1 - tokens) * float64(perToken))
+}
+
+// MemoryRateLimitStore keeps the buckets in the process, each replica counting on its own
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// NewMemoryRateLimitStore creates an empty store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*bucket{}}
+}
+
+// Take takes a token from the bucket of the key
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	var retryAfter time.Duration
+	b.tokens, retryAfter = take(b.tokens, b.updated, limit, now)
+	b.updated, b.period = now, limit.Period
+	return retryAfter, nil
+}
+
+// sweep drops, once a minute, the buckets refilled since they were last used
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RedisRateLimitStore keeps the buckets in Redis, or anything speaking its protocol and
+// running Lua scripts, so the replicas share them
+type RedisRateLimitStore struct {
+	Client *RedisClient
+	Prefix string
+}
+
+// takeScript is take, run atomically by Redis. Buckets expire once refilled.
+const takeScript = `
+local burst = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+if now > updated then
+  tokens = math.min(burst, tokens + (now - updated) * burst / period)
+end
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) * period / burst)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], period)
+return wait
+`
+
+// Take takes a token from the bucket of the key
+func (s *RedisRateLimitStore) Take(key string, limit RateLimit, now time.Time) (time.Duration, error) {
+	reply, err := s.Client.Do("EVAL", takeScript, "1", s.Prefix+key,
+		strconv.Itoa(limit.Burst),
+		strconv.FormatInt(limit.Period.Milliseconds(), 10),
+		strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10),
+	)
+	if err != nil {
+		return 0, err
+	}
+	wait, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
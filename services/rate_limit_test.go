@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseRateLimit(t *testing.T) {
+	cases := []struct {
+		value   string
+		limit   RateLimit
+		invalid bool
+	}{
+		{"10/10m", RateLimit{Burst: 10, Period: 10 * time.Minute}, false},
+		{"600/1m", RateLimit{Burst: 600, Period: time.Minute}, false},
+		{"off", RateLimit{}, false},
+		{"0/1m", RateLimit{Period: time.Minute}, false},
+		{"10", RateLimit{}, true},
+		{"ten/1m", RateLimit{}, true},
+		{"10/0s", RateLimit{}, true},
+	}
+	for _, c := range cases {
+		limit, err := ParseRateLimit(c.value)
+		if (err != nil) != c.invalid || limit != c.limit {
+			t.Errorf("%s: expected %v (invalid %v), got %v (%v)", c.value, c.limit, c.invalid, limit, err)
+		}
+	}
+}
+
+func Test_MemoryRateLimitStore_Take(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Burst: 2, Period: time.Minute}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if wait, _ := store.Take("ip:192.0.2.1", limit, now); wait != 0 {
+			t.Fatalf("request %d: expected to go through, got a wait of %s", i+1, wait)
+		}
+	}
+	if wait, _ := store.Take("ip:192.0.2.1", limit, now); wait != 30*time.Second {
+		t.Errorf("expected to wait for a token, 30s, got %s", wait)
+	}
+	if wait, _ := store.Take("ip:192.0.2.2", limit, now); wait != 0 {
+		t.Errorf("expected other keys to have their own bucket, got a wait of %s", wait)
+	}
+	if wait, _ := store.Take("ip:192.0.2.1", limit, now.Add(30*time.Second)); wait != 0 {
+		t.Errorf("expected a token after 30s, got a wait of %s", wait)
+	}
+
+	store.Take("ip:192.0.2.3", limit, now.Add(5*time.Minute))
+	if len(store.buckets) != 1 {
+		t.Errorf("expected the refilled buckets to be dropped, got %d buckets", len(store.buckets))
+	}
+}
+
+func Test_RateLimiter_Allow(t *testing.T) {
+	limiter := &RateLimiter{
+		Store:  NewMemoryRateLimitStore(),
+		Limits: map[string]RateLimit{LimitCheckoutEmail: {Burst: 1, Period: time.Hour}, LimitAPIKey: {}},
+		Now:    time.Now,
+	}
+
+	if limiter.Allow(LimitCheckoutEmail, "ana@example.com") != 0 {
+		t.Error("expected the first request to go through")
+	}
+	if limiter.Allow(LimitCheckoutEmail, "ana@example.com") == 0 {
+		t.Error("expected the second request to be limited")
+	}
+	if limiter.Allow(LimitCheckoutEmail, "") != 0 || limiter.Allow(LimitCheckoutEmail, "") != 0 {
+		t.Error("expected requests without a value not to be limited")
+	}
+	if limiter.Allow(LimitAPIKey, "api_key:1a2b3c4d") != 0 || limiter.Allow(LimitAPIKey, "api_key:1a2b3c4d") != 0 {
+		t.Error("expected disabled limits not to limit")
+	}
+}
+
+func Test_RedisRateLimitStore_Take(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	commands := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		size := func() int {
+			line, _ := reader.ReadString('\n')
+			n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			return n
+		}
+		args := make([]string, size())
+		for i := range args {
+			arg := make([]byte, size()+2)
+			io.ReadFull(reader, arg)
+			args[i] = string(arg[:len(arg)-2])
+		}
+		commands <- args
+		conn.Write([]byte(":1500\r\n"))
+	}()
+
+	store := &RedisRateLimitStore{Client: NewRedisClient(listener.Addr().String(), ""), Prefix: "rate_limit:"}
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	wait, err := store.Take("checkout_ip:192.0.2.1", RateLimit{Burst: 10, Period: 10 * time.Minute}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 1500*time.Millisecond {
+		t.Errorf("expected a wait of 1.5s, got %s", wait)
+	}
+
+	args := <-commands
+	if len(args) != 7 || args[0] != "EVAL" || args[3] != "rate_limit:checkout_ip:192.0.2.1" || args[4] != "10" || args[5] != "600000" {
+		t.Errorf("unexpected command %q", args)
+	}
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jung-kurt/gofpdf"
+	"io"
+	"os"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// ErrPaymentNotPaid is returned when a receipt is requested for a payment that was not settled
+var ErrPaymentNotPaid = errors.New("receipts can only be issued for paid payments")
+
+// ReceiptService issues receipts and rebuilds their documents from what they keep
+type ReceiptService struct {
+	Connection *pop.Connection
+}
+
+// ReceiptDocument aggregates everything printed on a receipt: what the receipt kept when it
+// was issued and the payment it was issued for
+type ReceiptDocument struct {
+	Receipt models.Receipt
+	Payment models.Payment
+}
+
+// ReceiptIssued is the payload of the payment.receipt_issued event
+type ReceiptIssued struct {
+	ReceiptID      uuid.UUID `json:"receipt_id"`
+	Number         string    `json:"number"`
+	PaymentID      uuid.UUID `json:"payment_id"`
+	SubscriptionID uuid.UUID `json:"subscription_id"`
+	Email          string    `json:"email"`
+	HTMLURL        string    `json:"html_url"`
+	PDFURL         string    `json:"pdf_url"`
+}
+
+// Creates a ReceiptService bound to the given connection
+func NewReceiptService(tx *pop.Connection) *ReceiptService {
+	return &ReceiptService{Connection: tx}
+}
+
+// Issue returns the receipt of the payment, numbering a new one in the year the payment was made
+// when it does not exist yet, with a copy of the customer, the plan and the period it paid.
+// Calling it more than once for the same payment is safe.
+func (s *ReceiptService) Issue(payment models.Payment) (*models.Receipt, error) {
+	if payment.Status != models.PaymentPaid {
+		return nil, ErrPaymentNotPaid
+	}
+
+	receipt := &models.Receipt{}
+	err := s.Connection.Where("payment_id = ?", payment.ID).First(receipt)
+	if err == nil {
+		return receipt, nil
+	}
+	if !models.IsNotFound(err) {
+		return nil, err
+	}
+
+	parties, err := LoadBillingParties(s.Connection, payment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Receipts issued later, e.g. by receipts:backfill, still count in the year of the payment
+	paidAt := payment.CreatedAt
+	sequence, err := models.NextReceiptSequence(s.Connection, paidAt.Year())
+	if err != nil {
+		return nil, err
+	}
+
+	receipt.ID, _ = uuid.NewV4()
+	receipt.PaymentID = payment.ID
+	receipt.Year = paidAt.Year()
+	receipt.Sequence = sequence
+	receipt.Number = models.FormatReceiptNumber(receipt.Year, receipt.Sequence)
+	receipt.IssuedAt = time.Now()
+	receipt.CustomerName = parties.Subscriber.Name
+	receipt.CustomerDocument = parties.Subscriber.DocumentNumber
+	receipt.CustomerAddress = SubscriberAddress(parties.Subscriber)
+	receipt.CustomerEmail = parties.Subscriber.Email
+	receipt.PlanName = parties.Plan.Name
+	receipt.PlanDescription = parties.Plan.Description
+	receipt.PeriodStart = parties.Subscription.StartDate
+	receipt.PeriodEnd = parties.Subscription.ExpiresAt
+	receipt.Total = payment.Total
+	receipt.PaidAt = paidAt
+
+	verrs, err := s.Connection.ValidateAndCreate(receipt)
+	if err != nil {
+		return nil, err
+	}
+	if verrs.HasAny() {
+		return nil, verrs
+	}
+
+	return receipt, nil
+}
+
+// Document loads the receipt and the payment it was issued for
+func (s *ReceiptService) Document(receiptID interface{}) (*ReceiptDocument, error) {
+	doc := &ReceiptDocument{}
+
+	if err := s.Connection.Find(&doc.Receipt, receiptID); err != nil {
+		return nil, err
+	}
+	if err := s.Connection.Find(&doc.Payment, doc.Receipt.PaymentID); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// IssuedEvent builds the event announcing the receipt, with links to both formats
+func (d *ReceiptDocument) IssuedEvent() Event {
+	url := ReceiptURL(d.Receipt.ID)
+	return NewEvent("payment.receipt_issued", ReceiptIssued{
+		ReceiptID:      d.Receipt.ID,
+		Number:         d.Receipt.Number,
+		PaymentID:      d.Payment.ID,
+		SubscriptionID: d.Payment.SubscriptionID,
+		Email:          d.Receipt.CustomerEmail,
+		HTMLURL:        url,
+		PDFURL:         url + "/pdf",
+	})
+}
+
+// CustomerDocument is the CPF or CNPJ of the customer, the CPF masked as the receipt is public
+func (d *ReceiptDocument) CustomerDocument() string {
+	return MaskDocumentNumber(d.Receipt.CustomerDocument)
+}
+
+// Period is the period the payment paid, e.g. "04/07/2020 a 04/08/2020"
+func (d *ReceiptDocument) Period() string {
+	return d.Receipt.PeriodStart.Format("02/01/2006") + " a " + d.Receipt.PeriodEnd.Format("02/01/2006")
+}
+
+// Total is the amount paid formatted in reais
+func (d *ReceiptDocument) Total() string {
+	return FormatCents(d.Receipt.Total)
+}
+
+// PaymentDescription describes how the payment was made
+func (d *ReceiptDocument) PaymentDescription() string {
+	if d.Payment.PaymentType == "boleto" {
+		return "Boleto bancário"
+	}
+	return fmt.Sprintf("Cartão de crédito %s final %s", strings.ToUpper(d.Payment.CardBrand), d.Payment.CardLastDigits)
+}
+
+// WritePDF renders the receipt as a PDF into w
+func (d *ReceiptDocument) WritePDF(w io.Writer) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 10, tr("Recibo nº "+d.Receipt.Number), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, tr("Emitido em "+d.Receipt.IssuedAt.Format("02/01/2006")), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	section := func(title string, lines ...string) {
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.CellFormat(0, 8, tr(title), "B", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		for _, line := range lines {
+			pdf.MultiCell(0, 6, tr(line), "", "L", false)
+		}
+		pdf.Ln(4)
+	}
+
+	section("Cliente",
+		d.Receipt.CustomerName,
+		"CPF/CNPJ: "+d.CustomerDocument(),
+		d.Receipt.CustomerAddress,
+		d.Receipt.CustomerEmail,
+	)
+	section("Plano",
+		d.Receipt.PlanName,
+		d.Receipt.PlanDescription,
+		"Período: "+d.Period(),
+	)
+	section("Pagamento",
+		d.PaymentDescription(),
+		"Transação: "+d.Payment.TransactionID,
+		"Data: "+d.Receipt.PaidAt.Format("02/01/2006"),
+	)
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 10, tr("Total pago: "+d.Total()), "", 1, "R", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+// ReceiptURL is the public address of the HTML receipt; append /pdf for the PDF version
+func ReceiptURL(receiptID uuid.UUID) string {
+	return strings.TrimRight(os.Getenv("APP_URL"), "/") + "/receipts/" + receiptID.String()
+}
+
+// FormatCents formats an amount in cents as reais, e.g. 4990 => "R$ 49,90"
+func FormatCents(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	reais := fmt.Sprintf("%d", cents/100)
+	var grouped []string
+	for len(reais) > 3 {
+		grouped = append([]string{reais[len(reais)-3:]}, grouped...)
+		reais = reais[:len(reais)-3]
+	}
+	grouped = append([]string{reais}, grouped...)
+
+	return fmt.Sprintf("%sR$ %s,%02d", sign, strings.Join(grouped, "."), cents%100)
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_FormatCents(t *testing.T) {
+	cases := map[int]string{
+		0:        "R$ 0,00",
+		5:        "R$ 0,05",
+		4990:     "R$ 49,90",
+		123456:   "R$ 1.234,56",
+		-199900:  "-R$ 1.999,00",
+		12345678: "R$ 123.456,78",
+	}
+	for cents, expected := range cases {
+		if got := FormatCents(cents); got != expected {
+			t.Errorf("FormatCents(%d) = %q, want %q", cents, got, expected)
+		}
+	}
+}
+
+func Test_ReceiptDocument_WritePDF(t *testing.T) {
+	doc := &ReceiptDocument{
+		Receipt: models.Receipt{
+			Number:           models.FormatReceiptNumber(2020, 7),
+			IssuedAt:         time.Now(),
+			CustomerName:     "José da Silva",
+			CustomerDocument: "529.982.247-25",
+			CustomerAddress:  "Rua José, 65 - Centro - CEP 06550-000",
+			PlanName:         "Plano mensal",
+			PeriodStart:      time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC),
+			PeriodEnd:        time.Date(2020, 8, 4, 0, 0, 0, 0, time.UTC),
+			Total:            4990,
+		},
+		Payment: models.Payment{Total: 4990, PaymentType: "credit_card", CardBrand: "visa", CardLastDigits: "1111"},
+	}
+
+	if doc.Receipt.Number != "2020-000007" {
+		t.Errorf("unexpected receipt number %q", doc.Receipt.Number)
+	}
+	if doc.CustomerDocument() != "***.982.247-**" {
+		t.Errorf("unexpected customer document %q", doc.CustomerDocument())
+	}
+	if doc.Period() != "04/07/2020 a 04/08/2020" {
+		t.Errorf("unexpected period %q", doc.Period())
+	}
+	if doc.PaymentDescription() != "Cartão de crédito VISA final 1111" {
+		t.Errorf("unexpected payment description %q", doc.PaymentDescription())
+	}
+
+	buf := &bytes.Buffer{}
+	if err := doc.WritePDF(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "%PDF-") {
+		t.Error("output is not a PDF document")
+	}
+}
+
+func Test_MaskDocumentNumber(t *testing.T) {
+	cases := map[string]string{
+		"52998224725":        "***.982.247-**",
+		"529.982.247-25":     "***.982.247-**",
+		"11.222.333/0001-81": "11.222.333/0001-81",
+		"":                   "",
+	}
+	for document, expected := range cases {
+		if got := MaskDocumentNumber(document); got != expected {
+			t.Errorf("MaskDocumentNumber(%q) = %q, want %q", document, got, expected)
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"io"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Kinds of reconciliation issues
+const (
+	// IssueMissingSubscription is a subscription the gateway has and we do not
+	IssueMissingSubscription = "missing_subscription"
+	// IssueMissingPayment is a transaction the gateway has and we do not
+	IssueMissingPayment = "missing_payment"
+	// IssueUnknownSubscription is a local subscription the gateway does not know, e.g. saved with a zero remote id
+	IssueUnknownSubscription = "unknown_subscription"
+	// IssueUnknownPayment is a local payment the gateway does not know
+	IssueUnknownPayment = "unknown_payment"
+	// IssueSubscriptionStatus is a subscription whose status differs from the gateway
+	IssueSubscriptionStatus = "subscription_status"
+	// IssuePaymentStatus is a payment whose status differs from the gateway
+	IssuePaymentStatus = "payment_status"
+	// IssuePaymentAmount is a payment whose total differs from the amount charged
+	IssuePaymentAmount = "payment_amount"
+)
+
+// ReconciliationIssue is a record that differs between the gateway and our database
+type ReconciliationIssue struct {
+	Kind        string
+	RemoteID    string
+	LocalID     string
+	Detail      string
+	Repaired    bool
+	RepairError string
+}
+
+// ReconciliationReport lists what a reconciliation run compared and found
+type ReconciliationReport struct {
+	From                time.Time
+	To                  time.Time
+	RemoteSubscriptions int
+	RemoteTransactions  int
+	LocalSubscriptions  int
+	LocalPayments       int
+	Issues              []ReconciliationIssue
+}
+
+// ReconciliationService compares the gateway records of a date range with ours
+type ReconciliationService struct {
+	Connection *pop.Connection
+	Gateway    *GatewayClient
+	RabbitMQ   *RabbitMQ
+	// PageSize is how many records are asked from the gateway per call
+	PageSize int
+}
+
+// Creates a ReconciliationService paging 100 records at a time
+func NewReconciliationService(tx *pop.Connection, rabbitMQ *RabbitMQ) *ReconciliationService {
+	return &ReconciliationService{Connection: tx, Gateway: NewGatewayClient(), RabbitMQ: rabbitMQ, PageSize: 100}
+}
+
+// Run diffs the subscriptions and transactions created between from and to. When repair is
+// set, missing records are imported and diverging statuses take the gateway value; unknown
+// records and amount differences are only reported since they need a person to look at them.
+// Each repair is made in a transaction of its own and publishes the event the change would
+// have published had it come the usual way.
+func (s *ReconciliationService) Run(from time.Time, to time.Time, repair bool) (*ReconciliationReport, error) {
+	report := &ReconciliationReport{From: from, To: to}
+
+	remoteSubscriptions, err := s.remoteSubscriptions(from, to)
+	if err != nil {
+		return report, err
+	}
+	remoteTransactions, err := s.remoteTransactions(from, to)
+	if err != nil {
+		return report, err
+	}
+
+	localSubscriptions := models.Subscriptions{}
+	if err := s.Connection.Where("created_at >= ? AND created_at < ?", from, to).All(&localSubscriptions); err != nil {
+		return report, err
+	}
+	localPayments := models.Payments{}
+	if err := s.Connection.Where("created_at >= ? AND created_at < ?", from, to).All(&localPayments); err != nil {
+		return report, err
+	}
+
+	report.RemoteSubscriptions = len(remoteSubscriptions)
+	report.RemoteTransactions = len(remoteTransactions)
+	report.LocalSubscriptions = len(localSubscriptions)
+	report.LocalPayments = len(localPayments)
+
+	subscriptionIssues := DiffSubscriptions(remoteSubscriptions, localSubscriptions)
+	paymentIssues := DiffPayments(remoteTransactions, localPayments)
+
+	remoteByID := map[string]*PaymentReturn{}
+	for i := range remoteSubscriptions {
+		remoteByID[strconv.Itoa(remoteSubscriptions[i].RemoteSubscriptionID)] = &remoteSubscriptions[i]
+	}
+	transactionsByID := map[string]*RemoteTransaction{}
+	for i := range remoteTransactions {
+		transactionsByID[strconv.Itoa(remoteTransactions[i].ID)] = &remoteTransactions[i]
+	}
+
+	// Records created near the range boundaries may sit on different sides of it for each
+	// system, so before calling something missing we look it up regardless of the date
+	for _, issue := range append(subscriptionIssues, paymentIssues...) {
+		switch issue.Kind {
+		case IssueMissingSubscription:
+			subscription := &models.Subscription{}
+			err := s.Connection.Where("remote_subscription_id = ?", issue.RemoteID).First(subscription)
+			if err == nil {
+				continue
+			}
+			if !models.IsNotFound(err) {
+				return report, err
+			}
+			if repair {
+				issue.setRepair(transaction(s.Connection, func(tx *pop.Connection) error {
+					return s.importSubscription(tx, remoteByID[issue.RemoteID])
+				}))
+			}
+
+		case IssueMissingPayment:
+			exists, err := s.Connection.Where("transaction_id = ?", issue.RemoteID).Exists(&models.Payment{})
+			if err != nil {
+				return report, err
+			}
+			if exists {
+				continue
+			}
+			if repair {
+				issue.setRepair(transaction(s.Connection, func(tx *pop.Connection) error {
+					return s.importTransaction(tx, transactionsByID[issue.RemoteID])
+				}))
+			}
+
+		case IssueSubscriptionStatus:
+			if repair {
+				issue.setRepair(transaction(s.Connection, func(tx *pop.Connection) error {
+					return s.updateSubscriptionStatus(tx, issue.LocalID, remoteByID[issue.RemoteID].Status)
+				}))
+			}
+
+		case IssuePaymentStatus:
+			if repair {
+				issue.setRepair(transaction(s.Connection, func(tx *pop.Connection) error {
+					return s.updatePaymentStatus(tx, issue.LocalID, transactionsByID[issue.RemoteID].Status)
+				}))
+			}
+		}
+
+		report.Issues = append(report.Issues, issue)
+	}
+
+	return report, nil
+}
+
+func (i *ReconciliationIssue) setRepair(err error) {
+	i.Repaired = err == nil
+	if err != nil {
+		i.RepairError = err.Error()
+	}
+}
+
+func (s *ReconciliationService) remoteSubscriptions(from time.Time, to time.Time) ([]PaymentReturn, error) {
+	all := []PaymentReturn{}
+	for page := 1; ; page++ {
+		subscriptions, err := s.Gateway.ListSubscriptions(from, to, page, s.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, subscriptions...)
+		if len(subscriptions) < s.PageSize {
+			return all, nil
+		}
+	}
+}
+
+func (s *ReconciliationService) remoteTransactions(from time.Time, to time.Time) ([]RemoteTransaction, error) {
+	all := []RemoteTransaction{}
+	for page := 1; ; page++ {
+		transactions, err := s.Gateway.ListTransactions(from, to, page, s.PageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, transactions...)
+		if len(transactions) < s.PageSize {
+			return all, nil
+		}
+	}
+}
+
+// importSubscription records a subscription we do not have, with its current transaction
+func (s *ReconciliationService) importSubscription(tx *pop.Connection, remote *PaymentReturn) error {
+	subscription, _, err := ImportRemoteSubscription(tx, remote)
+	if err != nil {
+		return err
+	}
+
+	payment := &models.Payment{}
+	err = tx.Where("subscription_id = ?", subscription.ID).First(payment)
+	if err == nil {
+		err = s.publishPayment(tx, *payment)
+	} else if models.IsNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.RabbitMQ.PublishEvent(tx, NewEvent(subscriptionEventType(subscription.Status), NewSubscriptionChanged(*subscription, reconciliationReason)))
+}
+
+// importTransaction records a transaction of a subscription we already have
+func (s *ReconciliationService) importTransaction(tx *pop.Connection, transaction *RemoteTransaction) error {
+	subscription := &models.Subscription{}
+	err := tx.Where("remote_subscription_id = ?", strconv.Itoa(transaction.SubscriptionID)).First(subscription)
+	if err != nil {
+		return err
+	}
+
+	payment := transaction.NewPayment(subscription.ID)
+	if err := validateAndCreate(tx, &payment); err != nil {
+		return err
+	}
+	return s.publishPayment(tx, payment)
+}
+
+func (s *ReconciliationService) updateSubscriptionStatus(tx *pop.Connection, id string, status string) error {
+	subscription := &models.Subscription{}
+	if err := tx.Find(subscription, id); err != nil {
+		return err
+	}
+	subscription.Status = status
+	subscription.StatusReason = reconciliationReason
+	if err := tx.Update(subscription); err != nil {
+		return err
+	}
+	return s.RabbitMQ.PublishEvent(tx, NewEvent(subscriptionEventType(status), NewSubscriptionChanged(*subscription, reconciliationReason)))
+}
+
+func (s *ReconciliationService) updatePaymentStatus(tx *pop.Connection, id string, status string) error {
+	payment := &models.Payment{}
+	if err := tx.Find(payment, id); err != nil {
+		return err
+	}
+	payment.Status = status
+	if err := tx.Update(payment); err != nil {
+		return err
+	}
+	return s.publishPayment(tx, *payment)
+}
+
+// reconciliationReason is the reason given for the changes of a repair
+const reconciliationReason = "gateway reconciliation"
+
+// subscriptionEventType is the event published when a subscription takes the status
+func subscriptionEventType(status string) string {
+	if status == models.SubscriptionCanceled || status == models.SubscriptionEnded {
+		return "subscription.canceled"
+	}
+	return "subscription.status_changed"
+}
+
+// publishPayment does what a payment reaching its status does: the receipt and the invoice of a
+// paid payment, the refund or the chargeback of a returned one, canceling its invoice
+func (s *ReconciliationService) publishPayment(tx *pop.Connection, payment models.Payment) error {
+	switch payment.Status {
+	case models.PaymentPaid:
+		if err := issueReceipt(tx, s.RabbitMQ, payment); err != nil {
+			return err
+		}
+		return requestInvoice(tx, payment)
+
+	case models.PaymentRefunded, models.PaymentPartiallyRefunded, models.PaymentChargedback:
+		eventType := "payment.refunded"
+		if payment.Status == models.PaymentChargedback {
+			eventType = "payment.chargeback"
+		}
+		// A partially refunded payment keeps its invoice
+		if payment.Status != models.PaymentPartiallyRefunded {
+			invoices, err := NewInvoiceService(tx)
+			if err != nil {
+				return err
+			}
+			if err := invoices.CancelForPayment(payment.ID, reconciliationReason); err != nil {
+				return err
+			}
+		}
+		return s.RabbitMQ.PublishEvent(tx, NewEvent(eventType, PaymentRefunded{
+			PaymentID:      payment.ID,
+			SubscriptionID: payment.SubscriptionID,
+			PaymentStatus:  payment.Status,
+			Reason:         reconciliationReason,
+		}))
+	}
+	return nil
+}
+
+// DiffSubscriptions compares the gateway subscriptions with ours by remote subscription id
+func DiffSubscriptions(remote []PaymentReturn, local models.Subscriptions) []ReconciliationIssue {
+	issues := []ReconciliationIssue{}
+
+	localByID := map[string]models.Subscription{}
+	for _, subscription := range local {
+		localByID[subscription.RemoteSubscriptionID] = subscription
+	}
+
+	seen := map[string]bool{}
+	for _, r := range remote {
+		remoteID := strconv.Itoa(r.RemoteSubscriptionID)
+		seen[remoteID] = true
+
+		subscription, ok := localByID[remoteID]
+		if !ok {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssueMissingSubscription,
+				RemoteID: remoteID,
+				Detail:   fmt.Sprintf("%s subscription of %s", r.Status, r.Customer.Email),
+			})
+			continue
+		}
+
+		if !subscriptionStatusMatches(subscription.Status, r.Status) {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssueSubscriptionStatus,
+				RemoteID: remoteID,
+				LocalID:  subscription.ID.String(),
+				Detail:   fmt.Sprintf("local %s, gateway %s", subscription.Status, r.Status),
+			})
+		}
+	}
+
+	for _, subscription := range local {
+		if !seen[subscription.RemoteSubscriptionID] {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssueUnknownSubscription,
+				RemoteID: subscription.RemoteSubscriptionID,
+				LocalID:  subscription.ID.String(),
+				Detail:   fmt.Sprintf("%s subscription not found at the gateway", subscription.Status),
+			})
+		}
+	}
+
+	return issues
+}
+
+// DiffPayments compares the gateway transactions with our payments by transaction id
+func DiffPayments(remote []RemoteTransaction, local models.Payments) []ReconciliationIssue {
+	issues := []ReconciliationIssue{}
+
+	localByID := map[string]models.Payment{}
+	for _, payment := range local {
+		localByID[payment.TransactionID] = payment
+	}
+
+	seen := map[string]bool{}
+	for _, r := range remote {
+		remoteID := strconv.Itoa(r.ID)
+		seen[remoteID] = true
+
+		payment, ok := localByID[remoteID]
+		if !ok {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssueMissingPayment,
+				RemoteID: remoteID,
+				Detail:   fmt.Sprintf("%s transaction of %d cents for subscription %d", r.Status, r.Amount, r.SubscriptionID),
+			})
+			continue
+		}
+
+		if !paymentStatusMatches(payment.Status, r.Status) {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssuePaymentStatus,
+				RemoteID: remoteID,
+				LocalID:  payment.ID.String(),
+				Detail:   fmt.Sprintf("local %s, gateway %s", payment.Status, r.Status),
+			})
+		}
+		if payment.Total != r.Amount {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssuePaymentAmount,
+				RemoteID: remoteID,
+				LocalID:  payment.ID.String(),
+				Detail:   fmt.Sprintf("local %d cents, gateway %d cents", payment.Total, r.Amount),
+			})
+		}
+	}
+
+	for _, payment := range local {
+		if !seen[payment.TransactionID] {
+			issues = append(issues, ReconciliationIssue{
+				Kind:     IssueUnknownPayment,
+				RemoteID: payment.TransactionID,
+				LocalID:  payment.ID.String(),
+				Detail:   fmt.Sprintf("%s payment of %d cents not found at the gateway", payment.Status, payment.Total),
+			})
+		}
+	}
+
+	return issues
+}
+
+// subscriptionStatusMatches treats our own expired status as agreeing with the gateway
+// statuses of a subscription nobody paid for anymore
+func subscriptionStatusMatches(local string, remote string) bool {
+	if local == models.SubscriptionExpired {
+		return remote == models.SubscriptionUnpaid || remote == models.SubscriptionEnded || remote == models.SubscriptionCanceled
+	}
+	return local == remote
+}
+
+// paymentStatusMatches treats a partial refund as agreeing with a paid transaction, since the
+// gateway only flags transactions refunded in full
+func paymentStatusMatches(local string, remote string) bool {
+	if local == models.PaymentPartiallyRefunded {
+		return remote == models.PaymentPaid
+	}
+	return local == remote
+}
+
+// WriteCSV writes one line per issue, with a header
+func (r *ReconciliationReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"kind", "remote_id", "local_id", "detail", "repaired", "repair_error"})
+	for _, issue := range r.Issues {
+		writer.Write([]string{
+			issue.Kind,
+			issue.RemoteID,
+			issue.LocalID,
+			issue.Detail,
+			strconv.FormatBool(issue.Repaired),
+			issue.RepairError,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportRemoteSubscription creates the local records of a gateway subscription: its
+// subscriber, found by e-mail or created from the gateway customer, the subscription and
+// the payment of its current transaction. It returns the existing subscription and false
+// when the remote id is already known.
+func ImportRemoteSubscription(tx *pop.Connection, remote *PaymentReturn) (*models.Subscription, bool, error) {
+	remoteID := strconv.Itoa(remote.RemoteSubscriptionID)
+
+	subscription := &models.Subscription{}
+	err := tx.Where("remote_subscription_id = ?", remoteID).First(subscription)
+	if err == nil {
+		return subscription, false, nil
+	}
+	if !models.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	plan := &models.Plan{}
+	if err := tx.Where("remote_plan_id = ?", strconv.Itoa(remote.RemotePlanID)).First(plan); err != nil {
+		return nil, false, fmt.Errorf("plan %d: %w", remote.RemotePlanID, err)
+	}
+
+	subscriber, err := subscriberByEmail(tx, remote.Customer.Email)
+	if models.IsNotFound(err) {
+		subscriber = remote.Customer.NewSubscriber()
+		err = validateAndCreate(tx, subscriber)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	startPeriod, err := time.Parse(time.RFC3339, remote.CurrentPeriodStart)
+	if err != nil {
+		startPeriod = remote.CreatedAt
+	}
+	endPeriod, err := time.Parse(time.RFC3339, remote.CurrentPeriodSEnd)
+	if err != nil {
+		endPeriod = plan.Interval().AddTo(startPeriod)
+	}
+
+	subscription = &models.Subscription{
+		SubscriberID:         subscriber.ID,
+		PlanID:               plan.ID,
+		RemotePlanID:         plan.RemotePanID,
+		RemoteSubscriptionID: remoteID,
+		StartDate:            startPeriod,
+		ExpiresAt:            endPeriod,
+		Status:               remote.Status,
+		CreatedAt:            remote.CreatedAt,
+		UpdatedAt:            remote.UpdatedAt,
+	}
+	subscription.ID, _ = uuid.NewV4()
+	if err := validateAndCreate(tx, subscription); err != nil {
+		return nil, false, err
+	}
+
+	if remote.CurrentTransaction.RemoteTransactionID != 0 {
+		payment := remote.NewPayment(subscription.ID)
+		if payment.Installments == 0 {
+			payment.Installments = 1
+		}
+		if err := validateAndCreate(tx, &payment); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return subscription, true, nil
+}
+
+// subscriberByEmail finds the latest subscriber with the e-mail, whatever its case, since each
+// checkout may have created one
+func subscriberByEmail(tx *pop.Connection, email string) (*models.Subscriber, error) {
+	subscriber := &models.Subscriber{}
+	err := tx.Where("lower(email) = ?", strings.ToLower(email)).Order("created_at desc").First(subscriber)
+	return subscriber, err
+}
+
+// NewSubscriber maps the gateway customer to a new Subscriber
+func (c RemoteCustomer) NewSubscriber() *models.Subscriber {
+	subscriber := &models.Subscriber{
+		Name:           c.Name,
+		Email:          c.Email,
+		DocumentNumber: c.DocumentNumber,
+		Street:         c.Address.Street,
+		StreetNumber:   c.Address.StreetNumber,
+		Complementary:  c.Address.Complementary,
+		Neighborhood:   c.Address.Neighborhood,
+		Zipcode:        c.Address.Zipcode,
+		DDD:            c.Phone.DDD,
+		Number:         c.Phone.Number,
+	}
+	subscriber.ID, _ = uuid.NewV4()
+	return subscriber
+}
+
+func validateAndCreate(tx *pop.Connection, model interface{}) error {
+	verrs, err := tx.ValidateAndCreate(model)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return nil
+}
+
+// ReconciliationRange parses the dates of a reconciliation run, YYYY-MM-DD with the end
+// inclusive. Missing dates default to the last 24 hours.
+func ReconciliationRange(from string, to string, now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+
+	start := end.Add(-24 * time.Hour)
+	if from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		start = t
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid range %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+	return start, end, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bytes"
+	"github.com/gofrs/uuid"
+	"strings"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_DiffSubscriptions(t *testing.T) {
+	remote := []PaymentReturn{
+		{RemoteSubscriptionID: 1, Status: models.SubscriptionPaid},
+		{RemoteSubscriptionID: 2, Status: models.SubscriptionCanceled},
+		{RemoteSubscriptionID: 3, Status: models.SubscriptionUnpaid},
+		{RemoteSubscriptionID: 4, Status: models.SubscriptionPaid},
+	}
+	local := models.Subscriptions{
+		{ID: uuid.Must(uuid.NewV4()), RemoteSubscriptionID: "1", Status: models.SubscriptionPaid},
+		{ID: uuid.Must(uuid.NewV4()), RemoteSubscriptionID: "2", Status: models.SubscriptionPaid},
+		{ID: uuid.Must(uuid.NewV4()), RemoteSubscriptionID: "3", Status: models.SubscriptionExpired},
+		{ID: uuid.Must(uuid.NewV4()), RemoteSubscriptionID: "0", Status: models.SubscriptionPaid},
+	}
+
+	issues := DiffSubscriptions(remote, local)
+
+	want := map[string]string{
+		"2": IssueSubscriptionStatus,
+		"4": IssueMissingSubscription,
+		"0": IssueUnknownSubscription,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d: %+v", len(issues), len(want), issues)
+	}
+	for _, issue := range issues {
+		if want[issue.RemoteID] != issue.Kind {
+			t.Errorf("subscription %s: got %s, want %s", issue.RemoteID, issue.Kind, want[issue.RemoteID])
+		}
+	}
+}
+
+func Test_DiffPayments(t *testing.T) {
+	remote := []RemoteTransaction{
+		{ID: 10, Status: models.PaymentPaid, Amount: 1990},
+		{ID: 11, Status: models.PaymentPaid, Amount: 1990},
+		{ID: 12, Status: models.PaymentRefunded, Amount: 1990},
+		{ID: 13, Status: models.PaymentPaid, Amount: 1990},
+	}
+	local := models.Payments{
+		{TransactionID: "10", Status: models.PaymentPartiallyRefunded, Total: 1990},
+		{TransactionID: "11", Status: models.PaymentPaid, Total: 990},
+		{TransactionID: "12", Status: models.PaymentPaid, Total: 1990},
+		{TransactionID: "0", Status: models.PaymentPaid, Total: 1990},
+	}
+
+	issues := DiffPayments(remote, local)
+
+	want := map[string]string{
+		"11": IssuePaymentAmount,
+		"12": IssuePaymentStatus,
+		"13": IssueMissingPayment,
+		"0":  IssueUnknownPayment,
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("got %d issues, want %d: %+v", len(issues), len(want), issues)
+	}
+	for _, issue := range issues {
+		if want[issue.RemoteID] != issue.Kind {
+			t.Errorf("transaction %s: got %s, want %s", issue.RemoteID, issue.Kind, want[issue.RemoteID])
+		}
+	}
+}
+
+func Test_ReconciliationReport_WriteCSV(t *testing.T) {
+	report := &ReconciliationReport{Issues: []ReconciliationIssue{
+		{Kind: IssueMissingPayment, RemoteID: "13", Detail: "paid, twice", Repaired: true},
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[1] != `missing_payment,13,,"paid, twice",true,` {
+		t.Errorf("unexpected csv: %q", buf.String())
+	}
+}
+
+func Test_ReconciliationRange(t *testing.T) {
+	now := time.Date(2020, 7, 10, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := ReconciliationRange("2020-07-01", "2020-07-05", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2020, 7, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v to %v", from, to)
+	}
+
+	from, to, err = ReconciliationRange("", "", now)
+	if err != nil || !to.Equal(now) || !from.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("default range: got %v to %v (%v)", from, to, err)
+	}
+
+	if _, _, err := ReconciliationRange("2020-07-05", "2020-07-01", now); err == nil {
+		t.Error("expected an error for an inverted range")
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisError is an error reply from Redis
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// RedisClient is a minimal Redis client, enough to run commands over a single connection. It
+// connects on the first command and again after a failure.
+type RedisClient struct {
+	Addr     string
+	Password string
+	Timeout  time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient creates a client for the server at addr, e.g. "localhost:6379"
+func NewRedisClient(addr string, password string) *RedisClient {
+	return &RedisClient{Addr: addr, Password: password, Timeout: time.Second}
+}
+
+// Do runs the command, returning its reply: nil, an int64, a string or a []interface{}.
+// Error replies are returned as a RedisError.
+func (r *RedisClient) Do(args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := r.do(args)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+func (r *RedisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", r.Addr, r.Timeout)
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+
+	if r.Password != "" {
+		if _, err := r.do([]string{"AUTH", r.Password}); err != nil {
+			r.conn.Close()
+			r.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RedisClient) do(args []string) (interface{}, error) {
+	if err := r.conn.SetDeadline(time.Now().Add(r.Timeout)); err != nil {
+		return nil, err
+	}
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(r.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return r.readReply()
+}
+
+func (r *RedisClient) readReply() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, size)
+		for i := range replies {
+			reply, err := r.readReply()
+			var redisErr RedisError
+			switch {
+			case errors.As(err, &redisErr):
+				// Errors inside arrays are values, not failures of the command
+				replies[i] = redisErr
+			case err != nil:
+				return nil, err
+			default:
+				replies[i] = reply
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"log"
+	"strconv"
+	"subscription_service/models"
+)
+
+var (
+	// ErrNotRefundable is returned when the payment status does not allow a refund
+	ErrNotRefundable = errors.New("payment can not be refunded")
+	// ErrRefundAmount is returned when the requested amount is not positive or exceeds what is left to refund
+	ErrRefundAmount = errors.New("invalid refund amount")
+)
+
+// RefundService returns money for payments, fully or partially, and records chargebacks
+type RefundService struct {
+	Connection *pop.Connection
+	Gateway    *GatewayClient
+	RabbitMQ   *RabbitMQ
+	// Ledger, when set, e.g. to models.DB, records the refunds and the payment status in
+	// transactions of their own, so a refund the gateway made is kept even if what follows it
+	// fails and the transaction of Connection rolls back
+	Ledger *pop.Connection
+	// ChangedBy is who the subscriptions canceled by the refunds are attributed to, "system"
+	// when empty
+	ChangedBy string
+}
+
+// RefundRequest is bound from the admin form and the API body.
+// Amount is in cents; zero refunds everything that is left.
+type RefundRequest struct {
+	Amount             int    `json:"amount" form:"Amount"`
+	Reason             string `json:"reason" form:"Reason"`
+	CancelSubscription bool   `json:"cancel_subscription" form:"CancelSubscription"`
+}
+
+// PaymentRefunded is the payload of the payment.refunded and payment.chargeback events
+type PaymentRefunded struct {
+	PaymentID            uuid.UUID `json:"payment_id"`
+	SubscriptionID       uuid.UUID `json:"subscription_id"`
+	RefundID             uuid.UUID `json:"refund_id"`
+	Amount               int       `json:"amount"`
+	RefundedTotal        int       `json:"refunded_total"`
+	PaymentStatus        string    `json:"payment_status"`
+	Reason               string    `json:"reason"`
+	SubscriptionCanceled bool      `json:"subscription_canceled"`
+}
+
+// Creates a RefundService
+func NewRefundService(tx *pop.Connection, rabbitMQ *RabbitMQ) *RefundService {
+	return &RefundService{Connection: tx, Gateway: NewGatewayClient(), RabbitMQ: rabbitMQ}
+}
+
+// Refund returns money of the payment through the gateway. A refund rejected by the
+// gateway is kept with the failed status and its error is returned.
+func (s *RefundService) Refund(paymentID interface{}, request RefundRequest) (*models.Refund, error) {
+	payment := &models.Payment{}
+	refund := &models.Refund{
+		Kind:               models.RefundKindRefund,
+		Status:             models.RefundPending,
+		Reason:             request.Reason,
+		CancelSubscription: request.CancelSubscription,
+	}
+	refund.ID, _ = uuid.NewV4()
+
+	// The payment stays locked until the pending refund is recorded, so concurrent refunds of it
+	// count each other and can not return more than was paid
+	err := s.ledger(func(tx *pop.Connection) error {
+		if err := tx.RawQuery("SELECT * FROM payments WHERE id = ? FOR UPDATE", paymentID).First(payment); err != nil {
+			return err
+		}
+		if err := tx.Where("payment_id = ?", payment.ID).All(&payment.Refunds); err != nil {
+			return err
+		}
+		if !payment.Refundable() {
+			return ErrNotRefundable
+		}
+
+		remaining := payment.Total - payment.Refunds.Total()
+		amount := request.Amount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 || amount > remaining {
+			return ErrRefundAmount
+		}
+
+		refund.PaymentID = payment.ID
+		refund.Amount = amount
+		return validateAndCreate(tx, refund)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gatewayRefund, err := s.Gateway.Refund(payment.TransactionID, refund.Amount)
+	if err != nil {
+		refund.Status = models.RefundFailed
+		if uerr := s.ledger(func(tx *pop.Connection) error { return tx.Update(refund) }); uerr != nil {
+			log.Println("Error saving failed refund:", uerr)
+		}
+		return refund, err
+	}
+
+	refund.Status = models.RefundSucceeded
+	refund.RemoteRefundID = nulls.NewString(strconv.Itoa(gatewayRefund.ID))
+	err = s.ledger(func(tx *pop.Connection) error {
+		if err := tx.Update(refund); err != nil {
+			return err
+		}
+		return settlePayment(tx, payment, refund)
+	})
+	if err != nil {
+		log.Printf("Error saving refund %s of payment %s made at the gateway: %v", refund.ID, payment.ID, err)
+		return refund, err
+	}
+
+	return refund, s.followUp(payment, refund)
+}
+
+// RecordRemoteRefund registers a refund made straight at the gateway, e.g. from its dashboard,
+// given the total refunded amount reported by the postback
+func (s *RefundService) RecordRemoteRefund(transactionID string, refundedAmount int) (*models.Refund, error) {
+	payment := &models.Payment{}
+	if err := s.Connection.Eager("Refunds").Where("transaction_id = ?", transactionID).First(payment); err != nil {
+		return nil, err
+	}
+
+	amount := refundedAmount - payment.Refunds.Total()
+	if refundedAmount == 0 {
+		amount = payment.Total - payment.Refunds.Total()
+	}
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	refund := &models.Refund{
+		PaymentID: payment.ID,
+		Kind:      models.RefundKindRefund,
+		Status:    models.RefundSucceeded,
+		Amount:    amount,
+		Reason:    "refunded at the gateway",
+	}
+	return refund, s.record(payment, refund)
+}
+
+// Chargeback records a chargeback notified by the gateway. Notifying the same
+// transaction twice records it only once.
+func (s *RefundService) Chargeback(transactionID string, amount int, reason string, cancelSubscription bool) (*models.Refund, error) {
+	payment := &models.Payment{}
+	if err := s.Connection.Eager("Refunds").Where("transaction_id = ?", transactionID).First(payment); err != nil {
+		return nil, err
+	}
+
+	for _, refund := range payment.Refunds {
+		if refund.Kind == models.RefundKindChargeback {
+			return &refund, nil
+		}
+	}
+
+	if amount == 0 {
+		amount = payment.Total
+	}
+
+	refund := &models.Refund{
+		PaymentID:          payment.ID,
+		Kind:               models.RefundKindChargeback,
+		Status:             models.RefundSucceeded,
+		Amount:             amount,
+		Reason:             reason,
+		CancelSubscription: cancelSubscription,
+	}
+	return refund, s.record(payment, refund)
+}
+
+func (s *RefundService) record(payment *models.Payment, refund *models.Refund) error {
+	refund.ID, _ = uuid.NewV4()
+
+	err := s.ledger(func(tx *pop.Connection) error {
+		if err := validateAndCreate(tx, refund); err != nil {
+			return err
+		}
+		return settlePayment(tx, payment, refund)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.followUp(payment, refund)
+}
+
+// ledger runs fn in a transaction of Ledger, made by the actor of Connection, or straight on
+// Connection when there is no Ledger
+func (s *RefundService) ledger(fn func(tx *pop.Connection) error) error {
+	if s.Ledger == nil {
+		return fn(s.Connection)
+	}
+	return s.Ledger.Transaction(func(tx *pop.Connection) error {
+		defer models.SetAuditActor(tx, models.CurrentAuditActor(s.Connection))()
+		return fn(tx)
+	})
+}
+
+// settlePayment updates the payment status after a successful refund or chargeback, from every
+// refund of the payment that went through by now. The payment is locked first, so concurrent
+// settlements apply in turn and the last one sees all the others.
+func settlePayment(tx *pop.Connection, payment *models.Payment, refund *models.Refund) error {
+	if err := tx.RawQuery("SELECT * FROM payments WHERE id = ? FOR UPDATE", payment.ID).First(payment); err != nil {
+		return err
+	}
+	if err := tx.Where("payment_id = ?", payment.ID).All(&payment.Refunds); err != nil {
+		return err
+	}
+
+	switch {
+	case refund.Kind == models.RefundKindChargeback:
+		payment.Status = models.PaymentChargedback
+	case payment.Refunds.Succeeded() >= payment.Total:
+		payment.Status = models.PaymentRefunded
+	default:
+		payment.Status = models.PaymentPartiallyRefunded
+	}
+
+	return tx.Update(payment)
+}
+
+// followUp cancels what depends on the settled payment and publishes the matching event
+func (s *RefundService) followUp(payment *models.Payment, refund *models.Refund) error {
+	refundedTotal := payment.Refunds.Succeeded()
+
+	eventType := "payment.refunded"
+	if refund.Kind == models.RefundKindChargeback {
+		eventType = "payment.chargeback"
+	}
+
+	if payment.Status != models.PaymentPartiallyRefunded {
+		invoices, err := NewInvoiceService(s.Connection)
+		if err == nil {
+			err = invoices.CancelForPayment(payment.ID, refund.Reason)
+		}
+		if err != nil {
+			log.Println("Error canceling invoice:", err)
+		}
+	}
+
+	subscriptionCanceled := false
+	if refund.CancelSubscription {
+		subscription := &models.Subscription{}
+		if err := s.Connection.Find(subscription, payment.SubscriptionID); err != nil {
+			return err
+		}
+		subscription.ChangedBy = s.ChangedBy
+		if err := CancelSubscription(s.Connection, s.Gateway, s.RabbitMQ, subscription, refund.Reason); err != nil {
+			return err
+		}
+		subscriptionCanceled = true
+	}
+
+	return s.RabbitMQ.PublishEvent(s.Connection, NewEvent(eventType, PaymentRefunded{
+		PaymentID:            payment.ID,
+		SubscriptionID:       payment.SubscriptionID,
+		RefundID:             refund.ID,
+		Amount:               refund.Amount,
+		RefundedTotal:        refundedTotal,
+		PaymentStatus:        payment.Status,
+		Reason:               refund.Reason,
+		SubscriptionCanceled: subscriptionCanceled,
+	}))
+}
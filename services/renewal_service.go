@@ -0,0 +1,269 @@
+package services
+
+import (
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"os"
+	"strconv"
+	"subscription_service/models"
+	"time"
+)
+
+// RenewalService keeps the local subscription periods in step with the gateway: it extends
+// renewed subscriptions, records their new payments and expires the ones nobody paid for
+type RenewalService struct {
+	Connection *pop.Connection
+	Gateway    *GatewayClient
+	RabbitMQ   *RabbitMQ
+	// Lookahead makes subscriptions expiring within this window get checked already
+	Lookahead time.Duration
+	// GracePeriod is how long after ExpiresAt we wait for a renewal before expiring the subscription
+	GracePeriod time.Duration
+}
+
+// RenewalReport counts what a renewal run did
+type RenewalReport struct {
+	Checked   int
+	Renewed   int
+	Expired   int
+	Changed   int
+	Failed    int
+	Unchanged int
+}
+
+// Creates a RenewalService with a one day lookahead and a grace period of
+// SUBSCRIPTION_GRACE_DAYS days (3 when not set)
+func NewRenewalService(tx *pop.Connection, rabbitMQ *RabbitMQ) *RenewalService {
+	graceDays, err := strconv.Atoi(os.Getenv("SUBSCRIPTION_GRACE_DAYS"))
+	if err != nil {
+		graceDays = 3
+	}
+
+	return &RenewalService{
+		Connection:  tx,
+		Gateway:     NewGatewayClient(),
+		RabbitMQ:    rabbitMQ,
+		Lookahead:   24 * time.Hour,
+		GracePeriod: time.Duration(graceDays) * 24 * time.Hour,
+	}
+}
+
+// Run reconciles every subscription near or past its expiration date
+func (s *RenewalService) Run(now time.Time) (RenewalReport, error) {
+	report := RenewalReport{}
+
+	statuses := make([]interface{}, len(models.RenewableSubscriptionStatuses))
+	for i, status := range models.RenewableSubscriptionStatuses {
+		statuses[i] = status
+	}
+
+	subscriptions := models.Subscriptions{}
+	err := s.Connection.
+		Where("status IN (?)", statuses...).
+		Where("expires_at <= ?", now.Add(s.Lookahead)).
+		Order("expires_at asc").
+		All(&subscriptions)
+	if err != nil {
+		return report, err
+	}
+
+	for i := range subscriptions {
+		report.Checked++
+
+		transition, err := s.Reconcile(&subscriptions[i], now)
+		if err != nil {
+			log.Printf("Error renewing subscription %s: %v", subscriptions[i].ID, err)
+			report.Failed++
+			continue
+		}
+
+		switch transition {
+		case "subscription.renewed":
+			report.Renewed++
+		case "subscription.expired":
+			report.Expired++
+		case "":
+			report.Unchanged++
+		default:
+			report.Changed++
+		}
+	}
+
+	return report, nil
+}
+
+// Reconcile fetches the subscription from the gateway and applies what changed, all of it or
+// nothing. It returns the type of the event published, empty when nothing changed.
+func (s *RenewalService) Reconcile(subscription *models.Subscription, now time.Time) (string, error) {
+	remote, err := s.Gateway.FetchSubscription(subscription.RemoteSubscriptionID)
+	if err != nil {
+		return "", err
+	}
+
+	var transition string
+	err = transaction(s.Connection, func(tx *pop.Connection) error {
+		// A postback and the scheduler may reconcile the same subscription at once; the second
+		// waits here and applies the gateway state over what the first saved
+		if err := tx.RawQuery("SELECT * FROM subscriptions WHERE id = ? FOR UPDATE", subscription.ID).First(subscription); err != nil {
+			return err
+		}
+		renewals := *s
+		renewals.Connection = tx
+		transition, err = renewals.update(subscription, remote, now)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return transition, nil
+}
+
+// update applies the remote state to the subscription, saving it, queueing its email and
+// publishing its event
+func (s *RenewalService) update(subscription *models.Subscription, remote *PaymentReturn, now time.Time) (string, error) {
+	wasPaid := subscription.Status == models.SubscriptionPaid
+	transition := s.apply(subscription, remote, now)
+	if transition == "" {
+		return "", nil
+	}
+
+	if err := s.Connection.Update(subscription); err != nil {
+		return "", err
+	}
+	// Recorded once the new period is saved, so the receipt of the payment prints the period it paid
+	if transition == "subscription.renewed" {
+		if err := s.recordRenewalPayment(subscription, remote); err != nil {
+			return "", err
+		}
+	}
+	if err := s.notify(subscription, transition, wasPaid); err != nil {
+		log.Printf("Error queueing the %s email of subscription %s: %v", transition, subscription.ID, err)
+	}
+
+	return transition, s.RabbitMQ.PublishEvent(s.Connection, NewEvent(transition, NewSubscriptionChanged(*subscription, "")))
+}
+
+// notify queues the email telling the subscriber about the transition. Boleto subscriptions
+// get their welcome once the boleto is paid.
+func (s *RenewalService) notify(subscription *models.Subscription, transition string, wasPaid bool) error {
+	switch {
+	case transition == "subscription.canceled":
+		return Notify(s.Connection, models.NotificationSubscriptionCanceled, *subscription, nil)
+	case transition == "subscription.expired":
+		return Notify(s.Connection, models.NotificationSubscriptionExpired, *subscription, nil)
+	case !wasPaid && subscription.Status == models.SubscriptionPaid:
+		return Notify(s.Connection, models.NotificationWelcome, *subscription, nil)
+	}
+	return nil
+}
+
+// apply changes the subscription according to the remote state, returning the transition it made
+func (s *RenewalService) apply(subscription *models.Subscription, remote *PaymentReturn, now time.Time) string {
+	periodEnd, err := time.Parse(time.RFC3339, remote.CurrentPeriodSEnd)
+	renewed := err == nil && periodEnd.After(subscription.ExpiresAt)
+
+	switch {
+	case remote.Status == models.SubscriptionPaid && renewed:
+		if periodStart, err := time.Parse(time.RFC3339, remote.CurrentPeriodStart); err == nil {
+			subscription.StartDate = periodStart
+		}
+		subscription.ExpiresAt = periodEnd
+		subscription.Status = remote.Status
+		return "subscription.renewed"
+
+	case remote.Status == models.SubscriptionCanceled || remote.Status == models.SubscriptionEnded:
+		if subscription.Status == remote.Status {
+			return ""
+		}
+		subscription.Status = remote.Status
+		return "subscription.canceled"
+
+	case now.After(subscription.ExpiresAt.Add(s.GracePeriod)):
+		subscription.Status = models.SubscriptionExpired
+		subscription.StatusReason = "not renewed within the grace period"
+		return "subscription.expired"
+
+	case remote.Status != subscription.Status:
+		subscription.Status = remote.Status
+		return "subscription.status_changed"
+	}
+
+	return ""
+}
+
+// recordRenewalPayment stores the transaction that paid the new period, or marks it paid when we
+// already have it waiting, e.g. a boleto. The payment is dated when the period started, as the
+// dates of the return are the subscription's.
+func (s *RenewalService) recordRenewalPayment(subscription *models.Subscription, remote *PaymentReturn) error {
+	payment := remote.NewPayment(subscription.ID)
+	if periodStart, err := time.Parse(time.RFC3339, remote.CurrentPeriodStart); err == nil {
+		payment.CreatedAt = periodStart
+	} else {
+		payment.CreatedAt = remote.UpdatedAt
+	}
+	payment.UpdatedAt = payment.CreatedAt
+
+	existing := &models.Payment{}
+	err := s.Connection.Where("transaction_id = ?", payment.TransactionID).First(existing)
+	switch {
+	case err == nil:
+		if payment.Status != models.PaymentPaid || existing.Settled() {
+			return nil
+		}
+		existing.Status = models.PaymentPaid
+		if err := s.Connection.Update(existing); err != nil {
+			return err
+		}
+		payment = *existing
+
+	case models.IsNotFound(err):
+		verrs, err := s.Connection.ValidateAndCreate(&payment)
+		if err != nil {
+			return err
+		}
+		if verrs.HasAny() {
+			return verrs
+		}
+		if payment.Status != models.PaymentPaid {
+			return nil
+		}
+
+	default:
+		return err
+	}
+
+	issuePaidDocuments(s.Connection, s.RabbitMQ, payment)
+	err = transaction(s.Connection, func(tx *pop.Connection) error {
+		return Notify(tx, models.NotificationRenewalReceipt, *subscription, &payment)
+	})
+	if err != nil {
+		log.Println("Error queueing the renewal receipt email:", err)
+	}
+
+	return nil
+}
+
+// StartRenewalScheduler runs the renewal every interval in the background until the process
+// exits, each run in a transaction attributed to the scheduler
+func StartRenewalScheduler(db *pop.Connection, rabbitMQ *RabbitMQ, interval time.Duration) {
+	actor := models.AuditActor{Type: models.ActorJob, ID: "renewal_scheduler"}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			var report RenewalReport
+			err := transactionAs(db, actor, func(tx *pop.Connection) error {
+				var err error
+				report, err = NewRenewalService(tx, rabbitMQ).Run(now)
+				return err
+			})
+			if err != nil {
+				log.Println("Error running the renewal scheduler:", err)
+				continue
+			}
+			log.Printf("Renewal scheduler: %+v", report)
+		}
+	}()
+}
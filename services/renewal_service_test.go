@@ -0,0 +1,38 @@
+package services
+
+import (
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_RenewalService_apply(t *testing.T) {
+	now := time.Date(2020, 7, 10, 12, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2020, 7, 4, 0, 0, 0, 0, time.UTC)
+	s := &RenewalService{GracePeriod: 3 * 24 * time.Hour}
+
+	cases := []struct {
+		name       string
+		local      string
+		remote     string
+		expiresAt  time.Time
+		transition string
+		status     string
+	}{
+		{"canceled at the gateway", models.SubscriptionPaid, models.SubscriptionCanceled, expiresAt, "subscription.canceled", models.SubscriptionCanceled},
+		{"already canceled", models.SubscriptionCanceled, models.SubscriptionCanceled, expiresAt, "", models.SubscriptionCanceled},
+		{"past the grace period", models.SubscriptionPaid, models.SubscriptionUnpaid, expiresAt, "subscription.expired", models.SubscriptionExpired},
+		{"within the grace period", models.SubscriptionPaid, models.SubscriptionPendingPayment, now.Add(-24 * time.Hour), "subscription.status_changed", models.SubscriptionPendingPayment},
+		{"nothing new", models.SubscriptionPaid, models.SubscriptionPaid, now.Add(12 * time.Hour), "", models.SubscriptionPaid},
+	}
+
+	for _, c := range cases {
+		subscription := &models.Subscription{Status: c.local, ExpiresAt: c.expiresAt}
+		remote := &PaymentReturn{Status: c.remote, CurrentPeriodSEnd: c.expiresAt.Format(time.RFC3339)}
+
+		transition := s.apply(subscription, remote, now)
+		if transition != c.transition || subscription.Status != c.status {
+			t.Errorf("%s: got %q/%q, want %q/%q", c.name, transition, subscription.Status, c.transition, c.status)
+		}
+	}
+}
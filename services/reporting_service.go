@@ -0,0 +1,418 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gobuffalo/pop/v5"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// reportMonthLayout is how months are given to and shown by the reports
+const reportMonthLayout = "2006-01"
+
+// ReportingService computes the recurring revenue, churn and retention metrics from the
+// subscriptions, their status history and the payments
+type ReportingService struct {
+	Connection *pop.Connection
+}
+
+// Creates a ReportingService reading from the given connection
+func NewReportingService(tx *pop.Connection) *ReportingService {
+	return &ReportingService{Connection: tx}
+}
+
+// MonthlyMetrics are the revenue figures of a month, amounts in cents
+type MonthlyMetrics struct {
+	Month string `json:"month"`
+	// MRR and ARR are taken at the end of the month
+	MRR int `json:"mrr"`
+	ARR int `json:"arr"`
+	// The MRR movements of the month, comparing each subscriber at its start and end
+	NewMRR          int `json:"new_mrr"`
+	ReactivationMRR int `json:"reactivation_mrr"`
+	ExpansionMRR    int `json:"expansion_mrr"`
+	ContractionMRR  int `json:"contraction_mrr"`
+	ChurnedMRR      int `json:"churned_mrr"`
+	NetNewMRR       int `json:"net_new_mrr"`
+
+	Customers        int `json:"customers"`
+	NewCustomers     int `json:"new_customers"`
+	ChurnedCustomers int `json:"churned_customers"`
+	// ChurnRate is the share of the paying customers at the start of the month that stopped paying
+	ChurnRate float64 `json:"churn_rate"`
+	// ARPU is the MRR per paying customer
+	ARPU int `json:"arpu"`
+
+	TrialsStarted int `json:"trials_started"`
+	// TrialsConverted counts the trials started in the month that ever paid
+	TrialsConverted     int     `json:"trials_converted"`
+	TrialConversionRate float64 `json:"trial_conversion_rate"`
+
+	// Collected is the total of the payments made in the month
+	Collected int `json:"collected"`
+}
+
+// Cohort follows the subscribers who signed up in a month. Retention[n] is the share of them
+// still paying n months later, Retention[0] being the signup month itself.
+type Cohort struct {
+	Month     string    `json:"month"`
+	Customers int       `json:"customers"`
+	Retention []float64 `json:"retention"`
+}
+
+// BusinessReport has the metrics of every month in the range and the cohorts that signed up in it
+type BusinessReport struct {
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Months  []MonthlyMetrics `json:"months"`
+	Cohorts []Cohort         `json:"cohorts"`
+}
+
+// Run builds the report of the months from the one starting at from to the one containing to.
+// The figures are aggregated by the database; customers are told apart by e-mail, since each
+// checkout may have created a subscriber.
+func (s *ReportingService) Run(from time.Time, to time.Time) (*BusinessReport, error) {
+	from, to = monthStart(from), monthStart(to)
+	report := &BusinessReport{From: from.Format(reportMonthLayout), To: to.Format(reportMonthLayout)}
+
+	collected, err := s.Collected(from, to.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	if report.Months, err = s.Months(from, to, collected); err != nil {
+		return nil, err
+	}
+	if report.Cohorts, err = s.Cohorts(from, to); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Collected sums the payments made between from and to by month, refunded ones included
+func (s *ReportingService) Collected(from time.Time, to time.Time) (map[string]int, error) {
+	rows := []monthTotal{}
+	err := s.Connection.RawQuery(`
+		SELECT to_char(created_at, 'YYYY-MM') AS month, COALESCE(SUM(total), 0) AS total
+		FROM payments
+		WHERE status IN (?, ?, ?, ?) AND created_at >= ? AND created_at < ?
+		GROUP BY 1`,
+		models.PaymentPaid, models.PaymentPartiallyRefunded, models.PaymentRefunded, models.PaymentChargedback, from, to,
+	).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	collected := map[string]int{}
+	for _, row := range rows {
+		collected[row.Month] = row.Total
+	}
+	return collected, nil
+}
+
+type monthTotal struct {
+	Month string `db:"month"`
+	Total int    `db:"total"`
+}
+
+// revenueSpansSQL works out, for every subscription, the stretch of time it paid for its plan
+// from its status history. Subscriptions stop counting when canceled, expired or ended, not when
+// the paid period runs out, and those created before the history was kept are taken as paying
+// since they started. The MRR is the plan price spread over its months, in cents, day and week
+// based plans using 30 day months.
+var revenueSpansSQL = fmt.Sprintf(`
+	WITH history AS (
+		SELECT subscription_id,
+			(array_agg(CASE WHEN from_status = '' THEN to_status ELSE from_status END ORDER BY created_at))[1] AS first_status,
+			MIN(created_at) FILTER (WHERE to_status = %[1]s) AS paid_at,
+			MAX(created_at) FILTER (WHERE to_status NOT IN (%[2]s)) AS resumed_at
+		FROM subscription_status_changes
+		GROUP BY subscription_id
+	),
+	ended AS (
+		SELECT changes.subscription_id, MIN(changes.created_at) AS ended_at
+		FROM subscription_status_changes changes
+		JOIN history ON history.subscription_id = changes.subscription_id
+		WHERE changes.to_status IN (%[2]s) AND (history.resumed_at IS NULL OR changes.created_at > history.resumed_at)
+		GROUP BY changes.subscription_id
+	),
+	spans AS (
+		SELECT lower(subscribers.email) AS customer,
+			round(CASE plans.interval_unit
+				WHEN %[5]s THEN plans.price * 100 / (12 * plans.interval_count)
+				WHEN %[6]s THEN plans.price * 100 * 30 / (7 * plans.interval_count)
+				WHEN %[7]s THEN plans.price * 100 * 30 / plans.interval_count
+				ELSE plans.price * 100 / plans.interval_count
+			END)::integer AS mrr,
+			subscriptions.start_date AS signup_at,
+			COALESCE(history.first_status, subscriptions.status) = %[3]s AS trial,
+			CASE
+				WHEN COALESCE(history.first_status, subscriptions.status) IN (%[1]s, %[4]s)
+					OR (history.subscription_id IS NULL AND subscriptions.status IN (%[2]s)) THEN subscriptions.start_date
+				ELSE history.paid_at
+			END AS paid_from,
+			COALESCE(ended.ended_at, CASE WHEN subscriptions.status IN (%[2]s) THEN subscriptions.updated_at END) AS ended_at
+		FROM subscriptions
+		JOIN subscribers ON subscribers.id = subscriptions.subscriber_id
+		JOIN plans ON plans.id = subscriptions.plan_id
+		LEFT JOIN history ON history.subscription_id = subscriptions.id
+		LEFT JOIN ended ON ended.subscription_id = subscriptions.id
+	)`,
+	sqlQuote(models.SubscriptionPaid),
+	sqlQuote(models.SubscriptionCanceled)+", "+sqlQuote(models.SubscriptionEnded)+", "+sqlQuote(models.SubscriptionExpired),
+	sqlQuote(models.SubscriptionTrialing),
+	sqlQuote(models.SubscriptionUnpaid),
+	sqlQuote(models.IntervalYear),
+	sqlQuote(models.IntervalWeek),
+	sqlQuote(models.IntervalDay),
+)
+
+// payingAtSQL tells whether the span was paying right before the instant %s
+const payingAtSQL = "(spans.mrr > 0 AND spans.paid_from < %[1]s AND (spans.ended_at IS NULL OR spans.ended_at >= %[1]s))"
+
+func sqlQuote(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// monthAggregates are the figures of a month summed by the database, amounts in cents
+type monthAggregates struct {
+	Month           string `db:"month"`
+	MRR             int    `db:"mrr"`
+	NewMRR          int    `db:"new_mrr"`
+	ReactivationMRR int    `db:"reactivation_mrr"`
+	ExpansionMRR    int    `db:"expansion_mrr"`
+	ContractionMRR  int    `db:"contraction_mrr"`
+	ChurnedMRR      int    `db:"churned_mrr"`
+	Customers       int    `db:"customers"`
+	CustomersBefore int    `db:"customers_before"`
+	NewCustomers    int    `db:"new_customers"`
+	Churned         int    `db:"churned_customers"`
+	TrialsStarted   int    `db:"trials_started"`
+	TrialsConverted int    `db:"trials_converted"`
+}
+
+// Months computes the metrics of the months from the one starting at from to the one starting
+// at to, comparing the MRR of each customer at the start and at the end of the month
+func (s *ReportingService) Months(from time.Time, to time.Time, collected map[string]int) ([]MonthlyMetrics, error) {
+	rows := []monthAggregates{}
+	err := s.Connection.RawQuery(revenueSpansSQL+`,
+	months AS (
+		SELECT start, start + interval '1 month' AS finish
+		FROM generate_series(?::timestamp, ?::timestamp, interval '1 month') AS series(start)
+	),
+	customer_months AS (
+		SELECT months.start, spans.customer,
+			COALESCE(SUM(spans.mrr) FILTER (WHERE `+fmt.Sprintf(payingAtSQL, "months.start")+`), 0) AS mrr_before,
+			COALESCE(SUM(spans.mrr) FILTER (WHERE `+fmt.Sprintf(payingAtSQL, "months.finish")+`), 0) AS mrr_after,
+			COALESCE(bool_or(spans.paid_from < months.start), false) AS paid_before
+		FROM months CROSS JOIN spans
+		GROUP BY months.start, spans.customer
+	)
+	SELECT to_char(months.start, 'YYYY-MM') AS month,
+		COALESCE(SUM(mrr_after), 0) AS mrr,
+		COALESCE(SUM(mrr_after) FILTER (WHERE mrr_after > 0 AND mrr_before = 0 AND NOT paid_before), 0) AS new_mrr,
+		COALESCE(SUM(mrr_after) FILTER (WHERE mrr_after > 0 AND mrr_before = 0 AND paid_before), 0) AS reactivation_mrr,
+		COALESCE(SUM(mrr_after - mrr_before) FILTER (WHERE mrr_before > 0 AND mrr_after > mrr_before), 0) AS expansion_mrr,
+		COALESCE(SUM(mrr_before - mrr_after) FILTER (WHERE mrr_after > 0 AND mrr_after < mrr_before), 0) AS contraction_mrr,
+		COALESCE(SUM(mrr_before) FILTER (WHERE mrr_before > 0 AND mrr_after = 0), 0) AS churned_mrr,
+		COUNT(customer) FILTER (WHERE mrr_after > 0) AS customers,
+		COUNT(customer) FILTER (WHERE mrr_before > 0) AS customers_before,
+		COUNT(customer) FILTER (WHERE mrr_after > 0 AND mrr_before = 0 AND NOT paid_before) AS new_customers,
+		COUNT(customer) FILTER (WHERE mrr_before > 0 AND mrr_after = 0) AS churned_customers,
+		(SELECT COUNT(*) FROM spans WHERE trial AND signup_at >= months.start AND signup_at < months.finish) AS trials_started,
+		(SELECT COUNT(*) FROM spans WHERE trial AND signup_at >= months.start AND signup_at < months.finish AND paid_from IS NOT NULL) AS trials_converted
+	FROM months
+	LEFT JOIN customer_months ON customer_months.start = months.start
+	GROUP BY months.start, months.finish
+	ORDER BY months.start`,
+		from, to,
+	).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	months := make([]MonthlyMetrics, 0, len(rows))
+	for _, row := range rows {
+		metrics := MonthlyMetrics{
+			Month:            row.Month,
+			MRR:              row.MRR,
+			ARR:              12 * row.MRR,
+			NewMRR:           row.NewMRR,
+			ReactivationMRR:  row.ReactivationMRR,
+			ExpansionMRR:     row.ExpansionMRR,
+			ContractionMRR:   row.ContractionMRR,
+			ChurnedMRR:       row.ChurnedMRR,
+			Customers:        row.Customers,
+			NewCustomers:     row.NewCustomers,
+			ChurnedCustomers: row.Churned,
+			ChurnRate:        ratio(row.Churned, row.CustomersBefore),
+			TrialsStarted:    row.TrialsStarted,
+			TrialsConverted:  row.TrialsConverted,
+			Collected:        collected[row.Month],
+		}
+		metrics.NetNewMRR = metrics.NewMRR + metrics.ReactivationMRR + metrics.ExpansionMRR - metrics.ContractionMRR - metrics.ChurnedMRR
+		metrics.TrialConversionRate = ratio(metrics.TrialsConverted, metrics.TrialsStarted)
+		if metrics.Customers > 0 {
+			metrics.ARPU = int(math.Round(float64(metrics.MRR) / float64(metrics.Customers)))
+		}
+		months = append(months, metrics)
+	}
+	return months, nil
+}
+
+type cohortRetention struct {
+	Month     string `db:"month"`
+	Customers int    `db:"customers"`
+	Retained  int    `db:"retained"`
+}
+
+// Cohorts follows the customers who first signed up in each month from the one starting at
+// from to the one starting at to, up to the end of the latter
+func (s *ReportingService) Cohorts(from time.Time, to time.Time) ([]Cohort, error) {
+	rows := []cohortRetention{}
+	err := s.Connection.RawQuery(revenueSpansSQL+`,
+	signups AS (
+		SELECT customer, date_trunc('month', MIN(signup_at)) AS cohort
+		FROM spans
+		GROUP BY customer
+	),
+	offsets AS (
+		SELECT cohort, instant
+		FROM generate_series(?::timestamp, ?::timestamp, interval '1 month') AS cohorts(cohort),
+			generate_series(cohort + interval '1 month', ?::timestamp, interval '1 month') AS instants(instant)
+	)
+	SELECT to_char(offsets.cohort, 'YYYY-MM') AS month,
+		(SELECT COUNT(*) FROM signups WHERE signups.cohort = offsets.cohort) AS customers,
+		COUNT(DISTINCT spans.customer) FILTER (WHERE `+fmt.Sprintf(payingAtSQL, "offsets.instant")+`) AS retained
+	FROM offsets
+	LEFT JOIN signups ON signups.cohort = offsets.cohort
+	LEFT JOIN spans ON spans.customer = signups.customer
+	GROUP BY offsets.cohort, offsets.instant
+	ORDER BY offsets.cohort, offsets.instant`,
+		from, to, to.AddDate(0, 1, 0),
+	).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	cohorts := []Cohort{}
+	for _, row := range rows {
+		if len(cohorts) == 0 || cohorts[len(cohorts)-1].Month != row.Month {
+			cohorts = append(cohorts, Cohort{Month: row.Month, Customers: row.Customers, Retention: []float64{}})
+		}
+		c := &cohorts[len(cohorts)-1]
+		c.Retention = append(c.Retention, ratio(row.Retained, row.Customers))
+	}
+	return cohorts, nil
+}
+
+func ratio(part int, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)/float64(whole)*10000) / 10000
+}
+
+// FormatPercent shows a ratio the Brazilian way, e.g. 0.1234 as "12,3%"
+func FormatPercent(ratio float64) string {
+	return strings.Replace(strconv.FormatFloat(ratio*100, 'f', 1, 64), ".", ",", 1) + "%"
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ReportRange parses the from and to months (YYYY-MM), defaulting to the twelve months up to now
+func ReportRange(from string, to string, now time.Time) (time.Time, time.Time, error) {
+	end := monthStart(now)
+	if to != "" {
+		t, err := time.Parse(reportMonthLayout, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to month %q, use YYYY-MM", to)
+		}
+		end = t
+	}
+
+	start := end.AddDate(0, -11, 0)
+	if from != "" {
+		t, err := time.Parse(reportMonthLayout, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from month %q, use YYYY-MM", from)
+		}
+		start = t
+	}
+
+	if start.After(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from month %s is after to month %s", start.Format(reportMonthLayout), end.Format(reportMonthLayout))
+	}
+	if end.Sub(start) > 10*366*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("reports cover at most ten years")
+	}
+	return start, end, nil
+}
+
+// WriteMonthsCSV writes the monthly metrics, amounts in cents
+func (r *BusinessReport) WriteMonthsCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"month", "mrr", "arr", "new_mrr", "reactivation_mrr", "expansion_mrr", "contraction_mrr", "churned_mrr", "net_new_mrr",
+		"customers", "new_customers", "churned_customers", "churn_rate", "arpu",
+		"trials_started", "trials_converted", "trial_conversion_rate", "collected",
+	})
+	for _, m := range r.Months {
+		writer.Write([]string{
+			m.Month,
+			strconv.Itoa(m.MRR),
+			strconv.Itoa(m.ARR),
+			strconv.Itoa(m.NewMRR),
+			strconv.Itoa(m.ReactivationMRR),
+			strconv.Itoa(m.ExpansionMRR),
+			strconv.Itoa(m.ContractionMRR),
+			strconv.Itoa(m.ChurnedMRR),
+			strconv.Itoa(m.NetNewMRR),
+			strconv.Itoa(m.Customers),
+			strconv.Itoa(m.NewCustomers),
+			strconv.Itoa(m.ChurnedCustomers),
+			strconv.FormatFloat(m.ChurnRate, 'f', 4, 64),
+			strconv.Itoa(m.ARPU),
+			strconv.Itoa(m.TrialsStarted),
+			strconv.Itoa(m.TrialsConverted),
+			strconv.FormatFloat(m.TrialConversionRate, 'f', 4, 64),
+			strconv.Itoa(m.Collected),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteCohortsCSV writes one row per cohort with its retention n months after signup
+func (r *BusinessReport) WriteCohortsCSV(w io.Writer) error {
+	longest := 0
+	for _, c := range r.Cohorts {
+		if len(c.Retention) > longest {
+			longest = len(c.Retention)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{"cohort", "customers"}
+	for n := 0; n < longest; n++ {
+		header = append(header, "month_"+strconv.Itoa(n))
+	}
+	writer.Write(header)
+
+	for _, c := range r.Cohorts {
+		row := []string{c.Month, strconv.Itoa(c.Customers)}
+		for _, retention := range c.Retention {
+			row = append(row, strconv.FormatFloat(retention, 'f', 4, 64))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
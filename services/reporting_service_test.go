@@ -0,0 +1,58 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func Test_BusinessReport_CSV(t *testing.T) {
+	report := &BusinessReport{
+		From: "2020-01",
+		To:   "2020-02",
+		Months: []MonthlyMetrics{
+			{Month: "2020-01", MRR: 15000, ARR: 180000, NewMRR: 5000, NetNewMRR: 5000, Customers: 2, NewCustomers: 1, ARPU: 7500},
+			{Month: "2020-02", MRR: 13000, ARR: 156000, NewMRR: 3000, ChurnedMRR: 5000, NetNewMRR: -2000, Customers: 2, ChurnRate: 0.5},
+		},
+		Cohorts: []Cohort{
+			{Month: "2020-01", Customers: 3, Retention: []float64{0.3333, 0.3333}},
+			{Month: "2020-02", Customers: 0, Retention: []float64{0}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteMonthsCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[2], "2020-02,13000,156000,3000,0,0,0,5000,-2000,2,0,0,0.5000,") {
+		t.Errorf("unexpected csv %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteCohortsCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "cohort,customers,month_0,month_1\n2020-01,3,0.3333,0.3333\n2020-02,0,0.0000\n" {
+		t.Errorf("unexpected csv %s", buf.String())
+	}
+}
+
+func Test_ReportRange(t *testing.T) {
+	from, to, err := ReportRange("", "", day("2020-07-15"))
+	if err != nil || !from.Equal(day("2019-08-01")) || !to.Equal(day("2020-07-01")) {
+		t.Errorf("unexpected default range %s - %s (%v)", from, to, err)
+	}
+
+	if _, _, err := ReportRange("2020-05", "2020-01", day("2020-07-15")); err == nil {
+		t.Error("expected an inverted range to fail")
+	}
+	if _, _, err := ReportRange("may", "", day("2020-07-15")); err == nil {
+		t.Error("expected an invalid month to fail")
+	}
+}
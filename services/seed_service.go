@@ -0,0 +1,477 @@
+package services
+
+import (
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"math/rand"
+	"strconv"
+	"subscription_service/models"
+	"time"
+)
+
+// Transaction statuses the gateway reports besides the ones in models
+const (
+	seedPaymentWaiting = "waiting_payment"
+	seedPaymentRefused = "refused"
+)
+
+// seedPlan is a plan of the sample catalog with its features and interest table
+type seedPlan struct {
+	Plan     models.Plan
+	Features map[string]int
+	Rates    map[int]float64
+}
+
+// seedCatalog is the sample catalog. Features without a limit are unlimited; the inactive
+// plans are retired ones that still have subscribers.
+var seedCatalog = []seedPlan{
+	{
+		Plan:     models.Plan{Name: "Básico Mensal", Description: "Para quem está começando", Price: 29.90, RemotePanID: "1001", Recurrence: "Mensal", IntervalUnit: models.IntervalMonth, IntervalCount: 1, Active: true, MaxInstallments: 1},
+		Features: map[string]int{"projects": 3, "reports": 1},
+	},
+	{
+		Plan:     models.Plan{Name: "Básico Anual", Description: "O plano básico com dois meses grátis", Price: 299.00, RemotePanID: "1002", Recurrence: "Anual", IntervalUnit: models.IntervalYear, IntervalCount: 1, Active: true, MaxInstallments: 6},
+		Features: map[string]int{"projects": 3, "reports": 1},
+	},
+	{
+		Plan:     models.Plan{Name: "Profissional Mensal", Description: "Para equipes pequenas", Price: 79.90, RemotePanID: "1003", Recurrence: "Mensal", IntervalUnit: models.IntervalMonth, IntervalCount: 1, Active: true, MaxInstallments: 1},
+		Features: map[string]int{"projects": 20, "reports": 10, "api": -1},
+	},
+	{
+		Plan:     models.Plan{Name: "Profissional Anual", Description: "O plano profissional com dois meses grátis", Price: 799.00, RemotePanID: "1004", Recurrence: "Anual", IntervalUnit: models.IntervalYear, IntervalCount: 1, Active: true, MaxInstallments: 12},
+		Features: map[string]int{"projects": 20, "reports": 10, "api": -1},
+		Rates:    map[int]float64{7: 4.99, 8: 5.99, 9: 6.99, 10: 7.99, 11: 8.99, 12: 9.99},
+	},
+	{
+		Plan:     models.Plan{Name: "Empresarial Mensal", Description: "Sem limites, com suporte prioritário", Price: 199.90, RemotePanID: "1005", Recurrence: "Mensal", IntervalUnit: models.IntervalMonth, IntervalCount: 1, Active: true, MaxInstallments: 1},
+		Features: map[string]int{"projects": -1, "reports": -1, "api": -1, "priority_support": -1},
+	},
+	{
+		Plan:     models.Plan{Name: "Plano Único", Description: "Plano de lançamento, descontinuado", Price: 19.90, RemotePanID: "1000", Recurrence: "Mensal", IntervalUnit: models.IntervalMonth, IntervalCount: 1, Active: false, MaxInstallments: 1},
+		Features: map[string]int{"projects": 1},
+	},
+	{
+		Plan:     models.Plan{Name: "Profissional Semestral", Description: "Descontinuado", Price: 419.40, RemotePanID: "1006", Recurrence: "Semestral", IntervalUnit: models.IntervalMonth, IntervalCount: 6, Active: false, MaxInstallments: 6},
+		Features: map[string]int{"projects": 20, "reports": 10, "api": -1},
+	},
+}
+
+// Sample data the subscribers are made of
+var (
+	seedFirstNames    = []string{"Ana", "Bruno", "Carla", "Daniel", "Eduarda", "Felipe", "Gabriela", "Henrique", "Isabela", "João", "Larissa", "Marcos", "Natália", "Otávio", "Paula", "Rafael", "Sofia", "Thiago", "Vitória", "William"}
+	seedLastNames     = []string{"Silva", "Santos", "Oliveira", "Souza", "Rodrigues", "Ferreira", "Alves", "Pereira", "Lima", "Gomes", "Costa", "Ribeiro", "Martins", "Carvalho", "Almeida", "Lopes"}
+	seedStreets       = []string{"Rua das Flores", "Avenida Paulista", "Rua XV de Novembro", "Rua Sete de Setembro", "Avenida Brasil", "Rua Augusta", "Rua da Consolação", "Avenida Atlântica"}
+	seedNeighborhoods = []string{"Centro", "Jardim América", "Vila Mariana", "Copacabana", "Savassi", "Boa Viagem", "Moinhos de Vento", "Batel"}
+	seedDDDs          = []string{"11", "21", "31", "41", "51", "61", "71", "81"}
+	seedCardBrands    = []string{"visa", "mastercard", "elo", "amex", "hipercard"}
+)
+
+// SeedOptions tells how much sample data to create. The same Seed and Now always create
+// the same data.
+type SeedOptions struct {
+	Subscribers int
+	Seed        int64
+	// Now is the day the sample history ends, today when zero
+	Now time.Time
+}
+
+// SeedSummary counts the records created by a seeding run
+type SeedSummary struct {
+	Plans         int
+	Subscribers   int
+	Subscriptions int
+	Payments      int
+}
+
+// SeedService fills a development database with a plan catalog and subscribers whose
+// subscriptions went through trials, renewals, failed charges and cancellations
+type SeedService struct {
+	Connection *pop.Connection
+	rand       *rand.Rand
+	now        time.Time
+	summary    SeedSummary
+}
+
+// Creates a SeedService that writes with the given connection
+func NewSeedService(tx *pop.Connection) *SeedService {
+	return &SeedService{Connection: tx}
+}
+
+// Run creates the catalog, skipping the plans that already exist, and the subscribers.
+// Running it again with the same seed skips the subscribers created before.
+func (s *SeedService) Run(options SeedOptions) (SeedSummary, error) {
+	s.rand = rand.New(rand.NewSource(options.Seed))
+	s.now = options.Now
+	if s.now.IsZero() {
+		s.now = time.Now()
+	}
+	s.now = time.Date(s.now.Year(), s.now.Month(), s.now.Day(), 0, 0, 0, 0, time.UTC)
+	s.summary = SeedSummary{}
+
+	active, retired, err := s.seedPlans()
+	if err != nil {
+		return s.summary, err
+	}
+
+	for i := 1; i <= options.Subscribers; i++ {
+		if err := s.seedSubscriber(options.Seed, i, active, retired); err != nil {
+			return s.summary, fmt.Errorf("subscriber %d: %w", i, err)
+		}
+	}
+	return s.summary, nil
+}
+
+func (s *SeedService) seedPlans() (models.Plans, models.Plans, error) {
+	active, retired := models.Plans{}, models.Plans{}
+	for _, sample := range seedCatalog {
+		plan := sample.Plan
+		err := s.Connection.Where("remote_plan_id = ?", plan.RemotePanID).First(&plan)
+		if models.IsNotFound(err) {
+			err = s.createPlan(&plan, sample)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("plan %s: %w", sample.Plan.Name, err)
+		}
+
+		if plan.Active {
+			active = append(active, plan)
+		} else {
+			retired = append(retired, plan)
+		}
+	}
+	return active, retired, nil
+}
+
+func (s *SeedService) createPlan(plan *models.Plan, sample seedPlan) error {
+	plan.ID, _ = uuid.NewV4()
+	if err := validateAndCreate(s.Connection, plan); err != nil {
+		return err
+	}
+
+	for key, limit := range sample.Features {
+		feature := &models.PlanFeature{PlanID: plan.ID, FeatureKey: key}
+		if limit >= 0 {
+			feature.Limit = nulls.NewInt(limit)
+		}
+		feature.ID, _ = uuid.NewV4()
+		if err := validateAndCreate(s.Connection, feature); err != nil {
+			return err
+		}
+	}
+
+	for installments, rate := range sample.Rates {
+		line := &models.PlanInstallment{PlanID: plan.ID, Installments: installments, InterestRate: rate}
+		line.ID, _ = uuid.NewV4()
+		if err := validateAndCreate(s.Connection, line); err != nil {
+			return err
+		}
+	}
+
+	s.summary.Plans++
+	return nil
+}
+
+func (s *SeedService) seedSubscriber(seed int64, n int, active models.Plans, retired models.Plans) error {
+	// Draw everything before looking the subscriber up, so skipping it does not change the others
+	first, last := s.pick(seedFirstNames), s.pick(seedLastNames)
+	subscriber := &models.Subscriber{
+		Name:           first + " " + last,
+		Email:          fmt.Sprintf("seed%d.%d@example.com", seed, n),
+		DocumentNumber: seedCPF(s.rand),
+		Street:         s.pick(seedStreets),
+		StreetNumber:   strconv.Itoa(1 + s.rand.Intn(2000)),
+		Neighborhood:   s.pick(seedNeighborhoods),
+		Zipcode:        fmt.Sprintf("%08d", 1000000+s.rand.Intn(98000000)),
+		DDD:            s.pick(seedDDDs),
+		Number:         fmt.Sprintf("9%08d", s.rand.Intn(100000000)),
+	}
+	if s.rand.Intn(4) == 0 {
+		subscriber.Complementary = fmt.Sprintf("Apto %d", 11+s.rand.Intn(150))
+	}
+
+	// Some subscribers came from a retired plan they were moved off of
+	var legacy *models.Plan
+	if len(retired) > 0 && s.rand.Intn(6) == 0 {
+		legacy = &retired[s.rand.Intn(len(retired))]
+	}
+	plan := s.pickPlan(active)
+	start := s.now.AddDate(0, 0, -1-s.rand.Intn(540))
+	seedRand := s.rand.Int63()
+
+	exists, err := s.Connection.Where("email = ?", subscriber.Email).Exists(&models.Subscriber{})
+	if err != nil || exists {
+		return err
+	}
+
+	return s.Connection.Transaction(func(tx *pop.Connection) error {
+		history := &seedHistory{Connection: tx, rand: rand.New(rand.NewSource(seedRand)), now: s.now}
+
+		subscriber.ID, _ = uuid.NewV4()
+		subscriber.CreatedAt = start
+		if legacy != nil {
+			subscriber.CreatedAt = start.AddDate(-1, 0, 0)
+		}
+		if err := validateAndCreate(tx, subscriber); err != nil {
+			return err
+		}
+
+		if legacy != nil {
+			if err := history.legacySubscription(subscriber, *legacy, subscriber.CreatedAt, start); err != nil {
+				return err
+			}
+		}
+		if err := history.subscription(subscriber, plan, start); err != nil {
+			return err
+		}
+
+		s.summary.Subscribers++
+		s.summary.Subscriptions += history.subscriptions
+		s.summary.Payments += history.payments
+		return nil
+	})
+}
+
+func (s *SeedService) pick(list []string) string {
+	return list[s.rand.Intn(len(list))]
+}
+
+// pickPlan favors the cheaper and the monthly plans, the way real catalogs sell
+func (s *SeedService) pickPlan(plans models.Plans) models.Plan {
+	weights := make([]int, len(plans))
+	total := 0
+	for i, plan := range plans {
+		weights[i] = 3
+		if plan.IsAnnual() {
+			weights[i] = 1
+		}
+		if plan.MonthlyPriceInCents() > 10000 {
+			weights[i]--
+		}
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+		total += weights[i]
+	}
+
+	n := s.rand.Intn(total)
+	for i, weight := range weights {
+		if n < weight {
+			return plans[i]
+		}
+		n -= weight
+	}
+	return plans[len(plans)-1]
+}
+
+// seedCPF draws a CPF with valid check digits
+func seedCPF(r *rand.Rand) string {
+	base := fmt.Sprintf("%09d", r.Intn(1000000000))
+	return base + cpfCheckDigits(base)
+}
+
+// cpfCheckDigits works out the two check digits of the first nine digits of a CPF
+func cpfCheckDigits(base string) string {
+	digits := []int{}
+	for _, c := range base {
+		digits = append(digits, int(c-'0'))
+	}
+	for n := len(digits); n < 11; n++ {
+		sum := 0
+		for i, digit := range digits {
+			sum += digit * (n + 1 - i)
+		}
+		digits = append(digits, sum*10%11%10)
+	}
+	return strconv.Itoa(digits[9]) + strconv.Itoa(digits[10])
+}
+
+// seedHistory writes the life of the subscriptions of a subscriber, backdating every record
+// to when it would have happened
+type seedHistory struct {
+	Connection    *pop.Connection
+	rand          *rand.Rand
+	now           time.Time
+	subscriptions int
+	payments      int
+}
+
+type seedStatus struct {
+	status string
+	at     time.Time
+	reason string
+}
+
+// subscription lives from start until now: an optional trial, then one charge per period,
+// some of them failing, until it is canceled or still running
+func (h *seedHistory) subscription(subscriber *models.Subscriber, plan models.Plan, start time.Time) error {
+	method := "credit_card"
+	if h.rand.Intn(10) < 3 {
+		method = "boleto"
+	}
+	installments := 1
+	if method == "credit_card" && plan.MaxInstallments > 1 {
+		installments = 1 + h.rand.Intn(plan.MaxInstallments)
+	}
+
+	statuses := []seedStatus{}
+	charges := []models.Payment{}
+	periodStart, expiresAt := start, start
+
+	if h.rand.Intn(4) == 0 {
+		statuses = append(statuses, seedStatus{models.SubscriptionTrialing, start, "trial"})
+		trialEnd := start.AddDate(0, 0, 14)
+		expiresAt = trialEnd
+		if !trialEnd.Before(h.now) {
+			return h.save(subscriber, plan, start, expiresAt, statuses, charges)
+		}
+		if h.rand.Intn(10) < 3 {
+			statuses = append(statuses, seedStatus{models.SubscriptionCanceled, trialEnd, "trial ended without payment"})
+			return h.save(subscriber, plan, start, expiresAt, statuses, charges)
+		}
+		periodStart = trialEnd
+	}
+
+	for first := true; periodStart.Before(h.now); first = false {
+		periodEnd := plan.Interval().AddTo(periodStart)
+
+		if !first && h.rand.Intn(25) == 0 {
+			statuses = append(statuses, seedStatus{models.SubscriptionCanceled, periodStart, "customer asked"})
+			break
+		}
+
+		charge := h.charge(plan, method, installments, periodStart)
+		switch {
+		case method == "boleto" && h.now.Sub(periodStart) < 3*24*time.Hour:
+			// The boleto of the current period was not paid yet
+			charge.Status = seedPaymentWaiting
+			statuses = append(statuses, seedStatus{models.SubscriptionPendingPayment, periodStart, "boleto issued"})
+			charges = append(charges, charge)
+			expiresAt = periodEnd
+			return h.save(subscriber, plan, start, expiresAt, statuses, charges)
+
+		case h.rand.Intn(20) == 0:
+			charge.Status = seedPaymentRefused
+			charges = append(charges, charge)
+			statuses = append(statuses, seedStatus{models.SubscriptionUnpaid, periodStart, "payment failed"})
+
+			retryAt := periodStart.AddDate(0, 0, 5)
+			if !retryAt.Before(h.now) {
+				return h.save(subscriber, plan, start, periodStart, statuses, charges)
+			}
+			if h.rand.Intn(2) == 0 {
+				statuses = append(statuses, seedStatus{models.SubscriptionCanceled, retryAt, "payment failed"})
+				return h.save(subscriber, plan, start, periodStart, statuses, charges)
+			}
+			charge = h.charge(plan, method, installments, retryAt)
+		}
+
+		charges = append(charges, charge)
+		if len(statuses) == 0 || statuses[len(statuses)-1].status != models.SubscriptionPaid {
+			statuses = append(statuses, seedStatus{models.SubscriptionPaid, charge.CreatedAt, "payment confirmed"})
+		}
+		expiresAt = periodEnd
+		periodStart = periodEnd
+	}
+
+	return h.save(subscriber, plan, start, expiresAt, statuses, charges)
+}
+
+// legacySubscription ran on a retired plan until the subscriber moved to a current one
+func (h *seedHistory) legacySubscription(subscriber *models.Subscriber, plan models.Plan, start time.Time, end time.Time) error {
+	statuses := []seedStatus{{models.SubscriptionPaid, start, "payment confirmed"}}
+	charges := []models.Payment{}
+
+	periodStart := start
+	for ; periodStart.Before(end); periodStart = plan.Interval().AddTo(periodStart) {
+		charges = append(charges, h.charge(plan, "credit_card", 1, periodStart))
+	}
+	statuses = append(statuses, seedStatus{models.SubscriptionEnded, end, "plan retired"})
+
+	return h.save(subscriber, plan, start, periodStart, statuses, charges)
+}
+
+func (h *seedHistory) charge(plan models.Plan, method string, installments int, at time.Time) models.Payment {
+	payment := models.Payment{
+		TransactionID: strconv.Itoa(100000000 + h.rand.Intn(900000000)),
+		Gateway:       "seed",
+		PaymentType:   method,
+		Status:        models.PaymentPaid,
+		Total:         plan.PriceInCents(),
+		Installments:  1,
+		CreatedAt:     at.Add(time.Duration(8+h.rand.Intn(12)) * time.Hour),
+	}
+
+	if method == "boleto" {
+		payment.BoletoURL = "https://boletos.example.com/" + payment.TransactionID
+		payment.BoletoBarcode = fmt.Sprintf("23793.38128 60000.%06d 00000.000000 1 %014d", h.rand.Intn(1000000), payment.Total)
+		payment.BoletoExpirationDate = at.AddDate(0, 0, 3).Format("2006-01-02")
+		return payment
+	}
+
+	if option, ok := plan.InstallmentOption(installments); ok {
+		payment.Installments, payment.Total = option.Installments, option.Total
+	}
+	payment.CardBrand = seedCardBrands[h.rand.Intn(len(seedCardBrands))]
+	payment.CardLastDigits = fmt.Sprintf("%04d", h.rand.Intn(10000))
+	return payment
+}
+
+// save creates the subscription with its payments, going through the statuses so the
+// callbacks record the history, then backdates each change
+func (h *seedHistory) save(subscriber *models.Subscriber, plan models.Plan, start time.Time, expiresAt time.Time, statuses []seedStatus, charges []models.Payment) error {
+	subscription := &models.Subscription{
+		SubscriberID:         subscriber.ID,
+		PlanID:               plan.ID,
+		RemotePlanID:         plan.RemotePanID,
+		RemoteSubscriptionID: strconv.Itoa(100000000 + h.rand.Intn(900000000)),
+		StartDate:            start,
+		ExpiresAt:            expiresAt,
+		Status:               statuses[0].status,
+		StatusReason:         statuses[0].reason,
+		ChangedBy:            "db:seed",
+		CreatedAt:            start,
+	}
+	subscription.ID, _ = uuid.NewV4()
+	if err := validateAndCreate(h.Connection, subscription); err != nil {
+		return err
+	}
+	if err := h.backdate(subscription.ID, statuses[0].at); err != nil {
+		return err
+	}
+
+	for _, status := range statuses[1:] {
+		subscription.Status, subscription.StatusReason, subscription.ChangedBy = status.status, status.reason, "db:seed"
+		if err := h.Connection.Update(subscription); err != nil {
+			return err
+		}
+		if err := h.backdate(subscription.ID, status.at); err != nil {
+			return err
+		}
+	}
+
+	updatedAt := statuses[len(statuses)-1].at
+	if err := h.Connection.RawQuery("UPDATE subscriptions SET updated_at = ? WHERE id = ?", updatedAt, subscription.ID).Exec(); err != nil {
+		return err
+	}
+
+	for _, charge := range charges {
+		charge.ID, _ = uuid.NewV4()
+		charge.SubscriptionID = subscription.ID
+		if err := validateAndCreate(h.Connection, &charge); err != nil {
+			return err
+		}
+	}
+
+	h.subscriptions++
+	h.payments += len(charges)
+	return nil
+}
+
+// backdate moves the status change just recorded to when it happened. Every sample date is
+// before today, so the change still dated today is the new one.
+func (h *seedHistory) backdate(subscriptionID uuid.UUID, at time.Time) error {
+	return h.Connection.RawQuery("UPDATE subscription_status_changes SET created_at = ?, updated_at = ? WHERE subscription_id = ? AND created_at >= ?",
+		at, at, subscriptionID, h.now).Exec()
+}
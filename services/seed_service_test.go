@@ -0,0 +1,24 @@
+package services
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func Test_cpfCheckDigits(t *testing.T) {
+	for base, expected := range map[string]string{"529982247": "25", "111444777": "35", "390533447": "05"} {
+		if digits := cpfCheckDigits(base); digits != expected {
+			t.Errorf("expected %s to check with %s, got %s", base, expected, digits)
+		}
+	}
+}
+
+func Test_seedCPF(t *testing.T) {
+	cpf := seedCPF(rand.New(rand.NewSource(7)))
+	if len(cpf) != 11 || cpfCheckDigits(cpf[:9]) != cpf[9:] {
+		t.Errorf("%s is not a valid CPF", cpf)
+	}
+	if seedCPF(rand.New(rand.NewSource(7))) != cpf {
+		t.Error("expected the same seed to draw the same CPF")
+	}
+}
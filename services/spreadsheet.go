@@ -0,0 +1,197 @@
+package services
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SheetWriter writes spreadsheet rows as they come, so exports never hold the whole sheet.
+// Cells can be strings, ints, float64s, bools or times; nil leaves the cell blank.
+type SheetWriter interface {
+	WriteRow(cells []interface{}) error
+	// Flush sends the rows written so far on to the client
+	Flush() error
+	Close() error
+}
+
+// sheetTimeLayout is how dates and times are written to the sheets
+const sheetTimeLayout = "2006-01-02 15:04:05"
+
+func cellText(cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(sheetTimeLayout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// flushClient pushes buffered bytes to the client when writing to an HTTP response
+func flushClient(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type csvSheetWriter struct {
+	out    io.Writer
+	writer *csv.Writer
+}
+
+// NewCSVSheetWriter writes UTF-8 CSV starting with a byte order mark, so spreadsheet apps
+// read the accents right
+func NewCSVSheetWriter(w io.Writer) (SheetWriter, error) {
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+	return &csvSheetWriter{out: w, writer: csv.NewWriter(w)}, nil
+}
+
+func (s *csvSheetWriter) WriteRow(cells []interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		text := cellText(cell)
+		// Keep spreadsheet apps from running text that looks like a formula
+		if _, ok := cell.(string); ok && text != "" && strings.ContainsAny(text[:1], "=+-@") {
+			text = "'" + text
+		}
+		record[i] = text
+	}
+	return s.writer.Write(record)
+}
+
+func (s *csvSheetWriter) Flush() error {
+	s.writer.Flush()
+	flushClient(s.out)
+	return s.writer.Error()
+}
+
+func (s *csvSheetWriter) Close() error {
+	return s.Flush()
+}
+
+// The parts of a workbook with a single sheet. Cells are written as inline strings, so
+// no shared string table has to be built before the sheet can be written.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// XLSXContentType is the MIME type of the workbooks written by NewXLSXSheetWriter
+const XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+type xlsxSheetWriter struct {
+	out   io.Writer
+	zip   *zip.Writer
+	sheet *bufio.Writer
+}
+
+// NewXLSXSheetWriter writes an Excel workbook with a single sheet of the given name
+func NewXLSXSheetWriter(w io.Writer, sheetName string) (SheetWriter, error) {
+	z := zip.NewWriter(w)
+
+	var escapedName strings.Builder
+	xml.EscapeText(&escapedName, []byte(sheetName))
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, escapedName.String())},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := z.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	if _, err := sheet.WriteString(xlsxSheetStart); err != nil {
+		return nil, err
+	}
+
+	return &xlsxSheetWriter{out: w, zip: z, sheet: sheet}, nil
+}
+
+func (s *xlsxSheetWriter) WriteRow(cells []interface{}) error {
+	s.sheet.WriteString("<row>")
+	for _, cell := range cells {
+		switch v := cell.(type) {
+		case nil:
+			s.sheet.WriteString("<c/>")
+		case int, float64:
+			s.sheet.WriteString("<c><v>" + cellText(v) + "</v></c>")
+		default:
+			s.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(s.sheet, []byte(cellText(v))); err != nil {
+				return err
+			}
+			s.sheet.WriteString("</t></is></c>")
+		}
+	}
+	_, err := s.sheet.WriteString("</row>")
+	return err
+}
+
+func (s *xlsxSheetWriter) Flush() error {
+	if err := s.sheet.Flush(); err != nil {
+		return err
+	}
+	if err := s.zip.Flush(); err != nil {
+		return err
+	}
+	flushClient(s.out)
+	return nil
+}
+
+func (s *xlsxSheetWriter) Close() error {
+	if _, err := s.sheet.WriteString(xlsxSheetEnd); err != nil {
+		return err
+	}
+	if err := s.sheet.Flush(); err != nil {
+		return err
+	}
+	if err := s.zip.Close(); err != nil {
+		return err
+	}
+	flushClient(s.out)
+	return nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CSVSheetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCSVSheetWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteRow([]interface{}{"name", "total", "paid_at"})
+	w.WriteRow([]interface{}{"=HYPERLINK(\"x\")", 49.9, time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)})
+	w.WriteRow([]interface{}{"José", -10, nil})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "\xEF\xBB\xBFname,total,paid_at\n\"'=HYPERLINK(\"\"x\"\")\",49.9,2020-07-01 10:00:00\nJosé,-10,\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected csv %q", buf.String())
+	}
+}
+
+func Test_XLSXSheetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewXLSXSheetWriter(&buf, "Pagamentos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteRow([]interface{}{"name", "total"})
+	w.WriteRow([]interface{}{"Ana & <Bia>", 49.9})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		r, _ := f.Open()
+		content, _ := ioutil.ReadAll(r)
+		parts[f.Name] = string(content)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="Pagamentos"`) {
+		t.Errorf("unexpected workbook %s", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	if !strings.Contains(sheet, `<row><c t="inlineStr"><is><t xml:space="preserve">Ana &amp; &lt;Bia&gt;</t></is></c><c><v>49.9</v></c></row>`) || !strings.HasSuffix(sheet, "</sheetData></worksheet>") {
+		t.Errorf("unexpected sheet %s", sheet)
+	}
+}
+
+func Test_ParseExportFilter(t *testing.T) {
+	params := map[string]string{"from": "2020-07-01", "to": "2020-07-31", "payment_type": "boleto"}
+	filter, err := ParseExportFilter(func(key string) string { return params[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.From.Equal(time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)) || !filter.To.Equal(time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)) || filter.PaymentType != "boleto" {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+
+	params = map[string]string{"plan_id": "mensal"}
+	if _, err := ParseExportFilter(func(key string) string { return params[key] }); err == nil {
+		t.Error("expected an invalid plan id to fail")
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"log"
+	"subscription_service/models"
+)
+
+// SubscriptionChanged is the payload of the subscription.* events
+type SubscriptionChanged struct {
+	SubscriptionID       uuid.UUID `json:"subscription_id"`
+	SubscriberID         uuid.UUID `json:"subscriber_id"`
+	PlanID               uuid.UUID `json:"plan_id"`
+	RemoteSubscriptionID string    `json:"remote_subscription_id"`
+	Status               string    `json:"status"`
+	Reason               string    `json:"reason,omitempty"`
+}
+
+// NewSubscriptionChanged builds the event payload from the subscription
+func NewSubscriptionChanged(subscription models.Subscription, reason string) SubscriptionChanged {
+	return SubscriptionChanged{
+		SubscriptionID:       subscription.ID,
+		SubscriberID:         subscription.SubscriberID,
+		PlanID:               subscription.PlanID,
+		RemoteSubscriptionID: subscription.RemoteSubscriptionID,
+		Status:               subscription.Status,
+		Reason:               reason,
+	}
+}
+
+// CancelSubscription stops the subscription at the gateway, marks it as canceled locally
+// and publishes the subscription.canceled event
+func CancelSubscription(tx *pop.Connection, gateway *GatewayClient, rabbitMQ *RabbitMQ, subscription *models.Subscription, reason string) error {
+	if subscription.Status == models.SubscriptionCanceled {
+		return nil
+	}
+
+	if err := gateway.CancelSubscription(subscription.RemoteSubscriptionID); err != nil {
+		return err
+	}
+
+	subscription.Status = models.SubscriptionCanceled
+	subscription.StatusReason = reason
+	if err := tx.Update(subscription); err != nil {
+		return err
+	}
+	if err := Notify(tx, models.NotificationSubscriptionCanceled, *subscription, nil); err != nil {
+		log.Println("Error queueing the cancellation email:", err)
+	}
+
+	return rabbitMQ.PublishEvent(tx, NewEvent("subscription.canceled", NewSubscriptionChanged(*subscription, reason)))
+}
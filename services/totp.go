@@ -0,0 +1,151 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP codes follow RFC 6238 with the defaults authenticator apps expect:
+// HMAC-SHA1, 30 second steps and 6 digits
+const (
+	totpStep   = 30
+	totpDigits = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a random base32 secret to be loaded into an authenticator app
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI shown as a QR code to set up the authenticator app
+func TOTPProvisioningURI(secret string, account string, issuer string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), query.Encode())
+}
+
+// TOTPCode computes the code of the secret for the step containing t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/totpStep))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// VerifyTOTP checks the code against the current step and the ones right before and after it,
+// tolerating clocks a little out of step
+func VerifyTOTP(secret string, code string, now time.Time) bool {
+	_, ok := MatchTOTP(secret, code, now)
+	return ok
+}
+
+// MatchTOTP is VerifyTOTP also returning the step the code belongs to, so a code can be
+// refused once a code of its step or a later one was used
+func MatchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	for _, skew := range []int{0, -1, 1} {
+		at := now.Add(time.Duration(skew*totpStep) * time.Second)
+		expected, err := TOTPCode(secret, at)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpStep, true
+		}
+	}
+	return 0, false
+}
+
+// ErrTOTPKey is returned when TOTP_ENCRYPTION_KEY is not 32 bytes encoded in base64
+var ErrTOTPKey = errors.New("TOTP_ENCRYPTION_KEY must be 32 random bytes encoded in base64")
+
+// totpSealedPrefix marks the secrets encrypted by EncryptTOTPSecret
+const totpSealedPrefix = "aesgcm:"
+
+func totpCipher() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		return nil, ErrTOTPKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptTOTPSecret seals the secret with AES-GCM under TOTP_ENCRYPTION_KEY, so a copy of the
+// database is not enough to compute the codes
+func EncryptTOTPSecret(secret string) (string, error) {
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return totpSealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptTOTPSecret opens a secret sealed by EncryptTOTPSecret. Secrets stored before they were
+// encrypted are returned as they are.
+func DecryptTOTPSecret(stored string) (string, error) {
+	if !TOTPSecretEncrypted(stored) {
+		return stored, nil
+	}
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, totpSealedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed TOTP secret")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// TOTPSecretEncrypted reports whether the stored secret was sealed by EncryptTOTPSecret
+func TOTPSecretEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, totpSealedPrefix)
+}
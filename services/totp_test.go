@@ -0,0 +1,97 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 vectors of RFC 6238, appendix B, truncated to 6 digits
+func Test_TOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("at %d expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func Test_VerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	if !VerifyTOTP(secret, code, now) {
+		t.Error("expected the current code to be accepted")
+	}
+	if !VerifyTOTP(secret, code, now.Add(30*time.Second)) {
+		t.Error("expected the previous step code to be accepted")
+	}
+	if VerifyTOTP(secret, code, now.Add(5*time.Minute)) {
+		t.Error("expected an old code to be refused")
+	}
+	if VerifyTOTP(secret, "", now) {
+		t.Error("expected a blank code to be refused")
+	}
+}
+
+func Test_MatchTOTP_Step(t *testing.T) {
+	secret, _ := GenerateTOTPSecret()
+	now := time.Unix(1234567890, 0)
+	previous, _ := TOTPCode(secret, now.Add(-30*time.Second))
+
+	step, ok := MatchTOTP(secret, previous, now)
+	if !ok || step != now.Unix()/30-1 {
+		t.Errorf("expected the previous step, got %d/%v", step, ok)
+	}
+}
+
+func Test_EncryptTOTPSecret(t *testing.T) {
+	os.Setenv("TOTP_ENCRYPTION_KEY", "")
+	if _, err := EncryptTOTPSecret("JBSWY3DPEHPK3PXP"); err != ErrTOTPKey {
+		t.Errorf("expected ErrTOTPKey without a key, got %v", err)
+	}
+
+	os.Setenv("TOTP_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	defer os.Unsetenv("TOTP_ENCRYPTION_KEY")
+
+	sealed, err := EncryptTOTPSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") || !TOTPSecretEncrypted(sealed) {
+		t.Errorf("expected the secret sealed, got %s", sealed)
+	}
+	if secret, err := DecryptTOTPSecret(sealed); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected the secret back, got %q/%v", secret, err)
+	}
+	if secret, err := DecryptTOTPSecret("JBSWY3DPEHPK3PXP"); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected a secret stored before encryption as it is, got %q/%v", secret, err)
+	}
+	if _, err := DecryptTOTPSecret(sealed[:len(sealed)-4] + "AAA="); err == nil {
+		t.Error("expected a tampered secret to be refused")
+	}
+}
+
+func Test_TOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("JBSWY3DPEHPK3PXP", "ana@example.com", "Code Shop")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Code%20Shop:ana@example.com?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
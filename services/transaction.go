@@ -0,0 +1,89 @@
+package services
+
+import (
+	"github.com/gobuffalo/pop/v5"
+	"log"
+	"subscription_service/models"
+	"sync"
+)
+
+// transaction runs fn in a transaction of its own, with the audit actor of conn. When conn is in
+// a transaction already, such as the one of a request, fn runs in a savepoint of it instead, so
+// a failure of fn undoes only what fn did.
+func transaction(conn *pop.Connection, fn func(tx *pop.Connection) error) error {
+	if conn.TX == nil {
+		return transactionAs(conn, models.CurrentAuditActor(conn), fn)
+	}
+
+	if err := conn.RawQuery("SAVEPOINT nested").Exec(); err != nil {
+		return err
+	}
+	afterCommit.Lock()
+	pending := len(afterCommit.byTransaction[conn.TX.ID])
+	afterCommit.Unlock()
+
+	if err := fn(conn); err != nil {
+		if rerr := conn.RawQuery("ROLLBACK TO SAVEPOINT nested").Exec(); rerr != nil {
+			log.Println("Error rolling back to savepoint:", rerr)
+		}
+		afterCommit.Lock()
+		if hooks := afterCommit.byTransaction[conn.TX.ID]; len(hooks) > pending {
+			afterCommit.byTransaction[conn.TX.ID] = hooks[:pending]
+		}
+		afterCommit.Unlock()
+		return err
+	}
+	return conn.RawQuery("RELEASE SAVEPOINT nested").Exec()
+}
+
+// transactionAs runs fn in a new transaction of conn whose changes are attributed to actor,
+// and then what fn registered with AfterCommit if it committed
+func transactionAs(conn *pop.Connection, actor models.AuditActor, fn func(tx *pop.Connection) error) error {
+	var committed *pop.Connection
+	err := conn.Transaction(func(tx *pop.Connection) error {
+		defer models.SetAuditActor(tx, actor)()
+		committed = tx
+		return fn(tx)
+	})
+	if committed != nil {
+		Finished(committed, err == nil)
+	}
+	return err
+}
+
+var afterCommit = struct {
+	sync.Mutex
+	byTransaction map[int][]func()
+}{byTransaction: map[int][]func(){}}
+
+// AfterCommit runs fn once the transaction of tx commits, and never if it rolls back. Outside
+// of a transaction fn runs at once.
+func AfterCommit(tx *pop.Connection, fn func()) {
+	if tx.TX == nil {
+		fn()
+		return
+	}
+
+	afterCommit.Lock()
+	defer afterCommit.Unlock()
+	afterCommit.byTransaction[tx.TX.ID] = append(afterCommit.byTransaction[tx.TX.ID], fn)
+}
+
+// Finished runs what was registered with AfterCommit for the transaction of tx when it
+// committed, and forgets it when it rolled back
+func Finished(tx *pop.Connection, committed bool) {
+	if tx.TX == nil {
+		return
+	}
+
+	afterCommit.Lock()
+	hooks := afterCommit.byTransaction[tx.TX.ID]
+	delete(afterCommit.byTransaction, tx.TX.ID)
+	afterCommit.Unlock()
+
+	if committed {
+		for _, fn := range hooks {
+			fn()
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"subscription_service/models"
+	"time"
+)
+
+// Headers of the webhook requests
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// webhookResponseLimit is how much of the answer of an endpoint is kept in the attempt log
+const webhookResponseLimit = 2048
+
+var (
+	// ErrWebhookSignature is returned when a webhook request is not signed with the secret
+	ErrWebhookSignature = errors.New("webhook signature does not match")
+	// ErrWebhookSignatureExpired is returned when a webhook request was signed too long ago
+	ErrWebhookSignatureExpired = errors.New("webhook signature expired")
+)
+
+// Webhooks queues the events published for the webhook endpoints subscribing to them. It is
+// nil, and no event goes to webhooks, until App sets it.
+var Webhooks *WebhookService
+
+// WebhookPayload is the body POSTed to the endpoints: the event, with an ID that stays the
+// same across retries and redeliveries so endpoints can drop the copies
+type WebhookPayload struct {
+	ID uuid.UUID `json:"id"`
+	Event
+}
+
+// SignWebhook signs the body for the endpoint, in the form of the X-Webhook-Signature header:
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>" with the secret>". The time
+// keeps a captured request from being replayed later.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), webhookMAC(secret, timestamp.Unix(), body))
+}
+
+// VerifyWebhookSignature checks a signature made by SignWebhook no longer than tolerance ago,
+// the way endpoints receiving our webhooks should
+func VerifyWebhookSignature(secret string, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var macs []string
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			macs = append(macs, kv[1])
+		}
+	}
+	if timestamp == 0 || len(macs) == 0 {
+		return ErrWebhookSignature
+	}
+
+	expected := webhookMAC(secret, timestamp, body)
+	for _, mac := range macs {
+		if hmac.Equal([]byte(mac), []byte(expected)) {
+			if now.Sub(time.Unix(timestamp, 0)) > tolerance {
+				return ErrWebhookSignatureExpired
+			}
+			return nil
+		}
+	}
+	return ErrWebhookSignature
+}
+
+func webhookMAC(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhookEndpoint registers an endpoint for the event types, separated by spaces, with a
+// new secret to sign its requests
+func CreateWebhookEndpoint(tx *pop.Connection, url string, eventTypes string, description string) (*models.WebhookEndpoint, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		URL:         strings.TrimSpace(url),
+		Secret:      "whsec_" + base64.RawURLEncoding.EncodeToString(secret),
+		EventTypes:  strings.Join(strings.Fields(eventTypes), " "),
+		Description: strings.TrimSpace(description),
+		Enabled:     true,
+	}
+	return endpoint, validateAndCreate(tx, endpoint)
+}
+
+// EnableWebhookEndpoint sends events to the endpoint again, along with the deliveries held
+// while it was disabled
+func EnableWebhookEndpoint(tx *pop.Connection, endpoint *models.WebhookEndpoint) error {
+	endpoint.Enabled, endpoint.ConsecutiveFailures = true, 0
+	endpoint.DisabledAt, endpoint.DisabledReason = nulls.Time{}, ""
+	return tx.Update(endpoint)
+}
+
+// DisableWebhookEndpoint stops sending events to the endpoint. Its pending deliveries are
+// held until it is enabled.
+func DisableWebhookEndpoint(tx *pop.Connection, endpoint *models.WebhookEndpoint, reason string, now time.Time) error {
+	endpoint.Enabled = false
+	endpoint.DisabledAt, endpoint.DisabledReason = nulls.NewTime(now), reason
+	return tx.Update(endpoint)
+}
+
+// WebhookBackoff is how long to wait after the failed attempt before the next one: a minute
+// after the first, doubling after each, up to 6 hours
+func WebhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 10 {
+		return 6 * time.Hour
+	}
+	backoff := time.Minute << uint(attempts-1)
+	if backoff > 6*time.Hour {
+		return 6 * time.Hour
+	}
+	return backoff
+}
+
+// WebhookService delivers the events to the webhook endpoints. Failed deliveries are retried,
+// waiting longer each time, until MaxAttempts. Endpoints failing DisableAfter attempts in a
+// row are disabled.
+type WebhookService struct {
+	Connection   *pop.Connection
+	HTTPClient   *http.Client
+	MaxAttempts  int
+	DisableAfter int
+	// Stale is how long a delivery may stay claimed by a worker that died before it is sent again
+	Stale time.Duration
+	Now   func() time.Time
+}
+
+// WebhookReport counts what a run of the worker did
+type WebhookReport struct {
+	Succeeded int
+	Retried   int
+	Failed    int
+	Disabled  int
+}
+
+// NewWebhookService creates a service disabling the endpoints after WEBHOOKS_DISABLE_AFTER
+// failed attempts in a row (20 when not set)
+func NewWebhookService(tx *pop.Connection) *WebhookService {
+	return &WebhookService{
+		Connection:   tx,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  8,
+		DisableAfter: envInt("WEBHOOKS_DISABLE_AFTER", 20),
+		Stale:        15 * time.Minute,
+		Now:          time.Now,
+	}
+}
+
+// Enqueue queues the event for every enabled endpoint subscribing to its type, in the
+// transaction of tx, so the events of a change rolled back are never delivered
+func (s *WebhookService) Enqueue(tx *pop.Connection, event Event) error {
+	endpoints := models.WebhookEndpoints{}
+	if err := tx.Where("enabled = ?", true).All(&endpoints); err != nil {
+		return err
+	}
+
+	var payload []byte
+	id := uuid.Must(uuid.NewV4())
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribes(event.Type) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			if payload, err = json.Marshal(WebhookPayload{ID: id, Event: event}); err != nil {
+				return err
+			}
+		}
+		err := validateAndCreate(tx, &models.WebhookDelivery{
+			WebhookEndpointID: endpoint.ID,
+			EventID:           id,
+			EventType:         event.Type,
+			Payload:           string(payload),
+			Status:            models.WebhookDeliveryPending,
+			NextAttemptAt:     s.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redeliver queues the event of the delivery again for its endpoint, as first sent
+func (s *WebhookService) Redeliver(delivery models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	redelivery := &models.WebhookDelivery{
+		WebhookEndpointID: delivery.WebhookEndpointID,
+		EventID:           delivery.EventID,
+		EventType:         delivery.EventType,
+		Payload:           delivery.Payload,
+		Status:            models.WebhookDeliveryPending,
+		NextAttemptAt:     s.Now(),
+	}
+	return redelivery, validateAndCreate(s.Connection, redelivery)
+}
+
+// DeliverPending POSTs up to limit deliveries due to enabled endpoints. Each one is claimed
+// before being sent, so workers running side by side do not send it twice.
+func (s *WebhookService) DeliverPending(limit int) (WebhookReport, error) {
+	report := WebhookReport{}
+	now := s.Now()
+
+	deliveries := models.WebhookDeliveries{}
+	err := s.Connection.
+		Where("((status = ? AND next_attempt_at <= ?) OR (status = ? AND updated_at < ?))",
+			models.WebhookDeliveryPending, now, models.WebhookDeliveryDelivering, now.Add(-s.Stale)).
+		Where("webhook_endpoint_id IN (SELECT id FROM webhook_endpoints WHERE enabled = ?)", true).
+		Order("next_attempt_at asc").
+		Limit(limit).
+		All(&deliveries)
+	if err != nil {
+		return report, err
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		claimed, err := s.Connection.RawQuery(
+			"UPDATE webhook_deliveries SET status = ?, updated_at = ? WHERE id = ? AND status = ? AND updated_at = ?",
+			models.WebhookDeliveryDelivering, now, delivery.ID, delivery.Status, delivery.UpdatedAt,
+		).ExecWithCount()
+		if err != nil {
+			return report, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		// The endpoint may have been disabled by an earlier delivery of this run
+		endpoint := models.WebhookEndpoint{}
+		if err := s.Connection.Find(&endpoint, delivery.WebhookEndpointID); err != nil {
+			return report, err
+		}
+		if !endpoint.Enabled {
+			delivery.Status = models.WebhookDeliveryPending
+			if err := s.Connection.Update(delivery); err != nil {
+				return report, err
+			}
+			continue
+		}
+
+		attempt := s.post(endpoint, *delivery)
+		if err := validateAndCreate(s.Connection, &attempt); err != nil {
+			return report, err
+		}
+
+		delivery.Attempts++
+		delivery.LastStatusCode, delivery.LastError = attempt.StatusCode, attempt.Error
+		switch {
+		case attempt.Error == "":
+			delivery.Status, delivery.DeliveredAt = models.WebhookDeliverySucceeded, nulls.NewTime(s.Now())
+			report.Succeeded++
+		case delivery.Attempts >= s.MaxAttempts:
+			log.Printf("Giving up on webhook %s of %s to %s: %s", delivery.ID, delivery.EventType, endpoint.URL, attempt.Error)
+			delivery.Status = models.WebhookDeliveryFailed
+			report.Failed++
+		default:
+			log.Printf("Error delivering webhook %s of %s to %s: %s", delivery.ID, delivery.EventType, endpoint.URL, attempt.Error)
+			delivery.Status, delivery.NextAttemptAt = models.WebhookDeliveryPending, now.Add(WebhookBackoff(delivery.Attempts))
+			report.Retried++
+		}
+		if err := s.Connection.Update(delivery); err != nil {
+			return report, err
+		}
+
+		disabled, err := s.countAttempt(endpoint, attempt.Error == "")
+		if err != nil {
+			return report, err
+		}
+		if disabled {
+			log.Printf("Disabled webhook endpoint %s (%s) after %d failed attempts in a row", endpoint.ID, endpoint.URL, s.DisableAfter)
+			report.Disabled++
+		}
+	}
+
+	return report, nil
+}
+
+// post sends the delivery to the endpoint, signed with its secret at the time of sending
+func (s *WebhookService) post(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) models.WebhookDeliveryAttempt {
+	attempt := models.WebhookDeliveryAttempt{WebhookDeliveryID: delivery.ID}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "subscription-service-webhooks")
+	req.Header.Set(WebhookIDHeader, delivery.EventID.String())
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(endpoint.Secret, s.Now(), []byte(delivery.Payload)))
+
+	start := time.Now()
+	res, err := s.HTTPClient.Do(req)
+	attempt.DurationMS = int(time.Since(start) / time.Millisecond)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, webhookResponseLimit))
+	attempt.StatusCode, attempt.ResponseBody = res.StatusCode, strings.ToValidUTF8(string(body), "")
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("endpoint answered %d", res.StatusCode)
+	}
+	return attempt
+}
+
+// countAttempt keeps the failed attempts in a row of the endpoint, and disables it once they
+// reach DisableAfter, reporting whether it did
+func (s *WebhookService) countAttempt(endpoint models.WebhookEndpoint, succeeded bool) (bool, error) {
+	if succeeded {
+		return false, s.Connection.RawQuery(
+			"UPDATE webhook_endpoints SET consecutive_failures = 0 WHERE id = ? AND consecutive_failures > 0", endpoint.ID,
+		).Exec()
+	}
+
+	err := s.Connection.RawQuery(
+		"UPDATE webhook_endpoints SET consecutive_failures = consecutive_failures + 1 WHERE id = ?", endpoint.ID,
+	).Exec()
+	if err != nil || s.DisableAfter < 1 {
+		return false, err
+	}
+
+	now := s.Now()
+	disabled, err := s.Connection.RawQuery(
+		"UPDATE webhook_endpoints SET enabled = ?, disabled_at = ?, disabled_reason = ?, updated_at = ? WHERE id = ? AND enabled = ? AND consecutive_failures >= ?",
+		false, now, fmt.Sprintf("%d tentativas seguidas falharam", s.DisableAfter), now, endpoint.ID, true, s.DisableAfter,
+	).ExecWithCount()
+	return disabled > 0, err
+}
+
+// StartWebhookDeliverer delivers the queued webhooks every interval in the background until the process exits
+func StartWebhookDeliverer(tx *pop.Connection, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := NewWebhookService(tx).DeliverPending(100)
+			if err != nil {
+				log.Println("Error delivering webhooks:", err)
+				continue
+			}
+			if report != (WebhookReport{}) {
+				log.Printf("Webhook deliverer: %+v", report)
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"subscription_service/models"
+	"testing"
+	"time"
+)
+
+func Test_SignWebhook(t *testing.T) {
+	body := []byte(`{"id":"1","type":"subscription.canceled"}`)
+	now := time.Unix(1760000000, 0)
+	signature := SignWebhook("whsec_test", now, body)
+
+	if err := VerifyWebhookSignature("whsec_test", signature, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected the signature to verify, got %v", err)
+	}
+	if err := VerifyWebhookSignature("whsec_other", signature, body, 5*time.Minute, now); err != ErrWebhookSignature {
+		t.Errorf("expected another secret to fail, got %v", err)
+	}
+	if err := VerifyWebhookSignature("whsec_test", signature, []byte(`{"id":"2"}`), 5*time.Minute, now); err != ErrWebhookSignature {
+		t.Errorf("expected another body to fail, got %v", err)
+	}
+	if err := VerifyWebhookSignature("whsec_test", signature, body, 5*time.Minute, now.Add(time.Hour)); err != ErrWebhookSignatureExpired {
+		t.Errorf("expected an old signature to expire, got %v", err)
+	}
+	if err := VerifyWebhookSignature("whsec_test", "sha1=abc", body, 5*time.Minute, now); err != ErrWebhookSignature {
+		t.Errorf("expected a malformed signature to fail, got %v", err)
+	}
+}
+
+func Test_WebhookBackoff(t *testing.T) {
+	expected := map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		4:  8 * time.Minute,
+		8:  128 * time.Minute,
+		9:  256 * time.Minute,
+		10: 6 * time.Hour,
+		40: 6 * time.Hour,
+	}
+	for attempts, backoff := range expected {
+		if got := WebhookBackoff(attempts); got != backoff {
+			t.Errorf("attempt %d: expected %v, got %v", attempts, backoff, got)
+		}
+	}
+}
+
+func Test_WebhookService_post(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	var received *http.Request
+	var receivedBody []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	payload, _ := json.Marshal(WebhookPayload{ID: uuid.Must(uuid.NewV4()), Event: NewEvent("payment.refunded", map[string]int{"amount": 100})})
+	delivery := models.WebhookDelivery{
+		ID:        uuid.Must(uuid.NewV4()),
+		EventID:   uuid.Must(uuid.NewV4()),
+		EventType: "payment.refunded",
+		Payload:   string(payload),
+	}
+	endpoint := models.WebhookEndpoint{URL: server.URL, Secret: "whsec_test"}
+	service := &WebhookService{HTTPClient: server.Client(), Now: func() time.Time { return now }}
+
+	attempt := service.post(endpoint, delivery)
+	if attempt.Error != "" || attempt.StatusCode != http.StatusOK || attempt.ResponseBody != "ok" {
+		t.Fatalf("expected the delivery to succeed, got %+v", attempt)
+	}
+	if received.Header.Get(WebhookIDHeader) != delivery.EventID.String() || received.Header.Get(WebhookEventHeader) != "payment.refunded" {
+		t.Errorf("unexpected headers: %v", received.Header)
+	}
+	if string(receivedBody) != delivery.Payload {
+		t.Errorf("expected the payload to be sent as is, got %s", receivedBody)
+	}
+	if err := VerifyWebhookSignature("whsec_test", received.Header.Get(WebhookSignatureHeader), receivedBody, time.Minute, now); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+
+	status = http.StatusBadGateway
+	attempt = service.post(endpoint, delivery)
+	if attempt.StatusCode != http.StatusBadGateway || attempt.Error != "endpoint answered 502" {
+		t.Errorf("expected the delivery to fail, got %+v", attempt)
+	}
+
+	server.Close()
+	attempt = service.post(endpoint, delivery)
+	if attempt.StatusCode != 0 || attempt.Error == "" {
+		t.Errorf("expected the delivery to fail without an answer, got %+v", attempt)
+	}
+}